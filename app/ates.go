@@ -0,0 +1,325 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// AtesClass is a terrain exposure class modeled on the Avalanche Terrain
+// Exposure Scale. The classification is a heuristic from the elevation model
+// and does not replace a rating by a professional.
+type AtesClass string
+
+const (
+	AtesSimple      AtesClass = "simple"
+	AtesChallenging AtesClass = "challenging"
+	AtesComplex     AtesClass = "complex"
+)
+
+var atesRank = map[AtesClass]int{AtesSimple: 0, AtesChallenging: 1, AtesComplex: 2}
+
+// AtesThresholds configure the classification
+type AtesThresholds struct {
+	// continuous distance on slopes of at least ChallengingSlope or
+	// ComplexSlope degrees that makes a route challenging or complex
+	ChallengingSlope    float64 `json:"challenging_slope"`
+	ChallengingDistance float64 `json:"challenging_distance_m"`
+	ComplexSlope        float64 `json:"complex_slope"`
+	ComplexDistance     float64 `json:"complex_distance_m"`
+
+	// a terrain trap is a gully or depression at least TrapDepth below the
+	// ground TrapRadius away with a challenging slope above it.
+	// ComplexTrapCount traps make a route complex.
+	TrapDepth        float64 `json:"trap_depth_m"`
+	TrapRadius       float64 `json:"trap_radius_m"`
+	ComplexTrapCount int     `json:"complex_trap_count"`
+}
+
+// AtesSegment is a stretch of the route that raised its classification
+type AtesSegment struct {
+	Class         AtesClass `json:"class"`
+	Reason        string    `json:"reason"`
+	StartDistance float64   `json:"start_distance_m"`
+	EndDistance   float64   `json:"end_distance_m"`
+	MaxSlope      float64   `json:"max_slope"`
+	// Coordinates are [longitude, latitude] pairs, as in GeoJSON
+	Coordinates [][]float64 `json:"coordinates"`
+}
+
+// AtesReport is the classification of an activity or planned route
+type AtesReport struct {
+	Name       string         `json:"name"`
+	Class      AtesClass      `json:"class"`
+	Distance   float64        `json:"distance_m"`
+	MaxSlope   float64        `json:"max_slope"`
+	Coverage   float64        `json:"coverage"`
+	Thresholds AtesThresholds `json:"thresholds"`
+	Segments   []AtesSegment  `json:"segments"`
+}
+
+// DefaultAtesThresholds returns the default classification thresholds
+func DefaultAtesThresholds() AtesThresholds {
+	return AtesThresholds{
+		ChallengingSlope:    30,
+		ChallengingDistance: 100,
+		ComplexSlope:        35,
+		ComplexDistance:     250,
+		TrapDepth:           8,
+		TrapRadius:          60,
+		ComplexTrapCount:    3,
+	}
+}
+
+// ParseAtesThresholds overrides the default thresholds with the
+// challenging_slope, challenging_distance, complex_slope, complex_distance,
+// trap_depth, trap_radius and complex_trap_count parameters
+func ParseAtesThresholds(query url.Values) (AtesThresholds, error) {
+	thresholds := DefaultAtesThresholds()
+
+	parameters := map[string]*float64{
+		"challenging_slope":    &thresholds.ChallengingSlope,
+		"challenging_distance": &thresholds.ChallengingDistance,
+		"complex_slope":        &thresholds.ComplexSlope,
+		"complex_distance":     &thresholds.ComplexDistance,
+		"trap_depth":           &thresholds.TrapDepth,
+		"trap_radius":          &thresholds.TrapRadius,
+	}
+	for name, target := range parameters {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil || parsed <= 0 {
+				return thresholds, fmt.Errorf("%s must be a positive number", name)
+			}
+			*target = parsed
+		}
+	}
+
+	if value := query.Get("complex_trap_count"); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil || count <= 0 {
+			return thresholds, fmt.Errorf("complex_trap_count must be a positive integer")
+		}
+		thresholds.ComplexTrapCount = count
+	}
+
+	if thresholds.ChallengingSlope >= 90 || thresholds.ComplexSlope >= 90 {
+		return thresholds, fmt.Errorf("slopes must be less than 90 degrees")
+	}
+	if thresholds.ComplexSlope < thresholds.ChallengingSlope {
+		return thresholds, fmt.Errorf("complex_slope must be at least challenging_slope")
+	}
+	return thresholds, nil
+}
+
+// http request handlers
+
+// handleActivityAtes classifies the terrain of one of the authenticated
+// athlete's activities
+func (s *ServerState) handleActivityAtes(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if s.elevation == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Elevation model is not configured")
+	}
+
+	thresholds, err := ParseAtesThresholds(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	streamPoints, err := client.getActivityStream(strconv.Itoa(activity.Id))
+	if err != nil {
+		slog.Error("failed to fetch activity streams", "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch activity from strava")
+	}
+
+	report := classifyAtes(resampleRun(streamPoints, 0, routeSampleDistance), s.elevation, thresholds)
+	report.Name = activity.Name
+	return c.JSON(http.StatusOK, report)
+}
+
+// handleRouteAtes classifies the terrain of a planned route uploaded as GPX
+func (s *ServerState) handleRouteAtes(c echo.Context) error {
+	if _, err := s.AuthenticateRequest(c.Request()); err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if s.elevation == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Elevation model is not configured")
+	}
+
+	thresholds, err := ParseAtesThresholds(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	route, err := s.readRouteUpload(c)
+	if err != nil {
+		return err
+	}
+
+	report := classifyAtes(route.Points, s.elevation, thresholds)
+	report.Name = route.Name
+	return c.JSON(http.StatusOK, report)
+}
+
+// helpers
+
+// classifyAtes classifies a track resampled to even spacing. Long stretches
+// of steep slopes and terrain traps become segments and the track takes the
+// class of its worst segment.
+func classifyAtes(points []StravaStreamPoint, model *ElevationModel, thresholds AtesThresholds) AtesReport {
+	report := AtesReport{Class: AtesSimple, Thresholds: thresholds, Segments: []AtesSegment{}}
+	if len(points) < 2 {
+		return report
+	}
+
+	terrain := annotateTerrain(points, model)
+	distances := cumulativeDistances(points)
+	report.Distance = roundTo(distances[len(distances)-1], 1)
+
+	challenging := make([]bool, len(points))
+	complex := make([]bool, len(points))
+	traps := make([]bool, len(points))
+	covered := 0
+	for i, point := range terrain {
+		if !point.Ok {
+			continue
+		}
+		covered++
+		report.MaxSlope = math.Max(report.MaxSlope, point.Slope)
+		challenging[i] = point.Slope >= thresholds.ChallengingSlope
+		complex[i] = point.Slope >= thresholds.ComplexSlope
+		// gully floors are flatter than their sides, so only points that are
+		// not steep themselves are checked
+		if !challenging[i] {
+			traps[i] = isTerrainTrap(model, points[i], thresholds)
+		}
+	}
+	report.Coverage = roundTo(float64(covered)/float64(len(points)), 3)
+
+	segment := func(class AtesClass, run [2]int, reason string) AtesSegment {
+		end := min(run[1], len(points)-1)
+		result := AtesSegment{
+			Class:         class,
+			Reason:        reason,
+			StartDistance: roundTo(distances[run[0]], 1),
+			EndDistance:   roundTo(distances[end], 1),
+		}
+		for i := run[0]; i <= end; i++ {
+			result.MaxSlope = math.Max(result.MaxSlope, terrain[i].Slope)
+			result.Coordinates = append(result.Coordinates, []float64{points[i].Longitude, points[i].Latitude})
+		}
+		return result
+	}
+	runLength := func(run [2]int) float64 {
+		return distances[min(run[1], len(points)-1)] - distances[run[0]]
+	}
+
+	var complexRuns [][2]int
+	for _, run := range flagRuns(complex) {
+		if length := runLength(run); length >= thresholds.ComplexDistance {
+			complexRuns = append(complexRuns, run)
+			report.Segments = append(report.Segments, segment(AtesComplex, run,
+				fmt.Sprintf("%.0f m on slopes of %.0f° or more", length, thresholds.ComplexSlope)))
+		}
+	}
+
+	for _, run := range flagRuns(challenging) {
+		if overlapsAny(run, complexRuns) {
+			continue
+		}
+		if length := runLength(run); length >= thresholds.ChallengingDistance {
+			report.Segments = append(report.Segments, segment(AtesChallenging, run,
+				fmt.Sprintf("%.0f m on slopes of %.0f° or more", length, thresholds.ChallengingSlope)))
+		}
+	}
+
+	trapRuns := flagRuns(traps)
+	trapClass := AtesChallenging
+	if len(trapRuns) >= thresholds.ComplexTrapCount {
+		trapClass = AtesComplex
+	}
+	for _, run := range trapRuns {
+		report.Segments = append(report.Segments, segment(trapClass, run, "terrain trap below steep slopes"))
+	}
+
+	for _, segment := range report.Segments {
+		if atesRank[segment.Class] > atesRank[report.Class] {
+			report.Class = segment.Class
+		}
+	}
+	report.MaxSlope = roundTo(report.MaxSlope, 1)
+	return report
+}
+
+// isTerrainTrap reports whether a point lies at least TrapDepth below the
+// average ground TrapRadius around it with a challenging slope on one of
+// those sides
+func isTerrainTrap(model *ElevationModel, point StravaStreamPoint, thresholds AtesThresholds) bool {
+	center, ok := model.Elevation(point.Latitude, point.Longitude)
+	if !ok {
+		return false
+	}
+
+	dLat := thresholds.TrapRadius / earthRadiusMeters * 180 / math.Pi
+	dLon := dLat / math.Cos(point.Latitude*math.Pi/180)
+
+	var sum float64
+	steepAbove := false
+	for i := 0; i < 8; i++ {
+		bearing := float64(i) * math.Pi / 4
+		lat, lon := point.Latitude+dLat*math.Cos(bearing), point.Longitude+dLon*math.Sin(bearing)
+		elevation, ok := model.Elevation(lat, lon)
+		if !ok {
+			return false
+		}
+		sum += elevation
+
+		if elevation > center {
+			if slope, _, ok := model.SlopeAspect(lat, lon); ok && slope >= thresholds.ChallengingSlope {
+				steepAbove = true
+			}
+		}
+	}
+	return steepAbove && sum/8-center >= thresholds.TrapDepth
+}
+
+// flagRuns returns the [start, end) ranges of consecutive true values
+func flagRuns(flags []bool) [][2]int {
+	var runs [][2]int
+	for i := 0; i < len(flags); i++ {
+		if !flags[i] {
+			continue
+		}
+		start := i
+		for i < len(flags) && flags[i] {
+			i++
+		}
+		runs = append(runs, [2]int{start, i})
+	}
+	return runs
+}
+
+func overlapsAny(run [2]int, others [][2]int) bool {
+	for _, other := range others {
+		if run[0] < other[1] && other[0] < run[1] {
+			return true
+		}
+	}
+	return false
+}
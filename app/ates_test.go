@@ -0,0 +1,144 @@
+package app
+
+import (
+	"net/url"
+	"testing"
+)
+
+// northward returns a track heading north along a meridian from 46.2N
+func northward(lon float64) []StravaStreamPoint {
+	var points []StravaStreamPoint
+	for i := 0; i < 10; i++ {
+		points = append(points, StravaStreamPoint{Latitude: 46.2 + float64(i)*0.06, Longitude: lon, Time: float64(i) * 60})
+	}
+	return points
+}
+
+func TestParseAtesThresholds(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		wantErr bool
+	}{
+		{"defaults", "", false},
+		{"overrides", "challenging_slope=28&complex_distance=400&complex_trap_count=2", false},
+		{"not a number", "trap_depth=deep", true},
+		{"negative", "challenging_distance=-5", true},
+		{"complex below challenging", "complex_slope=25", true},
+		{"vertical", "complex_slope=90", true},
+		{"fractional trap count", "complex_trap_count=1.5", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, _ := url.ParseQuery(tt.query)
+			_, err := ParseAtesThresholds(query)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("expected error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	query, _ := url.ParseQuery("challenging_slope=28&complex_trap_count=2")
+	thresholds, _ := ParseAtesThresholds(query)
+	if thresholds.ChallengingSlope != 28 || thresholds.ComplexTrapCount != 2 || thresholds.ComplexSlope != 35 {
+		t.Errorf("unexpected thresholds %+v", thresholds)
+	}
+}
+
+func TestClassifyAtes(t *testing.T) {
+	dir := t.TempDir()
+	// a plane falling about 30° to the east, as in the terrain tests
+	writeHgtTile(t, dir, "N46E007.hgt", [9]int16{
+		30000, 7904, -14192,
+		30000, 7904, -14192,
+		30000, 7904, -14192,
+	})
+	// a valley running north along 8.5E
+	writeHgtTile(t, dir, "N46E008.hgt", [9]int16{
+		30000, 7904, 30000,
+		30000, 7904, 30000,
+		30000, 7904, 30000,
+	})
+	// flat ground
+	writeHgtTile(t, dir, "N46E009.hgt", [9]int16{})
+	model := NewElevationModel(dir)
+
+	// the tiles are coarse, so traps are measured on their scale
+	thresholds := DefaultAtesThresholds()
+	thresholds.TrapRadius = 20000
+	// the plane is just about 30° at these latitudes
+	thresholds.ChallengingSlope = 28
+
+	t.Run("steep slope", func(t *testing.T) {
+		report := classifyAtes(northward(7.5), model, thresholds)
+		if report.Class != AtesChallenging || len(report.Segments) != 1 {
+			t.Fatalf("expected one challenging segment, got %s with %+v", report.Class, report.Segments)
+		}
+		segment := report.Segments[0]
+		if segment.StartDistance != 0 || segment.EndDistance != report.Distance || len(segment.Coordinates) != 10 {
+			t.Errorf("expected the segment to cover the track, got %+v", segment)
+		}
+		if report.MaxSlope < 29 || report.MaxSlope >= 35 || report.Coverage != 1 {
+			t.Errorf("unexpected max slope %v and coverage %v", report.MaxSlope, report.Coverage)
+		}
+	})
+
+	t.Run("lower complex slope", func(t *testing.T) {
+		lowered := thresholds
+		lowered.ComplexSlope = 29
+		report := classifyAtes(northward(7.5), model, lowered)
+		if report.Class != AtesComplex || len(report.Segments) != 1 {
+			t.Errorf("expected one complex segment, got %s with %+v", report.Class, report.Segments)
+		}
+	})
+
+	t.Run("short steep stretch", func(t *testing.T) {
+		longer := thresholds
+		longer.ChallengingDistance = 100000
+		report := classifyAtes(northward(7.5), model, longer)
+		if report.Class != AtesSimple || len(report.Segments) != 0 {
+			t.Errorf("expected simple terrain, got %s with %+v", report.Class, report.Segments)
+		}
+	})
+
+	t.Run("terrain trap", func(t *testing.T) {
+		report := classifyAtes(northward(8.5), model, thresholds)
+		if report.Class != AtesChallenging || len(report.Segments) != 1 || report.Segments[0].Reason != "terrain trap below steep slopes" {
+			t.Fatalf("expected a terrain trap, got %s with %+v", report.Class, report.Segments)
+		}
+
+		single := thresholds
+		single.ComplexTrapCount = 1
+		if report := classifyAtes(northward(8.5), model, single); report.Class != AtesComplex {
+			t.Errorf("expected complex terrain, got %s", report.Class)
+		}
+	})
+
+	t.Run("flat", func(t *testing.T) {
+		report := classifyAtes(northward(9.5), model, thresholds)
+		if report.Class != AtesSimple || len(report.Segments) != 0 || report.MaxSlope != 0 {
+			t.Errorf("expected simple terrain, got %+v", report)
+		}
+	})
+
+	t.Run("outside the tiles", func(t *testing.T) {
+		report := classifyAtes(northward(10.5), model, thresholds)
+		if report.Class != AtesSimple || report.Coverage != 0 {
+			t.Errorf("expected uncovered simple terrain, got %+v", report)
+		}
+	})
+}
+
+func TestFlagRuns(t *testing.T) {
+	runs := flagRuns([]bool{true, true, false, false, true, false, true})
+	expected := [][2]int{{0, 2}, {4, 5}, {6, 7}}
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, runs)
+	}
+	for i := range runs {
+		if runs[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, runs)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package app
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// backfillLockTTL is how long a backfill holds the athlete's slot after
+	// its last heartbeat, so a backfill that died with its server does not
+	// block the next one for long
+	backfillLockTTL = 5 * time.Minute
+
+	// backfillHeartbeatInterval is how often a running backfill renews the
+	// athlete's slot
+	backfillHeartbeatInterval = time.Minute
+)
+
+type BackfillStatus string
+
+const (
+	BackfillRunning  BackfillStatus = "running"
+	BackfillComplete BackfillStatus = "complete"
+	BackfillFailed   BackfillStatus = "failed"
+)
+
+// BackfillJob adds an athlete's past activities to their history, which the
+// processing pipeline otherwise only builds from activities strava reports
+// after the athlete connected
+type BackfillJob struct {
+	AthleteId int            `json:"athlete_id"`
+	Status    BackfillStatus `json:"status"`
+	// Total counts the past activities that count towards the history
+	Total       int        `json:"total"`
+	Completed   int        `json:"completed"`
+	Failed      int        `json:"failed"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// http request handlers
+
+// handleBackfillStart starts adding the authenticated athlete's past
+// activities to their history
+func (s *ServerState) handleBackfillStart(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	job, started, err := s.startBackfill(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to start backfill", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start backfill")
+	}
+	if !started {
+		return echo.NewHTTPError(http.StatusConflict, "A backfill is already in progress")
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// handleBackfillStatus reports the progress of the authenticated athlete's
+// latest backfill
+func (s *ServerState) handleBackfillStatus(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	job, err := s.store.FetchBackfillJob(tokenInfo.athleteId)
+	if err == redis.Nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No backfill has run")
+	}
+	if err != nil {
+		slog.Error("failed to fetch backfill", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch backfill")
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// background job
+
+// startBackfill claims the athlete's backfill slot and starts the backfill,
+// returning false when one is already running
+func (s *ServerState) startBackfill(athleteId int) (BackfillJob, bool, error) {
+	claimed, err := s.store.ClaimBackfill(athleteId)
+	if err != nil || !claimed {
+		return BackfillJob{}, false, err
+	}
+
+	job := BackfillJob{AthleteId: athleteId, Status: BackfillRunning, StartedAt: time.Now().UTC()}
+	if err := s.store.SaveBackfillJob(job); err != nil {
+		s.store.ReleaseBackfill(athleteId)
+		return BackfillJob{}, false, err
+	}
+
+	slog.Info("starting backfill", "athlete_id", athleteId)
+	go s.runBackfill(context.Background(), job)
+	return job, true, nil
+}
+
+// startInitialBackfill starts a backfill for athletes who never had one, such
+// as when they first connect
+func (s *ServerState) startInitialBackfill(athleteId int) {
+	_, err := s.store.FetchBackfillJob(athleteId)
+	if err == nil {
+		return
+	}
+	if err == redis.Nil {
+		_, _, err = s.startBackfill(athleteId)
+	}
+	if err != nil {
+		slog.Error("failed to start initial backfill", "athlete_id", athleteId, "err", err)
+	}
+}
+
+// runBackfill processes the athlete's past activities oldest first, so that
+// records are set in the order they were achieved. Requests wait for spare
+// rate limit capacity so the backfill does not starve webhook processing.
+func (s *ServerState) runBackfill(ctx context.Context, job BackfillJob) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go s.backfillHeartbeat(ctx, job.AthleteId)
+	defer func() {
+		if err := s.store.ReleaseBackfill(job.AthleteId); err != nil {
+			slog.Error("failed to release backfill", "athlete_id", job.AthleteId, "err", err)
+		}
+	}()
+
+	fail := func(err error) {
+		slog.Error("backfill failed", "athlete_id", job.AthleteId, "err", err)
+		job.Status = BackfillFailed
+		job.Error = err.Error()
+		if err := s.store.SaveBackfillJob(job); err != nil {
+			slog.Error("failed to save backfill", "athlete_id", job.AthleteId, "err", err)
+		}
+	}
+
+	listed, err := s.listAllActivities(ctx, job.AthleteId)
+	if err != nil {
+		fail(err)
+		return
+	}
+	activities := backfillActivities(listed)
+	job.Total = len(activities)
+	if err := s.store.SaveBackfillJob(job); err != nil {
+		fail(err)
+		return
+	}
+
+	for _, activity := range activities {
+		if err := stravaRateLimits.WaitForBackgroundCapacity(ctx); err != nil {
+			fail(err)
+			return
+		}
+		// fetch the client for every activity since long backfills can
+		// outlive the access token
+		client, err := s.athleteClient(job.AthleteId)
+		if err != nil {
+			fail(err)
+			return
+		}
+
+		if err := s.backfillActivity(client, activity); err != nil {
+			slog.Warn("backfill: failed to process activity", "athlete_id", job.AthleteId, "activity_id", activity.Id, "err", err)
+			job.Failed++
+		} else {
+			job.Completed++
+		}
+		if err := s.store.SaveBackfillJob(job); err != nil {
+			slog.Error("failed to save backfill progress", "athlete_id", job.AthleteId, "err", err)
+		}
+	}
+
+	recordsLock.Lock()
+	_, err = s.rebuildRecords(job.AthleteId)
+	recordsLock.Unlock()
+	if err != nil {
+		fail(err)
+		return
+	}
+	if err := s.invalidateHeatmap(job.AthleteId); err != nil {
+		fail(err)
+		return
+	}
+
+	completedAt := time.Now().UTC()
+	job.Status = BackfillComplete
+	job.CompletedAt = &completedAt
+	if err := s.store.SaveBackfillJob(job); err != nil {
+		slog.Error("failed to save backfill", "athlete_id", job.AthleteId, "err", err)
+		return
+	}
+	slog.Info("backfill complete", "athlete_id", job.AthleteId, "completed", job.Completed, "failed", job.Failed)
+}
+
+// backfillActivity adds a past activity's efforts, heatmap track and season
+// contribution to the athlete's history. Records are rebuilt and heatmap
+// tiles invalidated once the backfill is done, and no record events are
+// emitted for past activities. The summary is stored for activities without
+// one, so that deleting the activity later removes what the backfill added.
+func (s *ServerState) backfillActivity(client StravaClient, activity StravaActivity) error {
+	summary, streamPoints, err := s.summarizeActivity(client, activity)
+	if err != nil {
+		return err
+	}
+
+	previous, err := s.store.FetchActivitySummary(strconv.Itoa(activity.Id))
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	// activities already counted towards a season are left as they are
+	switch {
+	case previous == nil:
+		if err := s.updateSeasons(nil, &summary, activity); err != nil {
+			return err
+		}
+		if err := s.store.SaveActivitySummary(summary); err != nil {
+			return err
+		}
+	case previous.Season == "":
+		if err := s.updateSeasons(previous, &summary, activity); err != nil {
+			return err
+		}
+		if summary.Season != "" {
+			previous.Season, previous.SeasonHemisphere = summary.Season, summary.SeasonHemisphere
+			if err := s.store.SaveActivitySummary(*previous); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := s.store.SaveActivityEfforts(activity.Athlete.Id, FindEfforts(activity, summary, streamPoints)); err != nil {
+		return err
+	}
+
+	track := newHeatmapTrack(activity.Id, streamPoints)
+	if len(track.Segments) == 0 {
+		return nil
+	}
+	return s.store.SaveHeatmapTrack(activity.Athlete.Id, track)
+}
+
+// backfillHeartbeat renews the athlete's backfill slot until the backfill
+// returns
+func (s *ServerState) backfillHeartbeat(ctx context.Context, athleteId int) {
+	ticker := time.NewTicker(backfillHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.store.RenewBackfill(athleteId); err != nil {
+				slog.Warn("failed to renew backfill", "athlete_id", athleteId, "err", err)
+			}
+		}
+	}
+}
+
+// helpers
+
+// backfillActivities returns the activities that count towards the history,
+// the same as for seasons, oldest first
+func backfillActivities(activities []StravaActivity) []StravaActivity {
+	var result []StravaActivity
+	for _, activity := range activities {
+		if seasonActivityTypes[activity.Type] {
+			result = append(result, activity)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].StartDate < result[j].StartDate })
+	return result
+}
+
+// historyComplete reports whether the athlete's past activities have been
+// backfilled, before which records cannot tell new bests from old ones
+func (s *ServerState) historyComplete(athleteId int) (bool, error) {
+	job, err := s.store.FetchBackfillJob(athleteId)
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return job.Status == BackfillComplete, nil
+}
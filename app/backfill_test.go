@@ -0,0 +1,22 @@
+package app
+
+import "testing"
+
+func TestBackfillActivities(t *testing.T) {
+	activities := []StravaActivity{
+		{Id: 3, Type: "BackcountrySki", StartDate: "2025-02-14T12:30:00Z"},
+		{Id: 2, Type: "Run", StartDate: "2024-12-01T08:00:00Z"},
+		{Id: 1, Type: "BackcountrySki", StartDate: "2024-01-20T08:00:00Z"},
+		{Id: 4, Type: "Snowshoe", StartDate: "2024-12-24T09:00:00Z"},
+	}
+
+	result := backfillActivities(activities)
+	if len(result) != 3 {
+		t.Fatalf("expected the 3 ski tours, got %d activities", len(result))
+	}
+	for i, expected := range []int{1, 4, 3} {
+		if result[i].Id != expected {
+			t.Errorf("position %d: expected activity %d, got %d", i, expected, result[i].Id)
+		}
+	}
+}
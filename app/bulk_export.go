@@ -0,0 +1,480 @@
+package app
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// exportJobTTL is how long job state and archives are kept
+	exportJobTTL = 24 * time.Hour
+
+	// exportDownloadTTL is how long a download link stays valid
+	exportDownloadTTL = time.Hour
+
+	exportListPageSize = 100
+
+	// exportHeartbeatInterval is how often a running job renews its
+	// heartbeat, and exportHeartbeatTTL how long after the last one a job
+	// is taken to have died with the server that ran it
+	exportHeartbeatInterval = time.Minute
+	exportHeartbeatTTL      = 5 * time.Minute
+)
+
+type ExportJobStatus string
+
+const (
+	ExportJobPending  ExportJobStatus = "pending"
+	ExportJobRunning  ExportJobStatus = "running"
+	ExportJobComplete ExportJobStatus = "complete"
+	ExportJobFailed   ExportJobStatus = "failed"
+)
+
+// ExportJob is a background export of all of an athlete's activities into a
+// single zip archive
+type ExportJob struct {
+	Id        string       `json:"id"`
+	AthleteId int          `json:"athlete_id"`
+	Format    ExportFormat `json:"format"`
+	// HideFromHome applies strava's hide_from_home flag, for archives that
+	// will be shared
+	HideFromHome bool            `json:"hide_from_home"`
+	Status       ExportJobStatus `json:"status"`
+	Total        int             `json:"total"`
+	Completed    int             `json:"completed"`
+	Failed       int             `json:"failed"`
+	Error        string          `json:"error,omitempty"`
+	CreatedAt    time.Time       `json:"created_at"`
+	CompletedAt  *time.Time      `json:"completed_at,omitempty"`
+}
+
+type exportJobResponse struct {
+	ExportJob
+	DownloadUrl       string     `json:"download_url,omitempty"`
+	DownloadExpiresAt *time.Time `json:"download_expires_at,omitempty"`
+}
+
+// http request handlers
+
+// handleExportStart starts a bulk export job for the authenticated athlete
+func (s *ServerState) handleExportStart(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	formatName := c.QueryParam("format")
+	if formatName == "" {
+		formatName = string(ExportFormatGpx)
+	}
+	format, err := ParseExportFormat(formatName)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	job := ExportJob{
+		Id:           randomString(16),
+		AthleteId:    tokenInfo.athleteId,
+		Format:       format,
+		HideFromHome: c.QueryParam("hide_from_home") == "true",
+		Status:       ExportJobPending,
+		CreatedAt:    time.Now().UTC(),
+	}
+
+	// only one export per athlete may run at a time
+	claimed, err := s.claimExportSlot(job)
+	if err != nil {
+		slog.Error("failed to claim export slot", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start export")
+	}
+	if !claimed {
+		return echo.NewHTTPError(http.StatusConflict, "An export is already in progress")
+	}
+
+	err = s.store.SaveExportJob(job)
+	if err == nil {
+		err = s.store.TouchExportJob(job.Id)
+	}
+	if err != nil {
+		slog.Error("failed to save export job", "athlete_id", tokenInfo.athleteId, "err", err)
+		if err := s.store.ReleaseExportJob(job.AthleteId, job.Id); err != nil {
+			slog.Error("failed to release export slot", "athlete_id", tokenInfo.athleteId, "err", err)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start export")
+	}
+
+	slog.Info("starting export job", "job_id", job.Id, "athlete_id", job.AthleteId, "format", job.Format)
+	go s.runExportJob(context.Background(), job)
+
+	return c.JSON(http.StatusAccepted, s.exportJobResponse(job))
+}
+
+// handleExportStatus reports the progress of a bulk export job, including a
+// download link once the archive is ready
+func (s *ServerState) handleExportStatus(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	job, err := s.store.FetchExportJob(c.Param("id"))
+	if err == redis.Nil || (err == nil && job.AthleteId != tokenInfo.athleteId) {
+		return echo.NewHTTPError(http.StatusNotFound, "Export not found")
+	}
+	if err != nil {
+		slog.Error("failed to fetch export job", "job_id", c.Param("id"), "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch export")
+	}
+	if err := s.failOrphanedExportJob(job); err != nil {
+		slog.Error("failed to check export job", "job_id", job.Id, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch export")
+	}
+
+	return c.JSON(http.StatusOK, s.exportJobResponse(*job))
+}
+
+// handleExportDownload serves a finished archive. The signed link is the
+// credential so it can be opened directly in a browser.
+func (s *ServerState) handleExportDownload(c echo.Context) error {
+	jobId := c.Param("id")
+	expires, err := strconv.ParseInt(c.QueryParam("expires"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid download link")
+	}
+
+	if !verifyExportDownload(jobId, expires, c.QueryParam("signature"), s.config.Secret) {
+		return echo.NewHTTPError(http.StatusForbidden, "Invalid download link")
+	}
+	if time.Now().Unix() > expires {
+		return echo.NewHTTPError(http.StatusGone, "Download link has expired")
+	}
+
+	job, err := s.store.FetchExportJob(jobId)
+	if err == redis.Nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Export not found")
+	}
+	if err != nil {
+		slog.Error("failed to fetch export job", "job_id", jobId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch export")
+	}
+	if job.Status != ExportJobComplete {
+		return echo.NewHTTPError(http.StatusConflict, "Export is not complete")
+	}
+
+	archivePath := s.exportArchivePath(job.Id)
+	if _, err := os.Stat(archivePath); err != nil {
+		slog.Error("export archive missing", "job_id", job.Id, "path", archivePath, "err", err)
+		return echo.NewHTTPError(http.StatusNotFound, "Export archive not found")
+	}
+
+	filename := fmt.Sprintf("strava-%d-%s-%s.zip", job.AthleteId, job.Format, job.CreatedAt.Format("20060102"))
+	return c.Attachment(archivePath, filename)
+}
+
+// background job
+
+// runExportJob exports every activity of the athlete into a zip archive with
+// a manifest. Requests wait for spare rate limit capacity so a large export
+// does not starve webhook processing, and a heartbeat is kept up meanwhile
+// so the job is not taken for orphaned.
+func (s *ServerState) runExportJob(ctx context.Context, job ExportJob) {
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go s.exportHeartbeat(ctx, job.Id)
+
+	fail := func(err error) {
+		slog.Error("export job failed", "job_id", job.Id, "athlete_id", job.AthleteId, "err", err)
+		job.Status = ExportJobFailed
+		job.Error = err.Error()
+		if err := s.store.SaveExportJob(job); err != nil {
+			slog.Error("failed to save export job", "job_id", job.Id, "err", err)
+		}
+	}
+
+	job.Status = ExportJobRunning
+	if err := s.store.SaveExportJob(job); err != nil {
+		fail(err)
+		return
+	}
+
+	s.removeExpiredArchives()
+
+	activities, err := s.listAllActivities(ctx, job.AthleteId)
+	if err != nil {
+		fail(err)
+		return
+	}
+	job.Total = len(activities)
+	if err := s.store.SaveExportJob(job); err != nil {
+		fail(err)
+		return
+	}
+
+	if err := os.MkdirAll(s.config.ExportDir, 0755); err != nil {
+		fail(fmt.Errorf("error creating export directory: %w", err))
+		return
+	}
+	archive, err := os.Create(s.exportArchivePath(job.Id))
+	if err != nil {
+		fail(fmt.Errorf("error creating export archive: %w", err))
+		return
+	}
+	defer archive.Close()
+
+	zipWriter := zip.NewWriter(archive)
+	manifest := [][]string{{"activity_id", "name", "type", "start_date", "distance_m", "elevation_gain_m", "filename", "status", "error"}}
+
+	for _, summary := range activities {
+		row := []string{
+			strconv.Itoa(summary.Id),
+			summary.Name,
+			summary.Type,
+			summary.StartDate,
+			strconv.FormatFloat(summary.Distance, 'f', 1, 64),
+			strconv.FormatFloat(float64(summary.ElevationGain), 'f', 1, 64),
+		}
+
+		filename := job.Format.Filename(summary.Id)
+		data, err := s.exportForJob(ctx, job, summary.Id)
+		if err == nil {
+			var entry io.Writer
+			entry, err = zipWriter.Create(filename)
+			if err == nil {
+				_, err = entry.Write(data)
+			}
+		}
+
+		if errors.Is(err, context.Canceled) {
+			fail(err)
+			return
+		}
+		if err != nil {
+			slog.Warn("export job: failed to export activity", "job_id", job.Id, "activity_id", summary.Id, "err", err)
+			job.Failed++
+			row = append(row, "", "failed", err.Error())
+		} else {
+			job.Completed++
+			row = append(row, filename, "ok", "")
+		}
+		manifest = append(manifest, row)
+
+		if err := s.store.SaveExportJob(job); err != nil {
+			slog.Error("failed to save export job progress", "job_id", job.Id, "err", err)
+		}
+	}
+
+	manifestEntry, err := zipWriter.Create("manifest.csv")
+	if err != nil {
+		fail(fmt.Errorf("error writing manifest: %w", err))
+		return
+	}
+	if err := csv.NewWriter(manifestEntry).WriteAll(manifest); err != nil {
+		fail(fmt.Errorf("error writing manifest: %w", err))
+		return
+	}
+	if err := zipWriter.Close(); err != nil {
+		fail(fmt.Errorf("error finalizing archive: %w", err))
+		return
+	}
+
+	completedAt := time.Now().UTC()
+	job.Status = ExportJobComplete
+	job.CompletedAt = &completedAt
+	if err := s.store.SaveExportJob(job); err != nil {
+		slog.Error("failed to save export job", "job_id", job.Id, "err", err)
+		return
+	}
+	slog.Info("export job complete", "job_id", job.Id, "athlete_id", job.AthleteId, "completed", job.Completed, "failed", job.Failed)
+}
+
+// listAllActivities pages through the athlete's activity list
+func (s *ServerState) listAllActivities(ctx context.Context, athleteId int) ([]StravaActivity, error) {
+	var activities []StravaActivity
+	for page := 1; ; page++ {
+		if err := stravaRateLimits.WaitForBackgroundCapacity(ctx); err != nil {
+			return nil, err
+		}
+
+		// fetch the client for every page since long exports can outlive
+		// the access token
+		client, err := s.athleteClient(athleteId)
+		if err != nil {
+			return nil, err
+		}
+
+		pageActivities, err := client.ListActivities(page, exportListPageSize)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, pageActivities...)
+		if len(pageActivities) < exportListPageSize {
+			return activities, nil
+		}
+	}
+}
+
+// exportForJob fetches the detailed activity, for its laps, and encodes it
+func (s *ServerState) exportForJob(ctx context.Context, job ExportJob, activityId int) ([]byte, error) {
+	if err := stravaRateLimits.WaitForBackgroundCapacity(ctx); err != nil {
+		return nil, err
+	}
+	client, err := s.athleteClient(job.AthleteId)
+	if err != nil {
+		return nil, err
+	}
+	activity, err := client.GetActivity(strconv.Itoa(activityId))
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := NewGpxMetadata(activity)
+	if err != nil {
+		return nil, err
+	}
+
+	privacy, err := s.store.FetchPrivacySettings(job.AthleteId)
+	if err != nil {
+		return nil, err
+	}
+	metadata.Privacy = privacy.Options(job.HideFromHome)
+
+	if err := stravaRateLimits.WaitForBackgroundCapacity(ctx); err != nil {
+		return nil, err
+	}
+	return client.ExportActivity(activity, job.Format, metadata)
+}
+
+// exportHeartbeat renews a job's heartbeat until the job returns
+func (s *ServerState) exportHeartbeat(ctx context.Context, jobId string) {
+	ticker := time.NewTicker(exportHeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.store.TouchExportJob(jobId); err != nil {
+				slog.Warn("failed to renew export job heartbeat", "job_id", jobId, "err", err)
+			}
+		}
+	}
+}
+
+// helpers
+
+// claimExportSlot makes the job the athlete's current export, taking the
+// place of a finished or orphaned one. The slot is claimed atomically so that
+// concurrent requests cannot both start an export.
+func (s *ServerState) claimExportSlot(job ExportJob) (bool, error) {
+	claimed, err := s.store.ClaimExportJob(job.AthleteId, job.Id)
+	if err != nil || claimed {
+		return claimed, err
+	}
+
+	current, err := s.store.FetchAthleteExportJob(job.AthleteId)
+	if err == redis.Nil {
+		// the current job expired meanwhile
+		return s.store.ClaimExportJob(job.AthleteId, job.Id)
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := s.failOrphanedExportJob(current); err != nil {
+		return false, err
+	}
+	if current.Status == ExportJobPending || current.Status == ExportJobRunning {
+		return false, nil
+	}
+	return s.store.ReplaceExportJob(job.AthleteId, current.Id, job.Id)
+}
+
+// failOrphanedExportJob marks a pending or running job as failed once its
+// heartbeat has expired, such as when the server restarted during the
+// export, so the athlete can start a new one
+func (s *ServerState) failOrphanedExportJob(job *ExportJob) error {
+	if job.Status != ExportJobPending && job.Status != ExportJobRunning {
+		return nil
+	}
+	alive, err := s.store.IsExportJobAlive(job.Id)
+	if err != nil || alive {
+		return err
+	}
+
+	slog.Warn("export job orphaned", "job_id", job.Id, "athlete_id", job.AthleteId)
+	job.Status = ExportJobFailed
+	job.Error = "export was interrupted"
+	return s.store.SaveExportJob(*job)
+}
+
+func (s *ServerState) exportArchivePath(jobId string) string {
+	return filepath.Join(s.config.ExportDir, fmt.Sprintf("%s.zip", jobId))
+}
+
+// removeExpiredArchives deletes archives whose job state has expired
+func (s *ServerState) removeExpiredArchives() {
+	entries, err := os.ReadDir(s.config.ExportDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < exportJobTTL {
+			continue
+		}
+		path := filepath.Join(s.config.ExportDir, entry.Name())
+		if err := os.Remove(path); err != nil {
+			slog.Warn("failed to remove expired export archive", "path", path, "err", err)
+		}
+	}
+}
+
+func (s *ServerState) exportJobResponse(job ExportJob) exportJobResponse {
+	response := exportJobResponse{ExportJob: job}
+	if job.Status != ExportJobComplete {
+		return response
+	}
+
+	expiresAt := time.Now().Add(exportDownloadTTL).UTC().Truncate(time.Second)
+	downloadUrl, err := url.JoinPath(s.config.BaseUrl, "api/exports", job.Id, "download")
+	if err != nil {
+		slog.Error("error building download url", "base_url", s.config.BaseUrl, "err", err)
+		return response
+	}
+
+	query := url.Values{}
+	query.Set("expires", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("signature", signExportDownload(job.Id, expiresAt.Unix(), s.config.Secret))
+	response.DownloadUrl = downloadUrl + "?" + query.Encode()
+	response.DownloadExpiresAt = &expiresAt
+	return response
+}
+
+// signExportDownload signs a job id and expiry for a time limited download
+// link
+func signExportDownload(jobId string, expires int64, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "export-download:%s:%d", jobId, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyExportDownload(jobId string, expires int64, signature string, secret string) bool {
+	expected := signExportDownload(jobId, expires, secret)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
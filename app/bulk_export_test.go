@@ -0,0 +1,113 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestExportDownloadSignature(t *testing.T) {
+	expires := time.Now().Add(time.Hour).Unix()
+	signature := signExportDownload("job-1", expires, testSecret)
+
+	if !verifyExportDownload("job-1", expires, signature, testSecret) {
+		t.Error("expected signature to verify")
+	}
+	if verifyExportDownload("job-2", expires, signature, testSecret) {
+		t.Error("expected signature for another job to be rejected")
+	}
+	if verifyExportDownload("job-1", expires+1, signature, testSecret) {
+		t.Error("expected signature with modified expiry to be rejected")
+	}
+	if verifyExportDownload("job-1", expires, signature, strings.Repeat("ab", 32)) {
+		t.Error("expected signature with another secret to be rejected")
+	}
+}
+
+func TestExportJobResponse_DownloadUrl(t *testing.T) {
+	s := &ServerState{config: Config{BaseUrl: "https://skintrackr.example", Secret: testSecret}}
+
+	pending := s.exportJobResponse(ExportJob{Id: "job-1", Status: ExportJobRunning})
+	if pending.DownloadUrl != "" {
+		t.Errorf("expected no download url for running job, got %q", pending.DownloadUrl)
+	}
+
+	complete := s.exportJobResponse(ExportJob{Id: "job-1", Status: ExportJobComplete})
+	parsed, err := url.Parse(complete.DownloadUrl)
+	if err != nil {
+		t.Fatalf("invalid download url %q: %v", complete.DownloadUrl, err)
+	}
+	if parsed.Path != "/api/exports/job-1/download" {
+		t.Errorf("unexpected download path %q", parsed.Path)
+	}
+
+	expires, err := strconv.ParseInt(parsed.Query().Get("expires"), 10, 64)
+	if err != nil {
+		t.Fatalf("invalid expires parameter: %v", err)
+	}
+	if !verifyExportDownload("job-1", expires, parsed.Query().Get("signature"), testSecret) {
+		t.Error("expected download url to carry a valid signature")
+	}
+}
+
+func TestHandleExportDownload_InvalidLinks(t *testing.T) {
+	past := time.Now().Add(-time.Minute).Unix()
+
+	tests := []struct {
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{name: "missing expiry", query: "signature=abc", expectedStatus: http.StatusBadRequest},
+		{name: "bad signature", query: "expires=" + strconv.FormatInt(time.Now().Unix()+60, 10) + "&signature=abc", expectedStatus: http.StatusForbidden},
+		{name: "expired link", query: "expires=" + strconv.FormatInt(past, 10) + "&signature=" + signExportDownload("job-1", past, testSecret), expectedStatus: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodGet, "/api/exports/job-1/download?"+tt.query, nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("id")
+			c.SetParamValues("job-1")
+
+			s := &ServerState{config: Config{Secret: testSecret}}
+			err := s.handleExportDownload(c)
+
+			httpErr, ok := err.(*echo.HTTPError)
+			if !ok {
+				t.Fatalf("expected *echo.HTTPError, got %T", err)
+			}
+			if httpErr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, httpErr.Code)
+			}
+		})
+	}
+}
+
+func TestHandleExportStart_MissingAuthHeader(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/exports?format=gpx", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	s := &ServerState{}
+	err := s.handleExportStart(c)
+
+	httpErr, ok := err.(*echo.HTTPError)
+	if !ok {
+		t.Fatalf("expected *echo.HTTPError, got %T", err)
+	}
+	if httpErr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, httpErr.Code)
+	}
+}
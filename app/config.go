@@ -4,7 +4,10 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"log/slog"
+	"math"
 	"os"
+	"path/filepath"
+	"strconv"
 )
 
 type Config struct {
@@ -14,6 +17,20 @@ type Config struct {
 	VerifyToken        string
 	UpstashRedisUrl    string
 	Secret             string
+	ExportDir          string
+	TileCacheDir       string
+	// DemDir holds SRTM .hgt and GeoTIFF elevation tiles, empty to disable
+	// elevation correction
+	DemDir string
+	// Seasons holds the season boundaries of each hemisphere
+	Seasons SeasonCalendar
+	// PeaksFile is a GeoJSON or CSV file of named peaks, empty to disable
+	// summit detection
+	PeaksFile string
+	Summits   SummitOptions
+	// ExcludeLiftVertical leaves lift rides out of laps, skinning vertical
+	// and everything built from them
+	ExcludeLiftVertical bool
 }
 
 func randomString(byteLength int) string {
@@ -46,12 +63,81 @@ func LoadConfig() Config {
 		slog.Error("UPSTASH_REDIS_URL environment variable must be set")
 		panic("invalid configuration")
 	}
+	exportDir := os.Getenv("EXPORT_DIR")
+	if exportDir == "" {
+		exportDir = filepath.Join(os.TempDir(), "skintrackr-exports")
+	}
+
+	tileCacheDir := os.Getenv("TILE_CACHE_DIR")
+	if tileCacheDir == "" {
+		tileCacheDir = filepath.Join(os.TempDir(), "skintrackr-tiles")
+	}
+
+	seasons := SeasonCalendar{}
+	for _, hemisphere := range []struct {
+		env      string
+		fallback string
+		target   *SeasonStart
+	}{
+		{"SEASON_START_NORTH", "09-01", &seasons.North},
+		{"SEASON_START_SOUTH", "01-01", &seasons.South},
+	} {
+		value := os.Getenv(hemisphere.env)
+		if value == "" {
+			value = hemisphere.fallback
+		}
+		start, err := ParseSeasonStart(value)
+		if err != nil {
+			slog.Error("invalid season start", "env", hemisphere.env, "value", value, "err", err)
+			panic("invalid configuration")
+		}
+		*hemisphere.target = start
+	}
+
+	summits := DefaultSummitOptions()
+	for _, option := range []struct {
+		env    string
+		max    float64
+		target *float64
+	}{
+		{"SUMMIT_RADIUS_M", maxSummitRadius, &summits.Radius},
+		{"SUMMIT_ELEVATION_TOLERANCE_M", math.Inf(1), &summits.ElevationTolerance},
+	} {
+		value := os.Getenv(option.env)
+		if value == "" {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil || number <= 0 || number > option.max {
+			slog.Error("invalid summit option", "env", option.env, "value", value)
+			panic("invalid configuration")
+		}
+		*option.target = number
+	}
+
+	excludeLiftVertical := false
+	if value := os.Getenv("EXCLUDE_LIFT_VERTICAL"); value != "" {
+		exclude, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Error("EXCLUDE_LIFT_VERTICAL must be true or false", "value", value)
+			panic("invalid configuration")
+		}
+		excludeLiftVertical = exclude
+	}
+
 	return Config{
-		BaseUrl:            baseUrl,
-		StravaClientId:     clientId,
-		StravaClientSecret: clientSecret,
-		VerifyToken:        randomString(16),
-		UpstashRedisUrl:    upstashRedisUrl,
-		Secret:             secret,
+		BaseUrl:             baseUrl,
+		StravaClientId:      clientId,
+		StravaClientSecret:  clientSecret,
+		VerifyToken:         randomString(16),
+		UpstashRedisUrl:     upstashRedisUrl,
+		Secret:              secret,
+		ExportDir:           exportDir,
+		TileCacheDir:        tileCacheDir,
+		DemDir:              os.Getenv("DEM_DIR"),
+		Seasons:             seasons,
+		PeaksFile:           os.Getenv("PEAKS_FILE"),
+		Summits:             summits,
+		ExcludeLiftVertical: excludeLiftVertical,
 	}
 }
@@ -0,0 +1,630 @@
+package app
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// hgtVoid marks missing samples in SRTM tiles
+	hgtVoid = -32768
+
+	// defaultDemBlendWeight is the share of the DEM elevation when blending
+	defaultDemBlendWeight = 0.5
+
+	// terrainSampleSpacing is the distance between the elevations slope and
+	// aspect are computed from, about the resolution of 1 arc-second SRTM
+	terrainSampleSpacing = 30.0
+
+	// demTileCacheSize is how many tiles the elevation model keeps in
+	// memory. A 1 arc-second SRTM tile takes about 52 MB.
+	demTileCacheSize = 8
+)
+
+type ElevationMode string
+
+const (
+	// ElevationModeReplace replaces stream altitude with the DEM elevation
+	ElevationModeReplace ElevationMode = "dem"
+	// ElevationModeBlend averages stream altitude and the DEM elevation
+	ElevationModeBlend ElevationMode = "blend"
+)
+
+// ElevationCorrection controls how stream altitude is corrected from a
+// digital elevation model. A nil Model disables correction.
+type ElevationCorrection struct {
+	Model *ElevationModel
+	Mode  ElevationMode
+	// BlendWeight is the share of the DEM elevation in blend mode. Zero
+	// means defaultDemBlendWeight.
+	BlendWeight float64
+}
+
+// ElevationModel reads elevations from SRTM .hgt and uncompressed GeoTIFF
+// tiles in a local directory. Tiles are loaded on first use and the most
+// recently used ones are cached.
+type ElevationModel struct {
+	dir string
+
+	// mu guards the cache but not the tiles, which are not modified once
+	// loaded, so lookups are not serialized while sampling
+	mu    sync.Mutex
+	tiles map[string]*list.Element
+	lru   *list.List
+
+	// GeoTIFFs are not named by location so the directory is scanned once
+	// for their bounds
+	geoTiffsOnce sync.Once
+	geoTiffs     []*geoTiff
+}
+
+// demCacheEntry is a cached tile, loaded once by the first lookup that
+// needs it
+type demCacheEntry struct {
+	key  string
+	once sync.Once
+	tile *demTile
+}
+
+// demGrid is the layout of a grid of elevation samples. originLat and
+// originLon are the position of the first sample, the north-west corner of
+// the grid.
+type demGrid struct {
+	originLat float64
+	originLon float64
+	stepLat   float64
+	stepLon   float64
+	width     int
+	height    int
+}
+
+// demTile is a grid of elevation samples
+type demTile struct {
+	demGrid
+	// values are row major from north to south, NaN where missing
+	values []float32
+}
+
+func NewElevationModel(dir string) *ElevationModel {
+	return &ElevationModel{
+		dir:   dir,
+		tiles: map[string]*list.Element{},
+		lru:   list.New(),
+	}
+}
+
+// Elevation returns the interpolated elevation in meters at a point, and
+// false when no tile covers it
+func (m *ElevationModel) Elevation(lat float64, lon float64) (float64, bool) {
+	// points on a whole degree are on the edge of the tile named after
+	// them and of the tile to the south or west
+	for _, south := range []float64{math.Floor(lat), math.Ceil(lat) - 1} {
+		for _, west := range []float64{math.Floor(lon), math.Ceil(lon) - 1} {
+			if tile := m.hgtTile(south, west); tile != nil {
+				if elevation, ok := tile.sample(lat, lon); ok {
+					return elevation, true
+				}
+			}
+		}
+	}
+
+	for _, header := range m.geoTiffIndex() {
+		if _, _, ok := header.grid.position(lat, lon); !ok {
+			continue
+		}
+		if tile := m.cachedTile(header.path, header.readTile); tile != nil {
+			if elevation, ok := tile.sample(lat, lon); ok {
+				return elevation, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// SlopeAspect returns the terrain slope angle and the aspect, the compass
+// direction the slope faces, in degrees at a point. Both are estimated with
+// Horn's method from elevations terrainSampleSpacing meters apart.
+func (m *ElevationModel) SlopeAspect(lat float64, lon float64) (float64, float64, bool) {
+	dLat := terrainSampleSpacing / earthRadiusMeters * 180 / math.Pi
+	dLon := dLat / math.Cos(lat*math.Pi/180)
+
+	// z[row][col] with row 0 to the north and col 0 to the west
+	var z [3][3]float64
+	for row := 0; row < 3; row++ {
+		for col := 0; col < 3; col++ {
+			elevation, ok := m.Elevation(lat+float64(1-row)*dLat, lon+float64(col-1)*dLon)
+			if !ok {
+				return 0, 0, false
+			}
+			z[row][col] = elevation
+		}
+	}
+
+	dzdEast := ((z[0][2] + 2*z[1][2] + z[2][2]) - (z[0][0] + 2*z[1][0] + z[2][0])) / (8 * terrainSampleSpacing)
+	dzdNorth := ((z[0][0] + 2*z[0][1] + z[0][2]) - (z[2][0] + 2*z[2][1] + z[2][2])) / (8 * terrainSampleSpacing)
+
+	slope := math.Atan(math.Hypot(dzdEast, dzdNorth)) * 180 / math.Pi
+	// the slope faces downhill, against the gradient
+	aspect := math.Mod(math.Atan2(-dzdEast, -dzdNorth)*180/math.Pi+360, 360)
+	return slope, aspect, true
+}
+
+// hgtTile returns the SRTM tile with the given south-west corner, or nil
+// when there is none
+func (m *ElevationModel) hgtTile(south float64, west float64) *demTile {
+	name := hgtTileName(south, west)
+	return m.cachedTile(name, func() (*demTile, error) {
+		tile, err := readHgtTile(filepath.Join(m.dir, name), south, west)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return tile, err
+	})
+}
+
+// cachedTile returns the tile cached under key, loading it on first use and
+// evicting the least recently used tile when the cache is full. Tiles that
+// are missing or fail to load are cached as nil. The lock is only held to
+// find the entry, so lookups do not wait for other tiles to load.
+func (m *ElevationModel) cachedTile(key string, load func() (*demTile, error)) *demTile {
+	m.mu.Lock()
+	element, ok := m.tiles[key]
+	if ok {
+		m.lru.MoveToFront(element)
+	} else {
+		element = m.lru.PushFront(&demCacheEntry{key: key})
+		m.tiles[key] = element
+		if m.lru.Len() > demTileCacheSize {
+			oldest := m.lru.Remove(m.lru.Back()).(*demCacheEntry)
+			delete(m.tiles, oldest.key)
+		}
+	}
+	entry := element.Value.(*demCacheEntry)
+	m.mu.Unlock()
+
+	entry.once.Do(func() {
+		tile, err := load()
+		if err != nil {
+			slog.Error("failed to read elevation tile", "tile", key, "err", err)
+		}
+		entry.tile = tile
+	})
+	return entry.tile
+}
+
+// geoTiffIndex returns the headers of the GeoTIFFs in the directory, read on
+// first use
+func (m *ElevationModel) geoTiffIndex() []*geoTiff {
+	m.geoTiffsOnce.Do(m.loadGeoTiffs)
+	return m.geoTiffs
+}
+
+func (m *ElevationModel) loadGeoTiffs() {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		slog.Error("failed to list elevation tiles", "dem_dir", m.dir, "err", err)
+		return
+	}
+
+	for _, entry := range entries {
+		extension := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (extension != ".tif" && extension != ".tiff") {
+			continue
+		}
+
+		header, err := readGeoTiffHeader(filepath.Join(m.dir, entry.Name()))
+		if err != nil {
+			slog.Error("failed to read elevation tile", "tile", entry.Name(), "err", err)
+			continue
+		}
+		m.geoTiffs = append(m.geoTiffs, header)
+	}
+	slog.Info("indexed geotiff elevation tiles", "dem_dir", m.dir, "tiles", len(m.geoTiffs))
+}
+
+// position returns the fractional column and row of a point in the grid,
+// and false when the point is outside it
+func (g demGrid) position(lat float64, lon float64) (float64, float64, bool) {
+	x := (lon - g.originLon) / g.stepLon
+	y := (g.originLat - lat) / g.stepLat
+	if x < 0 || y < 0 || x > float64(g.width-1) || y > float64(g.height-1) {
+		return 0, 0, false
+	}
+	return x, y, true
+}
+
+// sample interpolates bilinearly between the four samples around a point.
+// Missing samples are skipped and the remaining weights renormalized.
+func (t *demTile) sample(lat float64, lon float64) (float64, bool) {
+	x, y, ok := t.position(lat, lon)
+	if !ok {
+		return 0, false
+	}
+
+	col := min(int(x), t.width-2)
+	row := min(int(y), t.height-2)
+	fx, fy := x-float64(col), y-float64(row)
+
+	corners := [4]struct {
+		value  float32
+		weight float64
+	}{
+		{t.values[row*t.width+col], (1 - fx) * (1 - fy)},
+		{t.values[row*t.width+col+1], fx * (1 - fy)},
+		{t.values[(row+1)*t.width+col], (1 - fx) * fy},
+		{t.values[(row+1)*t.width+col+1], fx * fy},
+	}
+
+	var sum, weights float64
+	for _, corner := range corners {
+		if math.IsNaN(float64(corner.value)) {
+			continue
+		}
+		sum += float64(corner.value) * corner.weight
+		weights += corner.weight
+	}
+	if weights == 0 {
+		return 0, false
+	}
+	return sum / weights, true
+}
+
+// hgtTileName returns the SRTM file name, named by its south-west corner,
+// covering a point
+func hgtTileName(lat float64, lon float64) string {
+	latPrefix, lonPrefix := "N", "E"
+	south, west := int(math.Floor(lat)), int(math.Floor(lon))
+	if south < 0 {
+		latPrefix, south = "S", -south
+	}
+	if west < 0 {
+		lonPrefix, west = "W", -west
+	}
+	return fmt.Sprintf("%s%02d%s%03d.hgt", latPrefix, south, lonPrefix, west)
+}
+
+// readHgtTile reads a one degree SRTM tile of big-endian int16 samples, 1201
+// (3 arc-second) or 3601 (1 arc-second) to a side
+func readHgtTile(path string, south float64, west float64) (*demTile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(math.Sqrt(float64(len(data) / 2)))
+	if size < 2 || size*size*2 != len(data) {
+		return nil, fmt.Errorf("unexpected hgt file size %d", len(data))
+	}
+
+	values := make([]float32, size*size)
+	for i := range values {
+		value := int16(binary.BigEndian.Uint16(data[i*2:]))
+		if value == hgtVoid {
+			values[i] = float32(math.NaN())
+		} else {
+			values[i] = float32(value)
+		}
+	}
+
+	step := 1 / float64(size-1)
+	return &demTile{
+		demGrid: demGrid{
+			originLat: south + 1,
+			originLon: west,
+			stepLat:   step,
+			stepLon:   step,
+			width:     size,
+			height:    size,
+		},
+		values: values,
+	}, nil
+}
+
+// tiff tags needed to read a single band, strip based GeoTIFF
+const (
+	tiffImageWidth      = 256
+	tiffImageLength     = 257
+	tiffBitsPerSample   = 258
+	tiffCompression     = 259
+	tiffStripOffsets    = 273
+	tiffSamplesPerPixel = 277
+	tiffStripByteCounts = 279
+	tiffSampleFormat    = 339
+	tiffPixelScale      = 33550
+	tiffTiepoint        = 33922
+	tiffGeoKeys         = 34735
+	tiffGdalNoData      = 42113
+
+	geoKeyRasterType  = 1025
+	rasterPixelIsArea = 1
+)
+
+// geoTiff is the header of a GeoTIFF tile, enough to tell which points it
+// covers and where its samples are without reading them
+type geoTiff struct {
+	path       string
+	grid       demGrid
+	noData     float64
+	sampleSize int
+	readSample func([]byte) float64
+	// stripOffsets and stripByteCounts locate the samples in the file
+	stripOffsets    []float64
+	stripByteCounts []float64
+}
+
+// readGeoTiffHeader reads the header of an uncompressed, single band GeoTIFF
+// in geographic coordinates with int16, uint16, int32 or float32 samples
+// stored in strips
+func readGeoTiffHeader(path string) (*geoTiff, error) {
+	file, err := openTiffFile(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header, err := file.read(0, 8)
+	if err != nil {
+		return nil, fmt.Errorf("file too short")
+	}
+	var order binary.ByteOrder
+	switch string(header[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a tiff file")
+	}
+	if order.Uint16(header[2:]) != 42 {
+		return nil, fmt.Errorf("unsupported tiff version (bigtiff is not supported)")
+	}
+
+	tags, err := readTiffTags(file, order, int(order.Uint32(header[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	width, height := int(tags.number(tiffImageWidth, 0)), int(tags.number(tiffImageLength, 0))
+	bits := int(tags.number(tiffBitsPerSample, 16))
+	format := int(tags.number(tiffSampleFormat, 1))
+	if width < 2 || height < 2 {
+		return nil, fmt.Errorf("invalid image size %dx%d", width, height)
+	}
+	if tags.number(tiffCompression, 1) != 1 {
+		return nil, fmt.Errorf("compressed geotiffs are not supported")
+	}
+	if tags.number(tiffSamplesPerPixel, 1) != 1 {
+		return nil, fmt.Errorf("only single band geotiffs are supported")
+	}
+
+	readSample, err := tiffSampleReader(order, bits, format)
+	if err != nil {
+		return nil, err
+	}
+
+	scale, tiepoint := tags.numbers(tiffPixelScale), tags.numbers(tiffTiepoint)
+	if len(scale) < 2 || len(tiepoint) < 6 {
+		return nil, fmt.Errorf("missing georeferencing tags")
+	}
+
+	noData := math.NaN()
+	if value, err := strconv.ParseFloat(strings.Trim(tags.ascii(tiffGdalNoData), "\x00 "), 64); err == nil {
+		noData = value
+	}
+
+	offsets, counts := tags.numbers(tiffStripOffsets), tags.numbers(tiffStripByteCounts)
+	if len(offsets) == 0 || len(offsets) != len(counts) {
+		return nil, fmt.Errorf("missing strip offsets (tiled geotiffs are not supported)")
+	}
+
+	// the tiepoint maps raster (i, j) to (lon, lat). Samples of a
+	// PixelIsArea raster are at pixel centers.
+	originLon := tiepoint[3] - tiepoint[0]*scale[0]
+	originLat := tiepoint[4] + tiepoint[1]*scale[1]
+	if tags.geoKey(geoKeyRasterType, rasterPixelIsArea) == rasterPixelIsArea {
+		originLon += scale[0] / 2
+		originLat -= scale[1] / 2
+	}
+
+	return &geoTiff{
+		path: path,
+		grid: demGrid{
+			originLat: originLat,
+			originLon: originLon,
+			stepLat:   scale[1],
+			stepLon:   scale[0],
+			width:     width,
+			height:    height,
+		},
+		noData:          noData,
+		sampleSize:      bits / 8,
+		readSample:      readSample,
+		stripOffsets:    offsets,
+		stripByteCounts: counts,
+	}, nil
+}
+
+// readTile reads the samples of the GeoTIFF strip by strip
+func (g *geoTiff) readTile() (*demTile, error) {
+	file, err := openTiffFile(g.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	samples := g.grid.width * g.grid.height
+	values := make([]float32, 0, samples)
+	for i, offset := range g.stripOffsets {
+		strip, err := file.read(int(offset), int(g.stripByteCounts[i]))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read strip %d: %w", i, err)
+		}
+		for p := 0; p+g.sampleSize <= len(strip) && len(values) < samples; p += g.sampleSize {
+			value := g.readSample(strip[p:])
+			if value == g.noData {
+				value = math.NaN()
+			}
+			values = append(values, float32(value))
+		}
+	}
+	if len(values) != samples {
+		return nil, fmt.Errorf("expected %d samples, got %d", samples, len(values))
+	}
+
+	return &demTile{demGrid: g.grid, values: values}, nil
+}
+
+// tiffFile reads byte ranges of a tiff file, so that headers can be read
+// without the samples
+type tiffFile struct {
+	*os.File
+	size int
+}
+
+func openTiffFile(path string) (tiffFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return tiffFile{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return tiffFile{}, err
+	}
+	return tiffFile{File: file, size: int(info.Size())}, nil
+}
+
+// read returns length bytes at offset, which must be within the file
+func (f tiffFile) read(offset int, length int) ([]byte, error) {
+	if offset < 0 || length < 0 || offset+length > f.size {
+		return nil, fmt.Errorf("%d bytes at offset %d are out of bounds", length, offset)
+	}
+	data := make([]byte, length)
+	if _, err := f.ReadAt(data, int64(offset)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func tiffSampleReader(order binary.ByteOrder, bits int, format int) (func([]byte) float64, error) {
+	switch {
+	case bits == 16 && format == 2:
+		return func(b []byte) float64 { return float64(int16(order.Uint16(b))) }, nil
+	case bits == 16 && format == 1:
+		return func(b []byte) float64 { return float64(order.Uint16(b)) }, nil
+	case bits == 32 && format == 2:
+		return func(b []byte) float64 { return float64(int32(order.Uint32(b))) }, nil
+	case bits == 32 && format == 3:
+		return func(b []byte) float64 { return float64(math.Float32frombits(order.Uint32(b))) }, nil
+	}
+	return nil, fmt.Errorf("unsupported sample type: %d bits, format %d", bits, format)
+}
+
+// tiffTags holds the decoded values of the first image directory
+type tiffTags map[uint16]tiffTag
+
+type tiffTag struct {
+	numbers []float64
+	ascii   string
+}
+
+func readTiffTags(file tiffFile, order binary.ByteOrder, offset int) (tiffTags, error) {
+	if offset < 8 {
+		return nil, fmt.Errorf("invalid image directory offset")
+	}
+	countData, err := file.read(offset, 2)
+	if err != nil {
+		return nil, fmt.Errorf("invalid image directory offset")
+	}
+
+	count := int(order.Uint16(countData))
+	directory, err := file.read(offset+2, count*12)
+	if err != nil {
+		return nil, fmt.Errorf("image directory is out of bounds")
+	}
+
+	// value sizes by tiff field type
+	typeSizes := map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 11: 4, 12: 8, 16: 8}
+
+	tags := tiffTags{}
+	for i := 0; i < count; i++ {
+		entry := directory[i*12:]
+		tag, fieldType, valueCount := order.Uint16(entry), order.Uint16(entry[2:]), int(order.Uint32(entry[4:]))
+
+		size, ok := typeSizes[fieldType]
+		if !ok {
+			continue
+		}
+
+		// values that fit in four bytes are stored in the entry itself
+		valueData := entry[8:12]
+		if size*valueCount > 4 {
+			valueData, err = file.read(int(order.Uint32(entry[8:])), size*valueCount)
+			if err != nil {
+				return nil, fmt.Errorf("tag %d is out of bounds", tag)
+			}
+		}
+
+		if fieldType == 2 {
+			tags[tag] = tiffTag{ascii: string(valueData[:valueCount])}
+			continue
+		}
+
+		numbers := make([]float64, valueCount)
+		for n := range numbers {
+			b := valueData[n*size:]
+			switch fieldType {
+			case 1:
+				numbers[n] = float64(b[0])
+			case 3:
+				numbers[n] = float64(order.Uint16(b))
+			case 4:
+				numbers[n] = float64(order.Uint32(b))
+			case 11:
+				numbers[n] = float64(math.Float32frombits(order.Uint32(b)))
+			case 12:
+				numbers[n] = math.Float64frombits(order.Uint64(b))
+			case 16:
+				numbers[n] = float64(order.Uint64(b))
+			}
+		}
+		tags[tag] = tiffTag{numbers: numbers}
+	}
+	return tags, nil
+}
+
+func (t tiffTags) number(tag uint16, fallback float64) float64 {
+	if values := t[tag].numbers; len(values) > 0 {
+		return values[0]
+	}
+	return fallback
+}
+
+func (t tiffTags) numbers(tag uint16) []float64 {
+	return t[tag].numbers
+}
+
+func (t tiffTags) ascii(tag uint16) string {
+	return t[tag].ascii
+}
+
+// geoKey looks up a short value in the GeoKeyDirectory
+func (t tiffTags) geoKey(key int, fallback int) int {
+	directory := t.numbers(tiffGeoKeys)
+	for i := 4; i+3 < len(directory); i += 4 {
+		// keys stored in other tags have a non-zero location
+		if int(directory[i]) == key && directory[i+1] == 0 {
+			return int(directory[i+3])
+		}
+	}
+	return fallback
+}
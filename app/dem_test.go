@@ -0,0 +1,248 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeHgtTile writes a 3x3 sample tile, rows from north to south
+func writeHgtTile(t *testing.T, dir string, name string, samples [9]int16) {
+	t.Helper()
+	var buf bytes.Buffer
+	for _, sample := range samples {
+		binary.Write(&buf, binary.BigEndian, sample)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeGeoTiff writes a little-endian 2x2 int16 GeoTIFF with its top-left
+// pixel corner at (west, north) and 0.5 degree pixels
+func writeGeoTiff(t *testing.T, path string, west float64, north float64, samples [4]int16) {
+	t.Helper()
+
+	type entry struct {
+		tag, fieldType uint16
+		count, value   uint32
+	}
+	const dataOffset = 8
+	const scaleOffset = dataOffset + 8
+	const tiepointOffset = scaleOffset + 24
+	const ifdOffset = tiepointOffset + 48
+
+	var buf bytes.Buffer
+	buf.WriteString("II")
+	binary.Write(&buf, binary.LittleEndian, uint16(42))
+	binary.Write(&buf, binary.LittleEndian, uint32(ifdOffset))
+	binary.Write(&buf, binary.LittleEndian, samples)
+	binary.Write(&buf, binary.LittleEndian, [3]float64{0.5, 0.5, 0})
+	binary.Write(&buf, binary.LittleEndian, [6]float64{0, 0, 0, west, north, 0})
+
+	entries := []entry{
+		{tiffImageWidth, 3, 1, 2},
+		{tiffImageLength, 3, 1, 2},
+		{tiffBitsPerSample, 3, 1, 16},
+		{tiffCompression, 3, 1, 1},
+		{tiffStripOffsets, 4, 1, dataOffset},
+		{tiffSamplesPerPixel, 3, 1, 1},
+		{tiffStripByteCounts, 4, 1, 8},
+		{tiffSampleFormat, 3, 1, 2},
+		{tiffPixelScale, 12, 3, scaleOffset},
+		{tiffTiepoint, 12, 6, tiepointOffset},
+	}
+	binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, e)
+	}
+	binary.Write(&buf, binary.LittleEndian, uint32(0))
+
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHgtTileName(t *testing.T) {
+	tests := []struct {
+		lat, lon float64
+		expected string
+	}{
+		{46.5, 7.9, "N46E007.hgt"},
+		{39.6, -106.1, "N39W107.hgt"},
+		{-43.5, 170.2, "S44E170.hgt"},
+	}
+
+	for _, tt := range tests {
+		if name := hgtTileName(tt.lat, tt.lon); name != tt.expected {
+			t.Errorf("hgtTileName(%v, %v) = %q, expected %q", tt.lat, tt.lon, name, tt.expected)
+		}
+	}
+}
+
+func TestElevationModel_Hgt(t *testing.T) {
+	dir := t.TempDir()
+	// samples half a degree apart, north-west corner at 47N 7E
+	writeHgtTile(t, dir, "N46E007.hgt", [9]int16{
+		1000, 1100, 1200,
+		2000, 2100, hgtVoid,
+		3000, 3100, 3200,
+	})
+	model := NewElevationModel(dir)
+
+	tests := []struct {
+		name     string
+		lat, lon float64
+		expected float64
+		ok       bool
+	}{
+		{"corner sample", 47, 7, 1000, true},
+		{"between two samples", 47, 7.25, 1050, true},
+		{"center of a cell", 46.75, 7.25, 1550, true},
+		{"south-east corner", 46, 8, 3200, true},
+		// the void sample is skipped and the others renormalized
+		{"next to a void", 46.5, 7.75, 2100, true},
+		{"outside the tiles", 45.5, 7.5, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			elevation, ok := model.Elevation(tt.lat, tt.lon)
+			if ok != tt.ok {
+				t.Fatalf("expected ok %v, got %v", tt.ok, ok)
+			}
+			if math.Abs(elevation-tt.expected) > 1e-6 {
+				t.Errorf("expected %v, got %v", tt.expected, elevation)
+			}
+		})
+	}
+}
+
+func TestElevationModel_GeoTiff(t *testing.T) {
+	dir := t.TempDir()
+	writeGeoTiff(t, filepath.Join(dir, "dem.tif"), 7, 47, [4]int16{1000, 1200, 2000, 2200})
+	model := NewElevationModel(dir)
+
+	// pixel centers are at 46.75/46.25 N and 7.25/7.75 E
+	tests := []struct {
+		lat, lon float64
+		expected float64
+	}{
+		{46.75, 7.25, 1000},
+		{46.25, 7.75, 2200},
+		{46.5, 7.5, 1600},
+	}
+
+	for _, tt := range tests {
+		elevation, ok := model.Elevation(tt.lat, tt.lon)
+		if !ok {
+			t.Fatalf("expected (%v, %v) to be covered", tt.lat, tt.lon)
+		}
+		if math.Abs(elevation-tt.expected) > 1e-6 {
+			t.Errorf("(%v, %v): expected %v, got %v", tt.lat, tt.lon, tt.expected, elevation)
+		}
+	}
+
+	if _, ok := model.Elevation(46.9, 7.1); ok {
+		t.Errorf("expected point outside the pixel centers to be uncovered")
+	}
+}
+
+func TestElevationModel_TileCache(t *testing.T) {
+	dir := t.TempDir()
+	for lon := 0; lon <= demTileCacheSize; lon++ {
+		writeHgtTile(t, dir, hgtTileName(46, float64(lon)), [9]int16{1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000})
+	}
+	tiffPath := filepath.Join(dir, "dem.tif")
+	writeGeoTiff(t, tiffPath, 20, 47, [4]int16{1000, 1200, 2000, 2200})
+	model := NewElevationModel(dir)
+
+	for lon := 0; lon <= demTileCacheSize; lon++ {
+		if _, ok := model.Elevation(46.5, float64(lon)+0.5); !ok {
+			t.Fatalf("expected tile %d to be covered", lon)
+		}
+	}
+	if model.lru.Len() != demTileCacheSize || len(model.tiles) != demTileCacheSize {
+		t.Errorf("expected %d cached tiles, got %d", demTileCacheSize, model.lru.Len())
+	}
+	if _, ok := model.tiles[hgtTileName(46, 0)]; ok {
+		t.Error("expected the least recently used tile to be evicted")
+	}
+
+	// geotiff samples are only read for points the geotiff covers
+	if _, ok := model.Elevation(10.5, 10.5); ok {
+		t.Error("expected a point outside the tiles to be uncovered")
+	}
+	if len(model.geoTiffs) != 1 {
+		t.Fatalf("expected the geotiff to be indexed, got %d", len(model.geoTiffs))
+	}
+	if _, ok := model.tiles[tiffPath]; ok {
+		t.Error("expected the geotiff samples not to be read")
+	}
+	if elevation, ok := model.Elevation(46.5, 20.5); !ok || math.Abs(elevation-1600) > 1e-6 {
+		t.Errorf("expected 1600 from the geotiff, got %v (%v)", elevation, ok)
+	}
+	if _, ok := model.tiles[tiffPath]; !ok {
+		t.Error("expected the geotiff samples to be cached")
+	}
+}
+
+func TestCorrectElevation(t *testing.T) {
+	dir := t.TempDir()
+	writeHgtTile(t, dir, "N46E007.hgt", [9]int16{1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000, 1000})
+	model := NewElevationModel(dir)
+
+	points := []StravaStreamPoint{
+		{Latitude: 46.5, Longitude: 7.5, Altitude: 1100},
+		{Latitude: 45.5, Longitude: 7.5, Altitude: 1100},
+	}
+
+	tests := []struct {
+		name       string
+		correction ElevationCorrection
+		expected   float64
+	}{
+		{"disabled", ElevationCorrection{}, 1100},
+		{"replace", ElevationCorrection{Model: model, Mode: ElevationModeReplace}, 1000},
+		{"blend", ElevationCorrection{Model: model, Mode: ElevationModeBlend}, 1050},
+		{"weighted blend", ElevationCorrection{Model: model, Mode: ElevationModeBlend, BlendWeight: 0.8}, 1020},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := correctElevation(points, tt.correction)
+			if math.Abs(result[0].Altitude-tt.expected) > 1e-6 {
+				t.Errorf("expected %v, got %v", tt.expected, result[0].Altitude)
+			}
+			// points outside the tiles keep their altitude
+			if result[1].Altitude != 1100 {
+				t.Errorf("expected uncovered point to keep its altitude, got %v", result[1].Altitude)
+			}
+		})
+	}
+
+	if coverage := demCoverage(points, model); coverage != 0.5 {
+		t.Errorf("expected coverage 0.5, got %v", coverage)
+	}
+}
+
+func TestSmoothedElevationChange(t *testing.T) {
+	altitudes := []float64{100, 101, 100, 101, 100, 110, 109, 110, 100}
+	points := make([]StravaStreamPoint, len(altitudes))
+	for i, altitude := range altitudes {
+		points[i].Altitude = altitude
+	}
+
+	gain, loss := smoothedElevationChange(points, 2)
+	if gain != 10 || loss != 10 {
+		t.Errorf("expected gain and loss of 10, got %v and %v", gain, loss)
+	}
+
+	rawGain, _ := elevationChange(points)
+	if rawGain != 13 {
+		t.Errorf("expected raw gain of 13, got %v", rawGain)
+	}
+}
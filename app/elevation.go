@@ -0,0 +1,132 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// elevationNoiseThreshold is the altitude change ignored when summing gain,
+// so GPS noise on flat ground is not counted as climbing
+const elevationNoiseThreshold = 2.0
+
+type elevationResponse struct {
+	ActivityId          int     `json:"activity_id"`
+	StravaElevationGain float64 `json:"strava_elevation_gain"`
+	StreamElevationGain float64 `json:"stream_elevation_gain"`
+	StreamElevationLoss float64 `json:"stream_elevation_loss"`
+	DemElevationGain    float64 `json:"dem_elevation_gain"`
+	DemElevationLoss    float64 `json:"dem_elevation_loss"`
+	// DemCoverage is the share of points covered by elevation tiles
+	DemCoverage float64 `json:"dem_coverage"`
+}
+
+// http request handlers
+
+// handleActivityElevation compares the elevation gain from the altitude
+// stream and from the elevation model with strava's total_elevation_gain
+func (s *ServerState) handleActivityElevation(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if s.elevation == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Elevation model is not configured")
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	streamPoints, err := client.getActivityStream(strconv.Itoa(activity.Id))
+	if err != nil {
+		slog.Error("failed to fetch activity streams", "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch activity from strava")
+	}
+
+	corrected := correctElevation(streamPoints, ElevationCorrection{Model: s.elevation, Mode: ElevationModeReplace})
+
+	response := elevationResponse{
+		ActivityId:          activity.Id,
+		StravaElevationGain: float64(activity.ElevationGain),
+		DemCoverage:         roundTo(demCoverage(streamPoints, s.elevation), 3),
+	}
+	response.StreamElevationGain, response.StreamElevationLoss = smoothedElevationChange(streamPoints, elevationNoiseThreshold)
+	response.DemElevationGain, response.DemElevationLoss = smoothedElevationChange(corrected, elevationNoiseThreshold)
+	response.StreamElevationGain, response.StreamElevationLoss = roundTo(response.StreamElevationGain, 1), roundTo(response.StreamElevationLoss, 1)
+	response.DemElevationGain, response.DemElevationLoss = roundTo(response.DemElevationGain, 1), roundTo(response.DemElevationLoss, 1)
+
+	return c.JSON(http.StatusOK, response)
+}
+
+// helpers
+
+// elevationCorrection reads the elevation (dem or blend) and dem_weight
+// parameters. Errors are returned as echo.HTTPError.
+func (s *ServerState) elevationCorrection(query url.Values) (ElevationCorrection, error) {
+	mode := ElevationMode(query.Get("elevation"))
+	if mode == "" {
+		return ElevationCorrection{}, nil
+	}
+	if mode != ElevationModeReplace && mode != ElevationModeBlend {
+		return ElevationCorrection{}, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("elevation must be %q or %q", ElevationModeReplace, ElevationModeBlend))
+	}
+	if s.elevation == nil {
+		return ElevationCorrection{}, echo.NewHTTPError(http.StatusServiceUnavailable, "Elevation model is not configured")
+	}
+
+	correction := ElevationCorrection{Model: s.elevation, Mode: mode}
+	if value := query.Get("dem_weight"); value != "" {
+		weight, err := strconv.ParseFloat(value, 64)
+		if err != nil || weight <= 0 || weight > 1 {
+			return ElevationCorrection{}, echo.NewHTTPError(http.StatusBadRequest, "dem_weight must be between 0 and 1")
+		}
+		correction.BlendWeight = weight
+	}
+	return correction, nil
+}
+
+// correctElevation replaces or blends stream altitude with the elevation
+// model. Points outside the model keep their stream altitude.
+func correctElevation(streamPoints []StravaStreamPoint, correction ElevationCorrection) []StravaStreamPoint {
+	if correction.Model == nil {
+		return streamPoints
+	}
+
+	weight := 1.0
+	if correction.Mode == ElevationModeBlend {
+		weight = correction.BlendWeight
+		if weight <= 0 {
+			weight = defaultDemBlendWeight
+		}
+	}
+
+	result := make([]StravaStreamPoint, len(streamPoints))
+	for i, point := range streamPoints {
+		if elevation, ok := correction.Model.Elevation(point.Latitude, point.Longitude); ok {
+			point.Altitude = weight*elevation + (1-weight)*point.Altitude
+		}
+		result[i] = point
+	}
+	return result
+}
+
+// demCoverage returns the share of points the elevation model covers
+func demCoverage(streamPoints []StravaStreamPoint, model *ElevationModel) float64 {
+	if len(streamPoints) == 0 {
+		return 0
+	}
+	covered := 0
+	for _, point := range streamPoints {
+		if _, ok := model.Elevation(point.Latitude, point.Longitude); ok {
+			covered++
+		}
+	}
+	return float64(covered) / float64(len(streamPoints))
+}
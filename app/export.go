@@ -0,0 +1,142 @@
+package app
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+type ExportFormat string
+
+const (
+	ExportFormatGpx     ExportFormat = "gpx"
+	ExportFormatTcx     ExportFormat = "tcx"
+	ExportFormatFit     ExportFormat = "fit"
+	ExportFormatGeoJSON ExportFormat = "geojson"
+	ExportFormatKml     ExportFormat = "kml"
+)
+
+var exportContentTypes = map[ExportFormat]string{
+	ExportFormatGpx:     "application/gpx+xml",
+	ExportFormatTcx:     "application/vnd.garmin.tcx+xml",
+	ExportFormatFit:     "application/vnd.ant.fit",
+	ExportFormatGeoJSON: "application/geo+json",
+	ExportFormatKml:     "application/vnd.google-earth.kml+xml",
+}
+
+// ParseExportFormat validates a user supplied format name
+func ParseExportFormat(name string) (ExportFormat, error) {
+	format := ExportFormat(strings.ToLower(strings.TrimSpace(name)))
+	if _, ok := exportContentTypes[format]; !ok {
+		return "", fmt.Errorf("unsupported export format: %q", name)
+	}
+	return format, nil
+}
+
+// ContentType returns the MIME type served for the format
+func (f ExportFormat) ContentType() string {
+	return exportContentTypes[f]
+}
+
+// Filename returns the file name an exported activity is saved under
+func (f ExportFormat) Filename(activityId int) string {
+	return fmt.Sprintf("activity-%d.%s", activityId, f)
+}
+
+// NewGpxMetadata builds export metadata for an activity with every optional
+// data stream enabled
+func NewGpxMetadata(activity StravaActivity) (GpxMetadata, error) {
+	startTime, err := time.Parse(time.RFC3339, activity.StartDate)
+	if err != nil {
+		return GpxMetadata{}, fmt.Errorf("failed to parse activity start time: %w", err)
+	}
+
+	return GpxMetadata{
+		Name:           activity.Name,
+		Type:           activity.Type,
+		Time:           startTime,
+		UseHeartRate:   true,
+		UseTemperature: true,
+		UseCadence:     true,
+		UsePower:       true,
+	}, nil
+}
+
+// ExportActivity fetches the streams of an activity and encodes them in the
+// requested format
+func (c *StravaClient) ExportActivity(activity StravaActivity, format ExportFormat, metadata GpxMetadata) ([]byte, error) {
+	streamPoints, err := c.getActivityStream(strconv.Itoa(activity.Id))
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeActivity(format, activity, streamPoints, metadata)
+}
+
+func encodeActivity(format ExportFormat, activity StravaActivity, streamPoints []StravaStreamPoint, metadata GpxMetadata) ([]byte, error) {
+	streamPoints = correctElevation(streamPoints, metadata.Elevation)
+	activity, streamPoints = applyPrivacy(activity, streamPoints, metadata.Privacy)
+	activity, streamPoints = simplifyTrack(activity, streamPoints, metadata.Simplify, metadata.PauseThreshold)
+
+	switch format {
+	case ExportFormatGpx:
+		gpxDoc, err := buildGpx(streamPoints, metadata)
+		if err != nil {
+			return nil, err
+		}
+		return gpxDoc.ToXml(gpx.ToXmlParams{})
+	case ExportFormatTcx:
+		return buildTcx(activity, streamPoints, metadata)
+	case ExportFormatFit:
+		return buildFit(activity, streamPoints, metadata)
+	case ExportFormatGeoJSON:
+		return buildGeoJSON(activity, streamPoints, metadata)
+	case ExportFormatKml:
+		return buildKml(activity, streamPoints, metadata)
+	default:
+		return nil, fmt.Errorf("unsupported export format: %q", format)
+	}
+}
+
+// trackLap is a segmented lap of an exported track with its statistics
+type trackLap struct {
+	Lap
+	StartTime  time.Time
+	Duration   time.Duration
+	Distance   float64
+	Vertical   float64
+	VerticalPH float64
+}
+
+// buildTrackLaps segments the stream into ascents, descents and transitions.
+// Vertical is the gain of ascents and the loss of descents.
+func buildTrackLaps(streamPoints []StravaStreamPoint, metadata GpxMetadata) []trackLap {
+	var laps []trackLap
+	for _, lap := range SegmentLaps(streamPoints, SegmentOptions{}) {
+		exported := trackLap{
+			Lap:       lap,
+			StartTime: metadata.Time.Add(time.Duration(lap.StartTime * float64(time.Second))),
+			Duration:  time.Duration((lap.EndTime - lap.StartTime) * float64(time.Second)),
+		}
+
+		if points := lapPoints(streamPoints, lap); lap.EndIndex > lap.StartIndex && len(points) > 1 {
+			gain, loss := elevationChange(points)
+			distances := cumulativeDistances(points)
+			exported.Distance = distances[len(distances)-1] - distances[0]
+			switch lap.Kind {
+			case LapAscent:
+				exported.Vertical = gain
+			case LapDescent:
+				exported.Vertical = loss
+			}
+		}
+		if exported.Duration > 0 {
+			exported.VerticalPH = exported.Vertical / exported.Duration.Hours()
+		}
+		laps = append(laps, exported)
+	}
+	return laps
+}
@@ -0,0 +1,105 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// http request handlers
+
+// handleActivityExport serves an activity owned by the authenticated athlete
+// as a downloadable file in the requested format
+func (s *ServerState) handleActivityExport(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	formatName := c.QueryParam("format")
+	if formatName == "" {
+		formatName = string(ExportFormatGpx)
+	}
+	format, err := ParseExportFormat(formatName)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	simplify, err := ParseSimplifyOptions(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	elevation, err := s.elevationCorrection(c.QueryParams())
+	if err != nil {
+		return err
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	metadata, err := NewGpxMetadata(activity)
+	if err != nil {
+		slog.Error("failed to build export metadata", "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export activity")
+	}
+
+	privacy, err := s.store.FetchPrivacySettings(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch privacy zones", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export activity")
+	}
+	metadata.Privacy = privacy.Options(c.QueryParam("hide_from_home") == "true")
+	metadata.Simplify = simplify
+	metadata.Elevation = elevation
+
+	data, err := client.ExportActivity(activity, format, metadata)
+	if err != nil {
+		slog.Error("failed to export activity", "activity_id", activity.Id, "format", format, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export activity")
+	}
+
+	slog.Info("exported activity", "athlete_id", tokenInfo.athleteId, "activity_id", activity.Id, "format", format)
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", format.Filename(activity.Id)))
+	return c.Blob(http.StatusOK, format.ContentType(), data)
+}
+
+// helpers
+
+// fetchOwnedActivity loads an activity with the athlete's own strava token and
+// verifies the athlete owns it. Errors are returned as echo.HTTPError.
+func (s *ServerState) fetchOwnedActivity(athleteId int, activityIdParam string) (StravaClient, StravaActivity, error) {
+	if _, err := strconv.ParseInt(activityIdParam, 10, 64); err != nil {
+		return StravaClient{}, StravaActivity{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid activity id")
+	}
+
+	client, err := s.athleteClient(athleteId)
+	if err != nil {
+		slog.Error("failed to create strava client", "athlete_id", athleteId, "err", err)
+		return StravaClient{}, StravaActivity{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch strava token")
+	}
+
+	activity, err := client.GetActivity(activityIdParam)
+	if errors.Is(err, ErrStravaNotFound) {
+		return StravaClient{}, StravaActivity{}, echo.NewHTTPError(http.StatusNotFound, "Activity not found")
+	}
+	if err != nil {
+		slog.Error("failed to fetch activity", "athlete_id", athleteId, "activity_id", activityIdParam, "err", err)
+		return StravaClient{}, StravaActivity{}, echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch activity from strava")
+	}
+
+	// respond as if the activity does not exist so ids of other athletes'
+	// activities cannot be probed
+	if activity.Athlete.Id != athleteId {
+		slog.Warn("athlete requested activity they do not own", "athlete_id", athleteId, "activity_id", activity.Id, "owner_id", activity.Athlete.Id)
+		return StravaClient{}, StravaActivity{}, echo.NewHTTPError(http.StatusNotFound, "Activity not found")
+	}
+
+	return client, activity, nil
+}
@@ -0,0 +1,69 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandleActivityExport_MissingAuthHeader(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/activities/123/export?format=gpx", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("123")
+
+	s := &ServerState{}
+	err := s.handleActivityExport(c)
+
+	httpErr, ok := err.(*echo.HTTPError)
+	if !ok {
+		t.Fatalf("expected *echo.HTTPError, got %T", err)
+	}
+	if httpErr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, httpErr.Code)
+	}
+}
+
+func TestHandleActivityExport_InvalidToken(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/activities/123/export?format=gpx", nil)
+	req.Header.Set("Authorization", "Bearer not-a-jwt")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("123")
+
+	s := &ServerState{config: Config{Secret: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}}
+	err := s.handleActivityExport(c)
+
+	httpErr, ok := err.(*echo.HTTPError)
+	if !ok {
+		t.Fatalf("expected *echo.HTTPError, got %T", err)
+	}
+	if httpErr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, httpErr.Code)
+	}
+}
+
+func TestFetchOwnedActivity_InvalidId(t *testing.T) {
+	s := &ServerState{}
+	_, _, err := s.fetchOwnedActivity(12345, "../athlete")
+
+	httpErr, ok := err.(*echo.HTTPError)
+	if !ok {
+		t.Fatalf("expected *echo.HTTPError, got %T", err)
+	}
+	if httpErr.Code != http.StatusBadRequest {
+		t.Errorf("expected status %d, got %d", http.StatusBadRequest, httpErr.Code)
+	}
+}
+
+func TestExportFormatFilename(t *testing.T) {
+	if filename := ExportFormatFit.Filename(987); filename != "activity-987.fit" {
+		t.Errorf("unexpected filename %q", filename)
+	}
+}
@@ -0,0 +1,59 @@
+package app
+
+import (
+	"testing"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    ExportFormat
+		expectError bool
+	}{
+		{input: "gpx", expected: ExportFormatGpx},
+		{input: "TCX", expected: ExportFormatTcx},
+		{input: " gpx ", expected: ExportFormatGpx},
+		{input: "csv", expectError: true},
+		{input: "", expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			format, err := ParseExportFormat(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("expected error for %q", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if format != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, format)
+			}
+			if format.ContentType() == "" {
+				t.Errorf("expected content type for %q", format)
+			}
+		})
+	}
+}
+
+func TestNewGpxMetadata(t *testing.T) {
+	activity := StravaActivity{Name: "Dawn patrol", Type: "BackcountrySki", StartDate: "2025-02-14T07:30:00Z"}
+	metadata, err := NewGpxMetadata(activity)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if metadata.Name != activity.Name || metadata.Type != activity.Type {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if metadata.Time.Hour() != 7 || metadata.Time.Minute() != 30 {
+		t.Errorf("unexpected start time %s", metadata.Time)
+	}
+
+	_, err = NewGpxMetadata(StravaActivity{StartDate: "yesterday"})
+	if err == nil {
+		t.Error("expected error for invalid start date")
+	}
+}
@@ -0,0 +1,353 @@
+package app
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// FIT base types as defined by the FIT SDK profile
+const (
+	fitEnum    byte = 0x00
+	fitSint8   byte = 0x01
+	fitUint8   byte = 0x02
+	fitUint16  byte = 0x84
+	fitSint32  byte = 0x85
+	fitUint32  byte = 0x86
+	fitUint32z byte = 0x8C
+)
+
+// FIT global message numbers
+const (
+	fitMesgFileId   uint16 = 0
+	fitMesgSession  uint16 = 18
+	fitMesgLap      uint16 = 19
+	fitMesgRecord   uint16 = 20
+	fitMesgEvent    uint16 = 21
+	fitMesgActivity uint16 = 34
+)
+
+const (
+	fitProtocolVersion = 0x20
+	fitProfileVersion  = 2132
+
+	// fitEpochOffset is the number of seconds between the unix epoch and the
+	// FIT epoch of 1989-12-31T00:00:00Z
+	fitEpochOffset = 631065600
+
+	fitManufacturerDevelopment = 255
+	fitFileTypeActivity        = 4
+
+	fitEventTimer       = 0
+	fitEventLap         = 9
+	fitEventSession     = 8
+	fitEventActivity    = 26
+	fitEventTypeStart   = 0
+	fitEventTypeStop    = 1
+	fitEventTypeStopAll = 4
+
+	// fitInvalidSint32 marks a missing value in sint32 fields such as
+	// positions
+	fitInvalidSint32 int32 = 0x7FFFFFFF
+)
+
+var fitCrcTable = [16]uint16{
+	0x0000, 0xCC01, 0xD801, 0x1400, 0xF001, 0x3C00, 0x2800, 0xE401,
+	0xA001, 0x6C00, 0x7800, 0xB401, 0x5000, 0x9C01, 0x8801, 0x4400,
+}
+
+type fitField struct {
+	Num   byte
+	Type  byte
+	Value any
+}
+
+// fitEncoder writes FIT messages, emitting a definition message the first
+// time each local message type is used
+type fitEncoder struct {
+	data    bytes.Buffer
+	locals  map[uint16]byte
+	nextKey byte
+}
+
+func newFitEncoder() *fitEncoder {
+	return &fitEncoder{locals: map[uint16]byte{}}
+}
+
+func (e *fitEncoder) write(globalNum uint16, fields []fitField) error {
+	local, ok := e.locals[globalNum]
+	if !ok {
+		if e.nextKey > 15 {
+			return fmt.Errorf("too many FIT message types")
+		}
+		local = e.nextKey
+		e.nextKey++
+		e.locals[globalNum] = local
+
+		// definition message: header, reserved, little endian, global
+		// message number, field count, then (number, size, type) per field
+		e.data.WriteByte(0x40 | local)
+		e.data.WriteByte(0)
+		e.data.WriteByte(0)
+		binary.Write(&e.data, binary.LittleEndian, globalNum)
+		e.data.WriteByte(byte(len(fields)))
+		for _, field := range fields {
+			e.data.WriteByte(field.Num)
+			e.data.WriteByte(byte(binary.Size(field.Value)))
+			e.data.WriteByte(field.Type)
+		}
+	}
+
+	e.data.WriteByte(local)
+	for _, field := range fields {
+		if err := binary.Write(&e.data, binary.LittleEndian, field.Value); err != nil {
+			return fmt.Errorf("error encoding FIT field %d of message %d: %w", field.Num, globalNum, err)
+		}
+	}
+	return nil
+}
+
+// bytes returns the complete FIT file: header, messages and trailing crc
+func (e *fitEncoder) bytes() []byte {
+	header := make([]byte, 14)
+	header[0] = 14
+	header[1] = fitProtocolVersion
+	binary.LittleEndian.PutUint16(header[2:], fitProfileVersion)
+	binary.LittleEndian.PutUint32(header[4:], uint32(e.data.Len()))
+	copy(header[8:], ".FIT")
+	binary.LittleEndian.PutUint16(header[12:], fitCrc(0, header[:12]))
+
+	file := append(header, e.data.Bytes()...)
+	return binary.LittleEndian.AppendUint16(file, fitCrc(0, file))
+}
+
+func fitCrc(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		tmp := fitCrcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCrcTable[b&0xF]
+
+		tmp = fitCrcTable[crc&0xF]
+		crc = (crc >> 4) & 0x0FFF
+		crc = crc ^ tmp ^ fitCrcTable[(b>>4)&0xF]
+	}
+	return crc
+}
+
+func fitTimestamp(t time.Time) uint32 {
+	return uint32(t.Unix() - fitEpochOffset)
+}
+
+func fitSemicircles(degrees float64) int32 {
+	return int32(math.Round(degrees * (1 << 31) / 180))
+}
+
+// fitPosition returns a point's latitude and longitude in semicircles, or the
+// invalid value for points without gps
+func fitPosition(point StravaStreamPoint) (int32, int32) {
+	if point.Latitude == 0 && point.Longitude == 0 {
+		return fitInvalidSint32, fitInvalidSint32
+	}
+	return fitSemicircles(point.Latitude), fitSemicircles(point.Longitude)
+}
+
+// fitScaled applies a FIT profile scale and offset, clamping to the range of
+// an unsigned field just below its invalid value
+func fitScaled(value float64, scale float64, offset float64, maxValue float64) float64 {
+	return math.Max(0, math.Min(math.Round((value+offset)*scale), maxValue-1))
+}
+
+// fitSport maps a strava activity type onto FIT sport and sub_sport values
+func fitSport(activityType string) (uint8, uint8) {
+	switch activityType {
+	case "BackcountrySki":
+		return 13, 37 // alpine_skiing, backcountry
+	case "AlpineSki":
+		return 13, 38 // alpine_skiing, resort
+	case "NordicSki":
+		return 12, 0 // cross_country_skiing
+	case "Snowboard":
+		return 14, 0
+	case "Run", "TrailRun":
+		return 1, 0
+	case "Ride", "MountainBikeRide", "GravelRide":
+		return 2, 0
+	case "Walk":
+		return 11, 0
+	case "Hike":
+		return 17, 0
+	default:
+		return 0, 0
+	}
+}
+
+func buildFit(activity StravaActivity, streamPoints []StravaStreamPoint, metadata GpxMetadata) ([]byte, error) {
+	if len(streamPoints) == 0 {
+		return nil, fmt.Errorf("cannot encode FIT file without stream data")
+	}
+
+	useHeartRate := metadata.UseHeartRate && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.HeartRate })
+	useTemperature := metadata.UseTemperature && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Temperature })
+	useCadence := metadata.UseCadence && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Cadence })
+	usePower := metadata.UsePower && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Power })
+	distances := cumulativeDistances(streamPoints)
+	sport, subSport := fitSport(metadata.Type)
+
+	startTime := streamPointTime(metadata.Time, streamPoints[0])
+	endTime := streamPointTime(metadata.Time, streamPoints[len(streamPoints)-1])
+
+	encoder := newFitEncoder()
+	err := encoder.write(fitMesgFileId, []fitField{
+		{Num: 0, Type: fitEnum, Value: uint8(fitFileTypeActivity)},
+		{Num: 1, Type: fitUint16, Value: uint16(fitManufacturerDevelopment)},
+		{Num: 2, Type: fitUint16, Value: uint16(0)},
+		{Num: 3, Type: fitUint32z, Value: uint32(activity.Id)},
+		{Num: 4, Type: fitUint32, Value: fitTimestamp(startTime)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	writeEvent := func(t time.Time, event uint8, eventType uint8) error {
+		return encoder.write(fitMesgEvent, []fitField{
+			{Num: 253, Type: fitUint32, Value: fitTimestamp(t)},
+			{Num: 0, Type: fitEnum, Value: event},
+			{Num: 1, Type: fitEnum, Value: eventType},
+		})
+	}
+
+	// records, with timer stop/start events around pauses so devices do not
+	// count the pause as moving time
+	var timerTime float64
+	pauseRuns := splitAtPauses(streamPoints, metadata.PauseThreshold)
+	for _, run := range pauseRuns {
+		runPoints := streamPoints[run[0]:run[1]]
+		if err := writeEvent(streamPointTime(metadata.Time, runPoints[0]), fitEventTimer, fitEventTypeStart); err != nil {
+			return nil, err
+		}
+
+		for i, point := range runPoints {
+			latitude, longitude := fitPosition(point)
+			fields := []fitField{
+				{Num: 253, Type: fitUint32, Value: fitTimestamp(streamPointTime(metadata.Time, point))},
+				{Num: 0, Type: fitSint32, Value: latitude},
+				{Num: 1, Type: fitSint32, Value: longitude},
+				{Num: 2, Type: fitUint16, Value: uint16(fitScaled(point.Altitude, 5, 500, math.MaxUint16))},
+				{Num: 5, Type: fitUint32, Value: uint32(fitScaled(distances[run[0]+i], 100, 0, math.MaxUint32))},
+			}
+			if useHeartRate {
+				heartRate := uint8(math.MaxUint8) // invalid marks a dropout
+				if point.HeartRate > 0 {
+					heartRate = uint8(fitScaled(point.HeartRate, 1, 0, math.MaxUint8))
+				}
+				fields = append(fields, fitField{Num: 3, Type: fitUint8, Value: heartRate})
+			}
+			if useCadence {
+				fields = append(fields, fitField{Num: 4, Type: fitUint8, Value: uint8(fitScaled(point.Cadence, 1, 0, math.MaxUint8))})
+			}
+			if usePower {
+				fields = append(fields, fitField{Num: 7, Type: fitUint16, Value: uint16(fitScaled(point.Power, 1, 0, math.MaxUint16))})
+			}
+			if useTemperature {
+				fields = append(fields, fitField{Num: 13, Type: fitSint8, Value: int8(math.Max(-127, math.Min(126, math.Round(point.Temperature))))})
+			}
+
+			if err := encoder.write(fitMesgRecord, fields); err != nil {
+				return nil, err
+			}
+		}
+
+		timerTime += runPoints[len(runPoints)-1].Time - runPoints[0].Time
+		if err := writeEvent(streamPointTime(metadata.Time, runPoints[len(runPoints)-1]), fitEventTimer, fitEventTypeStopAll); err != nil {
+			return nil, err
+		}
+	}
+
+	lapRanges := activityLapRanges(activity, len(streamPoints))
+	for lapIndex, lapRange := range lapRanges {
+		lapPoints := streamPoints[lapRange[0]:lapRange[1]]
+		first, last := lapPoints[0], lapPoints[len(lapPoints)-1]
+		gain, loss := elevationChange(lapPoints)
+		startLatitude, startLongitude := fitPosition(first)
+		endLatitude, endLongitude := fitPosition(last)
+		fields := []fitField{
+			{Num: 253, Type: fitUint32, Value: fitTimestamp(streamPointTime(metadata.Time, last))},
+			{Num: 254, Type: fitUint16, Value: uint16(lapIndex)},
+			{Num: 0, Type: fitEnum, Value: uint8(fitEventLap)},
+			{Num: 1, Type: fitEnum, Value: uint8(fitEventTypeStop)},
+			{Num: 2, Type: fitUint32, Value: fitTimestamp(streamPointTime(metadata.Time, first))},
+			{Num: 3, Type: fitSint32, Value: startLatitude},
+			{Num: 4, Type: fitSint32, Value: startLongitude},
+			{Num: 5, Type: fitSint32, Value: endLatitude},
+			{Num: 6, Type: fitSint32, Value: endLongitude},
+			{Num: 7, Type: fitUint32, Value: uint32(fitScaled(last.Time-first.Time, 1000, 0, math.MaxUint32))},
+			{Num: 8, Type: fitUint32, Value: uint32(fitScaled(last.Time-first.Time, 1000, 0, math.MaxUint32))},
+			{Num: 9, Type: fitUint32, Value: uint32(fitScaled(distances[lapRange[1]-1]-distances[lapRange[0]], 100, 0, math.MaxUint32))},
+			{Num: 21, Type: fitUint16, Value: uint16(fitScaled(gain, 1, 0, math.MaxUint16))},
+			{Num: 22, Type: fitUint16, Value: uint16(fitScaled(loss, 1, 0, math.MaxUint16))},
+			{Num: 25, Type: fitEnum, Value: sport},
+		}
+		if err := encoder.write(fitMesgLap, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	gain, loss := elevationChange(streamPoints)
+	averageHeartRate, maxHeartRate := uint8(math.MaxUint8), uint8(math.MaxUint8)
+	if useHeartRate {
+		var sum, peak float64
+		samples := 0
+		for _, point := range streamPoints {
+			if point.HeartRate > 0 {
+				sum += point.HeartRate
+				peak = math.Max(peak, point.HeartRate)
+				samples++
+			}
+		}
+		averageHeartRate = uint8(fitScaled(sum/float64(samples), 1, 0, math.MaxUint8))
+		maxHeartRate = uint8(fitScaled(peak, 1, 0, math.MaxUint8))
+	}
+
+	elapsed := endTime.Sub(startTime).Seconds()
+	startLatitude, startLongitude := fitPosition(streamPoints[0])
+	err = encoder.write(fitMesgSession, []fitField{
+		{Num: 253, Type: fitUint32, Value: fitTimestamp(endTime)},
+		{Num: 254, Type: fitUint16, Value: uint16(0)},
+		{Num: 0, Type: fitEnum, Value: uint8(fitEventSession)},
+		{Num: 1, Type: fitEnum, Value: uint8(fitEventTypeStop)},
+		{Num: 2, Type: fitUint32, Value: fitTimestamp(startTime)},
+		{Num: 3, Type: fitSint32, Value: startLatitude},
+		{Num: 4, Type: fitSint32, Value: startLongitude},
+		{Num: 5, Type: fitEnum, Value: sport},
+		{Num: 6, Type: fitEnum, Value: subSport},
+		{Num: 7, Type: fitUint32, Value: uint32(fitScaled(elapsed, 1000, 0, math.MaxUint32))},
+		{Num: 8, Type: fitUint32, Value: uint32(fitScaled(timerTime, 1000, 0, math.MaxUint32))},
+		{Num: 9, Type: fitUint32, Value: uint32(fitScaled(distances[len(distances)-1], 100, 0, math.MaxUint32))},
+		{Num: 11, Type: fitUint16, Value: uint16(fitScaled(activity.Calories, 1, 0, math.MaxUint16))},
+		{Num: 16, Type: fitUint8, Value: averageHeartRate},
+		{Num: 17, Type: fitUint8, Value: maxHeartRate},
+		{Num: 22, Type: fitUint16, Value: uint16(fitScaled(gain, 1, 0, math.MaxUint16))},
+		{Num: 23, Type: fitUint16, Value: uint16(fitScaled(loss, 1, 0, math.MaxUint16))},
+		{Num: 25, Type: fitUint16, Value: uint16(0)},
+		{Num: 26, Type: fitUint16, Value: uint16(len(lapRanges))},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	err = encoder.write(fitMesgActivity, []fitField{
+		{Num: 253, Type: fitUint32, Value: fitTimestamp(endTime)},
+		{Num: 0, Type: fitUint32, Value: uint32(fitScaled(timerTime, 1000, 0, math.MaxUint32))},
+		{Num: 1, Type: fitUint16, Value: uint16(1)},
+		{Num: 2, Type: fitEnum, Value: uint8(0)},
+		{Num: 3, Type: fitEnum, Value: uint8(fitEventActivity)},
+		{Num: 4, Type: fitEnum, Value: uint8(fitEventTypeStop)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return encoder.bytes(), nil
+}
@@ -0,0 +1,194 @@
+package app
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+type decodedFitMessage struct {
+	global uint16
+	fields map[byte][]byte
+}
+
+// decodeFit is a minimal FIT reader supporting the subset of the format
+// written by buildFit
+func decodeFit(t *testing.T, file []byte) []decodedFitMessage {
+	t.Helper()
+
+	if len(file) < 16 || string(file[8:12]) != ".FIT" {
+		t.Fatalf("missing FIT header")
+	}
+	if crc := fitCrc(0, file[:12]); crc != binary.LittleEndian.Uint16(file[12:14]) {
+		t.Fatalf("header crc mismatch")
+	}
+	if crc := fitCrc(0, file); crc != 0 {
+		t.Fatalf("file crc mismatch: %04x", crc)
+	}
+
+	dataSize := int(binary.LittleEndian.Uint32(file[4:8]))
+	data := file[14 : 14+dataSize]
+	if 14+dataSize+2 != len(file) {
+		t.Fatalf("data size %d does not match file length %d", dataSize, len(file))
+	}
+
+	type definition struct {
+		global uint16
+		fields [][2]byte
+	}
+	definitions := map[byte]definition{}
+
+	var messages []decodedFitMessage
+	for offset := 0; offset < len(data); {
+		header := data[offset]
+		local := header & 0x0F
+		offset++
+
+		if header&0x40 != 0 {
+			def := definition{global: binary.LittleEndian.Uint16(data[offset+2:])}
+			count := int(data[offset+4])
+			offset += 5
+			for i := 0; i < count; i++ {
+				def.fields = append(def.fields, [2]byte{data[offset], data[offset+1]})
+				offset += 3
+			}
+			definitions[local] = def
+			continue
+		}
+
+		def, ok := definitions[local]
+		if !ok {
+			t.Fatalf("data message for undefined local type %d", local)
+		}
+		message := decodedFitMessage{global: def.global, fields: map[byte][]byte{}}
+		for _, field := range def.fields {
+			message.fields[field[0]] = data[offset : offset+int(field[1])]
+			offset += int(field[1])
+		}
+		messages = append(messages, message)
+	}
+	return messages
+}
+
+func TestFitCrc(t *testing.T) {
+	// crc of a buffer followed by its own crc is always zero
+	data := []byte("skintrackr")
+	crc := fitCrc(0, data)
+	if fitCrc(0, binary.LittleEndian.AppendUint16(data, crc)) != 0 {
+		t.Errorf("expected crc check to be zero")
+	}
+}
+
+func TestBuildFit(t *testing.T) {
+	start := time.Date(2025, 2, 14, 7, 30, 0, 0, time.UTC)
+	activity := StravaActivity{
+		Id:       42,
+		Calories: 900,
+		Laps:     []StravaLap{{StartIndex: 0, EndIndex: 2}, {StartIndex: 2, EndIndex: 4}},
+	}
+	metadata := GpxMetadata{
+		Type:           "BackcountrySki",
+		Time:           start,
+		UseHeartRate:   true,
+		UseTemperature: true,
+		UsePower:       true,
+	}
+
+	file, err := buildFit(activity, testStreamPoints(), metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	counts := map[uint16]int{}
+	var records []decodedFitMessage
+	var session decodedFitMessage
+	for _, message := range decodeFit(t, file) {
+		counts[message.global]++
+		switch message.global {
+		case fitMesgRecord:
+			records = append(records, message)
+		case fitMesgSession:
+			session = message
+		}
+	}
+
+	if counts[fitMesgFileId] != 1 || counts[fitMesgSession] != 1 || counts[fitMesgActivity] != 1 {
+		t.Errorf("expected exactly one file_id, session and activity message, got %v", counts)
+	}
+	if counts[fitMesgLap] != 2 {
+		t.Errorf("expected 2 laps, got %d", counts[fitMesgLap])
+	}
+	// one start and one stop event for each side of the pause
+	if counts[fitMesgEvent] != 4 {
+		t.Errorf("expected 4 timer events, got %d", counts[fitMesgEvent])
+	}
+	if len(records) != len(testStreamPoints()) {
+		t.Fatalf("expected %d records, got %d", len(testStreamPoints()), len(records))
+	}
+
+	first := records[0]
+	if timestamp := binary.LittleEndian.Uint32(first.fields[253]); timestamp != uint32(start.Unix()-fitEpochOffset) {
+		t.Errorf("unexpected record timestamp %d", timestamp)
+	}
+	if latitude := int32(binary.LittleEndian.Uint32(first.fields[0])); latitude != fitSemicircles(44.2588) {
+		t.Errorf("unexpected latitude %d", latitude)
+	}
+	if altitude := binary.LittleEndian.Uint16(first.fields[2]); altitude != (610+500)*5 {
+		t.Errorf("unexpected altitude %d", altitude)
+	}
+	if heartRate := first.fields[3][0]; heartRate != 119 {
+		t.Errorf("unexpected heart rate %d", heartRate)
+	}
+	if temperature := int8(first.fields[13][0]); temperature != -5 {
+		t.Errorf("unexpected temperature %d", temperature)
+	}
+	if records[2].fields[3][0] != 0xFF {
+		t.Errorf("expected heart rate dropout to be written as invalid")
+	}
+	if _, ok := first.fields[7]; ok {
+		t.Error("expected power field to be omitted when power stream is absent")
+	}
+	if _, ok := first.fields[4]; ok {
+		t.Error("expected cadence field to be omitted when not requested")
+	}
+
+	if sport := session.fields[5][0]; sport != 13 {
+		t.Errorf("expected alpine skiing sport, got %d", sport)
+	}
+	if timerTime := binary.LittleEndian.Uint32(session.fields[8]); timerTime != 15000 {
+		t.Errorf("expected timer time to exclude the pause, got %d", timerTime)
+	}
+}
+
+func TestBuildFit_NoGps(t *testing.T) {
+	streamPoints := testStreamPoints()
+	for i := range streamPoints {
+		streamPoints[i].Latitude, streamPoints[i].Longitude = 0, 0
+	}
+
+	file, err := buildFit(StravaActivity{Id: 42}, streamPoints, GpxMetadata{Time: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// position fields of records, laps and the session
+	positionFields := map[uint16][]byte{
+		fitMesgRecord:  {0, 1},
+		fitMesgLap:     {3, 4, 5, 6},
+		fitMesgSession: {3, 4},
+	}
+	for _, message := range decodeFit(t, file) {
+		for _, num := range positionFields[message.global] {
+			if value := int32(binary.LittleEndian.Uint32(message.fields[num])); value != fitInvalidSint32 {
+				t.Errorf("message %d field %d: expected invalid position, got %d", message.global, num, value)
+			}
+		}
+	}
+}
+
+func TestBuildFit_EmptyStream(t *testing.T) {
+	_, err := buildFit(StravaActivity{}, nil, GpxMetadata{})
+	if err == nil {
+		t.Error("expected error for empty stream")
+	}
+}
@@ -0,0 +1,71 @@
+package app
+
+import "math"
+
+const earthRadiusMeters = 6371008.8
+
+// haversineDistance returns the great-circle distance in meters between two
+// points given in degrees
+func haversineDistance(lat1, lon1, lat2, lon2 float64) float64 {
+	phi1 := lat1 * math.Pi / 180
+	phi2 := lat2 * math.Pi / 180
+	dPhi := (lat2 - lat1) * math.Pi / 180
+	dLambda := (lon2 - lon1) * math.Pi / 180
+
+	a := math.Sin(dPhi/2)*math.Sin(dPhi/2) + math.Cos(phi1)*math.Cos(phi2)*math.Sin(dLambda/2)*math.Sin(dLambda/2)
+	return 2 * earthRadiusMeters * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// cumulativeDistances returns the distance in meters from the first point to
+// each point. Strava's distance stream is used when present, otherwise the
+// distance is integrated from the positions.
+func cumulativeDistances(points []StravaStreamPoint) []float64 {
+	distances := make([]float64, len(points))
+	if hasStreamData(points, func(p StravaStreamPoint) float64 { return p.Distance }) {
+		for i, point := range points {
+			distances[i] = point.Distance
+		}
+		return distances
+	}
+
+	for i := 1; i < len(points); i++ {
+		distances[i] = distances[i-1] + haversineDistance(points[i-1].Latitude, points[i-1].Longitude, points[i].Latitude, points[i].Longitude)
+	}
+	return distances
+}
+
+// elevationChange returns the summed positive and negative altitude changes
+// between consecutive points, both as positive numbers
+func elevationChange(points []StravaStreamPoint) (gain float64, loss float64) {
+	for i := 1; i < len(points); i++ {
+		delta := points[i].Altitude - points[i-1].Altitude
+		if delta > 0 {
+			gain += delta
+		} else {
+			loss -= delta
+		}
+	}
+	return gain, loss
+}
+
+// smoothedElevationChange is like elevationChange but ignores changes until
+// the altitude has moved more than threshold from the last turning point, so
+// sensor noise does not add up
+func smoothedElevationChange(points []StravaStreamPoint, threshold float64) (gain float64, loss float64) {
+	if len(points) == 0 {
+		return 0, 0
+	}
+
+	reference := points[0].Altitude
+	for _, point := range points[1:] {
+		delta := point.Altitude - reference
+		if delta > threshold {
+			gain += delta
+			reference = point.Altitude
+		} else if delta < -threshold {
+			loss -= delta
+			reference = point.Altitude
+		}
+	}
+	return gain, loss
+}
@@ -0,0 +1,90 @@
+package app
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+type geoJSONFeatureCollection struct {
+	Type     string           `json:"type"`
+	Features []geoJSONFeature `json:"features"`
+}
+
+type geoJSONFeature struct {
+	Type       string          `json:"type"`
+	Geometry   geoJSONGeometry `json:"geometry"`
+	Properties map[string]any  `json:"properties"`
+}
+
+type geoJSONGeometry struct {
+	Type        string `json:"type"`
+	Coordinates any    `json:"coordinates"`
+}
+
+func geoJSONPosition(point StravaStreamPoint) []float64 {
+	return []float64{point.Longitude, point.Latitude, roundTo(point.Altitude, 1)}
+}
+
+// buildGeoJSON encodes each lap as a LineString feature and each change
+// between laps as a transition Point feature
+func buildGeoJSON(activity StravaActivity, streamPoints []StravaStreamPoint, metadata GpxMetadata) ([]byte, error) {
+	collection := geoJSONFeatureCollection{Type: "FeatureCollection", Features: []geoJSONFeature{}}
+
+	laps := buildTrackLaps(streamPoints, metadata)
+	number := 0
+	for i, lap := range laps {
+		if lap.Kind == LapTransition {
+			point := streamPoints[lap.StartIndex]
+			collection.Features = append(collection.Features, geoJSONFeature{
+				Type:       "Feature",
+				Geometry:   geoJSONGeometry{Type: "Point", Coordinates: geoJSONPosition(point)},
+				Properties: transitionProperties(laps[i-1], lap, laps[i+1], point),
+			})
+			continue
+		}
+
+		var coordinates [][]float64
+		for _, point := range lapPoints(streamPoints, lap.Lap) {
+			coordinates = append(coordinates, geoJSONPosition(point))
+		}
+
+		number++
+		collection.Features = append(collection.Features, geoJSONFeature{
+			Type:       "Feature",
+			Geometry:   geoJSONGeometry{Type: "LineString", Coordinates: coordinates},
+			Properties: trackLapProperties(number, lap, metadata),
+		})
+	}
+
+	return json.Marshal(collection)
+}
+
+func trackLapProperties(number int, lap trackLap, metadata GpxMetadata) map[string]any {
+	return map[string]any{
+		"name":                  metadata.Name,
+		"lap":                   number,
+		"kind":                  string(lap.Kind),
+		"start_time":            lap.StartTime.Format(time.RFC3339),
+		"duration_s":            int(lap.Duration.Seconds()),
+		"distance_m":            roundTo(lap.Distance, 1),
+		"vertical_m":            roundTo(lap.Vertical, 1),
+		"avg_vertical_rate_m_h": roundTo(lap.VerticalPH, 1),
+	}
+}
+
+func transitionProperties(previous trackLap, transition trackLap, next trackLap, point StravaStreamPoint) map[string]any {
+	return map[string]any{
+		"kind":       string(LapTransition),
+		"from":       string(previous.Kind),
+		"to":         string(next.Kind),
+		"time":       transition.StartTime.Format(time.RFC3339),
+		"duration_s": int(transition.Duration.Seconds()),
+		"altitude_m": roundTo(point.Altitude, 1),
+	}
+}
+
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
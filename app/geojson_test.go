@@ -0,0 +1,68 @@
+package app
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestBuildGeoJSON(t *testing.T) {
+	points := skiTourPoints()
+
+	output, err := buildGeoJSON(StravaActivity{}, points, GpxMetadata{Name: "Hillman's", Time: time.Date(2025, 2, 14, 7, 30, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var collection struct {
+		Type     string `json:"type"`
+		Features []struct {
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+			Properties map[string]any `json:"properties"`
+		} `json:"features"`
+	}
+	if err := json.Unmarshal(output, &collection); err != nil {
+		t.Fatalf("failed to parse geojson: %v", err)
+	}
+
+	if collection.Type != "FeatureCollection" {
+		t.Errorf("expected FeatureCollection, got %q", collection.Type)
+	}
+	if len(collection.Features) != 7 {
+		t.Fatalf("expected 4 laps and 3 transitions, got %d features", len(collection.Features))
+	}
+
+	ascent, transition, descent := collection.Features[0], collection.Features[1], collection.Features[2]
+	if ascent.Geometry.Type != "LineString" || ascent.Properties["kind"] != "ascent" || ascent.Properties["lap"] != 1.0 {
+		t.Errorf("expected first ascent LineString, got %s %v", ascent.Geometry.Type, ascent.Properties)
+	}
+	if transition.Geometry.Type != "Point" || transition.Properties["kind"] != "transition" {
+		t.Errorf("expected transition Point, got %s %v", transition.Geometry.Type, transition.Properties["kind"])
+	}
+	if transition.Properties["from"] != "ascent" || transition.Properties["to"] != "descent" || transition.Properties["duration_s"] != 290.0 {
+		t.Errorf("expected 290 s transition from ascent to descent, got %v", transition.Properties)
+	}
+	if transition.Properties["time"] != "2025-02-14T08:00:00Z" {
+		t.Errorf("expected transition at 08:00, got %v", transition.Properties["time"])
+	}
+	if descent.Properties["kind"] != "descent" || descent.Properties["lap"] != 2.0 {
+		t.Errorf("expected second lap to be a descent, got %v", descent.Properties)
+	}
+	if descent.Properties["vertical_m"] != 300.0 {
+		t.Errorf("expected 300 m descent, got %v", descent.Properties["vertical_m"])
+	}
+	if rate := ascent.Properties["avg_vertical_rate_m_h"].(float64); rate < 590 || rate > 610 {
+		t.Errorf("expected about 600 m/h ascent rate, got %v", rate)
+	}
+
+	var coordinates [][]float64
+	if err := json.Unmarshal(ascent.Geometry.Coordinates, &coordinates); err != nil {
+		t.Fatalf("failed to parse coordinates: %v", err)
+	}
+	if len(coordinates) != 181 || coordinates[0][0] != -71 || coordinates[0][1] != points[0].Latitude {
+		t.Errorf("expected [lon, lat, alt] coordinates for the first climb, got %d starting %v", len(coordinates), coordinates[0])
+	}
+}
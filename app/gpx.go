@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/xml"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	xsiSchemaLoc = "http://www.topografix.com/GPX/1/1 http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd http://www.garmin.com/xmlschemas/PowerExtension/v1 http://www.garmin.com/xmlschemas/PowerExtensionv1.xsd"
+
+	trackPointExtensionNs = "http://www.garmin.com/xmlschemas/TrackPointExtension/v1"
+	powerExtensionNs      = "http://www.garmin.com/xmlschemas/PowerExtension/v1"
+
+	// defaultPauseThreshold is the gap between two consecutive samples that is
+	// treated as a pause when GpxMetadata.PauseThreshold is not set
+	defaultPauseThreshold = 60 * time.Second
+)
+
+type GpxMetadata struct {
+	Name           string
+	Type           string
+	Time           time.Time
+	UseHeartRate   bool
+	UseTemperature bool
+	UseCadence     bool
+	UsePower       bool
+	// PauseThreshold is the gap between samples after which a new track
+	// segment is started. Zero means defaultPauseThreshold.
+	PauseThreshold time.Duration
+	// Elevation corrects stream altitude before anything else is applied
+	Elevation ElevationCorrection
+	// Privacy is applied to the stream before it is encoded
+	Privacy PrivacyOptions
+	// Simplify is applied after privacy to reduce the size of the export
+	Simplify SimplifyOptions
+}
+
+func buildGpx(streamPoints []StravaStreamPoint, metadata GpxMetadata) (gpx.GPX, error) {
+	xmlNsAttrs := []xml.Attr{
+		{Name: xml.Name{Space: "xmlns", Local: "gpxtpx"}, Value: trackPointExtensionNs},
+		{Name: xml.Name{Space: "xmlns", Local: "gpxpx"}, Value: powerExtensionNs},
+	}
+
+	// extensions are only written when requested and the stream carries data,
+	// otherwise every point would claim a 0 bpm heart rate or 0 W of power
+	useHeartRate := metadata.UseHeartRate && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.HeartRate })
+	useTemperature := metadata.UseTemperature && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Temperature })
+	useCadence := metadata.UseCadence && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Cadence })
+	usePower := metadata.UsePower && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Power })
+
+	var segments []gpx.GPXTrackSegment
+	for _, indices := range splitAtPauses(streamPoints, metadata.PauseThreshold) {
+		trackSegment := gpx.GPXTrackSegment{}
+		for _, streamPoint := range streamPoints[indices[0]:indices[1]] {
+			point := gpx.Point{Latitude: streamPoint.Latitude, Longitude: streamPoint.Longitude, Elevation: *gpx.NewNullableFloat64(streamPoint.Altitude)}
+
+			trackPointExtension := gpx.ExtensionNode{XMLName: xml.Name{Space: "gpxtpx", Local: "TrackPointExtension"}}
+			if useTemperature {
+				trackPointExtension.Nodes = append(trackPointExtension.Nodes, gpx.ExtensionNode{
+					XMLName: xml.Name{Space: "gpxtpx", Local: "atemp"},
+					Data:    strconv.FormatFloat(streamPoint.Temperature, 'f', -1, 64),
+				})
+			}
+			if useHeartRate && streamPoint.HeartRate > 0 {
+				trackPointExtension.Nodes = append(trackPointExtension.Nodes, gpx.ExtensionNode{
+					XMLName: xml.Name{Space: "gpxtpx", Local: "hr"},
+					Data:    strconv.Itoa(int(math.Round(streamPoint.HeartRate))),
+				})
+			}
+			if useCadence {
+				trackPointExtension.Nodes = append(trackPointExtension.Nodes, gpx.ExtensionNode{
+					XMLName: xml.Name{Space: "gpxtpx", Local: "cad"},
+					Data:    strconv.Itoa(int(math.Round(streamPoint.Cadence))),
+				})
+			}
+
+			extension := gpx.Extension{}
+			if usePower {
+				extension.Nodes = append(extension.Nodes, gpx.ExtensionNode{
+					XMLName: xml.Name{Space: "gpxpx", Local: "PowerInWatts"},
+					Data:    strconv.Itoa(int(math.Round(streamPoint.Power))),
+				})
+			}
+			if len(trackPointExtension.Nodes) > 0 {
+				extension.Nodes = append(extension.Nodes, trackPointExtension)
+			}
+
+			gpxPoint := gpx.GPXPoint{Point: point, Timestamp: streamPointTime(metadata.Time, streamPoint), Extensions: extension}
+			trackSegment.AppendPoint(&gpxPoint)
+		}
+		segments = append(segments, trackSegment)
+	}
+
+	gpxTrack := gpx.GPXTrack{Name: metadata.Name, Type: metadata.Type, Segments: segments}
+	gpx := gpx.GPX{XmlSchemaLoc: xsiSchemaLoc, Attrs: gpx.NewGPXAttributes(xmlNsAttrs), Version: "1.1", Creator: "skintrackr.fly.dev", Time: &metadata.Time, Tracks: []gpx.GPXTrack{gpxTrack}}
+	return gpx, nil
+}
+
+// streamPointTime converts the stream's offset in seconds from the activity
+// start into an absolute timestamp
+func streamPointTime(start time.Time, point StravaStreamPoint) time.Time {
+	return start.Add(time.Duration(point.Time * float64(time.Second))).UTC()
+}
+
+// hasStreamData reports whether any point has a non-zero value for the given
+// stream. Strava omits streams the device did not record, which leaves the
+// corresponding field zeroed on every point.
+func hasStreamData(points []StravaStreamPoint, value func(StravaStreamPoint) float64) bool {
+	for _, point := range points {
+		if value(point) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// splitAtPauses returns [start, end) index pairs of runs of points that are
+// not separated by more than threshold
+func splitAtPauses(points []StravaStreamPoint, threshold time.Duration) [][2]int {
+	if len(points) == 0 {
+		return nil
+	}
+	if threshold <= 0 {
+		threshold = defaultPauseThreshold
+	}
+
+	var runs [][2]int
+	start := 0
+	for i := 1; i < len(points); i++ {
+		gap := time.Duration((points[i].Time - points[i-1].Time) * float64(time.Second))
+		if gap > threshold {
+			runs = append(runs, [2]int{start, i})
+			start = i
+		}
+	}
+	return append(runs, [2]int{start, len(points)})
+}
@@ -0,0 +1,132 @@
+package app
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+func testStreamPoints() []StravaStreamPoint {
+	return []StravaStreamPoint{
+		{Time: 0, Latitude: 44.2588, Longitude: -71.2533, Altitude: 610, HeartRate: 118.6, Temperature: -4.5, Cadence: 52},
+		{Time: 5, Latitude: 44.2590, Longitude: -71.2531, Altitude: 612, HeartRate: 121, Temperature: -4.5, Cadence: 54},
+		{Time: 10, Latitude: 44.2592, Longitude: -71.2529, Altitude: 615, HeartRate: 0, Temperature: -5, Cadence: 55},
+		{Time: 400, Latitude: 44.2594, Longitude: -71.2527, Altitude: 640, HeartRate: 125, Temperature: -6, Cadence: 50},
+		{Time: 405, Latitude: 44.2596, Longitude: -71.2525, Altitude: 642, HeartRate: 126, Temperature: -6, Cadence: 51},
+	}
+}
+
+func TestBuildGpx_Timestamps(t *testing.T) {
+	start := time.Date(2025, 2, 14, 7, 30, 0, 0, time.UTC)
+	doc, err := buildGpx(testStreamPoints(), GpxMetadata{Name: "Tuckerman", Type: "BackcountrySki", Time: start})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var points []gpx.GPXPoint
+	for _, segment := range doc.Tracks[0].Segments {
+		points = append(points, segment.Points...)
+	}
+
+	expected := []time.Duration{0, 5 * time.Second, 10 * time.Second, 400 * time.Second, 405 * time.Second}
+	if len(points) != len(expected) {
+		t.Fatalf("expected %d points, got %d", len(expected), len(points))
+	}
+	for i, offset := range expected {
+		if !points[i].Timestamp.Equal(start.Add(offset)) {
+			t.Errorf("point %d: expected timestamp %s, got %s", i, start.Add(offset), points[i].Timestamp)
+		}
+	}
+}
+
+func TestBuildGpx_SplitsSegmentsAtPauses(t *testing.T) {
+	tests := []struct {
+		name             string
+		threshold        time.Duration
+		expectedSegments []int
+	}{
+		{name: "default threshold", threshold: 0, expectedSegments: []int{3, 2}},
+		{name: "threshold longer than pause", threshold: 10 * time.Minute, expectedSegments: []int{5}},
+		{name: "short threshold", threshold: 4 * time.Second, expectedSegments: []int{1, 1, 1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := buildGpx(testStreamPoints(), GpxMetadata{Time: time.Now(), PauseThreshold: tt.threshold})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			segments := doc.Tracks[0].Segments
+			if len(segments) != len(tt.expectedSegments) {
+				t.Fatalf("expected %d segments, got %d", len(tt.expectedSegments), len(segments))
+			}
+			for i, size := range tt.expectedSegments {
+				if len(segments[i].Points) != size {
+					t.Errorf("segment %d: expected %d points, got %d", i, size, len(segments[i].Points))
+				}
+			}
+		})
+	}
+}
+
+func TestBuildGpx_Extensions(t *testing.T) {
+	metadata := GpxMetadata{
+		Time:           time.Date(2025, 2, 14, 7, 30, 0, 0, time.UTC),
+		UseHeartRate:   true,
+		UseTemperature: true,
+		UseCadence:     true,
+		UsePower:       true,
+	}
+
+	doc, err := buildGpx(testStreamPoints(), metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	xmlBytes, err := doc.ToXml(gpx.ToXmlParams{})
+	if err != nil {
+		t.Fatalf("failed to serialize gpx: %v", err)
+	}
+	xmlString := string(xmlBytes)
+
+	for _, expected := range []string{"<gpxtpx:hr>119</gpxtpx:hr>", "<gpxtpx:atemp>-4.5</gpxtpx:atemp>", "<gpxtpx:cad>52</gpxtpx:cad>"} {
+		if !strings.Contains(xmlString, expected) {
+			t.Errorf("expected gpx to contain %q", expected)
+		}
+	}
+
+	// no power stream was recorded, so no power extension should be written
+	if strings.Contains(xmlString, "PowerInWatts") {
+		t.Error("expected power extension to be omitted when power stream is absent")
+	}
+
+	// heart rate dropouts should not be written as 0 bpm
+	if strings.Contains(xmlString, "<gpxtpx:hr>0</gpxtpx:hr>") {
+		t.Error("expected zero heart rate samples to be omitted")
+	}
+
+	parsed, err := gpx.ParseBytes(xmlBytes)
+	if err != nil {
+		t.Fatalf("failed to parse generated gpx: %v", err)
+	}
+	if parsed.GetTrackPointsNo() != len(testStreamPoints()) {
+		t.Errorf("expected %d points after round trip, got %d", len(testStreamPoints()), parsed.GetTrackPointsNo())
+	}
+}
+
+func TestBuildGpx_NoExtensionsWhenDisabled(t *testing.T) {
+	doc, err := buildGpx(testStreamPoints(), GpxMetadata{Time: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	xmlBytes, err := doc.ToXml(gpx.ToXmlParams{})
+	if err != nil {
+		t.Fatalf("failed to serialize gpx: %v", err)
+	}
+
+	if strings.Contains(string(xmlBytes), "<extensions>") {
+		t.Error("expected no extensions when none are requested")
+	}
+}
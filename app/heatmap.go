@@ -0,0 +1,449 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	heatmapTileSize = 256
+	maxHeatmapZoom  = 18
+
+	// heatmapTolerance is how far stored tracks may stray from the stream,
+	// about a pixel at zoom 16
+	heatmapTolerance = 2.0
+
+	// heatmapMaxGap splits tracks where consecutive points are further
+	// apart, so GPS dropouts and removed privacy zones are not bridged
+	heatmapMaxGap = 200.0
+
+	// heatmapSaturation is the number of passes drawn in the hottest color
+	heatmapSaturation = 20
+)
+
+// heatmapColors is the color ramp from a single pass to saturation
+var heatmapColors = []color.NRGBA{
+	{R: 30, G: 80, B: 255, A: 140},
+	{R: 0, G: 200, B: 255, A: 190},
+	{R: 255, G: 230, B: 0, A: 220},
+	{R: 255, G: 40, B: 0, A: 255},
+}
+
+// HeatmapTrack is the simplified track of an activity drawn on heatmap tiles
+type HeatmapTrack struct {
+	ActivityId int `json:"activity_id"`
+	// Bounds is [west, south, east, north]
+	Bounds [4]float64 `json:"bounds"`
+	// Segments are runs of [longitude, latitude] pairs
+	Segments [][][2]float64 `json:"segments"`
+}
+
+// http request handlers
+
+// handleHeatmapTile renders a web mercator tile of all of the authenticated
+// athlete's tours. Map clients that cannot send headers may pass a tile
+// token as the key query parameter.
+func (s *ServerState) handleHeatmapTile(c echo.Context) error {
+	athleteId, err := s.authenticateTileRequest(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	z, x, y, err := parseTileCoordinates(c.Param("z"), c.Param("x"), strings.TrimSuffix(c.Param("y"), ".png"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	version, err := s.store.FetchHeatmapVersion(athleteId)
+	if err != nil {
+		slog.Error("failed to fetch heatmap version", "athlete_id", athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to render tile")
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=300")
+	c.Response().Header().Set("Referrer-Policy", "no-referrer")
+	cachePath := s.heatmapTilePath(athleteId, version, z, x, y)
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return c.Blob(http.StatusOK, "image/png", data)
+	}
+
+	tracks, err := s.store.FetchHeatmapTracks(athleteId)
+	if err != nil {
+		slog.Error("failed to fetch heatmap tracks", "athlete_id", athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to render tile")
+	}
+
+	privacy, err := s.store.FetchPrivacySettings(athleteId)
+	if err != nil {
+		slog.Error("failed to fetch privacy zones", "athlete_id", athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to render tile")
+	}
+
+	data, err := renderHeatmapTile(tracks, privacy.Zones, z, x, y)
+	if err != nil {
+		slog.Error("failed to render heatmap tile", "athlete_id", athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to render tile")
+	}
+
+	if err := writeFileAtomic(cachePath, data); err != nil {
+		slog.Warn("failed to cache heatmap tile", "path", cachePath, "err", err)
+	}
+	return c.Blob(http.StatusOK, "image/png", data)
+}
+
+// handleTileTokenCreate issues a tile token for the authenticated athlete,
+// replacing the previous one. Tile tokens only grant reading heatmap tiles,
+// so unlike the API token they can be kept in map layer urls.
+func (s *ServerState) handleTileTokenCreate(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	token := randomString(24)
+	if err := s.store.SaveTileToken(tokenInfo.athleteId, tileTokenHash(token)); err != nil {
+		slog.Error("failed to save tile token", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create tile token")
+	}
+
+	response := map[string]any{
+		"tile_token": token,
+		"tile_url":   strings.TrimSuffix(s.config.BaseUrl, "/") + "/api/tiles/{z}/{x}/{y}.png?key=" + token,
+	}
+	return c.JSON(http.StatusCreated, response)
+}
+
+// handleTileTokenDelete revokes the authenticated athlete's tile token
+func (s *ServerState) handleTileTokenDelete(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if err := s.store.DeleteTileToken(tokenInfo.athleteId); err != nil {
+		slog.Error("failed to delete tile token", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to revoke tile token")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// helpers
+
+// authenticateTileRequest authenticates with a tile token in the key query
+// parameter when present and the Authorization header otherwise. API tokens
+// are never accepted in the query string, where they would end up in logs.
+func (s *ServerState) authenticateTileRequest(c echo.Context) (int, error) {
+	if key := c.QueryParam("key"); key != "" {
+		athleteId, err := s.store.FetchTileTokenAthlete(tileTokenHash(key))
+		if err == redis.Nil {
+			return 0, fmt.Errorf("invalid or revoked tile token")
+		}
+		if err != nil {
+			slog.Error("failed to fetch tile token", "err", err)
+			return 0, fmt.Errorf("failed to verify tile token")
+		}
+		return athleteId, nil
+	}
+
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return 0, err
+	}
+	return tokenInfo.athleteId, nil
+}
+
+// tileTokenHash is what tile tokens are stored as, so the store never holds
+// usable tokens
+func tileTokenHash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// updateHeatmap stores the track of a tour for the athlete's heatmap, or
+// removes it for other activities, and invalidates the cached tiles
+func (s *ServerState) updateHeatmap(activity StravaActivity, streamPoints []StravaStreamPoint) error {
+	athleteId := activity.Athlete.Id
+	track := newHeatmapTrack(activity.Id, streamPoints)
+
+	var err error
+	if seasonActivityTypes[activity.Type] && len(track.Segments) > 0 {
+		err = s.store.SaveHeatmapTrack(athleteId, track)
+	} else {
+		err = s.store.DeleteHeatmapTrack(athleteId, activity.Id)
+	}
+	if err != nil {
+		return err
+	}
+	return s.invalidateHeatmap(athleteId)
+}
+
+// invalidateHeatmap bumps the athlete's tile version, so that other servers
+// stop using their cached tiles, and removes the local cache
+func (s *ServerState) invalidateHeatmap(athleteId int) error {
+	if err := s.store.IncrementHeatmapVersion(athleteId); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(filepath.Join(s.config.TileCacheDir, strconv.Itoa(athleteId))); err != nil {
+		slog.Warn("failed to remove cached heatmap tiles", "athlete_id", athleteId, "err", err)
+	}
+	return nil
+}
+
+func (s *ServerState) heatmapTilePath(athleteId int, version int64, z int, x int, y int) string {
+	return filepath.Join(s.config.TileCacheDir, strconv.Itoa(athleteId), strconv.FormatInt(version, 10),
+		strconv.Itoa(z), strconv.Itoa(x), fmt.Sprintf("%d.png", y))
+}
+
+// writeFileAtomic writes through a temporary file, so that concurrent
+// readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tile-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func parseTileCoordinates(zParam string, xParam string, yParam string) (int, int, int, error) {
+	z, err := strconv.Atoi(zParam)
+	if err != nil || z < 0 || z > maxHeatmapZoom {
+		return 0, 0, 0, fmt.Errorf("zoom must be between 0 and %d", maxHeatmapZoom)
+	}
+	x, errX := strconv.Atoi(xParam)
+	y, errY := strconv.Atoi(yParam)
+	if errX != nil || errY != nil || x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return 0, 0, 0, fmt.Errorf("invalid tile coordinates")
+	}
+	return z, x, y, nil
+}
+
+// newHeatmapTrack splits a stream at gaps and simplifies each run
+func newHeatmapTrack(activityId int, streamPoints []StravaStreamPoint) HeatmapTrack {
+	track := HeatmapTrack{ActivityId: activityId, Bounds: [4]float64{180, 90, -180, -90}}
+
+	var run []StravaStreamPoint
+	flush := func() {
+		if len(run) >= 2 {
+			keep := make([]bool, len(run))
+			keep[0], keep[len(run)-1] = true, true
+			ramerDouglasPeucker(run, 0, len(run)-1, heatmapTolerance, math.Inf(1), keep)
+
+			var segment [][2]float64
+			for i, point := range run {
+				if keep[i] {
+					segment = append(segment, [2]float64{roundTo(point.Longitude, 6), roundTo(point.Latitude, 6)})
+				}
+			}
+			track.Segments = append(track.Segments, segment)
+		}
+		run = nil
+	}
+
+	for _, point := range streamPoints {
+		// strava reports missing positions as 0,0
+		if point.Latitude == 0 && point.Longitude == 0 {
+			continue
+		}
+		if len(run) > 0 {
+			last := run[len(run)-1]
+			if haversineDistance(last.Latitude, last.Longitude, point.Latitude, point.Longitude) > heatmapMaxGap {
+				flush()
+			}
+		}
+		run = append(run, point)
+
+		track.Bounds[0] = math.Min(track.Bounds[0], point.Longitude)
+		track.Bounds[1] = math.Min(track.Bounds[1], point.Latitude)
+		track.Bounds[2] = math.Max(track.Bounds[2], point.Longitude)
+		track.Bounds[3] = math.Max(track.Bounds[3], point.Latitude)
+	}
+	flush()
+	return track
+}
+
+// renderHeatmapTile draws every track into a pass count per pixel and colors
+// the counts. Each track counts once per pixel. Pixels inside privacy zones
+// stay empty in either privacy mode, since fuzzing onto a decoy would draw a
+// hot spot.
+func renderHeatmapTile(tracks []HeatmapTrack, zones []PrivacyZone, z int, x int, y int) ([]byte, error) {
+	west, north := tilePixelToLonLat(z, float64(x*heatmapTileSize), float64(y*heatmapTileSize))
+	east, south := tilePixelToLonLat(z, float64((x+1)*heatmapTileSize), float64((y+1)*heatmapTileSize))
+
+	counts := make([]int, heatmapTileSize*heatmapTileSize)
+	drawn := make([]bool, len(counts))
+	hidden := privacyMask(zones, z, x, y)
+	var touched []int
+
+	plot := func(px int, py int) {
+		if px < 0 || py < 0 || px >= heatmapTileSize || py >= heatmapTileSize {
+			return
+		}
+		i := py*heatmapTileSize + px
+		if !drawn[i] && !hidden[i] {
+			drawn[i] = true
+			touched = append(touched, i)
+		}
+	}
+
+	for _, track := range tracks {
+		if track.Bounds[0] > east || track.Bounds[2] < west || track.Bounds[1] > north || track.Bounds[3] < south {
+			continue
+		}
+
+		offsetX, offsetY := float64(x*heatmapTileSize), float64(y*heatmapTileSize)
+		for _, segment := range track.Segments {
+			for i := 1; i < len(segment); i++ {
+				x0, y0 := lonLatToTilePixel(z, segment[i-1][0], segment[i-1][1])
+				x1, y1 := lonLatToTilePixel(z, segment[i][0], segment[i][1])
+				drawLine(x0-offsetX, y0-offsetY, x1-offsetX, y1-offsetY, plot)
+			}
+		}
+
+		for _, i := range touched {
+			counts[i]++
+			drawn[i] = false
+		}
+		touched = touched[:0]
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, heatmapTileSize, heatmapTileSize))
+	for i, count := range counts {
+		if count > 0 {
+			img.SetNRGBA(i%heatmapTileSize, i/heatmapTileSize, heatmapColor(count))
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// privacyMask marks the pixels of a tile that touch a privacy zone. Masking
+// pixels rather than points also hides simplified segments that cross a zone
+// between two points outside it.
+func privacyMask(zones []PrivacyZone, z int, x int, y int) []bool {
+	mask := make([]bool, heatmapTileSize*heatmapTileSize)
+	for _, zone := range zones {
+		cx, cy := lonLatToTilePixel(z, zone.Longitude, zone.Latitude)
+		cx -= float64(x * heatmapTileSize)
+		cy -= float64(y * heatmapTileSize)
+
+		metersPerPixel := 2 * math.Pi * earthRadiusMeters * math.Cos(zone.Latitude*math.Pi/180) / (heatmapTileSize * math.Exp2(float64(z)))
+		// half a pixel diagonal of margin covers pixels the zone only clips
+		radius := zone.RadiusMeters/metersPerPixel + math.Sqrt2/2
+
+		for py := max(0, int(cy-radius)); py <= min(heatmapTileSize-1, int(cy+radius)); py++ {
+			for px := max(0, int(cx-radius)); px <= min(heatmapTileSize-1, int(cx+radius)); px++ {
+				if math.Hypot(float64(px)+0.5-cx, float64(py)+0.5-cy) <= radius {
+					mask[py*heatmapTileSize+px] = true
+				}
+			}
+		}
+	}
+	return mask
+}
+
+// heatmapColor maps a pass count onto the color ramp on a log scale
+func heatmapColor(count int) color.NRGBA {
+	t := math.Min(1, math.Log(float64(count))/math.Log(heatmapSaturation))
+	position := t * float64(len(heatmapColors)-1)
+	i := min(int(position), len(heatmapColors)-2)
+	fraction := position - float64(i)
+
+	lerp := func(a uint8, b uint8) uint8 {
+		return uint8(math.Round(float64(a) + fraction*(float64(b)-float64(a))))
+	}
+	a, b := heatmapColors[i], heatmapColors[i+1]
+	return color.NRGBA{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B), A: lerp(a.A, b.A)}
+}
+
+// lonLatToTilePixel returns the global web mercator pixel of a position
+func lonLatToTilePixel(z int, lon float64, lat float64) (float64, float64) {
+	scale := float64(heatmapTileSize) * math.Exp2(float64(z))
+	lat = math.Max(-85.05112878, math.Min(85.05112878, lat))
+	sinLat := math.Sin(lat * math.Pi / 180)
+	px := (lon + 180) / 360 * scale
+	py := (0.5 - math.Log((1+sinLat)/(1-sinLat))/(4*math.Pi)) * scale
+	return px, py
+}
+
+// tilePixelToLonLat is the inverse of lonLatToTilePixel
+func tilePixelToLonLat(z int, px float64, py float64) (float64, float64) {
+	scale := float64(heatmapTileSize) * math.Exp2(float64(z))
+	lon := px/scale*360 - 180
+	lat := math.Atan(math.Sinh(math.Pi*(1-2*py/scale))) * 180 / math.Pi
+	return lon, lat
+}
+
+// drawLine plots the pixels along a line in tile pixel coordinates, clipped
+// to the tile so long segments at high zoom stay cheap
+func drawLine(x0 float64, y0 float64, x1 float64, y1 float64, plot func(int, int)) {
+	x0, y0, x1, y1, ok := clipLine(x0, y0, x1, y1, -1, heatmapTileSize+1)
+	if !ok {
+		return
+	}
+
+	steps := int(math.Ceil(math.Max(math.Abs(x1-x0), math.Abs(y1-y0))))
+	for i := 0; i <= steps; i++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(i) / float64(steps)
+		}
+		plot(int(math.Floor(x0+t*(x1-x0))), int(math.Floor(y0+t*(y1-y0))))
+	}
+}
+
+// clipLine clips a line to the square [low, high] with the Liang-Barsky
+// algorithm
+func clipLine(x0 float64, y0 float64, x1 float64, y1 float64, low float64, high float64) (float64, float64, float64, float64, bool) {
+	dx, dy := x1-x0, y1-y0
+	t0, t1 := 0.0, 1.0
+	for _, edge := range [][2]float64{{-dx, x0 - low}, {dx, high - x0}, {-dy, y0 - low}, {dy, high - y0}} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			t0 = math.Max(t0, r)
+		} else {
+			t1 = math.Min(t1, r)
+		}
+		if t0 > t1 {
+			return 0, 0, 0, 0, false
+		}
+	}
+	return x0 + t0*dx, y0 + t0*dy, x0 + t1*dx, y0 + t1*dy, true
+}
@@ -0,0 +1,195 @@
+package app
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestTilePixelProjection(t *testing.T) {
+	px, py := lonLatToTilePixel(0, 0, 0)
+	if px != 128 || math.Abs(py-128) > 1e-9 {
+		t.Errorf("expected the center of the world tile, got %v,%v", px, py)
+	}
+
+	px, py = lonLatToTilePixel(12, -71.3, 44.27)
+	lon, lat := tilePixelToLonLat(12, px, py)
+	if math.Abs(lon+71.3) > 1e-9 || math.Abs(lat-44.27) > 1e-9 {
+		t.Errorf("expected the round trip to return -71.3,44.27, got %v,%v", lon, lat)
+	}
+}
+
+func TestParseTileCoordinates(t *testing.T) {
+	tests := []struct {
+		z, x, y string
+		wantErr bool
+	}{
+		{"0", "0", "0", false},
+		{"12", "1236", "1497", false},
+		{"12", "4096", "0", true},
+		{"19", "0", "0", true},
+		{"-1", "0", "0", true},
+		{"3", "a", "1", true},
+	}
+
+	for _, tt := range tests {
+		if _, _, _, err := parseTileCoordinates(tt.z, tt.x, tt.y); (err != nil) != tt.wantErr {
+			t.Errorf("%s/%s/%s: expected error %v, got %v", tt.z, tt.x, tt.y, tt.wantErr, err)
+		}
+	}
+}
+
+func TestNewHeatmapTrack(t *testing.T) {
+	points := linePoints(20)
+	// a GPS dropout between the tenth and eleventh points
+	for i := 10; i < len(points); i++ {
+		points[i].Latitude += 0.01
+	}
+	points = append(points, StravaStreamPoint{})
+
+	track := newHeatmapTrack(42, points)
+	if len(track.Segments) != 2 {
+		t.Fatalf("expected 2 segments, got %d", len(track.Segments))
+	}
+	// straight runs simplify to their ends
+	for _, segment := range track.Segments {
+		if len(segment) != 2 {
+			t.Errorf("expected a straight segment to keep 2 points, got %d", len(segment))
+		}
+	}
+	if track.Bounds[1] != points[0].Latitude || track.Bounds[3] != points[19].Latitude {
+		t.Errorf("unexpected bounds %v", track.Bounds)
+	}
+}
+
+// tileTrack crosses tile 12/1236/1497 from west to east
+func tileTrack(activityId int) HeatmapTrack {
+	west, north := tilePixelToLonLat(12, 1236*256, 1497*256)
+	east, south := tilePixelToLonLat(12, 1237*256, 1498*256)
+	lat := (north + south) / 2
+	return HeatmapTrack{
+		ActivityId: activityId,
+		Bounds:     [4]float64{west - 0.01, lat, east + 0.01, lat},
+		Segments:   [][][2]float64{{{west - 0.01, lat}, {east + 0.01, lat}}},
+	}
+}
+
+func decodeTile(t *testing.T, data []byte) *image.NRGBA {
+	t.Helper()
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img.(*image.NRGBA)
+}
+
+func TestRenderHeatmapTile(t *testing.T) {
+	single, err := renderHeatmapTile([]HeatmapTrack{tileTrack(1)}, nil, 12, 1236, 1497)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := decodeTile(t, single)
+	if img.Bounds().Dx() != 256 || img.Bounds().Dy() != 256 {
+		t.Fatalf("expected a 256 px tile, got %v", img.Bounds())
+	}
+
+	row := -1
+	for y := 0; y < 256 && row < 0; y++ {
+		if img.NRGBAAt(128, y).A > 0 {
+			row = y
+		}
+	}
+	if row < 0 {
+		t.Fatal("expected the track to be drawn")
+	}
+	for _, x := range []int{0, 128, 255} {
+		if img.NRGBAAt(x, row) != heatmapColor(1) {
+			t.Errorf("expected a single pass at %d,%d, got %v", x, row, img.NRGBAAt(x, row))
+		}
+	}
+	if img.NRGBAAt(128, (row+128)%256).A != 0 {
+		t.Error("expected the rest of the tile to be transparent")
+	}
+
+	// the same track twice is hotter, and drawing it into the tile once per
+	// track keeps overlapping segments of one track from counting twice
+	double := tileTrack(2)
+	double.Segments = append(double.Segments, double.Segments[0])
+	data, _ := renderHeatmapTile([]HeatmapTrack{tileTrack(1), double}, nil, 12, 1236, 1497)
+	if color := decodeTile(t, data).NRGBAAt(128, row); color != heatmapColor(2) {
+		t.Errorf("expected two passes, got %v", color)
+	}
+
+	// a privacy zone on the middle of the track removes it there
+	lon, lat := tilePixelToLonLat(12, 1236*256+128, 1497*256+float64(row))
+	zone := PrivacyZone{Latitude: lat, Longitude: lon, RadiusMeters: 100}
+	data, _ = renderHeatmapTile([]HeatmapTrack{tileTrack(1)}, []PrivacyZone{zone}, 12, 1236, 1497)
+	img = decodeTile(t, data)
+	if color := img.NRGBAAt(128, row); color.A != 0 {
+		t.Errorf("expected the track through the zone to be removed, got %v", color)
+	}
+	if img.NRGBAAt(0, row).A == 0 || img.NRGBAAt(255, row).A == 0 {
+		t.Error("expected the track outside the zone to be drawn")
+	}
+
+	// tracks elsewhere are skipped
+	data, _ = renderHeatmapTile([]HeatmapTrack{tileTrack(1)}, nil, 12, 0, 0)
+	if color := decodeTile(t, data).NRGBAAt(128, row); color.A != 0 {
+		t.Errorf("expected an empty tile, got %v", color)
+	}
+}
+
+func TestHeatmapColor(t *testing.T) {
+	if heatmapColor(1) != heatmapColors[0] {
+		t.Errorf("expected a single pass to use the first color, got %v", heatmapColor(1))
+	}
+	if heatmapColor(heatmapSaturation) != heatmapColors[len(heatmapColors)-1] || heatmapColor(1000) != heatmapColors[len(heatmapColors)-1] {
+		t.Error("expected saturated counts to use the last color")
+	}
+	if heatmapColor(4).A <= heatmapColor(2).A {
+		t.Error("expected more passes to be more opaque")
+	}
+}
+
+func TestClipLine(t *testing.T) {
+	x0, y0, x1, y1, ok := clipLine(-100, 50, 400, 50, 0, 256)
+	if !ok || x0 != 0 || y0 != 50 || x1 != 256 || y1 != 50 {
+		t.Errorf("expected the line clipped to the tile, got %v,%v %v,%v", x0, y0, x1, y1)
+	}
+	if _, _, _, _, ok := clipLine(-100, -50, 400, -50, 0, 256); ok {
+		t.Error("expected a line above the tile to be dropped")
+	}
+}
+
+func TestHandleHeatmapTile_ApiTokenInQuery(t *testing.T) {
+	s := &ServerState{config: Config{Secret: testSecret}}
+	token, _, err := GenerateJWT(42, testSecret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/tiles/1/0/0.png?token="+token, nil)
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("z", "x", "y")
+	c.SetParamValues("1", "0", "0.png")
+
+	err = s.handleHeatmapTile(c)
+	httpErr, ok := err.(*echo.HTTPError)
+	if !ok || httpErr.Code != http.StatusUnauthorized {
+		t.Errorf("expected the api token in the query string to be refused, got %v", err)
+	}
+}
+
+func TestTileTokenHash(t *testing.T) {
+	if tileTokenHash("abc") == tileTokenHash("abd") || len(tileTokenHash("abc")) != 64 {
+		t.Error("expected distinct sha256 hashes of tile tokens")
+	}
+}
@@ -0,0 +1,121 @@
+package app
+
+import (
+	"encoding/xml"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const kmlNamespace = "http://www.opengis.net/kml/2.2"
+
+type kmlDocument struct {
+	XMLName xml.Name       `xml:"kml"`
+	Xmlns   string         `xml:"xmlns,attr"`
+	Name    string         `xml:"Document>name"`
+	Styles  []kmlStyle     `xml:"Document>Style"`
+	Marks   []kmlPlacemark `xml:"Document>Placemark"`
+}
+
+type kmlStyle struct {
+	Id        string        `xml:"id,attr"`
+	LineStyle *kmlLineStyle `xml:"LineStyle,omitempty"`
+	IconStyle *kmlIconStyle `xml:"IconStyle,omitempty"`
+}
+
+type kmlLineStyle struct {
+	Color string `xml:"color"`
+	Width int    `xml:"width"`
+}
+
+type kmlIconStyle struct {
+	Href string `xml:"Icon>href"`
+}
+
+type kmlPlacemark struct {
+	Name         string         `xml:"name"`
+	StyleUrl     string         `xml:"styleUrl"`
+	ExtendedData []kmlData      `xml:"ExtendedData>Data"`
+	LineString   *kmlLineString `xml:"LineString,omitempty"`
+	Point        *kmlPoint      `xml:"Point,omitempty"`
+}
+
+type kmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type kmlLineString struct {
+	AltitudeMode string `xml:"altitudeMode"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+type kmlPoint struct {
+	AltitudeMode string `xml:"altitudeMode"`
+	Coordinates  string `xml:"coordinates"`
+}
+
+// KML colors are aabbggrr
+var kmlStyles = []kmlStyle{
+	{Id: string(LapAscent), LineStyle: &kmlLineStyle{Color: "ff1f1fd6", Width: 4}},
+	{Id: string(LapDescent), LineStyle: &kmlLineStyle{Color: "ffd67a1f", Width: 4}},
+	{Id: string(LapTransition), IconStyle: &kmlIconStyle{Href: "http://maps.google.com/mapfiles/kml/shapes/flag.png"}},
+}
+
+func kmlCoordinate(point StravaStreamPoint) string {
+	return fmt.Sprintf("%s,%s,%s",
+		strconv.FormatFloat(point.Longitude, 'f', -1, 64),
+		strconv.FormatFloat(point.Latitude, 'f', -1, 64),
+		strconv.FormatFloat(roundTo(point.Altitude, 1), 'f', -1, 64))
+}
+
+func kmlExtendedData(properties map[string]any) []kmlData {
+	var data []kmlData
+	for name, value := range properties {
+		data = append(data, kmlData{Name: name, Value: fmt.Sprint(value)})
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Name < data[j].Name })
+	return data
+}
+
+// buildKml encodes the same lap and transition features as buildGeoJSON as
+// KML placemarks
+func buildKml(activity StravaActivity, streamPoints []StravaStreamPoint, metadata GpxMetadata) ([]byte, error) {
+	document := kmlDocument{Xmlns: kmlNamespace, Name: metadata.Name, Styles: kmlStyles}
+
+	laps := buildTrackLaps(streamPoints, metadata)
+	number, transitions := 0, 0
+	for i, lap := range laps {
+		if lap.Kind == LapTransition {
+			point := streamPoints[lap.StartIndex]
+			transitions++
+			document.Marks = append(document.Marks, kmlPlacemark{
+				Name:         fmt.Sprintf("Transition %d", transitions),
+				StyleUrl:     "#" + string(LapTransition),
+				ExtendedData: kmlExtendedData(transitionProperties(laps[i-1], lap, laps[i+1], point)),
+				Point:        &kmlPoint{AltitudeMode: "absolute", Coordinates: kmlCoordinate(point)},
+			})
+			continue
+		}
+
+		var coordinates []string
+		for _, point := range lapPoints(streamPoints, lap.Lap) {
+			coordinates = append(coordinates, kmlCoordinate(point))
+		}
+
+		number++
+		document.Marks = append(document.Marks, kmlPlacemark{
+			Name:         fmt.Sprintf("Lap %d (%s, %.0f m)", number, lap.Kind, lap.Vertical),
+			StyleUrl:     "#" + string(lap.Kind),
+			ExtendedData: kmlExtendedData(trackLapProperties(number, lap, metadata)),
+			LineString:   &kmlLineString{AltitudeMode: "absolute", Coordinates: strings.Join(coordinates, " ")},
+		})
+	}
+
+	output, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
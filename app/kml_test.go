@@ -0,0 +1,39 @@
+package app
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildKml(t *testing.T) {
+	output, err := buildKml(StravaActivity{}, skiTourPoints(), GpxMetadata{Name: "Tuckerman", Time: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var document kmlDocument
+	if err := xml.Unmarshal(output, &document); err != nil {
+		t.Fatalf("failed to parse kml: %v", err)
+	}
+
+	if document.Name != "Tuckerman" {
+		t.Errorf("expected document name, got %q", document.Name)
+	}
+	if len(document.Marks) != 7 {
+		t.Fatalf("expected 7 placemarks, got %d", len(document.Marks))
+	}
+	if document.Marks[0].LineString == nil || document.Marks[1].Point == nil || document.Marks[2].LineString == nil {
+		t.Error("expected lap, transition, lap placemarks")
+	}
+	if !strings.HasPrefix(document.Marks[0].LineString.Coordinates, "-71,44.00007") {
+		t.Errorf("unexpected coordinates %q", document.Marks[0].LineString.Coordinates)
+	}
+	if document.Marks[1].StyleUrl != "#transition" || document.Marks[1].Name != "Transition 1" {
+		t.Errorf("unexpected transition placemark %q %q", document.Marks[1].Name, document.Marks[1].StyleUrl)
+	}
+	if document.Marks[2].Name != "Lap 2 (descent, 300 m)" {
+		t.Errorf("unexpected lap name %q", document.Marks[2].Name)
+	}
+}
@@ -0,0 +1,257 @@
+package app
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	// defaultSmoothingWindow is the width of the moving average applied to
+	// altitude before segmenting
+	defaultSmoothingWindow = 30 * time.Second
+
+	// defaultLapHysteresis is how far the smoothed altitude must move back
+	// from a high or low point before it counts as a turnaround
+	defaultLapHysteresis = 25.0
+
+	// defaultStationarySpeed is the speed, averaged over the smoothing
+	// window, below which a skier is considered stopped when strava's moving
+	// stream is unavailable
+	defaultStationarySpeed = 0.3
+
+	// defaultTransitionBand is how far the altitude may drift from the
+	// turnaround while a transition is still in progress
+	defaultTransitionBand = 10.0
+)
+
+type LapKind string
+
+const (
+	LapAscent     LapKind = "ascent"
+	LapDescent    LapKind = "descent"
+	LapTransition LapKind = "transition"
+)
+
+// Lap is a segment of an activity. StartIndex and EndIndex are [start, end)
+// stream indices and StartTime and EndTime are seconds from the start of the
+// activity, like StravaStreamPoint.Time.
+type Lap struct {
+	Kind       LapKind
+	StartIndex int
+	EndIndex   int
+	StartTime  float64
+	EndTime    float64
+}
+
+// SegmentOptions tune lap segmentation. Zero values use the defaults.
+type SegmentOptions struct {
+	SmoothingWindow time.Duration
+	Hysteresis      float64
+	StationarySpeed float64
+	TransitionBand  float64
+}
+
+func (o SegmentOptions) withDefaults() SegmentOptions {
+	if o.SmoothingWindow <= 0 {
+		o.SmoothingWindow = defaultSmoothingWindow
+	}
+	if o.Hysteresis <= 0 {
+		o.Hysteresis = defaultLapHysteresis
+	}
+	if o.StationarySpeed <= 0 {
+		o.StationarySpeed = defaultStationarySpeed
+	}
+	if o.TransitionBand <= 0 {
+		o.TransitionBand = defaultTransitionBand
+	}
+	return o
+}
+
+// SegmentLaps splits an activity into alternating ascents and descents at the
+// turnarounds of its smoothed altitude, with a transition at every
+// turnaround. Transitions cover the time spent stopped at the turnaround and
+// are empty when the skier did not stop. Laps cover the whole stream.
+func SegmentLaps(streamPoints []StravaStreamPoint, options SegmentOptions) []Lap {
+	if len(streamPoints) == 0 {
+		return nil
+	}
+	options = options.withDefaults()
+
+	altitudes := smoothAltitude(streamPoints, options.SmoothingWindow)
+	turnarounds, firstKind := findTurnarounds(altitudes, options.Hysteresis)
+	stationary := stationaryPoints(streamPoints, options)
+
+	var laps []Lap
+	kind := firstKind
+	start := 0
+	for i, turnaround := range turnarounds {
+		// the transition may not reach past the neighbouring turnarounds
+		lower, upper := 0, len(streamPoints)
+		if i > 0 {
+			lower = turnarounds[i-1] + 1
+		}
+		if i+1 < len(turnarounds) {
+			upper = turnarounds[i+1]
+		}
+		lower = max(lower, start)
+
+		transitionStart, transitionEnd := turnaround, turnaround
+		for transitionStart > lower && stationary[transitionStart-1] && math.Abs(altitudes[transitionStart-1]-altitudes[turnaround]) <= options.TransitionBand {
+			transitionStart--
+		}
+		for transitionEnd < upper && stationary[transitionEnd] && math.Abs(altitudes[transitionEnd]-altitudes[turnaround]) <= options.TransitionBand {
+			transitionEnd++
+		}
+
+		// the next lap starts from the last stopped point
+		if transitionEnd > transitionStart {
+			transitionEnd--
+		}
+
+		laps = append(laps, newLap(streamPoints, kind, start, transitionStart))
+		laps = append(laps, newLap(streamPoints, LapTransition, transitionStart, transitionEnd))
+		start = transitionEnd
+		kind = oppositeLapKind(kind)
+	}
+	laps = append(laps, newLap(streamPoints, kind, start, len(streamPoints)))
+
+	return laps
+}
+
+// lapPoints returns the points of a lap up to and including the first point
+// of the next lap, so consecutive laps join up and their changes add up to
+// the activity's
+func lapPoints(streamPoints []StravaStreamPoint, lap Lap) []StravaStreamPoint {
+	return streamPoints[lap.StartIndex:min(lap.EndIndex+1, len(streamPoints))]
+}
+
+func newLap(streamPoints []StravaStreamPoint, kind LapKind, start int, end int) Lap {
+	endTime := streamPoints[len(streamPoints)-1].Time
+	if end < len(streamPoints) {
+		endTime = streamPoints[end].Time
+	}
+	startTime := endTime
+	if start < len(streamPoints) {
+		startTime = streamPoints[start].Time
+	}
+	return Lap{Kind: kind, StartIndex: start, EndIndex: end, StartTime: startTime, EndTime: endTime}
+}
+
+func oppositeLapKind(kind LapKind) LapKind {
+	if kind == LapAscent {
+		return LapDescent
+	}
+	return LapAscent
+}
+
+// smoothAltitude returns the mean altitude within half a window either side
+// of each point
+func smoothAltitude(streamPoints []StravaStreamPoint, window time.Duration) []float64 {
+	half := window.Seconds() / 2
+	smoothed := make([]float64, len(streamPoints))
+
+	// running sum over the points in [low, high)
+	low, high, sum := 0, 0, 0.0
+	for i, point := range streamPoints {
+		for high < len(streamPoints) && streamPoints[high].Time <= point.Time+half {
+			sum += streamPoints[high].Altitude
+			high++
+		}
+		for streamPoints[low].Time < point.Time-half {
+			sum -= streamPoints[low].Altitude
+			low++
+		}
+		smoothed[i] = sum / float64(high-low)
+	}
+	return smoothed
+}
+
+// findTurnarounds returns the indices of the highs and lows the altitude
+// moves away from by more than hysteresis, and whether the activity starts
+// with an ascent or a descent
+func findTurnarounds(altitudes []float64, hysteresis float64) ([]int, LapKind) {
+	var turnarounds []int
+	var direction LapKind
+	low, high := 0, 0
+
+	for i, altitude := range altitudes {
+		if altitude > altitudes[high] {
+			high = i
+		}
+		if altitude < altitudes[low] {
+			low = i
+		}
+
+		switch direction {
+		case "":
+			// the start is not a turnaround, only the direction is decided
+			if altitude-altitudes[low] > hysteresis {
+				direction, high = LapAscent, i
+			} else if altitudes[high]-altitude > hysteresis {
+				direction, low = LapDescent, i
+			}
+		case LapAscent:
+			if altitudes[high]-altitude > hysteresis {
+				turnarounds = append(turnarounds, high)
+				direction, low = LapDescent, i
+			}
+		case LapDescent:
+			if altitude-altitudes[low] > hysteresis {
+				turnarounds = append(turnarounds, low)
+				direction, high = LapAscent, i
+			}
+		}
+	}
+
+	if direction == "" {
+		// never moved more than hysteresis, so classify by the net change
+		direction = LapAscent
+		if altitudes[len(altitudes)-1] < altitudes[0] {
+			direction = LapDescent
+		}
+		return nil, direction
+	}
+
+	if len(turnarounds)%2 == 1 {
+		return turnarounds, oppositeLapKind(direction)
+	}
+	return turnarounds, direction
+}
+
+// stationaryPoints reports whether the skier was stopped at each point, from
+// strava's moving stream when present and otherwise from the average speed
+// over the smoothing window. Points after a gap longer than the window were
+// recorded while the device was paused and count as stopped too.
+func stationaryPoints(streamPoints []StravaStreamPoint, options SegmentOptions) []bool {
+	stationary := make([]bool, len(streamPoints))
+	hasMoving := false
+	for _, point := range streamPoints {
+		hasMoving = hasMoving || point.Moving
+	}
+
+	half := options.SmoothingWindow.Seconds() / 2
+	distances := cumulativeDistances(streamPoints)
+	low, high := 0, 0
+	for i, point := range streamPoints {
+		if i > 0 && point.Time-streamPoints[i-1].Time > options.SmoothingWindow.Seconds() {
+			stationary[i] = true
+			continue
+		}
+		if hasMoving {
+			stationary[i] = !point.Moving
+			continue
+		}
+
+		for high < len(streamPoints)-1 && streamPoints[high+1].Time <= point.Time+half {
+			high++
+		}
+		for streamPoints[low].Time < point.Time-half {
+			low++
+		}
+		elapsed := streamPoints[high].Time - streamPoints[low].Time
+		if elapsed > 0 {
+			stationary[i] = (distances[high]-distances[low])/elapsed < options.StationarySpeed
+		}
+	}
+	return stationary
+}
@@ -0,0 +1,149 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+// skiTourPoints returns a tour sampled every 10 s: a 300 m climb, a 5 minute
+// transition, a 300 m descent, a 3 minute stop, a 200 m climb and a descent
+// straight off the top without stopping
+func skiTourPoints() []StravaStreamPoint {
+	var points []StravaStreamPoint
+	latitude, altitude := 44.0, 1000.0
+
+	add := func(count int, climb float64, metersPerSample float64) {
+		for i := 0; i < count; i++ {
+			moving := metersPerSample > 0
+			if moving {
+				latitude += metersPerSample / 111195
+				altitude += climb / float64(count)
+			}
+			points = append(points, StravaStreamPoint{
+				Time:      float64(len(points) * 10),
+				Latitude:  latitude,
+				Longitude: -71,
+				Altitude:  altitude,
+				HeartRate: 140,
+				Moving:    moving,
+			})
+		}
+	}
+
+	add(180, 300, 8)
+	add(30, 0, 0)
+	add(30, -300, 100)
+	add(18, 0, 0)
+	add(120, 200, 8)
+	add(60, -200, 50)
+	return points
+}
+
+func TestSegmentLaps_SkiTour(t *testing.T) {
+	points := skiTourPoints()
+	laps := SegmentLaps(points, SegmentOptions{})
+
+	expected := []struct {
+		kind       LapKind
+		start, end int
+	}{
+		{LapAscent, 0, 180},
+		{LapTransition, 180, 209},
+		{LapDescent, 209, 240},
+		{LapTransition, 240, 257},
+		{LapAscent, 257, 377},
+		{LapTransition, 377, 377},
+		{LapDescent, 377, 438},
+	}
+
+	if len(laps) != len(expected) {
+		t.Fatalf("expected %d laps, got %d: %+v", len(expected), len(laps), laps)
+	}
+	for i, lap := range laps {
+		if lap.Kind != expected[i].kind {
+			t.Errorf("lap %d: expected %s, got %s", i, expected[i].kind, lap.Kind)
+		}
+		// smoothing may move boundaries by a sample
+		if abs(lap.StartIndex-expected[i].start) > 1 || abs(lap.EndIndex-expected[i].end) > 1 {
+			t.Errorf("lap %d: expected [%d, %d), got [%d, %d)", i, expected[i].start, expected[i].end, lap.StartIndex, lap.EndIndex)
+		}
+		if i > 0 && lap.StartIndex != laps[i-1].EndIndex {
+			t.Errorf("lap %d does not start where lap %d ends", i, i-1)
+		}
+		if lap.StartTime > lap.EndTime {
+			t.Errorf("lap %d ends before it starts", i)
+		}
+	}
+
+	if laps[1].EndTime-laps[1].StartTime != 290 {
+		t.Errorf("expected a 290 s transition, got %v", laps[1].EndTime-laps[1].StartTime)
+	}
+	if last := laps[len(laps)-1]; last.EndIndex != len(points) || last.EndTime != points[len(points)-1].Time {
+		t.Errorf("expected the last lap to end with the stream, got %+v", last)
+	}
+}
+
+func TestSegmentLaps_WithoutMovingStream(t *testing.T) {
+	points := skiTourPoints()
+	for i := range points {
+		points[i].Moving = false
+	}
+
+	laps := SegmentLaps(points, SegmentOptions{})
+	if len(laps) != 7 {
+		t.Fatalf("expected 7 laps, got %d", len(laps))
+	}
+	// the transition is found from the speed instead
+	if duration := laps[1].EndTime - laps[1].StartTime; duration < 270 || duration > 310 {
+		t.Errorf("expected a transition of about 290 s, got %v", duration)
+	}
+}
+
+func TestSegmentLaps_IgnoresNoise(t *testing.T) {
+	points := linePoints(100)
+	for i := range points {
+		// 10 m of noise on flat ground
+		points[i].Altitude = 1000 + float64(i%3)*5
+	}
+
+	laps := SegmentLaps(points, SegmentOptions{})
+	if len(laps) != 1 {
+		t.Fatalf("expected a single lap, got %d", len(laps))
+	}
+	if laps[0].StartIndex != 0 || laps[0].EndIndex != len(points) {
+		t.Errorf("expected the lap to cover the stream, got [%d, %d)", laps[0].StartIndex, laps[0].EndIndex)
+	}
+}
+
+func TestSegmentLaps_StartsWithDescent(t *testing.T) {
+	points := skiTourPoints()[210:]
+	for i := range points {
+		points[i].Time -= 2100
+	}
+
+	laps := SegmentLaps(points, SegmentOptions{})
+	if len(laps) == 0 || laps[0].Kind != LapDescent {
+		t.Fatalf("expected to start with a descent, got %+v", laps)
+	}
+}
+
+func TestSmoothAltitude(t *testing.T) {
+	points := linePoints(5)
+	points[2].Altitude += 30
+
+	smoothed := smoothAltitude(points, 20*time.Second)
+	// each point averages itself and its neighbours
+	if smoothed[2] != 512 {
+		t.Errorf("expected 512, got %v", smoothed[2])
+	}
+	if smoothed[0] != 500.5 {
+		t.Errorf("expected 500.5 at the edge, got %v", smoothed[0])
+	}
+}
+
+func abs(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
@@ -0,0 +1,120 @@
+package app
+
+import "math"
+
+const (
+	// liftMinAscentRate is the vertical per hour of moving time above which a
+	// straight ascent is taken to be a lift ride. Fast skimo racers climb
+	// about 1000 m/h, chairlifts and gondolas well over 2000 m/h.
+	liftMinAscentRate = 1500.0
+	// liftMinStraightness is the ratio of the straight line distance to the
+	// path distance above which an ascent is straight enough for a lift,
+	// which skin tracks with switchbacks are not
+	liftMinStraightness = 0.9
+	// liftMinSpeed is the horizontal speed a lift moves at at least
+	liftMinSpeed = 1.0
+
+	// bootpackMinGrade is the average grade from which a slow ascent is
+	// taken to be a bootpack, steeper than skin tracks are set
+	bootpackMinGrade = 0.45
+	// bootpackMaxSpeed is the horizontal speed bootpacks stay below
+	bootpackMaxSpeed = 0.6
+)
+
+type AscentType string
+
+const (
+	AscentSkin     AscentType = "skin"
+	AscentBootpack AscentType = "bootpack"
+	AscentLift     AscentType = "lift"
+)
+
+// helpers
+
+// classifyAscent tells lift rides, bootpacks and skinning apart by the
+// ascent rate and speed over the moving time of an ascent, its average grade
+// and how straight its path is
+func classifyAscent(points []StravaStreamPoint, stats LapStats) AscentType {
+	if len(points) < 2 || stats.Distance <= 0 || stats.VerticalGain <= 0 {
+		return AscentSkin
+	}
+
+	movingTime := movingDuration(points)
+	if movingTime <= 0 {
+		return AscentSkin
+	}
+	ascentRate := stats.VerticalGain / (movingTime / 3600)
+	speed := stats.Distance / movingTime
+
+	first, last := points[0], points[len(points)-1]
+	straightness := haversineDistance(first.Latitude, first.Longitude, last.Latitude, last.Longitude) / stats.Distance
+
+	switch {
+	case ascentRate >= liftMinAscentRate && straightness >= liftMinStraightness && speed >= liftMinSpeed:
+		return AscentLift
+	case stats.VerticalGain/stats.Distance >= bootpackMinGrade && speed < bootpackMaxSpeed:
+		return AscentBootpack
+	}
+	return AscentSkin
+}
+
+// movingDuration returns the time between points spent moving, from strava's
+// moving stream when present and the whole duration otherwise
+func movingDuration(points []StravaStreamPoint) float64 {
+	hasMoving := false
+	for _, point := range points {
+		hasMoving = hasMoving || point.Moving
+	}
+	if !hasMoving {
+		return points[len(points)-1].Time - points[0].Time
+	}
+
+	moving := 0.0
+	for i := 1; i < len(points); i++ {
+		if points[i].Moving {
+			moving += points[i].Time - points[i-1].Time
+		}
+	}
+	return moving
+}
+
+// excludeLiftAscents leaves lift rides out of the laps and skinning vertical
+// of a summary, and out of everything built from it, such as seasons,
+// records and zone climbs
+func excludeLiftAscents(summary *ActivitySummary) {
+	summary.LiftsExcluded = true
+	for _, lap := range summary.Laps {
+		if lap.AscentType == AscentLift {
+			summary.LapCount--
+			summary.SkinningVertical -= lap.VerticalGain
+		}
+	}
+	summary.SkinningVertical = roundTo(math.Max(summary.SkinningVertical, 0), 1)
+}
+
+// countsAscent reports whether an ascent counts towards the activity's laps
+// and vertical, which lift rides do not when they are excluded
+func (s ActivitySummary) countsAscent(lap LapStats) bool {
+	return lap.Kind == LapAscent && !(s.LiftsExcluded && lap.AscentType == AscentLift)
+}
+
+// humanPoweredRanges returns the [start, end] stream ranges between the lift
+// rides that are excluded from a summary, covering the whole stream when
+// none are
+func humanPoweredRanges(summary ActivitySummary, count int) [][2]int {
+	var ranges [][2]int
+	start := 0
+	for _, lap := range summary.Laps {
+		if !summary.LiftsExcluded || lap.AscentType != AscentLift {
+			continue
+		}
+		if lap.StartIndex > start {
+			ranges = append(ranges, [2]int{start, lap.StartIndex})
+		}
+		start = lap.EndIndex
+	}
+	if start < count-1 {
+		ranges = append(ranges, [2]int{start, count - 1})
+	}
+	return ranges
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+// sidecountryPoints skins 360 m up, skis down, rides a chairlift 360 m up
+// and skis down again, with a sample every 10 seconds
+func sidecountryPoints() []StravaStreamPoint {
+	var points []StravaStreamPoint
+	latitude, altitude := 44.0, 1000.0
+
+	add := func(count int, climb float64, metersPerSample float64) {
+		for i := 0; i < count; i++ {
+			moving := metersPerSample > 0
+			if moving {
+				latitude += metersPerSample / 111195
+				altitude += climb / float64(count)
+			}
+			points = append(points, StravaStreamPoint{
+				Time:      float64(len(points) * 10),
+				Latitude:  latitude,
+				Longitude: -71,
+				Altitude:  altitude,
+				Moving:    moving,
+			})
+		}
+	}
+
+	add(180, 360, 8)
+	add(30, 0, 0)
+	add(30, -360, 100)
+	add(18, 0, 0)
+	add(36, 360, 30)
+	add(10, 0, 0)
+	add(30, -360, 100)
+	return points
+}
+
+func TestClassifyAscent(t *testing.T) {
+	tests := []struct {
+		name            string
+		metersPerSample float64
+		climbPerSample  float64
+		zigzag          bool
+		expected        AscentType
+	}{
+		{"skin track", 8, 1.7, false, AscentSkin},
+		{"chairlift", 30, 10, false, AscentLift},
+		{"fast switchbacks", 30, 10, true, AscentSkin},
+		{"bootpack", 3, 2, false, AscentBootpack},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points := make([]StravaStreamPoint, 60)
+			for i := range points {
+				points[i] = StravaStreamPoint{
+					Time:      float64(i * 10),
+					Latitude:  44 + float64(i)*tt.metersPerSample/111195,
+					Longitude: -71,
+					Altitude:  1000 + float64(i)*tt.climbPerSample,
+					Moving:    true,
+				}
+				if tt.zigzag && i%2 == 1 {
+					points[i].Latitude = points[i-1].Latitude
+					points[i].Longitude = -71 + tt.metersPerSample/80000
+				}
+			}
+
+			lap := Lap{Kind: LapAscent, StartIndex: 0, EndIndex: len(points) - 1, EndTime: points[len(points)-1].Time}
+			if stats := computeLapStats(points, lap, time.Time{}); stats.AscentType != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, stats.AscentType)
+			}
+		})
+	}
+}
+
+func TestExcludeLiftAscents(t *testing.T) {
+	points := sidecountryPoints()
+	activity := StravaActivity{Id: 42, StartDateLocal: "2025-02-14T07:30:00Z"}
+	summary := SummarizeActivity(activity, points)
+
+	var types []AscentType
+	for _, lap := range summary.Laps {
+		if lap.Kind == LapAscent {
+			types = append(types, lap.AscentType)
+		}
+	}
+	if len(types) != 2 || types[0] != AscentSkin || types[1] != AscentLift {
+		t.Fatalf("expected a skin and a lift ride, got %v", types)
+	}
+	if summary.LapCount != 2 || summary.LiftVertical < 350 || summary.SkinningVertical < 700 {
+		t.Errorf("expected lift rides counted by default, got %+v", summary)
+	}
+
+	// the lift ride is the fastest 300 m by far
+	liftEfforts := FindEfforts(activity, summary, points)
+
+	excludeLiftAscents(&summary)
+	if summary.LapCount != 1 || summary.SkinningVertical > 370 || !summary.LiftsExcluded {
+		t.Errorf("expected only the skin counted, got %d laps and %v m", summary.LapCount, summary.SkinningVertical)
+	}
+
+	lift, skin := climbEffort(liftEfforts, RecordClimb300), climbEffort(FindEfforts(activity, summary, points), RecordClimb300)
+	if lift == nil || skin == nil {
+		t.Fatalf("expected 300 m climbs with and without the lift, got %v %v", lift, skin)
+	}
+	if skin.Value <= lift.Value || skin.EndTime > 1800 {
+		t.Errorf("expected the fastest 300 m on the skin track, got %+v", skin)
+	}
+}
+
+func climbEffort(efforts ActivityEfforts, kind RecordKind) *Effort {
+	for i, effort := range efforts.Efforts {
+		if effort.Kind == kind {
+			return &efforts.Efforts[i]
+		}
+	}
+	return nil
+}
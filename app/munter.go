@@ -0,0 +1,366 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// defaultMunterAscentRate and defaultMunterDescentRate are the Munter
+	// method's rates for skinning up and skiing down, in units per hour. A
+	// unit is a kilometer of distance or 100 m of vertical.
+	defaultMunterAscentRate  = 4.0
+	defaultMunterDescentRate = 10.0
+
+	// munterCalibrationActivities is how many of the athlete's most recent
+	// tours their rates are calibrated from
+	munterCalibrationActivities = 20
+	// minMunterCalibrationTime is the time on ascents or descents the
+	// athlete needs before their own rate replaces the default
+	minMunterCalibrationTime = time.Hour
+
+	defaultTourStart    = 8 * time.Hour
+	defaultTourReturnBy = 16 * time.Hour
+)
+
+// MunterRates are the rates tour times are estimated with, in units per hour
+type MunterRates struct {
+	Ascent  float64 `json:"ascent_units_h"`
+	Descent float64 `json:"descent_units_h"`
+	// AscentCalibrated and DescentCalibrated are true when the rate comes
+	// from the athlete's own laps rather than the default
+	AscentCalibrated  bool `json:"ascent_calibrated"`
+	DescentCalibrated bool `json:"descent_calibrated"`
+}
+
+// ActivityPace holds the Munter units and time of the ascents and descents
+// of one activity, which the athlete's rates are calibrated from
+type ActivityPace struct {
+	ActivityId   int     `json:"activity_id"`
+	Date         string  `json:"date"`
+	AscentUnits  float64 `json:"ascent_units"`
+	AscentTime   float64 `json:"ascent_time_s"`
+	DescentUnits float64 `json:"descent_units"`
+	DescentTime  float64 `json:"descent_time_s"`
+}
+
+// TourPlan holds the clock times of a planned tour as offsets from midnight.
+// With a Date, the estimate is checked against daylight at the start of the
+// route, in Location or the solar time zone of the route when it is nil.
+type TourPlan struct {
+	Start    time.Duration
+	ReturnBy time.Duration
+	Date     time.Time
+	Location *time.Location
+}
+
+// TourLeg is an ascent or descent of a planned route. StartIndex and
+// EndIndex are indices into the resampled route.
+type TourLeg struct {
+	Kind         LapKind `json:"kind"`
+	StartIndex   int     `json:"start_index"`
+	EndIndex     int     `json:"end_index"`
+	Distance     float64 `json:"distance_m"`
+	VerticalGain float64 `json:"vertical_gain_m"`
+	VerticalLoss float64 `json:"vertical_loss_m"`
+	Units        float64 `json:"units"`
+	Duration     float64 `json:"duration_s"`
+	// Arrival is the clock time at the end of the leg
+	Arrival string `json:"arrival"`
+}
+
+// TourTurnaround is the high point of a planned route and the latest time to
+// leave it and still be back by the planned return time
+type TourTurnaround struct {
+	Index    int     `json:"index"`
+	Altitude float64 `json:"altitude_m"`
+	// Arrival is the estimated clock time at the high point
+	Arrival string `json:"arrival"`
+	// Latest is the planned return time less the estimated time from the
+	// high point back
+	Latest string `json:"latest"`
+	// Margin is the time between the estimated arrival and Latest, negative
+	// when the tour is not expected to make it back in time
+	Margin float64 `json:"margin_s"`
+}
+
+// TourEstimate is the Munter method estimate of a planned route
+type TourEstimate struct {
+	Name         string         `json:"name"`
+	Rates        MunterRates    `json:"rates"`
+	Distance     float64        `json:"distance_m"`
+	VerticalGain float64        `json:"vertical_gain_m"`
+	VerticalLoss float64        `json:"vertical_loss_m"`
+	Duration     float64        `json:"duration_s"`
+	Start        string         `json:"start"`
+	ReturnBy     string         `json:"return_by"`
+	Legs         []TourLeg      `json:"legs"`
+	Turnaround   TourTurnaround `json:"turnaround"`
+	// Daylight is set when the plan has a date
+	Daylight *Daylight `json:"daylight,omitempty"`
+	Warnings []string  `json:"warnings,omitempty"`
+}
+
+// http request handlers
+
+// handleRouteEstimate estimates the time of a planned route uploaded as GPX
+// with the Munter method, at rates calibrated from the authenticated
+// athlete's laps
+func (s *ServerState) handleRouteEstimate(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	plan, err := ParseTourPlan(c.QueryParams())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	route, err := s.readRouteUpload(c)
+	if err != nil {
+		return err
+	}
+	if !route.HasElevation {
+		return echo.NewHTTPError(http.StatusBadRequest, "Route has no elevations and the elevation model is not configured")
+	}
+
+	paces, err := s.store.FetchActivityPaces(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch activity paces", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to estimate route")
+	}
+
+	estimate := EstimateTour(route.Points, CalibrateMunterRates(paces), plan)
+	estimate.Name = route.Name
+	return c.JSON(http.StatusOK, estimate)
+}
+
+// public functions
+
+// ParseTourPlan reads the start and return_by clock times of a planned tour,
+// as HH:MM, and its optional date and IANA time zone tz
+func ParseTourPlan(query url.Values) (TourPlan, error) {
+	plan := TourPlan{Start: defaultTourStart, ReturnBy: defaultTourReturnBy}
+
+	for _, param := range []struct {
+		name  string
+		value *time.Duration
+	}{
+		{"start", &plan.Start},
+		{"return_by", &plan.ReturnBy},
+	} {
+		value := strings.TrimSpace(query.Get(param.name))
+		if value == "" {
+			continue
+		}
+		clock, err := time.Parse("15:04", value)
+		if err != nil {
+			return TourPlan{}, fmt.Errorf("invalid %s, expected HH:MM", param.name)
+		}
+		*param.value = time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute
+	}
+
+	if plan.ReturnBy <= plan.Start {
+		return TourPlan{}, fmt.Errorf("return_by must be after start")
+	}
+
+	if value := strings.TrimSpace(query.Get("date")); value != "" {
+		date, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			return TourPlan{}, fmt.Errorf("invalid date, expected YYYY-MM-DD")
+		}
+		plan.Date = date
+	}
+	if value := strings.TrimSpace(query.Get("tz")); value != "" {
+		location, err := time.LoadLocation(value)
+		if err != nil {
+			return TourPlan{}, fmt.Errorf("invalid tz: %s", value)
+		}
+		plan.Location = location
+	}
+	return plan, nil
+}
+
+// CalibrateMunterRates computes the athlete's ascent and descent rates from
+// their most recent tours, keeping the default for either when there is less
+// than minMunterCalibrationTime of it
+func CalibrateMunterRates(paces []ActivityPace) MunterRates {
+	sort.Slice(paces, func(i, j int) bool { return paces[i].Date > paces[j].Date })
+	if len(paces) > munterCalibrationActivities {
+		paces = paces[:munterCalibrationActivities]
+	}
+
+	var ascentUnits, ascentTime, descentUnits, descentTime float64
+	for _, pace := range paces {
+		ascentUnits += pace.AscentUnits
+		ascentTime += pace.AscentTime
+		descentUnits += pace.DescentUnits
+		descentTime += pace.DescentTime
+	}
+
+	rates := MunterRates{Ascent: defaultMunterAscentRate, Descent: defaultMunterDescentRate}
+	if ascentTime >= minMunterCalibrationTime.Seconds() {
+		rates.Ascent = roundTo(ascentUnits/(ascentTime/3600), 2)
+		rates.AscentCalibrated = true
+	}
+	if descentTime >= minMunterCalibrationTime.Seconds() {
+		rates.Descent = roundTo(descentUnits/(descentTime/3600), 2)
+		rates.DescentCalibrated = true
+	}
+	return rates
+}
+
+// EstimateTour splits a planned route resampled to even spacing into ascents
+// and descents at the turnarounds of its altitude and estimates the time of
+// each with the Munter method. The turnaround is the route's high point.
+func EstimateTour(points []StravaStreamPoint, rates MunterRates, plan TourPlan) TourEstimate {
+	estimate := TourEstimate{
+		Rates:    rates,
+		Start:    formatTimeOfDay(plan.Start.Seconds()),
+		ReturnBy: formatTimeOfDay(plan.ReturnBy.Seconds()),
+		Legs:     []TourLeg{},
+	}
+	if len(points) < 2 {
+		return estimate
+	}
+
+	altitudes := make([]float64, len(points))
+	for i, point := range points {
+		altitudes[i] = point.Altitude
+	}
+	turnarounds, kind := findTurnarounds(altitudes, defaultLapHysteresis)
+	bounds := append(append([]int{0}, turnarounds...), len(points)-1)
+
+	elapsed := 0.0
+	highest := 0
+	highElapsed := 0.0
+	for i := 1; i < len(bounds); i++ {
+		leg := munterLeg(points, kind, bounds[i-1], bounds[i], rates)
+		elapsed += leg.Duration
+		leg.Arrival = formatTimeOfDay(plan.Start.Seconds() + elapsed)
+		estimate.Legs = append(estimate.Legs, leg)
+
+		estimate.Distance += leg.Distance
+		estimate.VerticalGain += leg.VerticalGain
+		estimate.VerticalLoss += leg.VerticalLoss
+		if altitudes[leg.EndIndex] > altitudes[highest] {
+			highest, highElapsed = leg.EndIndex, elapsed
+		}
+		kind = oppositeLapKind(kind)
+	}
+	estimate.Duration = elapsed
+
+	back := elapsed - highElapsed
+	latest := plan.ReturnBy.Seconds() - back
+	estimate.Turnaround = TourTurnaround{
+		Index:    highest,
+		Altitude: roundTo(altitudes[highest], 1),
+		Arrival:  formatTimeOfDay(plan.Start.Seconds() + highElapsed),
+		Latest:   formatTimeOfDay(latest),
+		Margin:   latest - (plan.Start.Seconds() + highElapsed),
+	}
+
+	if !plan.Date.IsZero() {
+		estimate.Daylight, estimate.Warnings = planDaylight(points[0], plan, elapsed)
+	}
+	return estimate
+}
+
+// helpers
+
+// updatePace stores the Munter units and time of a tour's ascents and
+// descents for calibrating the athlete's rates
+func (s *ServerState) updatePace(summary ActivitySummary, activity StravaActivity) error {
+	athleteId := activity.Athlete.Id
+	if !seasonActivityTypes[activity.Type] {
+		return s.store.DeleteActivityPace(athleteId, activity.Id)
+	}
+
+	pace := ActivityPace{ActivityId: activity.Id, Date: activityLocalDate(activity, summary).Format("2006-01-02")}
+	for _, lap := range summary.Laps {
+		switch {
+		case lap.Duration <= 0:
+		// lift rides would make anyone look fast
+		case lap.Kind == LapAscent && lap.AscentType != AscentLift && lap.VerticalGain >= minRouteVertical:
+			pace.AscentUnits += munterUnits(lap.Distance, lap.VerticalGain)
+			pace.AscentTime += lap.Duration
+		case lap.Kind == LapDescent && lap.VerticalLoss >= minRouteVertical:
+			pace.DescentUnits += munterUnits(lap.Distance, lap.VerticalLoss)
+			pace.DescentTime += lap.Duration
+		}
+	}
+	if pace.AscentTime == 0 && pace.DescentTime == 0 {
+		return s.store.DeleteActivityPace(athleteId, activity.Id)
+	}
+	return s.store.SaveActivityPace(athleteId, pace)
+}
+
+// planDaylight computes the daylight at the start of a planned route on the
+// plan's date and warns when the estimated finish is after civil dusk
+func planDaylight(first StravaStreamPoint, plan TourPlan, duration float64) (*Daylight, []string) {
+	location := plan.Location
+	if location == nil {
+		location = solarTimeZone(first.Longitude)
+	}
+
+	year, month, day := plan.Date.Date()
+	start := time.Date(year, month, day, 0, 0, 0, 0, location).Add(plan.Start)
+	finish := start.Add(time.Duration(duration * float64(time.Second)))
+	daylight := ComputeDaylight(first.Latitude, first.Longitude, start, finish)
+
+	var warnings []string
+	switch {
+	case daylight.Polar == "night":
+		warnings = append(warnings, "The sun does not rise on "+daylight.Date)
+	case daylight.CivilDusk != nil && finish.After(*daylight.CivilDusk):
+		warnings = append(warnings, fmt.Sprintf("Estimated finish at %s is after civil twilight ends at %s", finish.Format("15:04"), daylight.CivilDusk.Format("15:04")))
+	}
+	return &daylight, warnings
+}
+
+// munterLeg estimates the time of the points in [start, end] of a planned
+// route
+func munterLeg(points []StravaStreamPoint, kind LapKind, start int, end int, rates MunterRates) TourLeg {
+	legPoints := points[start : end+1]
+	distances := cumulativeDistances(legPoints)
+	leg := TourLeg{Kind: kind, StartIndex: start, EndIndex: end, Distance: distances[len(distances)-1]}
+	leg.VerticalGain, leg.VerticalLoss = smoothedElevationChange(legPoints, elevationNoiseThreshold)
+
+	rate := rates.Ascent
+	vertical := leg.VerticalGain
+	if kind == LapDescent {
+		rate, vertical = rates.Descent, leg.VerticalLoss
+	}
+	leg.Units = munterUnits(leg.Distance, vertical)
+	leg.Duration = math.Round(leg.Units / rate * 3600)
+
+	leg.Distance = roundTo(leg.Distance, 1)
+	leg.VerticalGain = roundTo(leg.VerticalGain, 1)
+	leg.VerticalLoss = roundTo(leg.VerticalLoss, 1)
+	leg.Units = roundTo(leg.Units, 2)
+	return leg
+}
+
+// munterUnits counts a kilometer of distance or 100 m of vertical as a unit
+func munterUnits(distance float64, vertical float64) float64 {
+	return distance/1000 + vertical/100
+}
+
+// formatTimeOfDay formats seconds from midnight as HH:MM, wrapping around
+// midnight
+func formatTimeOfDay(seconds float64) string {
+	minutes := int(math.Round(seconds/60)) % (24 * 60)
+	if minutes < 0 {
+		minutes += 24 * 60
+	}
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
@@ -0,0 +1,102 @@
+package app
+
+import (
+	"math"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// peakRoutePoints climbs 600 m over 2 km and descends 600 m over the next
+// 2 km, every 20 m
+func peakRoutePoints() []StravaStreamPoint {
+	points := make([]StravaStreamPoint, 201)
+	for i := range points {
+		altitude := 1000 + 6*float64(i)
+		if i > 100 {
+			altitude = 1600 - 6*float64(i-100)
+		}
+		points[i] = StravaStreamPoint{Latitude: 44 + float64(i)*20/111195, Longitude: -71, Altitude: altitude}
+	}
+	return points
+}
+
+func TestEstimateTour(t *testing.T) {
+	rates := MunterRates{Ascent: defaultMunterAscentRate, Descent: defaultMunterDescentRate}
+	estimate := EstimateTour(peakRoutePoints(), rates, TourPlan{Start: 8 * time.Hour, ReturnBy: 16 * time.Hour})
+
+	if len(estimate.Legs) != 2 {
+		t.Fatalf("expected an ascent and a descent, got %+v", estimate.Legs)
+	}
+	ascent, descent := estimate.Legs[0], estimate.Legs[1]
+	if ascent.Kind != LapAscent || ascent.EndIndex != 100 || math.Abs(ascent.Units-8) > 0.01 {
+		t.Errorf("unexpected ascent %+v", ascent)
+	}
+	// 8 units at 4 per hour up, 8 units at 10 per hour down
+	if math.Abs(ascent.Duration-7200) > 10 || math.Abs(descent.Duration-2880) > 10 {
+		t.Errorf("unexpected leg durations %v %v", ascent.Duration, descent.Duration)
+	}
+	if descent.Kind != LapDescent || descent.Arrival != "10:48" || estimate.Duration != ascent.Duration+descent.Duration {
+		t.Errorf("unexpected descent %+v", descent)
+	}
+	if math.Abs(estimate.VerticalGain-600) > 1 || math.Abs(estimate.Distance-4000) > 5 {
+		t.Errorf("unexpected totals %v %v", estimate.VerticalGain, estimate.Distance)
+	}
+
+	turnaround := estimate.Turnaround
+	if turnaround.Index != 100 || turnaround.Arrival != "10:00" || turnaround.Latest != "15:12" {
+		t.Errorf("unexpected turnaround %+v", turnaround)
+	}
+	if math.Abs(turnaround.Margin-5.2*3600) > 20 {
+		t.Errorf("expected a margin of 5:12, got %v", turnaround.Margin)
+	}
+
+	late := EstimateTour(peakRoutePoints(), rates, TourPlan{Start: 12 * time.Hour, ReturnBy: 14 * time.Hour})
+	if late.Turnaround.Margin >= 0 || late.Turnaround.Latest != "13:12" {
+		t.Errorf("expected a negative margin, got %+v", late.Turnaround)
+	}
+}
+
+func TestCalibrateMunterRates(t *testing.T) {
+	paces := []ActivityPace{
+		{ActivityId: 1, Date: "2025-01-01", AscentUnits: 10, AscentTime: 7200, DescentUnits: 1, DescentTime: 300},
+		{ActivityId: 2, Date: "2025-01-08", AscentUnits: 5, AscentTime: 3600, DescentUnits: 2, DescentTime: 600},
+	}
+
+	rates := CalibrateMunterRates(paces)
+	if !rates.AscentCalibrated || rates.Ascent != 5 {
+		t.Errorf("expected an ascent rate of 5 units/h, got %+v", rates)
+	}
+	// only 15 minutes of descents
+	if rates.DescentCalibrated || rates.Descent != defaultMunterDescentRate {
+		t.Errorf("expected the default descent rate, got %+v", rates)
+	}
+
+	if rates := CalibrateMunterRates(nil); rates.Ascent != defaultMunterAscentRate || rates.AscentCalibrated {
+		t.Errorf("expected the default rates without history, got %+v", rates)
+	}
+}
+
+func TestParseTourPlan(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected TourPlan
+		wantErr  bool
+	}{
+		{"", TourPlan{Start: 8 * time.Hour, ReturnBy: 16 * time.Hour}, false},
+		{"start=06:30&return_by=13:00", TourPlan{Start: 6*time.Hour + 30*time.Minute, ReturnBy: 13 * time.Hour}, false},
+		{"start=7am", TourPlan{}, true},
+		{"start=15:00&return_by=14:00", TourPlan{}, true},
+		{"date=2025-02-14", TourPlan{Start: 8 * time.Hour, ReturnBy: 16 * time.Hour, Date: time.Date(2025, 2, 14, 0, 0, 0, 0, time.UTC)}, false},
+		{"date=14.02.2025", TourPlan{}, true},
+		{"tz=Nowhere/Town", TourPlan{}, true},
+	}
+
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		plan, err := ParseTourPlan(query)
+		if (err != nil) != tt.wantErr || plan != tt.expected {
+			t.Errorf("%q: expected %+v, got %+v (%v)", tt.query, tt.expected, plan, err)
+		}
+	}
+}
@@ -6,10 +6,19 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
 
+const (
+	// stravaReadScope is requested from every athlete
+	stravaReadScope = "read,profile:read_all,activity:read_all"
+	// stravaWriteScope adds writing records to activity descriptions, and is
+	// only requested from athletes who enable it
+	stravaWriteScope = stravaReadScope + ",activity:write"
+)
+
 type TokenResponse struct {
 	TokenType    string `json:"token_type"`
 	ExpiresAt    int64  `json:"expires_at"`
@@ -22,6 +31,8 @@ type TokenResponse struct {
 	} `json:"athlete"`
 }
 
+// handleConnect starts the OAuth flow with the read scope, or with the write
+// scope when write=true
 func (s *ServerState) handleConnect(c echo.Context) error {
 	redirectUrl, err := url.JoinPath(s.config.BaseUrl, "oauth2/callback")
 	if err != nil {
@@ -33,7 +44,11 @@ func (s *ServerState) handleConnect(c echo.Context) error {
 	params.Add("client_id", s.config.StravaClientId)
 	params.Add("redirect_uri", redirectUrl)
 	params.Add("response_type", "code")
-	params.Add("scope", "read,activity:read_all")
+	scope := stravaReadScope
+	if c.QueryParam("write") == "true" {
+		scope = stravaWriteScope
+	}
+	params.Add("scope", scope)
 	authorizationUrl.RawQuery = params.Encode()
 
 	c.Redirect(http.StatusFound, authorizationUrl.String())
@@ -60,6 +75,14 @@ func (s *ServerState) handleCallback(c echo.Context) error {
 		slog.Error("failed to save token to redis", "athlete_id", token.Athlete.ID, "err", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save token to redis")
 	}
+	// strava reports the scopes the athlete accepted in the callback
+	err = s.store.SaveTokenScope(token.Athlete.ID, c.QueryParam("scope"))
+	if err != nil {
+		slog.Error("failed to save token scope", "athlete_id", token.Athlete.ID, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save token to redis")
+	}
+
+	s.startInitialBackfill(token.Athlete.ID)
 
 	// Display success page with token info
 	html, err := os.ReadFile("/usr/src/static/confirmation.html")
@@ -72,6 +95,16 @@ func (s *ServerState) handleCallback(c echo.Context) error {
 	return nil
 }
 
+// hasScope reports whether a comma separated list of scopes holds scope
+func hasScope(scopes string, scope string) bool {
+	for _, granted := range strings.Split(scopes, ",") {
+		if granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
 func exchangeCode(code string, config *Config, client *StravaClient) (*TokenResponse, error) {
 	formData := map[string]string{
 		"client_id":     config.StravaClientId,
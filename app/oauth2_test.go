@@ -4,7 +4,10 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
+
+	"github.com/labstack/echo/v4"
 )
 
 func TestExchangeCode(t *testing.T) {
@@ -229,3 +232,40 @@ func TestExchangeCode_FormDataEncoding(t *testing.T) {
 		t.Errorf("unexpected error: %v", err)
 	}
 }
+
+func TestHandleConnect_Scope(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected string
+	}{
+		{"", stravaReadScope},
+		{"?write=true", stravaWriteScope},
+	}
+
+	for _, tt := range tests {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/oauth2/connect"+tt.query, nil)
+		rec := httptest.NewRecorder()
+		s := &ServerState{config: Config{BaseUrl: "https://skintrackr.example", StravaClientId: "1"}}
+
+		if err := s.handleConnect(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		location, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if scope := location.Query().Get("scope"); scope != tt.expected {
+			t.Errorf("%q: expected scope %s, got %s", tt.query, tt.expected, scope)
+		}
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	if !hasScope("read,activity:write", "activity:write") {
+		t.Error("expected activity:write to be granted")
+	}
+	if hasScope("read,activity:read_all", "activity:write") || hasScope("", "activity:write") {
+		t.Error("expected activity:write not to be granted")
+	}
+}
@@ -0,0 +1,388 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultSummitRadius             = 100.0
+	defaultSummitElevationTolerance = 50.0
+	// maxSummitRadius keeps summit searches within neighbouring index cells
+	maxSummitRadius = 1000.0
+
+	// peakCellSize is the size of the peak index cells in degrees
+	peakCellSize = 0.1
+)
+
+// Peak is a named summit from the peaks file. Elevation is 0 when the file
+// does not give one.
+type Peak struct {
+	Id        string  `json:"id"`
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Elevation float64 `json:"elevation_m,omitempty"`
+}
+
+// Summit is a peak reached during an activity
+type Summit struct {
+	Peak
+	// Time is when the activity came closest to the peak, in seconds from
+	// its start
+	Time     float64 `json:"time_s"`
+	Distance float64 `json:"distance_m"`
+}
+
+// ActivitySummits are the summits reached during one activity
+type ActivitySummits struct {
+	ActivityId int      `json:"activity_id"`
+	Date       string   `json:"date"`
+	Summits    []Summit `json:"summits"`
+}
+
+// PeakVisits are an athlete's visits to one peak
+type PeakVisits struct {
+	Peak
+	Count           int    `json:"count"`
+	FirstVisit      string `json:"first_visit"`
+	FirstActivityId int    `json:"first_activity_id"`
+	LastVisit       string `json:"last_visit"`
+	LastActivityId  int    `json:"last_activity_id"`
+}
+
+// SummitOptions control how close an activity must come to a peak to count
+// as reaching it
+type SummitOptions struct {
+	Radius float64
+	// ElevationTolerance is how far below the peak's elevation the closest
+	// point may be, ignored for peaks without an elevation
+	ElevationTolerance float64
+}
+
+// DefaultSummitOptions returns the options used when none are configured
+func DefaultSummitOptions() SummitOptions {
+	return SummitOptions{Radius: defaultSummitRadius, ElevationTolerance: defaultSummitElevationTolerance}
+}
+
+// PeakIndex looks up peaks near a point
+type PeakIndex struct {
+	peaks []Peak
+	cells map[[2]int][]int
+}
+
+// http request handlers
+
+// handlePeaks returns the peaks the authenticated athlete has reached, most
+// recently visited first
+func (s *ServerState) handlePeaks(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	activities, err := s.store.FetchActivitySummits(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch summits", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch peaks")
+	}
+	return c.JSON(http.StatusOK, BuildPeakList(activities))
+}
+
+// public functions
+
+// LoadPeaks reads a GeoJSON FeatureCollection of Point features or a CSV file
+// with a header row. Names come from the name property or column and
+// elevations from ele or elevation, or the third GeoJSON coordinate.
+func LoadPeaks(path string) (*PeakIndex, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var peaks []Peak
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		peaks, err = parsePeaksCsv(file)
+	case ".geojson", ".json":
+		peaks, err = parsePeaksGeoJSON(file)
+	default:
+		return nil, fmt.Errorf("unsupported peaks file %s, expected .geojson or .csv", filepath.Base(path))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewPeakIndex(peaks), nil
+}
+
+// NewPeakIndex indexes peaks by location. Peaks without an id are given one
+// from their coordinates.
+func NewPeakIndex(peaks []Peak) *PeakIndex {
+	index := &PeakIndex{peaks: peaks, cells: map[[2]int][]int{}}
+	for i := range peaks {
+		if peaks[i].Id == "" {
+			peaks[i].Id = fmt.Sprintf("%.5f,%.5f", peaks[i].Latitude, peaks[i].Longitude)
+		}
+		cell := peakCell(peaks[i].Latitude, peaks[i].Longitude)
+		index.cells[cell] = append(index.cells[cell], i)
+	}
+	return index
+}
+
+// Len returns the number of indexed peaks
+func (p *PeakIndex) Len() int {
+	return len(p.peaks)
+}
+
+// FindSummits returns the peaks the track comes within the radius and
+// elevation tolerance of, in the order they were reached, each once at the
+// track's closest approach
+func (p *PeakIndex) FindSummits(streamPoints []StravaStreamPoint, options SummitOptions) []Summit {
+	closest := map[int]Summit{}
+	for _, point := range streamPoints {
+		if point.Latitude == 0 && point.Longitude == 0 {
+			continue
+		}
+		cell := peakCell(point.Latitude, point.Longitude)
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				for _, i := range p.cells[[2]int{cell[0] + dy, cell[1] + dx}] {
+					peak := p.peaks[i]
+					distance := haversineDistance(point.Latitude, point.Longitude, peak.Latitude, peak.Longitude)
+					if distance > options.Radius {
+						continue
+					}
+					if peak.Elevation != 0 && point.Altitude < peak.Elevation-options.ElevationTolerance {
+						continue
+					}
+					if previous, ok := closest[i]; ok && previous.Distance <= distance {
+						continue
+					}
+					closest[i] = Summit{Peak: peak, Time: point.Time, Distance: roundTo(distance, 1)}
+				}
+			}
+		}
+	}
+
+	summits := make([]Summit, 0, len(closest))
+	for _, summit := range closest {
+		summits = append(summits, summit)
+	}
+	sort.Slice(summits, func(i, j int) bool {
+		if summits[i].Time != summits[j].Time {
+			return summits[i].Time < summits[j].Time
+		}
+		return summits[i].Id < summits[j].Id
+	})
+	return summits
+}
+
+// BuildPeakList totals the summits of all activities per peak, most recently
+// visited first
+func BuildPeakList(activities []ActivitySummits) []PeakVisits {
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].Date != activities[j].Date {
+			return activities[i].Date < activities[j].Date
+		}
+		return activities[i].ActivityId < activities[j].ActivityId
+	})
+
+	byPeak := map[string]*PeakVisits{}
+	for _, activity := range activities {
+		for _, summit := range activity.Summits {
+			visits, ok := byPeak[summit.Id]
+			if !ok {
+				visits = &PeakVisits{FirstVisit: activity.Date, FirstActivityId: activity.ActivityId}
+				byPeak[summit.Id] = visits
+			}
+			visits.Peak = summit.Peak
+			visits.Count++
+			visits.LastVisit = activity.Date
+			visits.LastActivityId = activity.ActivityId
+		}
+	}
+
+	result := make([]PeakVisits, 0, len(byPeak))
+	for _, visits := range byPeak {
+		result = append(result, *visits)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].LastVisit != result[j].LastVisit {
+			return result[i].LastVisit > result[j].LastVisit
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// helpers
+
+// updateSummits lists the summits reached on the activity summary and stores
+// them for the athlete's peak list
+func (s *ServerState) updateSummits(summary *ActivitySummary, activity StravaActivity, streamPoints []StravaStreamPoint) error {
+	if s.peaks == nil {
+		return nil
+	}
+
+	summary.Summits = s.peaks.FindSummits(streamPoints, s.config.Summits)
+	if len(summary.Summits) == 0 {
+		return s.store.DeleteActivitySummits(activity.Athlete.Id, activity.Id)
+	}
+	return s.store.SaveActivitySummits(activity.Athlete.Id, ActivitySummits{
+		ActivityId: activity.Id,
+		Date:       activityLocalDate(activity, *summary).Format("2006-01-02"),
+		Summits:    summary.Summits,
+	})
+}
+
+func peakCell(lat, lon float64) [2]int {
+	return [2]int{int(math.Floor(lat / peakCellSize)), int(math.Floor(lon / peakCellSize))}
+}
+
+func parsePeaksGeoJSON(r io.Reader) ([]Peak, error) {
+	var collection geoJSONFeatureCollection
+	if err := json.NewDecoder(r).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("failed to decode peaks: %w", err)
+	}
+
+	var peaks []Peak
+	for i, feature := range collection.Features {
+		if feature.Geometry.Type != "Point" {
+			continue
+		}
+		coordinates, ok := feature.Geometry.Coordinates.([]any)
+		if !ok || len(coordinates) < 2 {
+			return nil, fmt.Errorf("feature %d: invalid coordinates", i)
+		}
+		position := make([]float64, len(coordinates))
+		for j, value := range coordinates {
+			number, ok := value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("feature %d: invalid coordinates", i)
+			}
+			position[j] = number
+		}
+
+		peak := Peak{Longitude: position[0], Latitude: position[1]}
+		if len(position) > 2 {
+			peak.Elevation = position[2]
+		}
+		for key, value := range feature.Properties {
+			switch strings.ToLower(key) {
+			case "id":
+				peak.Id = fmt.Sprint(value)
+			case "name":
+				peak.Name, _ = value.(string)
+			case "ele", "elevation":
+				if elevation, ok := propertyFloat(value); ok {
+					peak.Elevation = elevation
+				}
+			}
+		}
+		if err := peak.validate(); err != nil {
+			return nil, fmt.Errorf("feature %d: %w", i, err)
+		}
+		peaks = append(peaks, peak)
+	}
+	return peaks, nil
+}
+
+func parsePeaksCsv(r io.Reader) ([]Peak, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read peaks header: %w", err)
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "id":
+			columns["id"] = i
+		case "name":
+			columns["name"] = i
+		case "lat", "latitude":
+			columns["lat"] = i
+		case "lon", "lng", "longitude":
+			columns["lon"] = i
+		case "ele", "elevation":
+			columns["ele"] = i
+		}
+	}
+	for _, required := range []string{"name", "lat", "lon"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("peaks file must have name, latitude and longitude columns")
+		}
+	}
+
+	var peaks []Peak
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read peaks: %w", err)
+		}
+
+		peak := Peak{Name: record[columns["name"]]}
+		if i, ok := columns["id"]; ok {
+			peak.Id = record[i]
+		}
+		if peak.Latitude, err = strconv.ParseFloat(record[columns["lat"]], 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid latitude", line)
+		}
+		if peak.Longitude, err = strconv.ParseFloat(record[columns["lon"]], 64); err != nil {
+			return nil, fmt.Errorf("line %d: invalid longitude", line)
+		}
+		if i, ok := columns["ele"]; ok && record[i] != "" {
+			if peak.Elevation, err = strconv.ParseFloat(record[i], 64); err != nil {
+				return nil, fmt.Errorf("line %d: invalid elevation", line)
+			}
+		}
+		if err := peak.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		peaks = append(peaks, peak)
+	}
+	return peaks, nil
+}
+
+func (p *Peak) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return errors.New("peak has no name")
+	}
+	if p.Latitude < -90 || p.Latitude > 90 || p.Longitude < -180 || p.Longitude > 180 {
+		return errors.New("invalid coordinates")
+	}
+	return nil
+}
+
+// propertyFloat reads a numeric GeoJSON property, which peak datasets
+// sometimes store as a string
+func propertyFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		number, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return number, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,106 @@
+package app
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPeaks(t *testing.T) {
+	dir := t.TempDir()
+	geojson := filepath.Join(dir, "peaks.geojson")
+	os.WriteFile(geojson, []byte(`{"type": "FeatureCollection", "features": [
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [-71.3033, 44.2706]}, "properties": {"name": "Mount Washington", "ele": "1917"}},
+		{"type": "Feature", "geometry": {"type": "Point", "coordinates": [-71.3170, 44.2550, 1600]}, "properties": {"name": "Boott Spur", "id": "boott"}},
+		{"type": "Feature", "geometry": {"type": "LineString", "coordinates": [[0, 0], [1, 1]]}, "properties": {"name": "Ridge"}}
+	]}`), 0o644)
+	csv := filepath.Join(dir, "peaks.csv")
+	os.WriteFile(csv, []byte("name,lat,lon,elevation\nMount Washington,44.2706,-71.3033,1917\n\"Lion Head\",44.2650,-71.2920,\n"), 0o644)
+
+	peaks, err := LoadPeaks(geojson)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peaks.Len() != 2 {
+		t.Fatalf("expected 2 peaks, got %d", peaks.Len())
+	}
+	if peak := peaks.peaks[0]; peak.Elevation != 1917 || peak.Id != "44.27060,-71.30330" {
+		t.Errorf("unexpected peak %+v", peak)
+	}
+	if peak := peaks.peaks[1]; peak.Elevation != 1600 || peak.Id != "boott" {
+		t.Errorf("unexpected peak %+v", peak)
+	}
+
+	peaks, err = LoadPeaks(csv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peaks.Len() != 2 || peaks.peaks[1].Name != "Lion Head" || peaks.peaks[1].Elevation != 0 {
+		t.Errorf("unexpected peaks %+v", peaks.peaks)
+	}
+
+	invalid := filepath.Join(dir, "invalid.csv")
+	os.WriteFile(invalid, []byte("name,latitude\nMount Washington,44.27\n"), 0o644)
+	if _, err := LoadPeaks(invalid); err == nil {
+		t.Error("expected a file without longitudes to fail")
+	}
+	if _, err := LoadPeaks(filepath.Join(dir, "peaks.kml")); err == nil {
+		t.Error("expected an unsupported file to fail")
+	}
+}
+
+func TestFindSummits(t *testing.T) {
+	// skiTourPoints tops out at 1300 m about 1440 m north of its start, then
+	// descends north and climbs to 1200 m
+	points := skiTourPoints()
+	top := points[179]
+	second := points[377]
+	index := NewPeakIndex([]Peak{
+		// 50 m east of the first top
+		{Id: "a", Name: "First Top", Latitude: top.Latitude, Longitude: -70.99937, Elevation: 1320},
+		{Id: "b", Name: "Second Top", Latitude: second.Latitude, Longitude: -71},
+		// close by, but far above the track
+		{Id: "c", Name: "Tower", Latitude: top.Latitude, Longitude: -71.0005, Elevation: 1500},
+		{Id: "d", Name: "Far Away", Latitude: 45, Longitude: -71},
+	})
+
+	summits := index.FindSummits(points, SummitOptions{Radius: 100, ElevationTolerance: 50})
+	if len(summits) != 2 {
+		t.Fatalf("expected 2 summits, got %+v", summits)
+	}
+	if summits[0].Id != "a" || summits[0].Distance < 45 || summits[0].Distance > 55 {
+		t.Errorf("unexpected first summit %+v", summits[0])
+	}
+	if summits[1].Id != "b" || summits[1].Time != second.Time {
+		t.Errorf("expected the second summit at its closest approach, got %+v", summits[1])
+	}
+
+	if summits := index.FindSummits(points, SummitOptions{Radius: 30, ElevationTolerance: 50}); len(summits) != 1 {
+		t.Errorf("expected only the closest summit within 30 m, got %+v", summits)
+	}
+}
+
+func TestBuildPeakList(t *testing.T) {
+	washington := Peak{Id: "w", Name: "Mount Washington", Elevation: 1917}
+	boott := Peak{Id: "b", Name: "Boott Spur", Elevation: 1600}
+	activities := []ActivitySummits{
+		{ActivityId: 3, Date: "2025-03-01", Summits: []Summit{{Peak: washington}}},
+		{ActivityId: 1, Date: "2025-01-15", Summits: []Summit{{Peak: boott}, {Peak: washington}}},
+		{ActivityId: 2, Date: "2025-02-01", Summits: []Summit{{Peak: washington}}},
+	}
+
+	peaks := BuildPeakList(activities)
+	if len(peaks) != 2 {
+		t.Fatalf("expected 2 peaks, got %+v", peaks)
+	}
+	first := peaks[0]
+	if first.Id != "w" || first.Count != 3 || first.FirstActivityId != 1 || first.LastActivityId != 3 {
+		t.Errorf("unexpected visits %+v", first)
+	}
+	if first.FirstVisit != "2025-01-15" || first.LastVisit != "2025-03-01" {
+		t.Errorf("unexpected visit dates %+v", first)
+	}
+	if peaks[1].Id != "b" || peaks[1].Count != 1 {
+		t.Errorf("unexpected visits %+v", peaks[1])
+	}
+}
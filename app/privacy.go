@@ -0,0 +1,256 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	minPrivacyZoneRadius = 100.0
+	maxPrivacyZoneRadius = 5000.0
+
+	// hideFromHomeDistance is trimmed from both ends of activities strava
+	// has hidden from the home feed when exporting for shared links
+	hideFromHomeDistance = 500.0
+)
+
+type PrivacyMode string
+
+const (
+	// PrivacyModeRemove drops points inside a zone
+	PrivacyModeRemove PrivacyMode = "remove"
+	// PrivacyModeFuzz moves points inside a zone onto the zone's decoy point
+	PrivacyModeFuzz PrivacyMode = "fuzz"
+)
+
+// PrivacyZone is a circle around a sensitive location such as a home or
+// cabin
+type PrivacyZone struct {
+	Id           string  `json:"id"`
+	Name         string  `json:"name"`
+	Latitude     float64 `json:"latitude"`
+	Longitude    float64 `json:"longitude"`
+	RadiusMeters float64 `json:"radius_m"`
+
+	// the decoy point is chosen once when the zone is created so repeated
+	// exports cannot be averaged to find the center
+	DecoyLatitude  float64 `json:"decoy_latitude"`
+	DecoyLongitude float64 `json:"decoy_longitude"`
+}
+
+// PrivacySettings are an athlete's stored privacy zones
+type PrivacySettings struct {
+	Mode  PrivacyMode   `json:"mode"`
+	Zones []PrivacyZone `json:"zones"`
+}
+
+// PrivacyOptions control how a track is trimmed before it is exported or
+// rendered
+type PrivacyOptions struct {
+	Mode  PrivacyMode
+	Zones []PrivacyZone
+	// HideFromHome trims the start and end of activities strava has hidden
+	// from the home feed
+	HideFromHome bool
+}
+
+// Options returns the export options for the stored settings
+func (p PrivacySettings) Options(hideFromHome bool) PrivacyOptions {
+	return PrivacyOptions{Mode: p.Mode, Zones: p.Zones, HideFromHome: hideFromHome}
+}
+
+func (z PrivacyZone) contains(point StravaStreamPoint) bool {
+	return haversineDistance(z.Latitude, z.Longitude, point.Latitude, point.Longitude) <= z.RadiusMeters
+}
+
+// http request handlers
+
+// handlePrivacyZonesGet returns the authenticated athlete's privacy zones
+func (s *ServerState) handlePrivacyZonesGet(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	settings, err := s.store.FetchPrivacySettings(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch privacy zones", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch privacy zones")
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// handlePrivacyZonesPut replaces the authenticated athlete's privacy zones
+func (s *ServerState) handlePrivacyZonesPut(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	var settings PrivacySettings
+	if err := c.Bind(&settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid privacy zones")
+	}
+	if err := settings.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	existing, err := s.store.FetchPrivacySettings(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch privacy zones", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save privacy zones")
+	}
+	settings.assignDecoys(existing)
+
+	err = s.store.SavePrivacySettings(tokenInfo.athleteId, settings)
+	if err != nil {
+		slog.Error("failed to save privacy zones", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save privacy zones")
+	}
+
+	// heatmap tiles are rendered with the zones removed
+	if err := s.invalidateHeatmap(tokenInfo.athleteId); err != nil {
+		slog.Error("failed to invalidate heatmap", "athlete_id", tokenInfo.athleteId, "err", err)
+	}
+
+	slog.Info("saved privacy zones", "athlete_id", tokenInfo.athleteId, "zones", len(settings.Zones))
+	return c.JSON(http.StatusOK, settings)
+}
+
+// helpers
+
+func (p *PrivacySettings) validate() error {
+	if p.Mode == "" {
+		p.Mode = PrivacyModeRemove
+	}
+	if p.Mode != PrivacyModeRemove && p.Mode != PrivacyModeFuzz {
+		return fmt.Errorf("mode must be %q or %q", PrivacyModeRemove, PrivacyModeFuzz)
+	}
+
+	for i, zone := range p.Zones {
+		if zone.Latitude < -90 || zone.Latitude > 90 || zone.Longitude < -180 || zone.Longitude > 180 {
+			return fmt.Errorf("zone %d: invalid coordinates", i)
+		}
+		if zone.RadiusMeters < minPrivacyZoneRadius || zone.RadiusMeters > maxPrivacyZoneRadius {
+			return fmt.Errorf("zone %d: radius must be between %.0f and %.0f meters", i, minPrivacyZoneRadius, maxPrivacyZoneRadius)
+		}
+	}
+	return nil
+}
+
+// assignDecoys gives new or moved zones an id and a decoy point while
+// keeping the decoys of unchanged zones stable
+func (p *PrivacySettings) assignDecoys(existing PrivacySettings) {
+	previous := map[string]PrivacyZone{}
+	for _, zone := range existing.Zones {
+		previous[zone.Id] = zone
+	}
+
+	for i := range p.Zones {
+		zone := &p.Zones[i]
+		old, ok := previous[zone.Id]
+		if ok && old.Latitude == zone.Latitude && old.Longitude == zone.Longitude && old.RadiusMeters == zone.RadiusMeters {
+			zone.DecoyLatitude, zone.DecoyLongitude = old.DecoyLatitude, old.DecoyLongitude
+			continue
+		}
+
+		if zone.Id == "" {
+			zone.Id = randomString(8)
+		}
+
+		// somewhere between a quarter and three quarters of the radius from
+		// the center, in a random direction
+		bearing := rand.Float64() * 2 * math.Pi
+		distance := zone.RadiusMeters * (0.25 + rand.Float64()*0.5)
+		zone.DecoyLatitude = zone.Latitude + distance*math.Cos(bearing)/earthRadiusMeters*180/math.Pi
+		zone.DecoyLongitude = zone.Longitude + distance*math.Sin(bearing)/(earthRadiusMeters*math.Cos(zone.Latitude*math.Pi/180))*180/math.Pi
+	}
+}
+
+// applyPrivacy removes or fuzzes points according to the options. Lap
+// indices of the returned activity refer to the returned stream.
+func applyPrivacy(activity StravaActivity, streamPoints []StravaStreamPoint, options PrivacyOptions) (StravaActivity, []StravaStreamPoint) {
+	hidden, fuzzed := privacyMatches(activity, streamPoints, options)
+
+	// indexMap[i] is the index of original point i in the output, or the
+	// index of the next kept point when it was removed
+	indexMap := make([]int, len(streamPoints)+1)
+	result := make([]StravaStreamPoint, 0, len(streamPoints))
+	for i, point := range streamPoints {
+		indexMap[i] = len(result)
+		if hidden[i] {
+			continue
+		}
+		if zone := fuzzed[i]; zone != nil {
+			point.Latitude, point.Longitude = zone.DecoyLatitude, zone.DecoyLongitude
+		}
+		result = append(result, point)
+	}
+	indexMap[len(streamPoints)] = len(result)
+
+	if len(activity.Laps) > 0 {
+		laps := make([]StravaLap, len(activity.Laps))
+		for i, lap := range activity.Laps {
+			start := min(max(lap.StartIndex, 0), len(streamPoints))
+			end := min(max(lap.EndIndex+1, start), len(streamPoints))
+			lap.StartIndex = indexMap[start]
+			lap.EndIndex = indexMap[end] - 1
+			laps[i] = lap
+		}
+		activity.Laps = laps
+	}
+
+	// the summary endpoints reveal the hidden locations too
+	if len(result) < len(streamPoints) || fuzzedAny(fuzzed) {
+		activity.StartLatLon, activity.EndLatLon = [2]float64{}, [2]float64{}
+	}
+
+	return activity, result
+}
+
+// privacyMatches marks the points applyPrivacy removes and the zones of the
+// points it fuzzes
+func privacyMatches(activity StravaActivity, streamPoints []StravaStreamPoint, options PrivacyOptions) ([]bool, []*PrivacyZone) {
+	hidden := make([]bool, len(streamPoints))
+	fuzzed := make([]*PrivacyZone, len(streamPoints))
+
+	for i, point := range streamPoints {
+		for z := range options.Zones {
+			if options.Zones[z].contains(point) {
+				if options.Mode == PrivacyModeFuzz {
+					fuzzed[i] = &options.Zones[z]
+				} else {
+					hidden[i] = true
+				}
+				break
+			}
+		}
+	}
+
+	if options.HideFromHome && activity.HideFromHome {
+		distances := cumulativeDistances(streamPoints)
+		if len(distances) > 0 {
+			total := distances[len(distances)-1]
+			for i, distance := range distances {
+				if distance < hideFromHomeDistance || total-distance < hideFromHomeDistance {
+					hidden[i] = true
+				}
+			}
+		}
+	}
+	return hidden, fuzzed
+}
+
+func fuzzedAny(fuzzed []*PrivacyZone) bool {
+	for _, zone := range fuzzed {
+		if zone != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"testing"
+)
+
+// linePoints returns points heading north from the origin, roughly 111 m
+// apart
+func linePoints(count int) []StravaStreamPoint {
+	points := make([]StravaStreamPoint, count)
+	for i := range points {
+		points[i] = StravaStreamPoint{Time: float64(i * 10), Latitude: 44 + float64(i)*0.001, Longitude: -71, Altitude: 500 + float64(i)}
+	}
+	return points
+}
+
+func TestApplyPrivacy_RemoveMode(t *testing.T) {
+	points := linePoints(10)
+	zone := PrivacyZone{Latitude: 44, Longitude: -71, RadiusMeters: 250}
+	activity := StravaActivity{
+		StartLatLon: [2]float64{44, -71},
+		Laps:        []StravaLap{{StartIndex: 0, EndIndex: 4}, {StartIndex: 5, EndIndex: 9}},
+	}
+
+	filtered, result := applyPrivacy(activity, points, PrivacyOptions{Mode: PrivacyModeRemove, Zones: []PrivacyZone{zone}})
+
+	// points 0-2 are within 250 m of the zone center
+	if len(result) != 7 {
+		t.Fatalf("expected 7 points, got %d", len(result))
+	}
+	if result[0].Latitude != points[3].Latitude {
+		t.Errorf("expected first remaining point to be original point 3")
+	}
+	for _, point := range result {
+		if zone.contains(point) {
+			t.Errorf("point %v inside privacy zone was not removed", point)
+		}
+	}
+
+	if filtered.StartLatLon != [2]float64{} {
+		t.Error("expected start location to be cleared")
+	}
+	if activity.Laps[0].StartIndex != 0 {
+		t.Error("expected original activity laps to be left unchanged")
+	}
+
+	ranges := activityLapRanges(filtered, len(result))
+	expected := [][2]int{{0, 2}, {2, 7}}
+	if len(ranges) != len(expected) || ranges[0] != expected[0] || ranges[1] != expected[1] {
+		t.Errorf("expected remapped lap ranges %v, got %v", expected, ranges)
+	}
+}
+
+func TestApplyPrivacy_FuzzMode(t *testing.T) {
+	points := linePoints(10)
+	zone := PrivacyZone{Latitude: 44, Longitude: -71, RadiusMeters: 250, DecoyLatitude: 44.001, DecoyLongitude: -71.002}
+
+	_, result := applyPrivacy(StravaActivity{}, points, PrivacyOptions{Mode: PrivacyModeFuzz, Zones: []PrivacyZone{zone}})
+
+	if len(result) != len(points) {
+		t.Fatalf("expected fuzzing to keep all %d points, got %d", len(points), len(result))
+	}
+	for i := 0; i < 3; i++ {
+		if result[i].Latitude != zone.DecoyLatitude || result[i].Longitude != zone.DecoyLongitude {
+			t.Errorf("point %d: expected decoy location, got %f,%f", i, result[i].Latitude, result[i].Longitude)
+		}
+		if result[i].Altitude != points[i].Altitude {
+			t.Errorf("point %d: expected altitude to be kept", i)
+		}
+	}
+	if result[3] != points[3] {
+		t.Error("expected points outside the zone to be unchanged")
+	}
+}
+
+func TestApplyPrivacy_HideFromHome(t *testing.T) {
+	points := linePoints(20)
+
+	_, unchanged := applyPrivacy(StravaActivity{HideFromHome: false}, points, PrivacyOptions{HideFromHome: true})
+	if len(unchanged) != len(points) {
+		t.Errorf("expected visible activity to be unchanged, got %d points", len(unchanged))
+	}
+
+	_, notShared := applyPrivacy(StravaActivity{HideFromHome: true}, points, PrivacyOptions{})
+	if len(notShared) != len(points) {
+		t.Errorf("expected hide_from_home to be ignored unless requested, got %d points", len(notShared))
+	}
+
+	_, trimmed := applyPrivacy(StravaActivity{HideFromHome: true}, points, PrivacyOptions{HideFromHome: true})
+	// ~2.1 km track loses the first and last 500 m
+	if len(trimmed) != 10 {
+		t.Errorf("expected 10 points after trimming ends, got %d", len(trimmed))
+	}
+}
+
+func TestPrivacySettings_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		settings    PrivacySettings
+		expectError bool
+	}{
+		{name: "defaults mode", settings: PrivacySettings{Zones: []PrivacyZone{{Latitude: 44, Longitude: -71, RadiusMeters: 200}}}},
+		{name: "unknown mode", settings: PrivacySettings{Mode: "blur"}, expectError: true},
+		{name: "radius too small", settings: PrivacySettings{Zones: []PrivacyZone{{Latitude: 44, Longitude: -71, RadiusMeters: 10}}}, expectError: true},
+		{name: "invalid latitude", settings: PrivacySettings{Zones: []PrivacyZone{{Latitude: 95, Longitude: -71, RadiusMeters: 200}}}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.validate()
+			if tt.expectError != (err != nil) {
+				t.Errorf("expected error=%v, got %v", tt.expectError, err)
+			}
+			if err == nil && tt.settings.Mode != PrivacyModeRemove {
+				t.Errorf("expected default mode %q, got %q", PrivacyModeRemove, tt.settings.Mode)
+			}
+		})
+	}
+}
+
+func TestPrivacySettings_AssignDecoys(t *testing.T) {
+	settings := PrivacySettings{Zones: []PrivacyZone{{Name: "home", Latitude: 44, Longitude: -71, RadiusMeters: 400}}}
+	settings.assignDecoys(PrivacySettings{})
+
+	zone := settings.Zones[0]
+	if zone.Id == "" {
+		t.Fatal("expected new zone to be assigned an id")
+	}
+	decoyDistance := haversineDistance(zone.Latitude, zone.Longitude, zone.DecoyLatitude, zone.DecoyLongitude)
+	if decoyDistance < 99 || decoyDistance > 301 {
+		t.Errorf("expected decoy between 100 and 300 m from center, got %.1f m", decoyDistance)
+	}
+
+	// resubmitting the same zone keeps its decoy
+	resubmitted := PrivacySettings{Zones: []PrivacyZone{{Id: zone.Id, Name: "home", Latitude: 44, Longitude: -71, RadiusMeters: 400}}}
+	resubmitted.assignDecoys(settings)
+	if resubmitted.Zones[0].DecoyLatitude != zone.DecoyLatitude || resubmitted.Zones[0].DecoyLongitude != zone.DecoyLongitude {
+		t.Error("expected unchanged zone to keep its decoy")
+	}
+}
@@ -0,0 +1,130 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// rateLimitWindow is strava's short rate limit window. Windows start on
+	// the quarter hour.
+	rateLimitWindow = 15 * time.Minute
+
+	// backgroundRateLimitShare is the fraction of each rate limit background
+	// jobs may use, leaving the rest for webhook processing and API requests
+	backgroundRateLimitShare = 0.5
+)
+
+// RateLimitState tracks strava's application rate limits as reported in the
+// X-RateLimit-Limit and X-RateLimit-Usage headers of every response
+type RateLimitState struct {
+	mu         sync.Mutex
+	shortLimit int
+	shortUsage int
+	dailyLimit int
+	dailyUsage int
+	updatedAt  time.Time
+
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// stravaRateLimits is shared by all strava clients since the limits apply to
+// the application rather than to an athlete's token
+var stravaRateLimits = newRateLimitState()
+
+func newRateLimitState() *RateLimitState {
+	return &RateLimitState{now: time.Now, sleep: sleepContext}
+}
+
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Update records the limits reported in a response. Responses without rate
+// limit headers are ignored.
+func (r *RateLimitState) Update(header http.Header) {
+	shortLimit, dailyLimit, ok := parseRateLimitHeader(header.Get("X-RateLimit-Limit"))
+	if !ok {
+		return
+	}
+	shortUsage, dailyUsage, ok := parseRateLimitHeader(header.Get("X-RateLimit-Usage"))
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.shortLimit, r.dailyLimit = shortLimit, dailyLimit
+	r.shortUsage, r.dailyUsage = shortUsage, dailyUsage
+	r.updatedAt = r.now()
+}
+
+// WaitForBackgroundCapacity blocks until background work may make another
+// request without eating into the share reserved for foreground requests
+func (r *RateLimitState) WaitForBackgroundCapacity(ctx context.Context) error {
+	for {
+		wait := r.backgroundDelay()
+		if wait <= 0 {
+			return nil
+		}
+		if err := r.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// backgroundDelay returns how long background work must wait before the
+// next request, or zero when it may proceed
+func (r *RateLimitState) backgroundDelay() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.updatedAt.IsZero() {
+		return 0
+	}
+
+	now := r.now().UTC()
+	updatedAt := r.updatedAt.UTC()
+
+	// usage resets at the start of each window and at midnight UTC
+	shortReset := updatedAt.Truncate(rateLimitWindow).Add(rateLimitWindow)
+	dailyReset := time.Date(updatedAt.Year(), updatedAt.Month(), updatedAt.Day()+1, 0, 0, 0, 0, time.UTC)
+
+	if now.Before(dailyReset) && float64(r.dailyUsage) >= float64(r.dailyLimit)*backgroundRateLimitShare {
+		return dailyReset.Sub(now)
+	}
+	if now.Before(shortReset) && float64(r.shortUsage) >= float64(r.shortLimit)*backgroundRateLimitShare {
+		return shortReset.Sub(now)
+	}
+	return 0
+}
+
+// parseRateLimitHeader parses strava's "<15 minute>,<daily>" header format
+func parseRateLimitHeader(value string) (int, int, bool) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	short, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, false
+	}
+	daily, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, false
+	}
+	return short, daily, true
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimitHeader(t *testing.T) {
+	tests := []struct {
+		value         string
+		expectedShort int
+		expectedDaily int
+		expectOk      bool
+	}{
+		{value: "200,2000", expectedShort: 200, expectedDaily: 2000, expectOk: true},
+		{value: "12, 340", expectedShort: 12, expectedDaily: 340, expectOk: true},
+		{value: "", expectOk: false},
+		{value: "200", expectOk: false},
+		{value: "a,b", expectOk: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			short, daily, ok := parseRateLimitHeader(tt.value)
+			if ok != tt.expectOk {
+				t.Fatalf("expected ok=%v, got %v", tt.expectOk, ok)
+			}
+			if ok && (short != tt.expectedShort || daily != tt.expectedDaily) {
+				t.Errorf("expected %d,%d got %d,%d", tt.expectedShort, tt.expectedDaily, short, daily)
+			}
+		})
+	}
+}
+
+func TestRateLimitState_BackgroundDelay(t *testing.T) {
+	now := time.Date(2025, 2, 14, 10, 7, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		usage    string
+		expected time.Duration
+	}{
+		{name: "no usage", usage: "0,0", expected: 0},
+		{name: "below background share", usage: "99,500", expected: 0},
+		{name: "short window exhausted", usage: "100,500", expected: 8 * time.Minute},
+		{name: "daily limit exhausted", usage: "10,1000", expected: 13*time.Hour + 53*time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := newRateLimitState()
+			state.now = func() time.Time { return now }
+
+			header := http.Header{}
+			header.Set("X-RateLimit-Limit", "200,2000")
+			header.Set("X-RateLimit-Usage", tt.usage)
+			state.Update(header)
+
+			if delay := state.backgroundDelay(); delay != tt.expected {
+				t.Errorf("expected delay %s, got %s", tt.expected, delay)
+			}
+		})
+	}
+}
+
+func TestRateLimitState_WaitResetsAfterWindow(t *testing.T) {
+	now := time.Date(2025, 2, 14, 10, 7, 0, 0, time.UTC)
+	state := newRateLimitState()
+	state.now = func() time.Time { return now }
+
+	var slept time.Duration
+	state.sleep = func(ctx context.Context, duration time.Duration) error {
+		slept += duration
+		now = now.Add(duration)
+		return nil
+	}
+
+	header := http.Header{}
+	header.Set("X-RateLimit-Limit", "200,2000")
+	header.Set("X-RateLimit-Usage", "150,500")
+	state.Update(header)
+
+	if err := state.WaitForBackgroundCapacity(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if slept != 8*time.Minute {
+		t.Errorf("expected to wait until the next window, waited %s", slept)
+	}
+}
+
+func TestRateLimitState_IgnoresMissingHeaders(t *testing.T) {
+	state := newRateLimitState()
+	state.Update(http.Header{})
+	if delay := state.backgroundDelay(); delay != 0 {
+		t.Errorf("expected no delay without rate limit headers, got %s", delay)
+	}
+}
@@ -0,0 +1,594 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// recordEventLimit is how many record events are kept per athlete
+	recordEventLimit = 50
+
+	// maxRecordTemplateSize limits description templates
+	maxRecordTemplateSize = 2000
+)
+
+type RecordKind string
+
+const (
+	RecordClimb300       RecordKind = "climb_300m"
+	RecordClimb500       RecordKind = "climb_500m"
+	RecordClimb1000      RecordKind = "climb_1000m"
+	RecordDayVertical    RecordKind = "day_vertical"
+	RecordLongestDescent RecordKind = "longest_descent"
+	RecordHighestPoint   RecordKind = "highest_point"
+)
+
+// recordDefinition describes a kind of personal record. Climb records are the
+// fastest time to gain Vertical meters and lower values are better. All other
+// records are in meters and higher values are better.
+type recordDefinition struct {
+	Kind     RecordKind
+	Label    string
+	Vertical float64
+}
+
+var recordDefinitions = []recordDefinition{
+	{RecordClimb300, "Fastest 300 m climb", 300},
+	{RecordClimb500, "Fastest 500 m climb", 500},
+	{RecordClimb1000, "Fastest 1000 m climb", 1000},
+	{RecordDayVertical, "Most vertical in a day", 0},
+	{RecordLongestDescent, "Longest continuous descent", 0},
+	{RecordHighestPoint, "Highest point", 0},
+}
+
+// defaultRecordTemplate is appended to activity descriptions when the athlete
+// has not set a template
+const defaultRecordTemplate = `{{range .Records}}🏆 New PR: {{.Label}} {{.Value}}{{if .Previous}} (previously {{.Previous}}){{end}}
+{{end}}`
+
+// recordsLock serializes rebuilding personal records, so that concurrent
+// webhook events cannot store stale records
+var recordsLock sync.Mutex
+
+// Effort is an activity's best effort towards one kind of record. Value is
+// seconds for climbs and meters otherwise.
+type Effort struct {
+	Kind  RecordKind `json:"kind"`
+	Value float64    `json:"value"`
+	// StartTime and EndTime locate climbs and descents in the activity, in
+	// seconds from its start
+	StartTime float64 `json:"start_time_s,omitempty"`
+	EndTime   float64 `json:"end_time_s,omitempty"`
+}
+
+// ActivityEfforts are the best efforts of one activity
+type ActivityEfforts struct {
+	ActivityId int    `json:"activity_id"`
+	Name       string `json:"name"`
+	// Date is the local start date of the activity
+	Date    string   `json:"date"`
+	Efforts []Effort `json:"efforts"`
+}
+
+// PersonalRecord is the best effort of an athlete across all activities
+type PersonalRecord struct {
+	Kind         RecordKind `json:"kind"`
+	Label        string     `json:"label"`
+	Value        float64    `json:"value"`
+	Unit         string     `json:"unit"`
+	ActivityId   int        `json:"activity_id"`
+	ActivityName string     `json:"activity_name"`
+	Date         string     `json:"date"`
+	StartTime    float64    `json:"start_time_s,omitempty"`
+	EndTime      float64    `json:"end_time_s,omitempty"`
+}
+
+// RecordEvent is emitted when an activity sets a new personal record
+type RecordEvent struct {
+	Record    PersonalRecord  `json:"record"`
+	Previous  *PersonalRecord `json:"previous,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// RecordSettings control writing new records back to activity descriptions,
+// which needs the activity:write scope
+type RecordSettings struct {
+	WriteDescription bool `json:"write_description"`
+	// Template is a text/template rendered with the activity name, the named
+	// zones it enters and new records, the default template when empty
+	Template string `json:"template,omitempty"`
+}
+
+type recordsResponse struct {
+	Records []PersonalRecord `json:"records"`
+	Events  []RecordEvent    `json:"events"`
+}
+
+// recordTemplateFuncs are available to record templates, join to list the
+// activity's zones
+var recordTemplateFuncs = template.FuncMap{"join": strings.Join}
+
+type recordTemplateRecord struct {
+	Kind     RecordKind
+	Label    string
+	Value    string
+	Previous string
+}
+
+type recordTemplateData struct {
+	ActivityName string
+	// Zones are the names of the named zones the activity enters
+	Zones   []string
+	Records []recordTemplateRecord
+}
+
+func (r RecordSettings) validate() error {
+	if len(r.Template) > maxRecordTemplateSize {
+		return fmt.Errorf("template must be at most %d characters", maxRecordTemplateSize)
+	}
+	if _, err := template.New("records").Funcs(recordTemplateFuncs).Parse(r.Template); err != nil {
+		return fmt.Errorf("invalid template: %w", err)
+	}
+	return nil
+}
+
+// http request handlers
+
+// handleRecords returns the authenticated athlete's personal records and most
+// recent record events
+func (s *ServerState) handleRecords(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	records, err := s.store.FetchPersonalRecords(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch personal records", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch personal records")
+	}
+
+	events, err := s.store.FetchRecordEvents(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch record events", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch personal records")
+	}
+	return c.JSON(http.StatusOK, recordsResponse{Records: records, Events: events})
+}
+
+// handleRecordSettingsGet returns the authenticated athlete's record settings
+func (s *ServerState) handleRecordSettingsGet(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	settings, err := s.store.FetchRecordSettings(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch record settings", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch record settings")
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// handleRecordSettingsPut replaces the authenticated athlete's record settings
+func (s *ServerState) handleRecordSettingsPut(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	var settings RecordSettings
+	if err := c.Bind(&settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid record settings")
+	}
+	if err := settings.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if settings.WriteDescription {
+		scope, err := s.store.FetchTokenScope(tokenInfo.athleteId)
+		if err != nil {
+			slog.Error("failed to fetch token scope", "athlete_id", tokenInfo.athleteId, "err", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save record settings")
+		}
+		if !hasScope(scope, "activity:write") {
+			connectUrl, _ := url.JoinPath(s.config.BaseUrl, "oauth2/connect")
+			return echo.NewHTTPError(http.StatusForbidden, fmt.Sprintf("Writing descriptions needs the activity:write scope, grant it at %s?write=true", connectUrl))
+		}
+	}
+
+	if err := s.store.SaveRecordSettings(tokenInfo.athleteId, settings); err != nil {
+		slog.Error("failed to save record settings", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save record settings")
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// public functions
+
+// FindEfforts finds the best efforts of an activity. Climbs and the highest
+// point use smoothed altitude, so that a single noisy sample cannot set a
+// record.
+func FindEfforts(activity StravaActivity, summary ActivitySummary, streamPoints []StravaStreamPoint) ActivityEfforts {
+	efforts := ActivityEfforts{
+		ActivityId: activity.Id,
+		Name:       activity.Name,
+		Date:       activityLocalDate(activity, summary).Format(seasonDateLayout),
+		Efforts:    []Effort{},
+	}
+	if len(streamPoints) == 0 {
+		return efforts
+	}
+
+	altitudes := smoothAltitude(streamPoints, defaultSmoothingWindow)
+	for _, definition := range recordDefinitions {
+		switch {
+		case definition.Vertical > 0:
+			var best *Effort
+			for _, bounds := range humanPoweredRanges(summary, len(streamPoints)) {
+				start, end, ok := fastestClimb(streamPoints[bounds[0]:bounds[1]+1], altitudes[bounds[0]:bounds[1]+1], definition.Vertical)
+				if !ok {
+					continue
+				}
+				start, end = start+bounds[0], end+bounds[0]
+				if effort := streamPoints[end].Time - streamPoints[start].Time; best == nil || effort < best.Value {
+					best = &Effort{
+						Kind:      definition.Kind,
+						Value:     effort,
+						StartTime: streamPoints[start].Time,
+						EndTime:   streamPoints[end].Time,
+					}
+				}
+			}
+			if best != nil {
+				efforts.Efforts = append(efforts.Efforts, *best)
+			}
+		case definition.Kind == RecordDayVertical:
+			if summary.SkinningVertical > 0 {
+				efforts.Efforts = append(efforts.Efforts, Effort{Kind: definition.Kind, Value: summary.SkinningVertical})
+			}
+		case definition.Kind == RecordLongestDescent:
+			var longest *LapStats
+			for i, lap := range summary.Laps {
+				if lap.Kind == LapDescent && (longest == nil || lap.VerticalLoss > longest.VerticalLoss) {
+					longest = &summary.Laps[i]
+				}
+			}
+			if longest != nil && longest.VerticalLoss > 0 {
+				startTime := streamPoints[longest.StartIndex].Time
+				efforts.Efforts = append(efforts.Efforts, Effort{
+					Kind:      definition.Kind,
+					Value:     longest.VerticalLoss,
+					StartTime: startTime,
+					EndTime:   startTime + longest.Duration,
+				})
+			}
+		case definition.Kind == RecordHighestPoint:
+			highest := altitudes[0]
+			for _, altitude := range altitudes {
+				highest = max(highest, altitude)
+			}
+			efforts.Efforts = append(efforts.Efforts, Effort{Kind: definition.Kind, Value: roundTo(highest, 1)})
+		}
+	}
+	return efforts
+}
+
+// BuildPersonalRecords finds the best effort of each kind across activities.
+// Day vertical adds up the activities of each day and is credited to the
+// day's last activity. Ties go to the earlier effort.
+func BuildPersonalRecords(activities []ActivityEfforts) []PersonalRecord {
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].Date != activities[j].Date {
+			return activities[i].Date < activities[j].Date
+		}
+		return activities[i].ActivityId < activities[j].ActivityId
+	})
+
+	best := map[RecordKind]*PersonalRecord{}
+	consider := func(definition recordDefinition, activity ActivityEfforts, effort Effort) {
+		current := best[definition.Kind]
+		if current != nil {
+			if definition.Vertical > 0 && effort.Value >= current.Value {
+				return
+			}
+			if definition.Vertical == 0 && effort.Value <= current.Value {
+				return
+			}
+		}
+		best[definition.Kind] = &PersonalRecord{
+			Kind:         definition.Kind,
+			Label:        definition.Label,
+			Value:        effort.Value,
+			Unit:         recordUnit(definition),
+			ActivityId:   activity.ActivityId,
+			ActivityName: activity.Name,
+			Date:         activity.Date,
+			StartTime:    effort.StartTime,
+			EndTime:      effort.EndTime,
+		}
+	}
+
+	definitions := map[RecordKind]recordDefinition{}
+	for _, definition := range recordDefinitions {
+		definitions[definition.Kind] = definition
+	}
+
+	days := map[string]float64{}
+	lastOfDay := map[string]ActivityEfforts{}
+	for _, activity := range activities {
+		for _, effort := range activity.Efforts {
+			definition, ok := definitions[effort.Kind]
+			if !ok {
+				continue
+			}
+			if effort.Kind == RecordDayVertical {
+				days[activity.Date] += effort.Value
+				lastOfDay[activity.Date] = activity
+				continue
+			}
+			consider(definition, activity, effort)
+		}
+	}
+
+	dates := make([]string, 0, len(days))
+	for date := range days {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	for _, date := range dates {
+		consider(definitions[RecordDayVertical], lastOfDay[date], Effort{Kind: RecordDayVertical, Value: roundTo(days[date], 1)})
+	}
+
+	records := []PersonalRecord{}
+	for _, definition := range recordDefinitions {
+		if record := best[definition.Kind]; record != nil {
+			records = append(records, *record)
+		}
+	}
+	return records
+}
+
+// helpers
+
+// updateRecords stores the efforts of an activity, rebuilds the athlete's
+// personal records and returns the records the activity newly set
+func (s *ServerState) updateRecords(activity StravaActivity, summary ActivitySummary, streamPoints []StravaStreamPoint) ([]RecordEvent, error) {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	athleteId := activity.Athlete.Id
+	before, err := s.store.FetchPersonalRecords(athleteId)
+	if err != nil {
+		return nil, err
+	}
+
+	// records count the same activities as seasons
+	if seasonActivityTypes[activity.Type] {
+		err = s.store.SaveActivityEfforts(athleteId, FindEfforts(activity, summary, streamPoints))
+	} else {
+		err = s.store.DeleteActivityEfforts(athleteId, activity.Id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	after, err := s.rebuildRecords(athleteId)
+	if err != nil {
+		return nil, err
+	}
+
+	// until the athlete's past activities are backfilled, records only hold
+	// recent activities and would claim bests the athlete set long ago
+	complete, err := s.historyComplete(athleteId)
+	if err != nil || !complete {
+		return nil, err
+	}
+
+	events := newRecordEvents(before, after, activity.Id)
+	if len(events) > 0 {
+		if err := s.store.SaveRecordEvents(athleteId, events); err != nil {
+			return nil, err
+		}
+	}
+	return events, nil
+}
+
+// removeRecordEfforts removes a deleted activity's efforts from the athlete's
+// personal records
+func (s *ServerState) removeRecordEfforts(athleteId int, activityId int) error {
+	recordsLock.Lock()
+	defer recordsLock.Unlock()
+
+	if err := s.store.DeleteActivityEfforts(athleteId, activityId); err != nil {
+		return err
+	}
+	_, err := s.rebuildRecords(athleteId)
+	return err
+}
+
+func (s *ServerState) rebuildRecords(athleteId int) ([]PersonalRecord, error) {
+	activities, err := s.store.FetchActivityEfforts(athleteId)
+	if err != nil {
+		return nil, err
+	}
+	records := BuildPersonalRecords(activities)
+	if err := s.store.SavePersonalRecords(athleteId, records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// announceRecords appends new records to the activity's description when the
+// athlete has enabled it. It is only called for newly created activities,
+// since writing the description makes strava send an update event. Failures
+// are logged, since the records themselves are already stored.
+func (s *ServerState) announceRecords(client StravaClient, activity StravaActivity, summary ActivitySummary, events []RecordEvent) {
+	athleteId := activity.Athlete.Id
+	for _, event := range events {
+		slog.Info("new personal record", "athlete_id", athleteId, "activity_id", activity.Id, "kind", event.Record.Kind, "value", event.Record.Value)
+	}
+
+	settings, err := s.store.FetchRecordSettings(athleteId)
+	if err != nil {
+		slog.Error("failed to fetch record settings", "athlete_id", athleteId, "err", err)
+		return
+	}
+	if settings.WriteDescription {
+		// the athlete may have reconnected without the write scope since
+		scope, err := s.store.FetchTokenScope(athleteId)
+		if err != nil {
+			slog.Error("failed to fetch token scope", "athlete_id", athleteId, "err", err)
+			return
+		}
+		if !hasScope(scope, "activity:write") {
+			slog.Warn("not writing records without the activity:write scope", "athlete_id", athleteId, "activity_id", activity.Id)
+			return
+		}
+	}
+	if err := writeRecordDescription(client, settings, activity, summary, events); err != nil {
+		slog.Error("failed to write records to activity description", "athlete_id", athleteId, "activity_id", activity.Id, "err", err)
+	}
+}
+
+// writeRecordDescription appends the record events to the activity's
+// description, unless there are none, the athlete has not enabled it or the
+// description already holds them
+func writeRecordDescription(client StravaClient, settings RecordSettings, activity StravaActivity, summary ActivitySummary, events []RecordEvent) error {
+	if len(events) == 0 || !settings.WriteDescription {
+		return nil
+	}
+
+	text, err := renderRecordDescription(settings.Template, activity, summary, events)
+	if err != nil {
+		return err
+	}
+	if text == "" || strings.Contains(activity.Description, text) {
+		return nil
+	}
+
+	description := text
+	if activity.Description != "" {
+		description = activity.Description + "\n\n" + text
+	}
+	return client.UpdateActivity(strconv.Itoa(activity.Id), StravaActivityUpdate{Description: &description})
+}
+
+// newRecordEvents returns the records in after that the activity took from
+// another activity or set for the first time
+func newRecordEvents(before []PersonalRecord, after []PersonalRecord, activityId int) []RecordEvent {
+	previous := map[RecordKind]PersonalRecord{}
+	for _, record := range before {
+		previous[record.Kind] = record
+	}
+
+	events := []RecordEvent{}
+	now := time.Now().UTC()
+	for _, record := range after {
+		if record.ActivityId != activityId {
+			continue
+		}
+		event := RecordEvent{Record: record, CreatedAt: now}
+		if old, ok := previous[record.Kind]; ok {
+			if old.ActivityId == activityId {
+				continue
+			}
+			event.Previous = &old
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func renderRecordDescription(text string, activity StravaActivity, summary ActivitySummary, events []RecordEvent) (string, error) {
+	if text == "" {
+		text = defaultRecordTemplate
+	}
+	tmpl, err := template.New("records").Funcs(recordTemplateFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	data := recordTemplateData{ActivityName: activity.Name, Zones: summary.Zones}
+	for _, event := range events {
+		record := recordTemplateRecord{
+			Kind:  event.Record.Kind,
+			Label: event.Record.Label,
+			Value: formatRecordValue(event.Record),
+		}
+		if event.Previous != nil {
+			record.Previous = formatRecordValue(*event.Previous)
+		}
+		data.Records = append(data.Records, record)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+func recordUnit(definition recordDefinition) string {
+	if definition.Vertical > 0 {
+		return "s"
+	}
+	return "m"
+}
+
+// formatRecordValue formats climb times as h:mm:ss or m:ss and other records
+// in meters
+func formatRecordValue(record PersonalRecord) string {
+	if record.Unit != "s" {
+		return fmt.Sprintf("%.0f m", record.Value)
+	}
+	return formatClock(record.Value)
+}
+
+// formatClock formats seconds as h:mm:ss, or m:ss below an hour
+func formatClock(value float64) string {
+	seconds := int(value + 0.5)
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// fastestClimb returns the shortest [start, end] stream range over which the
+// smoothed altitude rises by at least vertical meters. Candidate starts are
+// kept on a stack of rising altitudes, since a later start at the same or
+// lower altitude always gives a shorter climb.
+func fastestClimb(streamPoints []StravaStreamPoint, altitudes []float64, vertical float64) (int, int, bool) {
+	var starts []int
+	bestStart, bestEnd := -1, -1
+	for j := range altitudes {
+		target := altitudes[j] - vertical
+		// the latest start low enough to reach j
+		k := sort.Search(len(starts), func(k int) bool { return altitudes[starts[k]] > target }) - 1
+		if k >= 0 {
+			i := starts[k]
+			if bestStart < 0 || streamPoints[j].Time-streamPoints[i].Time < streamPoints[bestEnd].Time-streamPoints[bestStart].Time {
+				bestStart, bestEnd = i, j
+			}
+		}
+
+		for len(starts) > 0 && altitudes[starts[len(starts)-1]] >= altitudes[j] {
+			starts = starts[:len(starts)-1]
+		}
+		starts = append(starts, j)
+	}
+	return bestStart, bestEnd, bestStart >= 0
+}
@@ -0,0 +1,261 @@
+package app
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// twoClimbPoints climbs 400 m at 1 m per 10 s sample, drops 100 m and climbs
+// another 400 m twice as fast
+func twoClimbPoints() []StravaStreamPoint {
+	var points []StravaStreamPoint
+	altitude := 0.0
+	add := func(count int, step float64) {
+		for i := 0; i < count; i++ {
+			altitude += step
+			points = append(points, StravaStreamPoint{
+				Time:      float64(len(points) * 10),
+				Latitude:  44 + float64(len(points))*0.0001,
+				Longitude: -71,
+				Altitude:  altitude,
+			})
+		}
+	}
+	add(1, 0)
+	add(400, 1)
+	add(10, -10)
+	add(200, 2)
+	return points
+}
+
+func TestFastestClimb(t *testing.T) {
+	points := twoClimbPoints()
+	altitudes := smoothAltitude(points, defaultSmoothingWindow)
+
+	tests := []struct {
+		vertical float64
+		expected float64
+		ok       bool
+	}{
+		// within the second climb
+		{300, 1500, true},
+		// from 200 m on the first climb to the top of the second
+		{500, 4100, true},
+		{1000, 0, false},
+	}
+
+	for _, tt := range tests {
+		start, end, ok := fastestClimb(points, altitudes, tt.vertical)
+		if ok != tt.ok {
+			t.Fatalf("%v m: expected ok %v, got %v", tt.vertical, tt.ok, ok)
+		}
+		if !ok {
+			continue
+		}
+		if duration := points[end].Time - points[start].Time; math.Abs(duration-tt.expected) > 30 {
+			t.Errorf("%v m: expected about %v s, got %v s", tt.vertical, tt.expected, duration)
+		}
+		if rise := altitudes[end] - altitudes[start]; rise < tt.vertical {
+			t.Errorf("%v m: climb only rises %v m", tt.vertical, rise)
+		}
+	}
+}
+
+func TestFindEfforts(t *testing.T) {
+	activity := StravaActivity{Id: 42, Name: "Dawn patrol", Type: "BackcountrySki", StartDateLocal: "2025-02-14T07:30:00Z"}
+	activity.Athlete.Id = 7
+	points := twoClimbPoints()
+	summary := SummarizeActivity(activity, points)
+
+	efforts := FindEfforts(activity, summary, points)
+	if efforts.ActivityId != 42 || efforts.Date != "2025-02-14" {
+		t.Errorf("unexpected activity %d on %s", efforts.ActivityId, efforts.Date)
+	}
+
+	values := map[RecordKind]float64{}
+	for _, effort := range efforts.Efforts {
+		values[effort.Kind] = effort.Value
+	}
+	if _, ok := values[RecordClimb1000]; ok {
+		t.Error("expected no 1000 m climb")
+	}
+	if math.Abs(values[RecordClimb300]-1500) > 30 {
+		t.Errorf("expected a 300 m climb of about 1500 s, got %v", values[RecordClimb300])
+	}
+	if values[RecordDayVertical] != summary.SkinningVertical {
+		t.Errorf("expected day vertical %v, got %v", summary.SkinningVertical, values[RecordDayVertical])
+	}
+	if math.Abs(values[RecordLongestDescent]-100) > 5 {
+		t.Errorf("expected a 100 m descent, got %v", values[RecordLongestDescent])
+	}
+	if math.Abs(values[RecordHighestPoint]-700) > 5 {
+		t.Errorf("expected a high point of about 700 m, got %v", values[RecordHighestPoint])
+	}
+}
+
+func TestBuildPersonalRecords(t *testing.T) {
+	activities := []ActivityEfforts{
+		{ActivityId: 3, Date: "2025-01-10", Efforts: []Effort{
+			{Kind: RecordClimb300, Value: 1400},
+			{Kind: RecordDayVertical, Value: 600},
+			{Kind: RecordHighestPoint, Value: 3000},
+		}},
+		{ActivityId: 1, Date: "2025-01-02", Efforts: []Effort{
+			{Kind: RecordClimb300, Value: 1400},
+			{Kind: RecordDayVertical, Value: 1000},
+			{Kind: RecordHighestPoint, Value: 2500},
+		}},
+		{ActivityId: 4, Date: "2025-01-10", Efforts: []Effort{
+			{Kind: RecordDayVertical, Value: 700},
+		}},
+	}
+
+	records := map[RecordKind]PersonalRecord{}
+	for _, record := range BuildPersonalRecords(activities) {
+		records[record.Kind] = record
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %+v", records)
+	}
+	// ties go to the earlier effort
+	if record := records[RecordClimb300]; record.ActivityId != 1 || record.Unit != "s" {
+		t.Errorf("unexpected climb record %+v", record)
+	}
+	// both activities of 2025-01-10 add up and the later one gets the record
+	if record := records[RecordDayVertical]; record.ActivityId != 4 || record.Value != 1300 || record.Unit != "m" {
+		t.Errorf("unexpected day vertical record %+v", record)
+	}
+	if record := records[RecordHighestPoint]; record.ActivityId != 3 || record.Label != "Highest point" {
+		t.Errorf("unexpected highest point record %+v", record)
+	}
+}
+
+func TestNewRecordEvents(t *testing.T) {
+	before := []PersonalRecord{
+		{Kind: RecordClimb300, Value: 1500, Unit: "s", ActivityId: 1},
+		{Kind: RecordHighestPoint, Value: 3000, Unit: "m", ActivityId: 2},
+	}
+	after := []PersonalRecord{
+		{Kind: RecordClimb300, Value: 1400, Unit: "s", ActivityId: 2},
+		{Kind: RecordHighestPoint, Value: 3100, Unit: "m", ActivityId: 2},
+		{Kind: RecordDayVertical, Value: 1200, Unit: "m", ActivityId: 2},
+		{Kind: RecordLongestDescent, Value: 900, Unit: "m", ActivityId: 1},
+	}
+
+	events := newRecordEvents(before, after, 2)
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %+v", events)
+	}
+	if events[0].Record.Kind != RecordClimb300 || events[0].Previous == nil || events[0].Previous.ActivityId != 1 {
+		t.Errorf("unexpected event %+v", events[0])
+	}
+	// the activity already held the highest point, so reprocessing it is not
+	// a new record
+	if events[1].Record.Kind != RecordDayVertical || events[1].Previous != nil {
+		t.Errorf("unexpected event %+v", events[1])
+	}
+}
+
+func TestRenderRecordDescription(t *testing.T) {
+	events := []RecordEvent{
+		{
+			Record:   PersonalRecord{Kind: RecordClimb500, Label: "Fastest 500 m climb", Value: 3725, Unit: "s"},
+			Previous: &PersonalRecord{Kind: RecordClimb500, Value: 3900, Unit: "s"},
+		},
+		{Record: PersonalRecord{Kind: RecordDayVertical, Label: "Most vertical in a day", Value: 1840.4, Unit: "m"}},
+	}
+	activity := StravaActivity{Name: "Dawn patrol"}
+
+	text, err := renderRecordDescription("", activity, ActivitySummary{}, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "🏆 New PR: Fastest 500 m climb 1:02:05 (previously 1:05:00)\n🏆 New PR: Most vertical in a day 1840 m"
+	if text != expected {
+		t.Errorf("expected %q, got %q", expected, text)
+	}
+
+	summary := ActivitySummary{Zones: []string{"Tuckerman Ravine", "Hillman's Highway"}}
+	text, err = renderRecordDescription(`{{.ActivityName}} in {{join .Zones ", "}}:{{range .Records}} {{.Kind}}={{.Value}}{{end}}`, activity, summary, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "Dawn patrol in Tuckerman Ravine, Hillman's Highway: climb_500m=1:02:05 day_vertical=1840 m" {
+		t.Errorf("unexpected custom description %q", text)
+	}
+}
+
+func TestWriteRecordDescription_Reprocessing(t *testing.T) {
+	var writes int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut {
+			writes++
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	originalActivityUrl := ActivityUrl
+	defer func() { ActivityUrl = originalActivityUrl }()
+	ActivityUrl = server.URL + "/activities/%s"
+
+	client := NewStravaClient("test-token")
+	settings := RecordSettings{WriteDescription: true}
+	activity := StravaActivity{Id: 7, Name: "Laps", Type: "BackcountrySki", StartDateLocal: "2025-02-14T07:30:00Z"}
+	points := twoClimbPoints()
+	summary := SummarizeActivity(activity, points)
+	records := BuildPersonalRecords([]ActivityEfforts{FindEfforts(activity, summary, points)})
+
+	// the first time the activity is processed it sets its records
+	events := newRecordEvents(nil, records, activity.Id)
+	if len(events) == 0 {
+		t.Fatal("expected new records")
+	}
+	if err := writeRecordDescription(client, settings, activity, summary, events); err != nil || writes != 1 {
+		t.Fatalf("expected one description write, got %d (%v)", writes, err)
+	}
+
+	// processing it again finds the records it already holds
+	events = newRecordEvents(records, records, activity.Id)
+	if len(events) != 0 {
+		t.Errorf("expected no events when reprocessing, got %+v", events)
+	}
+	if err := writeRecordDescription(client, settings, activity, summary, events); err != nil || writes != 1 {
+		t.Errorf("expected no description write when reprocessing, got %d (%v)", writes, err)
+	}
+}
+
+func TestRecordSettings_Validate(t *testing.T) {
+	if err := (RecordSettings{WriteDescription: true}).validate(); err != nil {
+		t.Errorf("expected the default template to be valid, got %v", err)
+	}
+	if err := (RecordSettings{Template: "{{range .Records}"}).validate(); err == nil {
+		t.Error("expected an invalid template to fail")
+	}
+	if err := (RecordSettings{Template: strings.Repeat("x", maxRecordTemplateSize+1)}).validate(); err == nil {
+		t.Error("expected a long template to fail")
+	}
+}
+
+func TestFormatRecordValue(t *testing.T) {
+	tests := []struct {
+		record   PersonalRecord
+		expected string
+	}{
+		{PersonalRecord{Value: 59.6, Unit: "s"}, "1:00"},
+		{PersonalRecord{Value: 1805, Unit: "s"}, "30:05"},
+		{PersonalRecord{Value: 3600, Unit: "s"}, "1:00:00"},
+		{PersonalRecord{Value: 4321.4, Unit: "m"}, "4321 m"},
+	}
+
+	for _, tt := range tests {
+		if value := formatRecordValue(tt.record); value != tt.expected {
+			t.Errorf("expected %s, got %s", tt.expected, value)
+		}
+	}
+}
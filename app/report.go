@@ -0,0 +1,440 @@
+package app
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	htmltemplate "html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type ReportFormat string
+
+const (
+	ReportFormatHtml     ReportFormat = "html"
+	ReportFormatMarkdown ReportFormat = "markdown"
+)
+
+var reportContentTypes = map[ReportFormat]string{
+	ReportFormatHtml:     "text/html; charset=utf-8",
+	ReportFormatMarkdown: "text/markdown; charset=utf-8",
+}
+
+var reportExtensions = map[ReportFormat]string{
+	ReportFormatHtml:     "html",
+	ReportFormatMarkdown: "md",
+}
+
+// ParseReportFormat validates a user supplied report format, accepting md for
+// markdown
+func ParseReportFormat(name string) (ReportFormat, error) {
+	format := ReportFormat(strings.ToLower(strings.TrimSpace(name)))
+	if format == "md" {
+		format = ReportFormatMarkdown
+	}
+	if _, ok := reportContentTypes[format]; !ok {
+		return "", fmt.Errorf("unsupported report format: %q", name)
+	}
+	return format, nil
+}
+
+// ContentType returns the MIME type served for the format
+func (f ReportFormat) ContentType() string {
+	return reportContentTypes[f]
+}
+
+// Filename returns the file name a report is saved under
+func (f ReportFormat) Filename(activityId int) string {
+	return fmt.Sprintf("activity-%d-report.%s", activityId, reportExtensions[f])
+}
+
+// ReportOptions control what a trip report includes. Reports are meant to be
+// shared, so the track outline leaves out the privacy zones.
+type ReportOptions struct {
+	Format ReportFormat
+	// Elevation corrects the altitude and adds terrain exposure, nil to
+	// leave both out
+	Elevation *ElevationModel
+	// Peaks lists the summits reached, nil to leave them out
+	Peaks   *PeakIndex
+	Summits SummitOptions
+	Privacy PrivacyOptions
+	// ExcludeLifts leaves lift rides out of the laps and skinning vertical
+	ExcludeLifts bool
+}
+
+type reportLap struct {
+	Number       int
+	Kind         LapKind
+	Start        string
+	Duration     string
+	Distance     string
+	VerticalGain string
+	VerticalLoss string
+	AscentRate   string
+	HeartRate    string
+}
+
+type reportExposure struct {
+	Band    string
+	Ascent  string
+	Descent string
+}
+
+type tripReportData struct {
+	Name           string
+	Type           string
+	Date           string
+	Duration       string
+	Distance       string
+	Laps           int
+	Skinning       string
+	Skiing         string
+	TransitionTime string
+	LapRows        []reportLap
+	// ProfileSvg and TrackSvg are inline SVG for HTML reports and data URIs
+	// for markdown reports
+	ProfileSvg string
+	TrackSvg   string
+	Slopes     []reportExposure
+	Aspects    []reportExposure
+	Coverage   string
+	Summits    []Summit
+	Notes      []string
+	Generated  string
+}
+
+// http request handlers
+
+// handleActivityReport serves a self-contained HTML or markdown trip report
+// of one of the authenticated athlete's activities
+func (s *ServerState) handleActivityReport(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	formatName := c.QueryParam("format")
+	if formatName == "" {
+		formatName = string(ReportFormatHtml)
+	}
+	format, err := ParseReportFormat(formatName)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	privacy, err := s.store.FetchPrivacySettings(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch privacy zones", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build report")
+	}
+
+	data, err := client.ActivityReport(activity, ReportOptions{
+		Format:       format,
+		Elevation:    s.elevation,
+		Peaks:        s.peaks,
+		Summits:      s.config.Summits,
+		Privacy:      privacy.Options(c.QueryParam("hide_from_home") == "true"),
+		ExcludeLifts: s.config.ExcludeLiftVertical,
+	})
+	if err != nil {
+		slog.Error("failed to build report", "activity_id", activity.Id, "format", format, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build report")
+	}
+
+	if c.QueryParam("download") == "true" {
+		c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", format.Filename(activity.Id)))
+	}
+	return c.Blob(http.StatusOK, format.ContentType(), data)
+}
+
+// public functions
+
+// ActivityReport fetches the streams of an activity and builds its trip
+// report
+func (c *StravaClient) ActivityReport(activity StravaActivity, options ReportOptions) ([]byte, error) {
+	streamPoints, err := c.getActivityStream(strconv.Itoa(activity.Id))
+	if err != nil {
+		return nil, err
+	}
+	return buildTripReport(activity, streamPoints, options, time.Now())
+}
+
+// helpers
+
+func buildTripReport(activity StravaActivity, streamPoints []StravaStreamPoint, options ReportOptions, now time.Time) ([]byte, error) {
+	streamPoints = correctElevation(streamPoints, ElevationCorrection{Model: options.Elevation, Mode: ElevationModeReplace})
+	summary := SummarizeActivity(activity, streamPoints)
+	if options.ExcludeLifts {
+		excludeLiftAscents(&summary)
+	}
+	laps := SegmentLaps(streamPoints, SegmentOptions{})
+
+	data := tripReportData{
+		Name:           activity.Name,
+		Type:           activity.Type,
+		Date:           activityLocalDate(activity, summary).Format("Monday, January 2, 2006"),
+		Duration:       formatClock(summary.ElapsedTime),
+		Distance:       formatKilometers(summary.Distance),
+		Laps:           summary.LapCount,
+		Skinning:       fmt.Sprintf("%.0f m", summary.SkinningVertical),
+		Skiing:         fmt.Sprintf("%.0f m", summary.SkiingVertical),
+		TransitionTime: formatClock(summary.TransitionTime),
+		Notes:          reportNotes(activity.Description),
+		Generated:      now.UTC().Format("2006-01-02 15:04 UTC"),
+	}
+
+	number := 0
+	for _, lap := range summary.Laps {
+		// lift rides excluded from the laps are left out of the table too
+		if lap.Kind == LapTransition || (lap.Kind == LapAscent && !summary.countsAscent(lap)) {
+			continue
+		}
+		number++
+		row := reportLap{
+			Number:       number,
+			Kind:         lap.Kind,
+			Start:        formatClock(lap.StartTime.Sub(summary.StartDate).Seconds()),
+			Duration:     formatClock(lap.Duration),
+			Distance:     formatKilometers(lap.Distance),
+			VerticalGain: fmt.Sprintf("%.0f m", lap.VerticalGain),
+			VerticalLoss: fmt.Sprintf("%.0f m", lap.VerticalLoss),
+			AscentRate:   "-",
+			HeartRate:    "-",
+		}
+		if lap.AscentRate > 0 {
+			row.AscentRate = fmt.Sprintf("%.0f m/h", lap.AscentRate)
+		}
+		if lap.AverageHeartRate > 0 {
+			row.HeartRate = fmt.Sprintf("%.0f bpm", lap.AverageHeartRate)
+		}
+		data.LapRows = append(data.LapRows, row)
+	}
+
+	hidden := svgHiddenPoints(activity, streamPoints, options.Privacy)
+	profile := renderProfileSvg("", streamPoints, laps, SvgOptions{Width: defaultProfileWidth, Height: defaultProfileHeight})
+	track := renderTrackSvg("", streamPoints, laps, hidden, SvgOptions{Width: defaultTrackSize, Height: defaultTrackSize})
+	if options.Format == ReportFormatMarkdown {
+		data.ProfileSvg = svgDataUri(profile)
+		data.TrackSvg = svgDataUri(track)
+	} else {
+		data.ProfileSvg = string(profile)
+		data.TrackSvg = string(track)
+	}
+
+	if options.Elevation != nil {
+		report := buildTerrainReport(streamPoints, annotateTerrain(streamPoints, options.Elevation), laps)
+		if report.Coverage > 0 {
+			data.Slopes = reportExposures(report.Ascent.Slopes, report.Descent.Slopes)
+			data.Aspects = reportExposures(report.Ascent.Aspects, report.Descent.Aspects)
+			data.Coverage = fmt.Sprintf("%.0f%%", report.Coverage*100)
+		}
+	}
+	if options.Peaks != nil {
+		data.Summits = options.Peaks.FindSummits(streamPoints, options.Summits)
+	}
+
+	var buf bytes.Buffer
+	var err error
+	if options.Format == ReportFormatMarkdown {
+		err = markdownReportTemplate.Execute(&buf, data)
+	} else {
+		err = htmlReportTemplate.Execute(&buf, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// reportExposures pairs the ascent and descent time of each band, skipping
+// bands neither spent time in
+func reportExposures(ascent []TerrainExposure, descent []TerrainExposure) []reportExposure {
+	var exposures []reportExposure
+	for i := range ascent {
+		if ascent[i].Time == 0 && descent[i].Time == 0 {
+			continue
+		}
+		exposures = append(exposures, reportExposure{
+			Band:    ascent[i].Band,
+			Ascent:  formatClock(ascent[i].Time),
+			Descent: formatClock(descent[i].Time),
+		})
+	}
+	return exposures
+}
+
+// reportNotes splits the activity description into paragraphs
+func reportNotes(description string) []string {
+	var notes []string
+	for _, paragraph := range strings.Split(strings.ReplaceAll(description, "\r\n", "\n"), "\n\n") {
+		if paragraph = strings.TrimSpace(paragraph); paragraph != "" {
+			notes = append(notes, paragraph)
+		}
+	}
+	return notes
+}
+
+func formatKilometers(meters float64) string {
+	return fmt.Sprintf("%.1f km", meters/1000)
+}
+
+func svgDataUri(data []byte) string {
+	return "data:image/svg+xml;base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+var htmlReportTemplate = htmltemplate.Must(htmltemplate.New("report").Funcs(htmltemplate.FuncMap{
+	"svg": func(s string) htmltemplate.HTML { return htmltemplate.HTML(s) },
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; max-width: 840px; margin: 2em auto; padding: 0 1em; color: #212529; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { padding: 4px 10px; border-bottom: 1px solid #dee2e6; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+.ascent { color: #d9480f; } .descent { color: #1c7ed6; }
+svg { max-width: 100%; height: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{.Type}} on {{.Date}}</p>
+<table>
+<tr><th>Elapsed time</th><td>{{.Duration}}</td></tr>
+<tr><th>Distance</th><td>{{.Distance}}</td></tr>
+<tr><th>Laps</th><td>{{.Laps}}</td></tr>
+<tr><th>Skinning vertical</th><td>{{.Skinning}}</td></tr>
+<tr><th>Skiing vertical</th><td>{{.Skiing}}</td></tr>
+<tr><th>Transition time</th><td>{{.TransitionTime}}</td></tr>
+</table>
+<h2>Elevation profile</h2>
+{{svg .ProfileSvg}}
+<h2>Track</h2>
+{{svg .TrackSvg}}
+{{- if .LapRows}}
+<h2>Laps</h2>
+<table>
+<tr><th>#</th><th>Kind</th><th>Start</th><th>Duration</th><th>Distance</th><th>Gain</th><th>Loss</th><th>Ascent rate</th><th>Heart rate</th></tr>
+{{- range .LapRows}}
+<tr><td>{{.Number}}</td><td class="{{.Kind}}">{{.Kind}}</td><td>{{.Start}}</td><td>{{.Duration}}</td><td>{{.Distance}}</td><td>{{.VerticalGain}}</td><td>{{.VerticalLoss}}</td><td>{{.AscentRate}}</td><td>{{.HeartRate}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Slopes}}
+<h2>Terrain exposure</h2>
+<table>
+<tr><th>Slope</th><th>Ascent</th><th>Descent</th></tr>
+{{- range .Slopes}}
+<tr><td>{{.Band}}°</td><td>{{.Ascent}}</td><td>{{.Descent}}</td></tr>
+{{- end}}
+</table>
+<table>
+<tr><th>Aspect</th><th>Ascent</th><th>Descent</th></tr>
+{{- range .Aspects}}
+<tr><td>{{.Band}}</td><td>{{.Ascent}}</td><td>{{.Descent}}</td></tr>
+{{- end}}
+</table>
+<p>The elevation model covers {{.Coverage}} of the track.</p>
+{{- end}}
+{{- if .Summits}}
+<h2>Summits</h2>
+<ul>
+{{- range .Summits}}
+<li>{{.Name}}{{if .Elevation}} ({{printf "%.0f" .Elevation}} m){{end}}</li>
+{{- end}}
+</ul>
+{{- end}}
+{{- if .Notes}}
+<h2>Notes</h2>
+{{- range .Notes}}
+<p>{{.}}</p>
+{{- end}}
+{{- end}}
+<footer><p><small>Generated {{.Generated}}</small></p></footer>
+</body>
+</html>
+`))
+
+var markdownReportTemplate = template.Must(template.New("report").Parse(`# {{.Name}}
+
+{{.Type}} on {{.Date}}
+
+| | |
+|---|---:|
+| Elapsed time | {{.Duration}} |
+| Distance | {{.Distance}} |
+| Laps | {{.Laps}} |
+| Skinning vertical | {{.Skinning}} |
+| Skiing vertical | {{.Skiing}} |
+| Transition time | {{.TransitionTime}} |
+
+## Elevation profile
+
+![Elevation profile]({{.ProfileSvg}})
+
+## Track
+
+![Track]({{.TrackSvg}})
+{{- if .LapRows}}
+
+## Laps
+
+| # | Kind | Start | Duration | Distance | Gain | Loss | Ascent rate | Heart rate |
+|---|---|---:|---:|---:|---:|---:|---:|---:|
+{{- range .LapRows}}
+| {{.Number}} | {{.Kind}} | {{.Start}} | {{.Duration}} | {{.Distance}} | {{.VerticalGain}} | {{.VerticalLoss}} | {{.AscentRate}} | {{.HeartRate}} |
+{{- end}}
+{{- end}}
+{{- if .Slopes}}
+
+## Terrain exposure
+
+| Slope | Ascent | Descent |
+|---|---:|---:|
+{{- range .Slopes}}
+| {{.Band}}° | {{.Ascent}} | {{.Descent}} |
+{{- end}}
+
+| Aspect | Ascent | Descent |
+|---|---:|---:|
+{{- range .Aspects}}
+| {{.Band}} | {{.Ascent}} | {{.Descent}} |
+{{- end}}
+
+The elevation model covers {{.Coverage}} of the track.
+{{- end}}
+{{- if .Summits}}
+
+## Summits
+{{range .Summits}}
+- {{.Name}}{{if .Elevation}} ({{printf "%.0f" .Elevation}} m){{end}}
+{{- end}}
+{{- end}}
+{{- if .Notes}}
+
+## Notes
+{{- range .Notes}}
+
+{{.}}
+{{- end}}
+{{- end}}
+
+---
+
+Generated {{.Generated}}
+`))
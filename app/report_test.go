@@ -0,0 +1,127 @@
+package app
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+func reportActivity() StravaActivity {
+	return StravaActivity{
+		Id:             42,
+		Name:           "Tuckerman <Ravine>",
+		Type:           "BackcountrySki",
+		StartDate:      "2025-02-14T12:30:00Z",
+		StartDateLocal: "2025-02-14T07:30:00Z",
+		Description:    "Firm snow up high.\r\n\r\nSoftened by noon.",
+	}
+}
+
+func TestBuildTripReport_Html(t *testing.T) {
+	points := skiTourPoints()
+	index := NewPeakIndex([]Peak{{Name: "First Top", Latitude: points[179].Latitude, Longitude: -71, Elevation: 1300}})
+	options := ReportOptions{Format: ReportFormatHtml, Peaks: index, Summits: DefaultSummitOptions()}
+
+	data, err := buildTripReport(reportActivity(), points, options, time.Date(2025, 2, 15, 8, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+
+	for _, expected := range []string{
+		"<title>Tuckerman &lt;Ravine&gt;</title>",
+		"BackcountrySki on Friday, February 14, 2025",
+		`<td class="ascent">ascent</td>`,
+		"<svg xmlns=",
+		"<li>First Top (1300 m)</li>",
+		"<p>Firm snow up high.</p>",
+		"<p>Softened by noon.</p>",
+		"Generated 2025-02-15 08:00 UTC",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Errorf("expected the report to contain %q", expected)
+		}
+	}
+	if strings.Count(report, "<svg") != 2 {
+		t.Errorf("expected the profile and track to be inlined, got %d svgs", strings.Count(report, "<svg"))
+	}
+	// four laps, the transitions are left out
+	if rows := strings.Count(report, `<tr><td>`); rows != 4 {
+		t.Errorf("expected 4 lap rows, got %d", rows)
+	}
+	if strings.Contains(report, "Terrain exposure") {
+		t.Error("expected no terrain exposure without an elevation model")
+	}
+}
+
+func TestBuildTripReport_Markdown(t *testing.T) {
+	data, err := buildTripReport(reportActivity(), skiTourPoints(), ReportOptions{Format: ReportFormatMarkdown}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := string(data)
+
+	if !strings.HasPrefix(report, "# Tuckerman <Ravine>\n") {
+		t.Errorf("unexpected heading in %q", report[:40])
+	}
+	if !strings.Contains(report, "| 1 | ascent | 0:00 | 30:00 | 1.4 km | 298 m | 0 m | 597 m/h |") {
+		t.Errorf("expected the first ascent in the lap table, got %s", report)
+	}
+	if strings.Contains(report, "## Summits") {
+		t.Error("expected no summits without peaks")
+	}
+
+	start := strings.Index(report, "data:image/svg+xml;base64,")
+	if start < 0 {
+		t.Fatal("expected the profile as a data uri")
+	}
+	encoded := report[start+len("data:image/svg+xml;base64,"):]
+	encoded = encoded[:strings.Index(encoded, ")")]
+	svg, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || !strings.HasPrefix(string(svg), "<svg") {
+		t.Errorf("expected an embedded svg, got %v", err)
+	}
+}
+
+func TestBuildTripReport_ExcludeLifts(t *testing.T) {
+	for _, tt := range []struct {
+		excludeLifts bool
+		ascents      int
+	}{
+		{false, 2},
+		{true, 1},
+	} {
+		options := ReportOptions{Format: ReportFormatMarkdown, ExcludeLifts: tt.excludeLifts}
+		data, err := buildTripReport(reportActivity(), sidecountryPoints(), options, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ascents := strings.Count(string(data), " | ascent | "); ascents != tt.ascents {
+			t.Errorf("excluding lifts %v: expected %d ascents in the lap table, got %d", tt.excludeLifts, tt.ascents, ascents)
+		}
+	}
+}
+
+func TestParseReportFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected ReportFormat
+		wantErr  bool
+	}{
+		{"html", ReportFormatHtml, false},
+		{" Markdown ", ReportFormatMarkdown, false},
+		{"md", ReportFormatMarkdown, false},
+		{"pdf", "", true},
+	}
+
+	for _, tt := range tests {
+		format, err := ParseReportFormat(tt.name)
+		if (err != nil) != tt.wantErr || format != tt.expected {
+			t.Errorf("%q: expected %q, got %q (%v)", tt.name, tt.expected, format, err)
+		}
+	}
+	if name := ReportFormatMarkdown.Filename(42); name != "activity-42-report.md" {
+		t.Errorf("unexpected filename %s", name)
+	}
+}
@@ -0,0 +1,415 @@
+package app
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// routeTrackStep is the spacing of the points ascents are compared by
+	routeTrackStep = 25.0
+	// maxRouteTrackPoints bounds the cost of comparing two ascents, longer
+	// ascents are resampled more coarsely
+	maxRouteTrackPoints = 200
+	// routeMatchTolerance is the largest discrete Fréchet distance between
+	// two ascents of the same route, wide enough for different switchbacks
+	// up the same skin track
+	routeMatchTolerance = 150.0
+	// minRouteVertical skips short ascents such as climbing back to a
+	// skin track
+	minRouteVertical = 100.0
+)
+
+// routeLock serializes route assignment, so that ascents processed at the
+// same time cannot start separate routes
+var routeLock sync.Mutex
+
+// AscentTrack is one ascent of a tour with the simplified track it is matched
+// to other ascents by. Ascents of the same route share a RouteId.
+type AscentTrack struct {
+	ActivityId int    `json:"activity_id"`
+	Name       string `json:"name"`
+	// Lap is the index of the ascent in the activity summary's laps
+	Lap          int     `json:"lap"`
+	RouteId      string  `json:"route_id"`
+	Date         string  `json:"date"`
+	Duration     float64 `json:"duration_s"`
+	Distance     float64 `json:"distance_m"`
+	VerticalGain float64 `json:"vertical_gain_m"`
+	AscentRate   float64 `json:"ascent_rate_m_h"`
+	// Track holds [longitude, latitude] pairs about routeTrackStep apart
+	Track [][2]float64 `json:"track"`
+}
+
+// ActivityAscents are the matched ascents of one activity
+type ActivityAscents struct {
+	ActivityId int           `json:"activity_id"`
+	Ascents    []AscentTrack `json:"ascents"`
+}
+
+// RouteAttempt is one ascent of a route, ranked by duration
+type RouteAttempt struct {
+	ActivityId   int     `json:"activity_id"`
+	Name         string  `json:"name"`
+	Lap          int     `json:"lap"`
+	Date         string  `json:"date"`
+	Duration     float64 `json:"duration_s"`
+	VerticalGain float64 `json:"vertical_gain_m"`
+	AscentRate   float64 `json:"ascent_rate_m_h"`
+	Rank         int     `json:"rank"`
+}
+
+// RouteComparison compares an ascent with the earlier ascents of its route.
+// Time deltas are negative when the ascent was faster and pace deltas, in
+// meters per hour of ascent rate, are positive.
+type RouteComparison struct {
+	Lap     int    `json:"lap"`
+	RouteId string `json:"route_id"`
+	// Rank is the ascent's rank among itself and the earlier attempts
+	Rank              int      `json:"rank"`
+	TimeDeltaBest     *float64 `json:"time_delta_best_s,omitempty"`
+	PaceDeltaBest     *float64 `json:"pace_delta_best_m_h,omitempty"`
+	TimeDeltaPrevious *float64 `json:"time_delta_previous_s,omitempty"`
+	PaceDeltaPrevious *float64 `json:"pace_delta_previous_m_h,omitempty"`
+	// Attempts are the earlier ascents of the route, fastest first
+	Attempts []RouteAttempt `json:"attempts"`
+}
+
+// RouteSummary describes one of an athlete's repeated routes
+type RouteSummary struct {
+	RouteId string `json:"route_id"`
+	// Name is the name of the first activity on the route
+	Name       string       `json:"name"`
+	Attempts   int          `json:"attempts"`
+	Best       RouteAttempt `json:"best"`
+	LastAscent string       `json:"last_ascent"`
+}
+
+func (a AscentTrack) attempt() RouteAttempt {
+	return RouteAttempt{
+		ActivityId:   a.ActivityId,
+		Name:         a.Name,
+		Lap:          a.Lap,
+		Date:         a.Date,
+		Duration:     a.Duration,
+		VerticalGain: a.VerticalGain,
+		AscentRate:   a.AscentRate,
+	}
+}
+
+// before orders ascents by date, then activity and lap
+func (a AscentTrack) before(b AscentTrack) bool {
+	if a.Date != b.Date {
+		return a.Date < b.Date
+	}
+	if a.ActivityId != b.ActivityId {
+		return a.ActivityId < b.ActivityId
+	}
+	return a.Lap < b.Lap
+}
+
+// http request handlers
+
+// handleActivityRoutes compares each ascent of one of the authenticated
+// athlete's tours with earlier ascents of the same route
+func (s *ServerState) handleActivityRoutes(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	activityId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid activity id")
+	}
+
+	activities, err := s.store.FetchActivityAscents(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch ascents", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch route comparisons")
+	}
+
+	var ascents []AscentTrack
+	var current []AscentTrack
+	for _, activity := range activities {
+		ascents = append(ascents, activity.Ascents...)
+		if activity.ActivityId == activityId {
+			current = activity.Ascents
+		}
+	}
+	if current == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No ascents found for activity")
+	}
+
+	comparisons := make([]RouteComparison, 0, len(current))
+	for _, ascent := range current {
+		comparisons = append(comparisons, CompareRouteAttempts(ascent, ascents))
+	}
+	return c.JSON(http.StatusOK, comparisons)
+}
+
+// handleRoutes lists the authenticated athlete's routes with more than one
+// ascent, most climbed first
+func (s *ServerState) handleRoutes(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	activities, err := s.store.FetchActivityAscents(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch ascents", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch routes")
+	}
+
+	var ascents []AscentTrack
+	for _, activity := range activities {
+		ascents = append(ascents, activity.Ascents...)
+	}
+	return c.JSON(http.StatusOK, BuildRouteList(ascents))
+}
+
+// public functions
+
+// MatchAscents builds the ascent tracks of a tour and assigns each the route
+// of the closest earlier ascent within routeMatchTolerance, or a new route.
+// Ascents of the same tour are matched to each other too, so laps of one
+// line share a route.
+func MatchAscents(activity StravaActivity, summary ActivitySummary, streamPoints []StravaStreamPoint, previous []AscentTrack) []AscentTrack {
+	date := activityLocalDate(activity, summary).Format("2006-01-02")
+	candidates := previous
+
+	ascents := []AscentTrack{}
+	for i, lap := range summary.Laps {
+		if !summary.countsAscent(lap) || lap.VerticalGain < minRouteVertical || lap.EndIndex >= len(streamPoints) {
+			continue
+		}
+		track := routeTrack(streamPoints[lap.StartIndex : lap.EndIndex+1])
+		if len(track) < 2 {
+			continue
+		}
+
+		ascent := AscentTrack{
+			ActivityId:   activity.Id,
+			Name:         activity.Name,
+			Lap:          i,
+			Date:         date,
+			Duration:     lap.Duration,
+			Distance:     lap.Distance,
+			VerticalGain: lap.VerticalGain,
+			AscentRate:   lap.AscentRate,
+			Track:        track,
+		}
+
+		best := routeMatchTolerance
+		for _, candidate := range candidates {
+			if distance, ok := routeDistance(track, candidate.Track, best); ok && (ascent.RouteId == "" || distance < best) {
+				ascent.RouteId, best = candidate.RouteId, distance
+			}
+		}
+		if ascent.RouteId == "" {
+			ascent.RouteId = randomString(8)
+		}
+
+		ascents = append(ascents, ascent)
+		candidates = append(candidates, ascent)
+	}
+	return ascents
+}
+
+// CompareRouteAttempts ranks an ascent against the earlier ascents of its
+// route among all of the athlete's ascents
+func CompareRouteAttempts(current AscentTrack, ascents []AscentTrack) RouteComparison {
+	comparison := RouteComparison{Lap: current.Lap, RouteId: current.RouteId, Rank: 1, Attempts: []RouteAttempt{}}
+
+	var previous *AscentTrack
+	for i, ascent := range ascents {
+		if ascent.RouteId != current.RouteId || !ascent.before(current) {
+			continue
+		}
+		comparison.Attempts = append(comparison.Attempts, ascent.attempt())
+		if ascent.Duration < current.Duration {
+			comparison.Rank++
+		}
+		if previous == nil || previous.before(ascent) {
+			previous = &ascents[i]
+		}
+	}
+	if len(comparison.Attempts) == 0 {
+		return comparison
+	}
+
+	sort.SliceStable(comparison.Attempts, func(i, j int) bool {
+		return comparison.Attempts[i].Duration < comparison.Attempts[j].Duration
+	})
+	for i := range comparison.Attempts {
+		comparison.Attempts[i].Rank = i + 1
+	}
+
+	best := comparison.Attempts[0]
+	comparison.TimeDeltaBest = deltaPtr(current.Duration, best.Duration)
+	comparison.PaceDeltaBest = deltaPtr(current.AscentRate, best.AscentRate)
+	comparison.TimeDeltaPrevious = deltaPtr(current.Duration, previous.Duration)
+	comparison.PaceDeltaPrevious = deltaPtr(current.AscentRate, previous.AscentRate)
+	return comparison
+}
+
+// BuildRouteList groups ascents by route, keeping routes climbed more than
+// once
+func BuildRouteList(ascents []AscentTrack) []RouteSummary {
+	sort.Slice(ascents, func(i, j int) bool { return ascents[i].before(ascents[j]) })
+
+	byRoute := map[string]*RouteSummary{}
+	var order []string
+	for _, ascent := range ascents {
+		route, ok := byRoute[ascent.RouteId]
+		if !ok {
+			route = &RouteSummary{RouteId: ascent.RouteId, Name: ascent.Name, Best: ascent.attempt()}
+			byRoute[ascent.RouteId] = route
+			order = append(order, ascent.RouteId)
+		}
+		route.Attempts++
+		route.LastAscent = ascent.Date
+		if ascent.Duration < route.Best.Duration {
+			route.Best = ascent.attempt()
+		}
+	}
+
+	routes := []RouteSummary{}
+	for _, routeId := range order {
+		if route := byRoute[routeId]; route.Attempts > 1 {
+			route.Best.Rank = 1
+			routes = append(routes, *route)
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool { return routes[i].Attempts > routes[j].Attempts })
+	return routes
+}
+
+// helpers
+
+// updateRouteMatches matches the ascents of a tour to the athlete's earlier
+// ascents, marking their routes on the summary's laps, and stores them
+func (s *ServerState) updateRouteMatches(summary *ActivitySummary, activity StravaActivity, streamPoints []StravaStreamPoint) error {
+	athleteId := activity.Athlete.Id
+	if !seasonActivityTypes[activity.Type] {
+		return s.store.DeleteActivityAscents(athleteId, activity.Id)
+	}
+
+	routeLock.Lock()
+	defer routeLock.Unlock()
+
+	activities, err := s.store.FetchActivityAscents(athleteId)
+	if err != nil {
+		return err
+	}
+	var previous []AscentTrack
+	for _, other := range activities {
+		if other.ActivityId != activity.Id {
+			previous = append(previous, other.Ascents...)
+		}
+	}
+
+	ascents := MatchAscents(activity, *summary, streamPoints, previous)
+	if len(ascents) == 0 {
+		return s.store.DeleteActivityAscents(athleteId, activity.Id)
+	}
+	for _, ascent := range ascents {
+		summary.Laps[ascent.Lap].RouteId = ascent.RouteId
+	}
+	return s.store.SaveActivityAscents(athleteId, ActivityAscents{ActivityId: activity.Id, Ascents: ascents})
+}
+
+// routeTrack resamples an ascent every routeTrackStep meters, or coarser to
+// stay within maxRouteTrackPoints
+func routeTrack(points []StravaStreamPoint) [][2]float64 {
+	var located []StravaStreamPoint
+	for _, point := range points {
+		if point.Latitude != 0 || point.Longitude != 0 {
+			located = append(located, point)
+		}
+	}
+	if len(located) < 2 {
+		return nil
+	}
+
+	step := routeTrackStep
+	distances := cumulativeDistances(located)
+	if length := distances[len(distances)-1]; length/step > maxRouteTrackPoints-2 {
+		step = length / (maxRouteTrackPoints - 2)
+	}
+
+	resampled := resampleRun(located, 0, step)
+	track := make([][2]float64, len(resampled))
+	for i, point := range resampled {
+		track[i] = [2]float64{point.Longitude, point.Latitude}
+	}
+	return track
+}
+
+// routeDistance returns the discrete Fréchet distance between two tracks in
+// meters, or false once it is certain to exceed limit
+func routeDistance(a [][2]float64, b [][2]float64, limit float64) (float64, bool) {
+	if len(a) == 0 || len(b) == 0 {
+		return 0, false
+	}
+
+	origin := StravaStreamPoint{Latitude: a[0][1], Longitude: a[0][0]}
+	project := func(track [][2]float64) [][2]float64 {
+		projected := make([][2]float64, len(track))
+		for i, position := range track {
+			x, y := projectLocal(origin, StravaStreamPoint{Latitude: position[1], Longitude: position[0]})
+			projected[i] = [2]float64{x, y}
+		}
+		return projected
+	}
+	pa, pb := project(a), project(b)
+	distance := func(i, j int) float64 {
+		return math.Hypot(pa[i][0]-pb[j][0], pa[i][1]-pb[j][1])
+	}
+
+	// both tracks must start and end near each other, which rules out most
+	// pairs before the full comparison
+	if distance(0, 0) > limit || distance(len(pa)-1, len(pb)-1) > limit {
+		return 0, false
+	}
+
+	previous := make([]float64, len(pb))
+	current := make([]float64, len(pb))
+	for i := range pa {
+		rowMin := math.Inf(1)
+		for j := range pb {
+			d := distance(i, j)
+			switch {
+			case i == 0 && j == 0:
+				current[j] = d
+			case i == 0:
+				current[j] = math.Max(current[j-1], d)
+			case j == 0:
+				current[j] = math.Max(previous[j], d)
+			default:
+				current[j] = math.Max(math.Min(previous[j], math.Min(previous[j-1], current[j-1])), d)
+			}
+			rowMin = math.Min(rowMin, current[j])
+		}
+		// every coupling passes through this row
+		if rowMin > limit {
+			return 0, false
+		}
+		previous, current = current, previous
+	}
+
+	result := previous[len(pb)-1]
+	return result, result <= limit
+}
+
+func deltaPtr(value float64, reference float64) *float64 {
+	delta := roundTo(value-reference, 1)
+	return &delta
+}
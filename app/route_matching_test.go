@@ -0,0 +1,110 @@
+package app
+
+import (
+	"math"
+	"testing"
+)
+
+// northTrack runs north from 44,-71 for length meters, offset meters east
+func northTrack(length float64, offset float64) [][2]float64 {
+	var track [][2]float64
+	for d := 0.0; d <= length; d += routeTrackStep {
+		track = append(track, [2]float64{-71 + offset/(111195*math.Cos(44*math.Pi/180)), 44 + d/111195})
+	}
+	return track
+}
+
+func TestRouteDistance(t *testing.T) {
+	distance, ok := routeDistance(northTrack(1000, 0), northTrack(1000, 50), routeMatchTolerance)
+	if !ok || math.Abs(distance-50) > 1 {
+		t.Errorf("expected parallel tracks 50 m apart to match at 50 m, got %v %v", distance, ok)
+	}
+
+	// a detour in the middle of an otherwise identical track
+	detour := northTrack(1000, 0)
+	for i := 15; i < 25; i++ {
+		detour[i][0] += 0.003
+	}
+	if _, ok := routeDistance(northTrack(1000, 0), detour, routeMatchTolerance); ok {
+		t.Error("expected a 240 m detour not to match")
+	}
+
+	reversed := northTrack(1000, 0)
+	for i, j := 0, len(reversed)-1; i < j; i, j = i+1, j-1 {
+		reversed[i], reversed[j] = reversed[j], reversed[i]
+	}
+	if _, ok := routeDistance(northTrack(1000, 0), reversed, routeMatchTolerance); ok {
+		t.Error("expected a track in the opposite direction not to match")
+	}
+}
+
+func TestMatchAscents(t *testing.T) {
+	points := skiTourPoints()
+	activity := StravaActivity{Id: 42, Name: "Laps", StartDateLocal: "2025-02-14T07:30:00Z"}
+	summary := SummarizeActivity(activity, points)
+
+	// an earlier ascent of the first climb, 40 m to the east
+	previous := []AscentTrack{{ActivityId: 7, RouteId: "known", Date: "2025-01-01", Track: northTrack(1440, 40)}}
+
+	ascents := MatchAscents(activity, summary, points, previous)
+	if len(ascents) != 2 {
+		t.Fatalf("expected 2 ascents, got %d", len(ascents))
+	}
+	first, second := ascents[0], ascents[1]
+	if first.RouteId != "known" || first.Lap != 0 || first.Date != "2025-02-14" {
+		t.Errorf("expected the first ascent on the known route, got %+v", first)
+	}
+	if second.RouteId == "" || second.RouteId == "known" {
+		t.Errorf("expected the second ascent on a new route, got %q", second.RouteId)
+	}
+	if second.Duration != summary.Laps[second.Lap].Duration || len(second.Track) < 30 {
+		t.Errorf("unexpected second ascent %+v", second)
+	}
+}
+
+func TestCompareRouteAttempts(t *testing.T) {
+	ascents := []AscentTrack{
+		{ActivityId: 1, RouteId: "r", Date: "2025-01-01", Duration: 3600, AscentRate: 500},
+		{ActivityId: 2, RouteId: "r", Date: "2025-01-08", Duration: 3000, AscentRate: 600},
+		{ActivityId: 3, RouteId: "r", Date: "2025-01-15", Duration: 3300, AscentRate: 545},
+		{ActivityId: 4, RouteId: "other", Date: "2025-01-10", Duration: 1000},
+		// later attempts are not compared
+		{ActivityId: 5, RouteId: "r", Date: "2025-02-01", Duration: 2000},
+	}
+
+	comparison := CompareRouteAttempts(ascents[2], ascents)
+	if comparison.Rank != 2 || len(comparison.Attempts) != 2 {
+		t.Fatalf("expected second of 3 attempts, got %+v", comparison)
+	}
+	if comparison.Attempts[0].ActivityId != 2 || comparison.Attempts[0].Rank != 1 || comparison.Attempts[1].ActivityId != 1 {
+		t.Errorf("expected attempts fastest first, got %+v", comparison.Attempts)
+	}
+	if *comparison.TimeDeltaBest != 300 || *comparison.PaceDeltaBest != -55 {
+		t.Errorf("unexpected deltas to the best attempt %v %v", *comparison.TimeDeltaBest, *comparison.PaceDeltaBest)
+	}
+	if *comparison.TimeDeltaPrevious != 300 {
+		t.Errorf("expected the previous attempt to be the week before, got %v", *comparison.TimeDeltaPrevious)
+	}
+
+	first := CompareRouteAttempts(ascents[0], ascents)
+	if first.Rank != 1 || len(first.Attempts) != 0 || first.TimeDeltaBest != nil {
+		t.Errorf("expected no earlier attempts, got %+v", first)
+	}
+}
+
+func TestBuildRouteList(t *testing.T) {
+	ascents := []AscentTrack{
+		{ActivityId: 2, Name: "Second", RouteId: "r", Date: "2025-01-08", Duration: 3000},
+		{ActivityId: 1, Name: "First", RouteId: "r", Date: "2025-01-01", Duration: 3600},
+		{ActivityId: 3, Name: "Once", RouteId: "single", Date: "2025-01-10", Duration: 1000},
+	}
+
+	routes := BuildRouteList(ascents)
+	if len(routes) != 1 {
+		t.Fatalf("expected only the repeated route, got %+v", routes)
+	}
+	route := routes[0]
+	if route.Name != "First" || route.Attempts != 2 || route.Best.ActivityId != 2 || route.LastAscent != "2025-01-08" {
+		t.Errorf("unexpected route %+v", route)
+	}
+}
@@ -0,0 +1,101 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/tkrajina/gpxgo/gpx"
+)
+
+const (
+	// maxRouteUploadSize limits uploaded GPX routes
+	maxRouteUploadSize = 10 << 20
+
+	// routeSampleDistance is the spacing planned routes are resampled to, so
+	// sparse routes drawn with a few clicks are analyzed like recorded tracks
+	routeSampleDistance = 20.0
+)
+
+// PlannedRoute is a route uploaded as GPX. Points have no time unless the
+// GPX had timestamps.
+type PlannedRoute struct {
+	Name   string
+	Points []StravaStreamPoint
+	// HasElevation is false when the GPX had no elevations
+	HasElevation bool
+}
+
+// helpers
+
+// readRouteUpload reads a GPX route from the request body, resampled to
+// routeSampleDistance. Elevations are filled in from the elevation model when
+// the GPX has none. Errors are returned as echo.HTTPError.
+func (s *ServerState) readRouteUpload(c echo.Context) (PlannedRoute, error) {
+	data, err := io.ReadAll(io.LimitReader(c.Request().Body, maxRouteUploadSize+1))
+	if err != nil {
+		return PlannedRoute{}, echo.NewHTTPError(http.StatusBadRequest, "Failed to read route")
+	}
+	if len(data) > maxRouteUploadSize {
+		return PlannedRoute{}, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "Route is too large")
+	}
+
+	route, err := parseGpxRoute(data)
+	if err != nil {
+		return PlannedRoute{}, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if !route.HasElevation && s.elevation != nil {
+		route.Points = correctElevation(route.Points, ElevationCorrection{Model: s.elevation, Mode: ElevationModeReplace})
+		route.HasElevation = true
+	}
+	route.Points = resampleRun(route.Points, 0, routeSampleDistance)
+	return route, nil
+}
+
+// parseGpxRoute reads the points of the tracks of a GPX file, or of its
+// routes when it has no tracks
+func parseGpxRoute(data []byte) (PlannedRoute, error) {
+	file, err := gpx.ParseBytes(data)
+	if err != nil {
+		return PlannedRoute{}, fmt.Errorf("invalid gpx: %w", err)
+	}
+
+	var gpxPoints []gpx.GPXPoint
+	for _, track := range file.Tracks {
+		for _, segment := range track.Segments {
+			gpxPoints = append(gpxPoints, segment.Points...)
+		}
+	}
+	if len(gpxPoints) == 0 {
+		for _, gpxRoute := range file.Routes {
+			gpxPoints = append(gpxPoints, gpxRoute.Points...)
+		}
+	}
+	if len(gpxPoints) < 2 {
+		return PlannedRoute{}, fmt.Errorf("gpx has fewer than two track or route points")
+	}
+
+	route := PlannedRoute{Name: file.Name, HasElevation: true}
+	if len(file.Tracks) > 0 && file.Tracks[0].Name != "" {
+		route.Name = file.Tracks[0].Name
+	} else if len(file.Routes) > 0 && file.Routes[0].Name != "" {
+		route.Name = file.Routes[0].Name
+	}
+
+	start := gpxPoints[0].Timestamp
+	for _, gpxPoint := range gpxPoints {
+		point := StravaStreamPoint{Latitude: gpxPoint.Latitude, Longitude: gpxPoint.Longitude}
+		if gpxPoint.Elevation.NotNull() {
+			point.Altitude = gpxPoint.Elevation.Value()
+		} else {
+			route.HasElevation = false
+		}
+		if !start.IsZero() && !gpxPoint.Timestamp.IsZero() {
+			point.Time = gpxPoint.Timestamp.Sub(start).Seconds()
+		}
+		route.Points = append(route.Points, point)
+	}
+	return route, nil
+}
@@ -0,0 +1,54 @@
+package app
+
+import "testing"
+
+func TestParseGpxRoute(t *testing.T) {
+	t.Run("track", func(t *testing.T) {
+		route, err := parseGpxRoute([]byte(`<?xml version="1.0"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <trk><name>Couloir</name><trkseg>
+    <trkpt lat="46.0" lon="7.0"><ele>1500</ele><time>2025-02-14T07:30:00Z</time></trkpt>
+    <trkpt lat="46.001" lon="7.0"><ele>1520</ele><time>2025-02-14T07:31:00Z</time></trkpt>
+  </trkseg></trk>
+</gpx>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if route.Name != "Couloir" || !route.HasElevation || len(route.Points) != 2 {
+			t.Fatalf("unexpected route %+v", route)
+		}
+		if route.Points[1].Altitude != 1520 || route.Points[1].Time != 60 {
+			t.Errorf("unexpected point %+v", route.Points[1])
+		}
+	})
+
+	t.Run("route without elevation", func(t *testing.T) {
+		route, err := parseGpxRoute([]byte(`<?xml version="1.0"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1">
+  <rte><name>Plan</name>
+    <rtept lat="46.0" lon="7.0"></rtept>
+    <rtept lat="46.01" lon="7.01"></rtept>
+    <rtept lat="46.02" lon="7.0"></rtept>
+  </rte>
+</gpx>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if route.Name != "Plan" || route.HasElevation || len(route.Points) != 3 {
+			t.Errorf("unexpected route %+v", route)
+		}
+	})
+
+	t.Run("too few points", func(t *testing.T) {
+		if _, err := parseGpxRoute([]byte(`<?xml version="1.0"?>
+<gpx version="1.1" creator="test" xmlns="http://www.topografix.com/GPX/1/1"></gpx>`)); err == nil {
+			t.Error("expected an error")
+		}
+	})
+
+	t.Run("not gpx", func(t *testing.T) {
+		if _, err := parseGpxRoute([]byte("lat,lon\n46,7\n")); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,379 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const seasonDateLayout = "2006-01-02"
+
+type Hemisphere string
+
+const (
+	HemisphereNorth Hemisphere = "north"
+	HemisphereSouth Hemisphere = "south"
+)
+
+// seasonActivityTypes are the strava activity types counted towards seasons
+var seasonActivityTypes = map[string]bool{
+	"BackcountrySki": true,
+	"AlpineSki":      true,
+	"NordicSki":      true,
+	"Snowboard":      true,
+	"Snowshoe":       true,
+}
+
+// seasonLabelPattern matches season labels, 2024-25 for seasons spanning
+// new year and 2025 for seasons starting on January 1st
+var seasonLabelPattern = regexp.MustCompile(`^(\d{4})(-\d{2})?$`)
+
+// seasonLock serializes rebuilding season statistics, so that concurrent
+// webhook events cannot store stale aggregates
+var seasonLock sync.Mutex
+
+// SeasonStart is the month and day a season begins
+type SeasonStart struct {
+	Month time.Month
+	Day   int
+}
+
+// SeasonCalendar holds the season boundaries of each hemisphere
+type SeasonCalendar struct {
+	North SeasonStart
+	South SeasonStart
+}
+
+// SeasonActivity is the contribution of one activity to its season
+type SeasonActivity struct {
+	ActivityId int `json:"activity_id"`
+	// Date is the local start date of the activity
+	Date             string     `json:"date"`
+	Hemisphere       Hemisphere `json:"hemisphere"`
+	ElapsedTime      float64    `json:"elapsed_time_s"`
+	Distance         float64    `json:"distance_m"`
+	SkinningVertical float64    `json:"skinning_vertical_m"`
+	SkiingVertical   float64    `json:"skiing_vertical_m"`
+	Laps             int        `json:"laps"`
+	BiggestClimb     float64    `json:"biggest_climb_m"`
+}
+
+// SeasonDay is the total of the activities of a single day
+type SeasonDay struct {
+	Date             string  `json:"date"`
+	Activities       int     `json:"activities"`
+	ElapsedTime      float64 `json:"elapsed_time_s"`
+	SkinningVertical float64 `json:"skinning_vertical_m"`
+}
+
+// SeasonClimb is the biggest single ascent of a season
+type SeasonClimb struct {
+	ActivityId int     `json:"activity_id"`
+	Date       string  `json:"date"`
+	Vertical   float64 `json:"vertical_m"`
+}
+
+// SeasonWeek is the skinning vertical of a week starting on Monday
+type SeasonWeek struct {
+	WeekStart string  `json:"week_start"`
+	Vertical  float64 `json:"vertical_m"`
+}
+
+// SeasonStats are the aggregates of an athlete's season
+type SeasonStats struct {
+	Season     string     `json:"season"`
+	Hemisphere Hemisphere `json:"hemisphere"`
+	StartDate  string     `json:"start_date"`
+	// EndDate is the last day of the season
+	EndDate          string  `json:"end_date"`
+	Days             int     `json:"days"`
+	Activities       int     `json:"activities"`
+	Laps             int     `json:"laps"`
+	Distance         float64 `json:"distance_m"`
+	SkinningVertical float64 `json:"skinning_vertical_m"`
+	SkiingVertical   float64 `json:"skiing_vertical_m"`
+	// LongestDay is the day with the most elapsed time
+	LongestDay   *SeasonDay   `json:"longest_day"`
+	BiggestClimb *SeasonClimb `json:"biggest_climb"`
+	// Weekly is the skinning vertical of every week of the season up to the
+	// latest activity
+	Weekly    []SeasonWeek `json:"weekly"`
+	UpdatedAt time.Time    `json:"updated_at"`
+}
+
+// ParseSeasonStart parses a season start in MM-DD format
+func ParseSeasonStart(value string) (SeasonStart, error) {
+	date, err := time.Parse("01-02", value)
+	if err != nil {
+		return SeasonStart{}, fmt.Errorf("season start must be formatted as MM-DD: %w", err)
+	}
+	if date.Month() == time.February && date.Day() == 29 {
+		return SeasonStart{}, fmt.Errorf("season start cannot be February 29th")
+	}
+	return SeasonStart{Month: date.Month(), Day: date.Day()}, nil
+}
+
+// Start returns the start of a season given its hemisphere and the year it
+// starts in
+func (c SeasonCalendar) Start(hemisphere Hemisphere, year int) time.Time {
+	start := c.North
+	if hemisphere == HemisphereSouth {
+		start = c.South
+	}
+	return time.Date(year, start.Month, start.Day, 0, 0, 0, 0, time.UTC)
+}
+
+// Season returns the label of the season a local date belongs to
+func (c SeasonCalendar) Season(hemisphere Hemisphere, date time.Time) string {
+	year := date.Year()
+	if date.Before(c.Start(hemisphere, year)) {
+		year--
+	}
+	return c.label(hemisphere, year)
+}
+
+func (c SeasonCalendar) label(hemisphere Hemisphere, year int) string {
+	start := c.Start(hemisphere, year)
+	if start.Month() == time.January && start.Day() == 1 {
+		return strconv.Itoa(year)
+	}
+	return fmt.Sprintf("%d-%02d", year, (year+1)%100)
+}
+
+// http request handlers
+
+// handleSeasonStats returns the authenticated athlete's statistics for a
+// season, given by its label or as current. hemisphere=south selects the
+// southern current season.
+func (s *ServerState) handleSeasonStats(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	season, hemisphere, err := s.seasonParam(c)
+	if err != nil {
+		return err
+	}
+
+	stats, err := s.store.FetchSeasonStats(tokenInfo.athleteId, hemisphere, season)
+	if err == redis.Nil {
+		return echo.NewHTTPError(http.StatusNotFound, "No activities in season")
+	}
+	if err != nil {
+		slog.Error("failed to fetch season stats", "athlete_id", tokenInfo.athleteId, "season", season, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch season stats")
+	}
+	return c.JSON(http.StatusOK, stats)
+}
+
+// public functions
+
+// BuildSeasonStats aggregates the activities of a season of a hemisphere
+func BuildSeasonStats(calendar SeasonCalendar, hemisphere Hemisphere, season string, activities []SeasonActivity) SeasonStats {
+	stats := SeasonStats{Season: season, Hemisphere: hemisphere, Weekly: []SeasonWeek{}, UpdatedAt: time.Now().UTC()}
+	if len(activities) == 0 {
+		return stats
+	}
+
+	sort.Slice(activities, func(i, j int) bool {
+		if activities[i].Date != activities[j].Date {
+			return activities[i].Date < activities[j].Date
+		}
+		return activities[i].ActivityId < activities[j].ActivityId
+	})
+
+	last, _ := time.Parse(seasonDateLayout, activities[len(activities)-1].Date)
+	startYear, _ := strconv.Atoi(season[:4])
+	start := calendar.Start(hemisphere, startYear)
+	stats.StartDate = start.Format(seasonDateLayout)
+	stats.EndDate = calendar.Start(hemisphere, startYear+1).AddDate(0, 0, -1).Format(seasonDateLayout)
+
+	days := map[string]*SeasonDay{}
+	weekly := map[string]float64{}
+	for _, activity := range activities {
+		stats.Activities++
+		stats.Laps += activity.Laps
+		stats.Distance += activity.Distance
+		stats.SkinningVertical += activity.SkinningVertical
+		stats.SkiingVertical += activity.SkiingVertical
+
+		day, ok := days[activity.Date]
+		if !ok {
+			day = &SeasonDay{Date: activity.Date}
+			days[activity.Date] = day
+		}
+		day.Activities++
+		day.ElapsedTime += activity.ElapsedTime
+		day.SkinningVertical += activity.SkinningVertical
+
+		if activity.BiggestClimb > 0 && (stats.BiggestClimb == nil || activity.BiggestClimb > stats.BiggestClimb.Vertical) {
+			stats.BiggestClimb = &SeasonClimb{ActivityId: activity.ActivityId, Date: activity.Date, Vertical: activity.BiggestClimb}
+		}
+
+		date, _ := time.Parse(seasonDateLayout, activity.Date)
+		weekly[weekStart(date).Format(seasonDateLayout)] += activity.SkinningVertical
+	}
+
+	stats.Days = len(days)
+	for _, day := range days {
+		if stats.LongestDay == nil || day.ElapsedTime > stats.LongestDay.ElapsedTime ||
+			(day.ElapsedTime == stats.LongestDay.ElapsedTime && day.Date < stats.LongestDay.Date) {
+			stats.LongestDay = day
+		}
+	}
+	stats.LongestDay.SkinningVertical = roundTo(stats.LongestDay.SkinningVertical, 1)
+
+	for week := weekStart(start); !week.After(last); week = week.AddDate(0, 0, 7) {
+		label := week.Format(seasonDateLayout)
+		stats.Weekly = append(stats.Weekly, SeasonWeek{WeekStart: label, Vertical: roundTo(weekly[label], 1)})
+	}
+
+	stats.Distance = roundTo(stats.Distance, 1)
+	stats.SkinningVertical = roundTo(stats.SkinningVertical, 1)
+	stats.SkiingVertical = roundTo(stats.SkiingVertical, 1)
+	return stats
+}
+
+// helpers
+
+// newSeasonActivity returns the season contribution of an activity, and false
+// for activities that do not count towards seasons
+func newSeasonActivity(calendar SeasonCalendar, activity StravaActivity, summary ActivitySummary) (SeasonActivity, string, bool) {
+	if !seasonActivityTypes[activity.Type] {
+		return SeasonActivity{}, "", false
+	}
+
+	date := activityLocalDate(activity, summary)
+	if date.IsZero() {
+		return SeasonActivity{}, "", false
+	}
+
+	hemisphere := HemisphereNorth
+	if activity.StartLatLon[0] < 0 {
+		hemisphere = HemisphereSouth
+	}
+
+	entry := SeasonActivity{
+		ActivityId:       activity.Id,
+		Date:             date.Format(seasonDateLayout),
+		Hemisphere:       hemisphere,
+		ElapsedTime:      summary.ElapsedTime,
+		Distance:         summary.Distance,
+		SkinningVertical: summary.SkinningVertical,
+		SkiingVertical:   summary.SkiingVertical,
+		Laps:             summary.LapCount,
+	}
+	for _, lap := range summary.Laps {
+		if summary.countsAscent(lap) && lap.VerticalGain > entry.BiggestClimb {
+			entry.BiggestClimb = lap.VerticalGain
+		}
+	}
+	return entry, calendar.Season(hemisphere, date), true
+}
+
+// activityLocalDate returns the local start date of an activity, falling back
+// to its UTC start date
+func activityLocalDate(activity StravaActivity, summary ActivitySummary) time.Time {
+	// strava formats local times like UTC times
+	date, err := time.Parse(time.RFC3339, activity.StartDateLocal)
+	if err != nil {
+		return summary.StartDate
+	}
+	return date
+}
+
+// updateSeasons moves an activity's contribution to its current season and
+// rebuilds the statistics of the seasons it was removed from or added to.
+// previous is the activity's earlier summary, if any.
+func (s *ServerState) updateSeasons(previous *ActivitySummary, summary *ActivitySummary, activity StravaActivity) error {
+	seasonLock.Lock()
+	defer seasonLock.Unlock()
+
+	entry, season, ok := newSeasonActivity(s.config.Seasons, activity, *summary)
+	if ok {
+		summary.Season = season
+		summary.SeasonHemisphere = entry.Hemisphere
+		if err := s.store.SaveSeasonActivity(summary.AthleteId, entry.Hemisphere, season, entry); err != nil {
+			return err
+		}
+		if err := s.rebuildSeason(summary.AthleteId, entry.Hemisphere, season); err != nil {
+			return err
+		}
+	}
+
+	if previous != nil && previous.Season != "" &&
+		(previous.Season != summary.Season || seasonHemisphere(*previous) != summary.SeasonHemisphere) {
+		return s.removeFromSeason(previous.AthleteId, seasonHemisphere(*previous), previous.Season, previous.ActivityId)
+	}
+	return nil
+}
+
+// removeSeasonActivity removes a deleted activity from its season
+func (s *ServerState) removeSeasonActivity(summary ActivitySummary) error {
+	if summary.Season == "" {
+		return nil
+	}
+	seasonLock.Lock()
+	defer seasonLock.Unlock()
+	return s.removeFromSeason(summary.AthleteId, seasonHemisphere(summary), summary.Season, summary.ActivityId)
+}
+
+func (s *ServerState) removeFromSeason(athleteId int, hemisphere Hemisphere, season string, activityId int) error {
+	if err := s.store.DeleteSeasonActivity(athleteId, hemisphere, season, activityId); err != nil {
+		return err
+	}
+	return s.rebuildSeason(athleteId, hemisphere, season)
+}
+
+func (s *ServerState) rebuildSeason(athleteId int, hemisphere Hemisphere, season string) error {
+	activities, err := s.store.FetchSeasonActivities(athleteId, hemisphere, season)
+	if err != nil {
+		return err
+	}
+	if len(activities) == 0 {
+		return s.store.DeleteSeasonStats(athleteId, hemisphere, season)
+	}
+	return s.store.SaveSeasonStats(athleteId, BuildSeasonStats(s.config.Seasons, hemisphere, season, activities))
+}
+
+// seasonHemisphere returns the hemisphere of the season a stored summary
+// counts towards, north for summaries stored before it was recorded
+func seasonHemisphere(summary ActivitySummary) Hemisphere {
+	if summary.SeasonHemisphere == "" {
+		return HemisphereNorth
+	}
+	return summary.SeasonHemisphere
+}
+
+// seasonParam reads the season label of a request, resolving current with
+// the hemisphere query parameter. Errors are returned as echo.HTTPError.
+func (s *ServerState) seasonParam(c echo.Context) (string, Hemisphere, error) {
+	hemisphere := HemisphereNorth
+	if c.QueryParam("hemisphere") == string(HemisphereSouth) {
+		hemisphere = HemisphereSouth
+	}
+
+	season := c.Param("season")
+	if season == "current" {
+		season = s.config.Seasons.Season(hemisphere, time.Now())
+	}
+	if !seasonLabelPattern.MatchString(season) {
+		return "", "", echo.NewHTTPError(http.StatusBadRequest, "Season must be formatted as 2024-25, 2025 or current")
+	}
+	return season, hemisphere, nil
+}
+
+// weekStart returns the Monday of a date's week
+func weekStart(date time.Time) time.Time {
+	offset := (int(date.Weekday()) + 6) % 7
+	return date.AddDate(0, 0, -offset)
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"testing"
+	"time"
+)
+
+var testCalendar = SeasonCalendar{
+	North: SeasonStart{Month: time.September, Day: 1},
+	South: SeasonStart{Month: time.January, Day: 1},
+}
+
+func TestParseSeasonStart(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected SeasonStart
+		wantErr  bool
+	}{
+		{"09-01", SeasonStart{time.September, 1}, false},
+		{"12-15", SeasonStart{time.December, 15}, false},
+		{"02-29", SeasonStart{}, true},
+		{"13-01", SeasonStart{}, true},
+		{"september", SeasonStart{}, true},
+	}
+
+	for _, tt := range tests {
+		start, err := ParseSeasonStart(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.value, tt.wantErr, err)
+		}
+		if start != tt.expected {
+			t.Errorf("%s: expected %v, got %v", tt.value, tt.expected, start)
+		}
+	}
+}
+
+func TestSeasonCalendar_Season(t *testing.T) {
+	tests := []struct {
+		hemisphere Hemisphere
+		date       string
+		expected   string
+	}{
+		{HemisphereNorth, "2025-02-14", "2024-25"},
+		{HemisphereNorth, "2024-09-01", "2024-25"},
+		{HemisphereNorth, "2024-08-31", "2023-24"},
+		{HemisphereNorth, "2099-12-31", "2099-00"},
+		{HemisphereSouth, "2025-08-01", "2025"},
+		{HemisphereSouth, "2025-01-01", "2025"},
+	}
+
+	for _, tt := range tests {
+		date, _ := time.Parse(seasonDateLayout, tt.date)
+		if season := testCalendar.Season(tt.hemisphere, date); season != tt.expected {
+			t.Errorf("%s %s: expected %s, got %s", tt.hemisphere, tt.date, tt.expected, season)
+		}
+	}
+}
+
+func TestNewSeasonActivity(t *testing.T) {
+	activity := StravaActivity{Id: 42, Type: "BackcountrySki", StartDateLocal: "2025-02-14T23:30:00Z", StartLatLon: [2]float64{46.5, 7.5}}
+	// in UTC the activity starts the next day
+	summary := SummarizeActivity(StravaActivity{StartDate: "2025-02-15T06:30:00Z"}, skiTourPoints())
+
+	entry, season, ok := newSeasonActivity(testCalendar, activity, summary)
+	if !ok || season != "2024-25" || entry.Date != "2025-02-14" || entry.Hemisphere != HemisphereNorth {
+		t.Fatalf("unexpected season activity %+v in %s", entry, season)
+	}
+	if entry.Laps != 2 || entry.SkinningVertical != summary.SkinningVertical {
+		t.Errorf("expected the summary totals, got %+v", entry)
+	}
+	if entry.BiggestClimb < 290 || entry.BiggestClimb > 310 {
+		t.Errorf("expected the 300 m climb to be the biggest, got %v", entry.BiggestClimb)
+	}
+
+	activity.StartLatLon = [2]float64{-45, 168.7}
+	if entry, season, _ := newSeasonActivity(testCalendar, activity, summary); entry.Hemisphere != HemisphereSouth || season != "2025" {
+		t.Errorf("expected the southern 2025 season, got %s in %s", entry.Hemisphere, season)
+	}
+
+	activity.Type = "Run"
+	if _, _, ok := newSeasonActivity(testCalendar, activity, summary); ok {
+		t.Error("expected runs not to count towards seasons")
+	}
+}
+
+func TestBuildSeasonStats(t *testing.T) {
+	activities := []SeasonActivity{
+		{ActivityId: 3, Date: "2025-01-08", Hemisphere: HemisphereNorth, ElapsedTime: 3600, SkinningVertical: 400, SkiingVertical: 400, Laps: 1, BiggestClimb: 400},
+		{ActivityId: 1, Date: "2024-12-30", Hemisphere: HemisphereNorth, ElapsedTime: 7200, SkinningVertical: 900, SkiingVertical: 900, Laps: 2, BiggestClimb: 600},
+		{ActivityId: 2, Date: "2025-01-08", Hemisphere: HemisphereNorth, ElapsedTime: 5400, SkinningVertical: 700, SkiingVertical: 650, Laps: 1, BiggestClimb: 700},
+	}
+
+	stats := BuildSeasonStats(testCalendar, HemisphereNorth, "2024-25", activities)
+
+	if stats.StartDate != "2024-09-01" || stats.EndDate != "2025-08-31" {
+		t.Errorf("unexpected season bounds %s to %s", stats.StartDate, stats.EndDate)
+	}
+	if stats.Days != 2 || stats.Activities != 3 || stats.Laps != 4 {
+		t.Errorf("expected 3 activities on 2 days with 4 laps, got %+v", stats)
+	}
+	if stats.SkinningVertical != 2000 || stats.SkiingVertical != 1950 {
+		t.Errorf("unexpected vertical %v and %v", stats.SkinningVertical, stats.SkiingVertical)
+	}
+	if stats.LongestDay == nil || stats.LongestDay.Date != "2025-01-08" || stats.LongestDay.ElapsedTime != 9000 || stats.LongestDay.Activities != 2 {
+		t.Errorf("unexpected longest day %+v", stats.LongestDay)
+	}
+	if stats.BiggestClimb == nil || stats.BiggestClimb.ActivityId != 2 || stats.BiggestClimb.Vertical != 700 {
+		t.Errorf("unexpected biggest climb %+v", stats.BiggestClimb)
+	}
+
+	// 2024-09-01 is a Sunday, so weeks start on 2024-08-26 and run through
+	// the week of 2025-01-06
+	if len(stats.Weekly) != 20 || stats.Weekly[0].WeekStart != "2024-08-26" {
+		t.Fatalf("unexpected weeks %+v", stats.Weekly)
+	}
+	if week := stats.Weekly[18]; week.WeekStart != "2024-12-30" || week.Vertical != 900 {
+		t.Errorf("unexpected week %+v", week)
+	}
+	if week := stats.Weekly[19]; week.WeekStart != "2025-01-06" || week.Vertical != 1100 {
+		t.Errorf("unexpected week %+v", week)
+	}
+}
+
+func TestBuildSeasonStats_Empty(t *testing.T) {
+	stats := BuildSeasonStats(testCalendar, HemisphereNorth, "2024-25", nil)
+	if stats.Days != 0 || stats.LongestDay != nil || stats.Weekly == nil {
+		t.Errorf("expected empty stats, got %+v", stats)
+	}
+}
+
+func TestBuildSeasonStats_CollidingLabels(t *testing.T) {
+	calendar := SeasonCalendar{
+		North: SeasonStart{Month: time.September, Day: 1},
+		South: SeasonStart{Month: time.March, Day: 1},
+	}
+	north := StravaActivity{Id: 1, Type: "BackcountrySki", StartDateLocal: "2024-12-20T08:00:00Z", StartLatLon: [2]float64{46.5, 7.5}}
+	south := StravaActivity{Id: 2, Type: "BackcountrySki", StartDateLocal: "2024-08-10T08:00:00Z", StartLatLon: [2]float64{-45, 168.7}}
+	summary := SummarizeActivity(StravaActivity{}, skiTourPoints())
+
+	northEntry, northSeason, _ := newSeasonActivity(calendar, north, summary)
+	southEntry, southSeason, _ := newSeasonActivity(calendar, south, summary)
+	if northSeason != "2024-25" || southSeason != "2024-25" || northEntry.Hemisphere == southEntry.Hemisphere {
+		t.Fatalf("expected both activities in 2024-25 of different hemispheres, got %s %s", northSeason, southSeason)
+	}
+
+	// the southern season is bounded by its own calendar even though its
+	// label matches the northern one
+	stats := BuildSeasonStats(calendar, HemisphereSouth, southSeason, []SeasonActivity{southEntry})
+	if stats.Hemisphere != HemisphereSouth || stats.StartDate != "2024-03-01" || stats.EndDate != "2025-02-28" || stats.Activities != 1 {
+		t.Errorf("unexpected southern season %+v", stats)
+	}
+	stats = BuildSeasonStats(calendar, HemisphereNorth, northSeason, []SeasonActivity{northEntry})
+	if stats.Hemisphere != HemisphereNorth || stats.StartDate != "2024-09-01" || stats.EndDate != "2025-08-31" || stats.Activities != 1 {
+		t.Errorf("unexpected northern season %+v", stats)
+	}
+}
@@ -2,9 +2,13 @@ package app
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"sync/atomic"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -14,12 +18,24 @@ import (
 var (
 	authUrl  = "https://www.strava.com/oauth/authorize"
 	tokenUrl = "https://www.strava.com/oauth/token"
+
+	// redactedQueryParams are the query parameters left out of request logs
+	redactedQueryParams = []string{"key", "token", "signature", "code", "state"}
 )
 
 type ServerState struct {
 	config       Config
 	store        Store
 	stravaClient StravaClient
+	// elevation is nil when no DEM directory is configured
+	elevation *ElevationModel
+	// peaks is nil when no peaks file is configured
+	peaks *PeakIndex
+	// subscriptionId is the id of the app's push subscription, 0 until it
+	// is established
+	subscriptionId *atomic.Int64
+	// events queues webhook activity events for processing
+	events *eventQueue
 }
 
 func NewServer() ServerState {
@@ -33,6 +49,22 @@ func NewServer() ServerState {
 	// Create a StravaClient without a token for OAuth and API requests
 	stravaClient := NewStravaClient("")
 	ctx := context.Background()
+
+	var elevation *ElevationModel
+	if config.DemDir != "" {
+		elevation = NewElevationModel(config.DemDir)
+	}
+
+	var peaks *PeakIndex
+	if config.PeaksFile != "" {
+		peaks, err = LoadPeaks(config.PeaksFile)
+		if err != nil {
+			slog.Error("Cannot load peaks file", "peaks_file", config.PeaksFile, "err", err)
+			panic(err)
+		}
+		slog.Info("loaded peaks", "peaks_file", config.PeaksFile, "peaks", peaks.Len())
+	}
+
 	return ServerState{
 		config: config,
 		store: Store{
@@ -41,7 +73,10 @@ func NewServer() ServerState {
 			config:       &config,
 			stravaClient: &stravaClient,
 		},
-		stravaClient: stravaClient,
+		stravaClient:   stravaClient,
+		elevation:      elevation,
+		peaks:          peaks,
+		subscriptionId: new(atomic.Int64),
 	}
 }
 
@@ -59,12 +94,12 @@ func (s *ServerState) RunForever() {
 		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
 			if v.Error == nil {
 				logger.LogAttrs(context.Background(), slog.LevelInfo, "REQUEST",
-					slog.String("uri", v.URI),
+					slog.String("uri", redactUri(v.URI)),
 					slog.Int("status", v.Status),
 				)
 			} else {
 				logger.LogAttrs(context.Background(), slog.LevelError, "REQUEST_ERROR",
-					slog.String("uri", v.URI),
+					slog.String("uri", redactUri(v.URI)),
 					slog.Int("status", v.Status),
 					slog.String("err", v.Error.Error()),
 				)
@@ -85,7 +120,7 @@ func (s *ServerState) RunForever() {
 	e.GET("/oauth2/connect", s.handleConnect)
 	e.GET("/oauth2/callback", s.handleCallback)
 	e.GET("/subscriptions/callback", s.handleSubscriptionCallback)
-	e.POST("/subscriptions/callback", handlePushEvent)
+	e.POST("/subscriptions/callback", s.handlePushEvent)
 
 	// token generation API
 	e.GET("/token/new", s.handleTokenStart)
@@ -94,13 +129,98 @@ func (s *ServerState) RunForever() {
 	e.POST("/token/revoke", s.handleTokenRevoke)
 	e.GET("/api/strava-token", s.handleStravaToken)
 
+	// activity API
+	e.GET("/api/activities/:id/export", s.handleActivityExport)
+	e.GET("/api/activities/:id/elevation", s.handleActivityElevation)
+	e.GET("/api/activities/:id/summary", s.handleActivitySummary)
+	e.POST("/api/activities/:id/summary/refresh", s.handleActivitySummaryRefresh)
+	e.GET("/api/activities/:id/terrain", s.handleActivityTerrain)
+	e.GET("/api/activities/:id/ates", s.handleActivityAtes)
+	e.GET("/api/activities/:id/routes", s.handleActivityRoutes)
+	e.GET("/api/activities/:id/profile.svg", s.handleActivityProfileSvg)
+	e.GET("/api/activities/:id/track.svg", s.handleActivityTrackSvg)
+	e.GET("/api/activities/:id/report", s.handleActivityReport)
+
+	// planned routes API
+	e.POST("/api/routes/ates", s.handleRouteAtes)
+	e.POST("/api/routes/estimate", s.handleRouteEstimate)
+
+	// athlete API
+	e.GET("/api/athletes/me/seasons/:season", s.handleSeasonStats)
+	e.GET("/api/athletes/me/training-load/:season", s.handleTrainingLoad)
+	e.GET("/api/athletes/me/records", s.handleRecords)
+	e.GET("/api/athletes/me/records/settings", s.handleRecordSettingsGet)
+	e.PUT("/api/athletes/me/records/settings", s.handleRecordSettingsPut)
+	e.GET("/api/athletes/me/zones", s.handleZoneStats)
+	e.GET("/api/athletes/me/peaks", s.handlePeaks)
+	e.GET("/api/athletes/me/routes", s.handleRoutes)
+	e.POST("/api/athletes/me/backfill", s.handleBackfillStart)
+	e.GET("/api/athletes/me/backfill", s.handleBackfillStatus)
+
+	// heatmap tiles
+	e.GET("/api/tiles/:z/:x/:y", s.handleHeatmapTile)
+	e.POST("/api/tiles/token", s.handleTileTokenCreate)
+	e.DELETE("/api/tiles/token", s.handleTileTokenDelete)
+
+	// privacy zones API
+	e.GET("/api/privacy-zones", s.handlePrivacyZonesGet)
+	e.PUT("/api/privacy-zones", s.handlePrivacyZonesPut)
+
+	// named zones and teams API
+	e.GET("/api/zones", s.handleZonesGet)
+	e.PUT("/api/zones", s.handleZonesPut)
+	e.GET("/api/teams", s.handleTeamsGet)
+	e.POST("/api/teams", s.handleTeamCreate)
+	e.POST("/api/teams/:id/members", s.handleTeamJoin)
+	e.GET("/api/teams/:id/zones", s.handleTeamZonesGet)
+	e.PUT("/api/teams/:id/zones", s.handleTeamZonesPut)
+
+	// bulk export API
+	e.POST("/api/exports", s.handleExportStart)
+	e.GET("/api/exports/:id", s.handleExportStatus)
+	e.GET("/api/exports/:id/download", s.handleExportDownload)
+
+	s.events = newEventQueue(webhookWorkers, webhookQueueSize, s.handleActivityEvent)
+
 	slog.Info("Establishing subscriptions in background")
-	go EstablishSubscriptions(&s.config, &s.stravaClient)
+	go func() {
+		if id := EstablishSubscriptions(&s.config, &s.stravaClient); id != 0 {
+			s.subscriptionId.Store(int64(id))
+		}
+	}()
 
 	slog.Info("starting server", "port", 8080)
 	e.Logger.Fatal(e.Start(":8080"))
 }
 
+// athleteClient returns a strava client authorized as the given athlete
+func (s *ServerState) athleteClient(athleteId int) (StravaClient, error) {
+	token, err := s.store.FetchToken(athleteId)
+	if err != nil {
+		return StravaClient{}, fmt.Errorf("error fetching strava token: %w", err)
+	}
+	return NewStravaClient(token), nil
+}
+
+// redactUri hides the values of query parameters that carry credentials, such
+// as tile tokens and signed download links, from request logs
+func redactUri(uri string) string {
+	path, rawQuery, found := strings.Cut(uri, "?")
+	if !found {
+		return uri
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return path
+	}
+	for _, name := range redactedQueryParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+		}
+	}
+	return path + "?" + query.Encode()
+}
+
 func handleHealthcheck(c echo.Context) error {
 	response := struct {
 		Ok bool `json:"ok"`
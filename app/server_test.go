@@ -0,0 +1,22 @@
+package app
+
+import "testing"
+
+func TestRedactUri(t *testing.T) {
+	tests := []struct {
+		uri      string
+		expected string
+	}{
+		{"/api/tiles/12/1200/1500.png", "/api/tiles/12/1200/1500.png"},
+		{"/api/tiles/12/1200/1500.png?key=abc", "/api/tiles/12/1200/1500.png?key=REDACTED"},
+		{"/api/exports/job-1/download?expires=1700000000&signature=abc", "/api/exports/job-1/download?expires=1700000000&signature=REDACTED"},
+		{"/oauth2/callback?code=abc&state=def&scope=read", "/oauth2/callback?code=REDACTED&scope=read&state=REDACTED"},
+		{"/api/tiles/1/0/0.png?token=abc", "/api/tiles/1/0/0.png?token=REDACTED"},
+	}
+
+	for _, tt := range tests {
+		if redacted := redactUri(tt.uri); redacted != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.uri, tt.expected, redacted)
+		}
+	}
+}
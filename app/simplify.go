@@ -0,0 +1,316 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultVerticalTolerance keeps simplification from flattening climbs
+	// when no vertical tolerance is given
+	defaultVerticalTolerance = 2.0
+
+	// despike defaults, well above anything reachable on skis
+	defaultSpikeMaxSpeed         = 45.0
+	defaultSpikeMaxVerticalSpeed = 15.0
+)
+
+// SimplifyOptions reduce the number of points in an exported track. Zero
+// values disable each step. Steps run in the order spike removal,
+// resampling, simplification.
+type SimplifyOptions struct {
+	// MaxSpeed and MaxVerticalSpeed, in m/s, mark a point as a GPS spike
+	// when it is reached faster than this from the previous good point
+	MaxSpeed         float64
+	MaxVerticalSpeed float64
+
+	// ResampleInterval and ResampleDistance resample the track at fixed
+	// time or distance steps. At most one should be set.
+	ResampleInterval time.Duration
+	ResampleDistance float64
+
+	// Tolerance is the Ramer-Douglas-Peucker tolerance in meters. Points
+	// are also kept when dropping them would move the altitude by more than
+	// VerticalTolerance.
+	Tolerance         float64
+	VerticalTolerance float64
+}
+
+func (o SimplifyOptions) enabled() bool {
+	return o.MaxSpeed > 0 || o.MaxVerticalSpeed > 0 || o.ResampleInterval > 0 || o.ResampleDistance > 0 || o.Tolerance > 0
+}
+
+// ParseSimplifyOptions reads simplify (meters), vertical_tolerance (meters),
+// resample (a duration such as "30s" or a distance such as "25m") and
+// despike (true or a max speed in m/s) parameters
+func ParseSimplifyOptions(query url.Values) (SimplifyOptions, error) {
+	var options SimplifyOptions
+
+	if value := query.Get("simplify"); value != "" {
+		tolerance, err := strconv.ParseFloat(value, 64)
+		if err != nil || tolerance <= 0 {
+			return options, fmt.Errorf("simplify must be a positive tolerance in meters")
+		}
+		options.Tolerance = tolerance
+	}
+
+	if value := query.Get("vertical_tolerance"); value != "" {
+		tolerance, err := strconv.ParseFloat(value, 64)
+		if err != nil || tolerance <= 0 {
+			return options, fmt.Errorf("vertical_tolerance must be a positive tolerance in meters")
+		}
+		options.VerticalTolerance = tolerance
+	}
+
+	if value := query.Get("resample"); value != "" {
+		// a bare number or trailing "m" is meters, so minutes must be given
+		// in another unit such as "300s"
+		if distance, err := strconv.ParseFloat(strings.TrimSuffix(value, "m"), 64); err == nil && distance > 0 {
+			options.ResampleDistance = distance
+		} else if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			options.ResampleInterval = interval
+		} else {
+			return options, fmt.Errorf("resample must be a duration such as 30s or a distance such as 25m")
+		}
+	}
+
+	if value := query.Get("despike"); value != "" && value != "false" {
+		options.MaxSpeed = defaultSpikeMaxSpeed
+		options.MaxVerticalSpeed = defaultSpikeMaxVerticalSpeed
+		if value != "true" {
+			maxSpeed, err := strconv.ParseFloat(value, 64)
+			if err != nil || maxSpeed <= 0 {
+				return options, fmt.Errorf("despike must be true or a max speed in m/s")
+			}
+			options.MaxSpeed = maxSpeed
+		}
+	}
+
+	return options, nil
+}
+
+// simplifyTrack applies the simplification options. Lap indices of the
+// returned activity refer to the returned stream.
+func simplifyTrack(activity StravaActivity, streamPoints []StravaStreamPoint, options SimplifyOptions, pauseThreshold time.Duration) (StravaActivity, []StravaStreamPoint) {
+	if !options.enabled() || len(streamPoints) < 3 {
+		return activity, streamPoints
+	}
+
+	result := streamPoints
+	if options.MaxSpeed > 0 || options.MaxVerticalSpeed > 0 {
+		result = removeSpikes(result, options.MaxSpeed, options.MaxVerticalSpeed)
+	}
+
+	if options.ResampleInterval > 0 || options.ResampleDistance > 0 {
+		// resample each run separately so pauses are not filled in
+		var resampled []StravaStreamPoint
+		for _, run := range splitAtPauses(result, pauseThreshold) {
+			resampled = append(resampled, resampleRun(result[run[0]:run[1]], options.ResampleInterval, options.ResampleDistance)...)
+		}
+		result = resampled
+	}
+
+	if options.Tolerance > 0 {
+		verticalTolerance := options.VerticalTolerance
+		if verticalTolerance <= 0 {
+			verticalTolerance = defaultVerticalTolerance
+		}
+
+		// keep lap boundaries so laps still start and end where they did
+		keep := make([]bool, len(result))
+		for _, lap := range remapLapsByTime(activity, streamPoints, result).Laps {
+			keep[min(max(lap.StartIndex, 0), len(result)-1)] = true
+			keep[min(max(lap.EndIndex, 0), len(result)-1)] = true
+		}
+		for _, run := range splitAtPauses(result, pauseThreshold) {
+			keep[run[0]] = true
+			keep[run[1]-1] = true
+			ramerDouglasPeucker(result, run[0], run[1]-1, options.Tolerance, verticalTolerance, keep)
+		}
+
+		simplified := make([]StravaStreamPoint, 0, len(result))
+		for i, point := range result {
+			if keep[i] {
+				simplified = append(simplified, point)
+			}
+		}
+		result = simplified
+	}
+
+	return remapLapsByTime(activity, streamPoints, result), result
+}
+
+// removeSpikes drops points that could only be reached from the previous
+// kept point at an implausible speed. Leading points are dropped until two
+// consecutive points agree, so a spike in the first fix is not taken as the
+// reference for the rest of the track.
+func removeSpikes(points []StravaStreamPoint, maxSpeed float64, maxVerticalSpeed float64) []StravaStreamPoint {
+	start := 0
+	for start+1 < len(points) && !plausibleStep(points[start], points[start+1], maxSpeed, maxVerticalSpeed) {
+		start++
+	}
+	if start+1 == len(points) {
+		// no two points agree, so there is no reference to judge by
+		start = 0
+	}
+
+	result := []StravaStreamPoint{points[start]}
+	for _, point := range points[start+1:] {
+		if plausibleStep(result[len(result)-1], point, maxSpeed, maxVerticalSpeed) {
+			result = append(result, point)
+		}
+	}
+	return result
+}
+
+// plausibleStep reports whether a point can be reached from the previous one
+// within the speed limits, limits of zero being ignored
+func plausibleStep(previous StravaStreamPoint, point StravaStreamPoint, maxSpeed float64, maxVerticalSpeed float64) bool {
+	elapsed := point.Time - previous.Time
+	if elapsed <= 0 {
+		return false
+	}
+
+	distance := haversineDistance(previous.Latitude, previous.Longitude, point.Latitude, point.Longitude)
+	if maxSpeed > 0 && distance/elapsed > maxSpeed {
+		return false
+	}
+	if maxVerticalSpeed > 0 && math.Abs(point.Altitude-previous.Altitude)/elapsed > maxVerticalSpeed {
+		return false
+	}
+	return true
+}
+
+// resampleRun interpolates points at fixed time or distance steps along a
+// run of points without pauses
+func resampleRun(points []StravaStreamPoint, interval time.Duration, step float64) []StravaStreamPoint {
+	if len(points) < 2 {
+		return points
+	}
+
+	// position of each point on the resampling axis
+	axis := make([]float64, len(points))
+	var stepSize float64
+	if interval > 0 {
+		stepSize = interval.Seconds()
+		for i, point := range points {
+			axis[i] = point.Time - points[0].Time
+		}
+	} else {
+		stepSize = step
+		distances := cumulativeDistances(points)
+		for i := range points {
+			axis[i] = distances[i] - distances[0]
+		}
+	}
+
+	result := []StravaStreamPoint{points[0]}
+	segment := 1
+	for target := stepSize; target < axis[len(axis)-1]; target += stepSize {
+		for segment < len(points)-1 && axis[segment] < target {
+			segment++
+		}
+		span := axis[segment] - axis[segment-1]
+		fraction := 0.0
+		if span > 0 {
+			fraction = (target - axis[segment-1]) / span
+		}
+		result = append(result, interpolatePoint(points[segment-1], points[segment], fraction))
+	}
+	return append(result, points[len(points)-1])
+}
+
+func interpolatePoint(a StravaStreamPoint, b StravaStreamPoint, fraction float64) StravaStreamPoint {
+	lerp := func(x, y float64) float64 { return x + (y-x)*fraction }
+	return StravaStreamPoint{
+		Time:        lerp(a.Time, b.Time),
+		Latitude:    lerp(a.Latitude, b.Latitude),
+		Longitude:   lerp(a.Longitude, b.Longitude),
+		Altitude:    lerp(a.Altitude, b.Altitude),
+		Distance:    lerp(a.Distance, b.Distance),
+		HeartRate:   lerp(a.HeartRate, b.HeartRate),
+		Temperature: lerp(a.Temperature, b.Temperature),
+		Cadence:     lerp(a.Cadence, b.Cadence),
+		Power:       lerp(a.Power, b.Power),
+		Moving:      a.Moving,
+	}
+}
+
+// ramerDouglasPeucker marks the points between first and last that must be
+// kept for the track to stay within tolerance horizontally and within
+// verticalTolerance in altitude
+func ramerDouglasPeucker(points []StravaStreamPoint, first int, last int, tolerance float64, verticalTolerance float64, keep []bool) {
+	if last-first < 2 {
+		return
+	}
+
+	start, end := points[first], points[last]
+	ex, ey := projectLocal(start, end)
+	segmentLengthSq := ex*ex + ey*ey
+
+	worstIndex, worstScore := -1, 1.0
+	for i := first + 1; i < last; i++ {
+		px, py := projectLocal(start, points[i])
+
+		// position along the chord, clamped to the segment
+		along := 0.0
+		if segmentLengthSq > 0 {
+			along = math.Max(0, math.Min(1, (px*ex+py*ey)/segmentLengthSq))
+		}
+		horizontal := math.Hypot(px-along*ex, py-along*ey)
+		vertical := math.Abs(points[i].Altitude - (start.Altitude + along*(end.Altitude-start.Altitude)))
+
+		score := math.Max(horizontal/tolerance, vertical/verticalTolerance)
+		if score > worstScore {
+			worstIndex, worstScore = i, score
+		}
+	}
+
+	if worstIndex < 0 {
+		return
+	}
+	keep[worstIndex] = true
+	ramerDouglasPeucker(points, first, worstIndex, tolerance, verticalTolerance, keep)
+	ramerDouglasPeucker(points, worstIndex, last, tolerance, verticalTolerance, keep)
+}
+
+// projectLocal returns the east and north offset in meters of point from
+// origin using an equirectangular projection, accurate over a few km
+func projectLocal(origin StravaStreamPoint, point StravaStreamPoint) (float64, float64) {
+	x := (point.Longitude - origin.Longitude) * math.Pi / 180 * earthRadiusMeters * math.Cos(origin.Latitude*math.Pi/180)
+	y := (point.Latitude - origin.Latitude) * math.Pi / 180 * earthRadiusMeters
+	return x, y
+}
+
+// remapLapsByTime moves lap indices from the original stream onto a
+// transformed stream by matching sample times
+func remapLapsByTime(activity StravaActivity, original []StravaStreamPoint, transformed []StravaStreamPoint) StravaActivity {
+	if len(activity.Laps) == 0 || len(original) == 0 {
+		return activity
+	}
+
+	// first transformed index at or after the given time
+	indexAt := func(t float64) int {
+		for i, point := range transformed {
+			if point.Time >= t {
+				return i
+			}
+		}
+		return len(transformed)
+	}
+
+	laps := make([]StravaLap, len(activity.Laps))
+	for i, lap := range activity.Laps {
+		start := min(max(lap.StartIndex, 0), len(original)-1)
+		end := min(max(lap.EndIndex, start), len(original)-1)
+		lap.StartIndex = indexAt(original[start].Time)
+		lap.EndIndex = indexAt(original[end].Time+1e-6) - 1
+		laps[i] = lap
+	}
+	activity.Laps = laps
+	return activity
+}
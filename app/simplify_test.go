@@ -0,0 +1,165 @@
+package app
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestParseSimplifyOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    url.Values
+		expected SimplifyOptions
+		wantErr  bool
+	}{
+		{"empty", url.Values{}, SimplifyOptions{}, false},
+		{"tolerance", url.Values{"simplify": {"5"}}, SimplifyOptions{Tolerance: 5}, false},
+		{"resample duration", url.Values{"resample": {"30s"}}, SimplifyOptions{ResampleInterval: 30 * time.Second}, false},
+		{"resample distance", url.Values{"resample": {"25m"}}, SimplifyOptions{ResampleDistance: 25}, false},
+		{"resample minutes", url.Values{"resample": {"300s"}}, SimplifyOptions{ResampleInterval: 5 * time.Minute}, false},
+		{"despike default", url.Values{"despike": {"true"}}, SimplifyOptions{MaxSpeed: defaultSpikeMaxSpeed, MaxVerticalSpeed: defaultSpikeMaxVerticalSpeed}, false},
+		{"despike speed", url.Values{"despike": {"20"}}, SimplifyOptions{MaxSpeed: 20, MaxVerticalSpeed: defaultSpikeMaxVerticalSpeed}, false},
+		{"despike false", url.Values{"despike": {"false"}}, SimplifyOptions{}, false},
+		{"negative tolerance", url.Values{"simplify": {"-1"}}, SimplifyOptions{}, true},
+		{"bad resample", url.Values{"resample": {"soon"}}, SimplifyOptions{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := ParseSimplifyOptions(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && options != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, options)
+			}
+		})
+	}
+}
+
+func TestRemoveSpikes(t *testing.T) {
+	points := linePoints(6)
+	// a horizontal jump of several km and an altitude jump of 300 m
+	points[2].Longitude = -70.9
+	points[4].Altitude = 800
+
+	result := removeSpikes(points, defaultSpikeMaxSpeed, defaultSpikeMaxVerticalSpeed)
+	if len(result) != 4 {
+		t.Fatalf("expected 4 points, got %d", len(result))
+	}
+	for _, point := range result {
+		if point.Longitude != -71 || point.Altitude > 600 {
+			t.Errorf("spike was kept: %+v", point)
+		}
+	}
+}
+
+func TestRemoveSpikes_LeadingSpike(t *testing.T) {
+	points := linePoints(6)
+	// the first fix is several km off before the receiver settles
+	points[0].Longitude = -70.9
+
+	result := removeSpikes(points, defaultSpikeMaxSpeed, defaultSpikeMaxVerticalSpeed)
+	if len(result) != 5 || result[0].Time != 10 {
+		t.Fatalf("expected the leading spike dropped and 5 points kept, got %d starting at %v", len(result), result[0].Time)
+	}
+	for _, point := range result {
+		if point.Longitude != -71 {
+			t.Errorf("spike was kept: %+v", point)
+		}
+	}
+}
+
+func TestResampleRun_Interval(t *testing.T) {
+	// samples every 10 s resampled every 25 s
+	result := resampleRun(linePoints(11), 25*time.Second, 0)
+
+	expectedTimes := []float64{0, 25, 50, 75, 100}
+	if len(result) != len(expectedTimes) {
+		t.Fatalf("expected %d points, got %d", len(expectedTimes), len(result))
+	}
+	for i, expected := range expectedTimes {
+		if result[i].Time != expected {
+			t.Errorf("point %d: expected time %v, got %v", i, expected, result[i].Time)
+		}
+	}
+	if result[1].Altitude != 502.5 {
+		t.Errorf("expected interpolated altitude 502.5, got %v", result[1].Altitude)
+	}
+}
+
+func TestResampleRun_Distance(t *testing.T) {
+	points := linePoints(11)
+	distances := cumulativeDistances(points)
+	result := resampleRun(points, 0, 250)
+
+	// about 1112 m at 250 m steps plus the final point
+	if len(result) != 6 {
+		t.Fatalf("expected 6 points, got %d", len(result))
+	}
+	resampled := cumulativeDistances(result)
+	if diff := resampled[1] - 250; diff > 0.5 || diff < -0.5 {
+		t.Errorf("expected first step of 250 m, got %v", resampled[1])
+	}
+	if diff := resampled[len(resampled)-1] - distances[len(distances)-1]; diff > 0.5 || diff < -0.5 {
+		t.Errorf("expected total distance %v, got %v", distances[len(distances)-1], resampled[len(resampled)-1])
+	}
+}
+
+func TestSimplifyTrack_StraightLine(t *testing.T) {
+	activity := StravaActivity{Laps: []StravaLap{{StartIndex: 0, EndIndex: 4}, {StartIndex: 5, EndIndex: 9}}}
+
+	simplified, result := simplifyTrack(activity, linePoints(10), SimplifyOptions{Tolerance: 5}, 0)
+
+	// only the ends and lap boundaries survive on a straight, steady climb
+	if len(result) != 4 {
+		t.Fatalf("expected 4 points, got %d", len(result))
+	}
+	expectedLaps := [][2]int{{0, 1}, {2, 3}}
+	for i, lap := range simplified.Laps {
+		if lap.StartIndex != expectedLaps[i][0] || lap.EndIndex != expectedLaps[i][1] {
+			t.Errorf("lap %d: expected %v, got [%d %d]", i, expectedLaps[i], lap.StartIndex, lap.EndIndex)
+		}
+	}
+}
+
+func TestSimplifyTrack_PreservesVertical(t *testing.T) {
+	points := linePoints(9)
+	// a 20 m bump in the middle of a straight line
+	points[4].Altitude += 20
+
+	_, result := simplifyTrack(StravaActivity{}, points, SimplifyOptions{Tolerance: 50}, 0)
+
+	found := false
+	for _, point := range result {
+		if point.Time == points[4].Time {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected summit point to be kept, got %d points", len(result))
+	}
+
+	gain, _ := elevationChange(result)
+	if originalGain, _ := elevationChange(points); gain != originalGain {
+		t.Errorf("expected elevation gain of %v, got %v", originalGain, gain)
+	}
+}
+
+func TestSimplifyTrack_KeepsPauses(t *testing.T) {
+	points := linePoints(10)
+	for i := 5; i < len(points); i++ {
+		points[i].Time += 600
+	}
+
+	_, result := simplifyTrack(StravaActivity{}, points, SimplifyOptions{Tolerance: 5}, 0)
+
+	// each side of the pause keeps its own ends
+	if len(result) != 4 {
+		t.Fatalf("expected 4 points, got %d", len(result))
+	}
+	if len(splitAtPauses(result, 0)) != 2 {
+		t.Errorf("expected the pause to be preserved")
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -57,6 +59,31 @@ func (s *Store) SaveToken(athleteId int, token TokenInfo) error {
 	return nil
 }
 
+// SaveTokenScope stores the scopes the athlete granted with their latest
+// token
+func (s *Store) SaveTokenScope(athleteId int, scope string) error {
+	key := fmt.Sprintf("athlete:%d:strava-scope", athleteId)
+	err := s.client.Set(s.ctx, key, scope, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save token scope: %w", err)
+	}
+	return nil
+}
+
+// FetchTokenScope loads the scopes the athlete granted, empty for athletes
+// who connected before they were recorded
+func (s *Store) FetchTokenScope(athleteId int) (string, error) {
+	key := fmt.Sprintf("athlete:%d:strava-scope", athleteId)
+	scope, err := s.client.Get(s.ctx, key).Result()
+	if err == redis.Nil {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch token scope: %w", err)
+	}
+	return scope, nil
+}
+
 func (s *Store) fetchTokenInfo(athleteId int) (*TokenInfo, error) {
 	authKey := fmt.Sprintf("athlete:%d:strava-token", athleteId)
 	var tokenInfo TokenInfo
@@ -230,3 +257,995 @@ func (s *Store) IsJWTRevoked(jti string) (bool, error) {
 
 	return exists > 0, nil
 }
+
+// SaveExportJob stores the state of a bulk export job
+func (s *Store) SaveExportJob(job ExportJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode export job: %w", err)
+	}
+
+	jobKey := fmt.Sprintf("export:job:%s", job.Id)
+	err = s.client.Set(s.ctx, jobKey, data, exportJobTTL).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save export job: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimExportJob makes a job the athlete's current bulk export unless the
+// athlete already has one, returning false if so
+func (s *Store) ClaimExportJob(athleteId int, jobId string) (bool, error) {
+	athleteKey := fmt.Sprintf("athlete:%d:export-job", athleteId)
+	claimed, err := s.client.SetNX(s.ctx, athleteKey, jobId, exportJobTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim export job: %w", err)
+	}
+	return claimed, nil
+}
+
+// ReplaceExportJob makes a job the athlete's current bulk export in place of
+// a previous one, returning false if the previous job is no longer current
+func (s *Store) ReplaceExportJob(athleteId int, previousId string, jobId string) (bool, error) {
+	return s.swapAthleteExportJob(athleteId, previousId, jobId)
+}
+
+// ReleaseExportJob clears the athlete's current bulk export if it is still
+// the given job
+func (s *Store) ReleaseExportJob(athleteId int, jobId string) error {
+	_, err := s.swapAthleteExportJob(athleteId, jobId, "")
+	return err
+}
+
+// swapAthleteExportJob points the athlete's current bulk export from one job
+// to another, or clears it when jobId is empty, unless it changed meanwhile
+func (s *Store) swapAthleteExportJob(athleteId int, previousId string, jobId string) (bool, error) {
+	athleteKey := fmt.Sprintf("athlete:%d:export-job", athleteId)
+
+	swapped := false
+	err := s.client.Watch(s.ctx, func(tx *redis.Tx) error {
+		current, err := tx.Get(s.ctx, athleteKey).Result()
+		if err != nil && err != redis.Nil {
+			return err
+		}
+		if current != previousId {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(s.ctx, func(pipe redis.Pipeliner) error {
+			if jobId == "" {
+				pipe.Del(s.ctx, athleteKey)
+			} else {
+				pipe.Set(s.ctx, athleteKey, jobId, exportJobTTL)
+			}
+			return nil
+		})
+		swapped = err == nil
+		return err
+	}, athleteKey)
+
+	// the transaction fails when another request changed the current job
+	if err == redis.TxFailedErr {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update athlete export job: %w", err)
+	}
+	return swapped, nil
+}
+
+// FetchExportJob loads a bulk export job, returning redis.Nil if it does not
+// exist or has expired
+func (s *Store) FetchExportJob(jobId string) (*ExportJob, error) {
+	jobKey := fmt.Sprintf("export:job:%s", jobId)
+	data, err := s.client.Get(s.ctx, jobKey).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var job ExportJob
+	err = json.Unmarshal(data, &job)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode export job: %w", err)
+	}
+	return &job, nil
+}
+
+// FetchAthleteExportJob loads the athlete's most recent bulk export job,
+// returning redis.Nil if there is none
+func (s *Store) FetchAthleteExportJob(athleteId int) (*ExportJob, error) {
+	athleteKey := fmt.Sprintf("athlete:%d:export-job", athleteId)
+	jobId, err := s.client.Get(s.ctx, athleteKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	return s.FetchExportJob(jobId)
+}
+
+// TouchExportJob records that a bulk export job is still being worked on.
+// The heartbeat expires unless it is renewed within exportHeartbeatTTL.
+func (s *Store) TouchExportJob(jobId string) error {
+	heartbeatKey := fmt.Sprintf("export:job:%s:heartbeat", jobId)
+	err := s.client.Set(s.ctx, heartbeatKey, time.Now().Unix(), exportHeartbeatTTL).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save export job heartbeat: %w", err)
+	}
+	return nil
+}
+
+// IsExportJobAlive checks if a bulk export job's heartbeat has not expired
+func (s *Store) IsExportJobAlive(jobId string) (bool, error) {
+	heartbeatKey := fmt.Sprintf("export:job:%s:heartbeat", jobId)
+
+	exists, err := s.client.Exists(s.ctx, heartbeatKey).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check export job heartbeat: %w", err)
+	}
+
+	return exists > 0, nil
+}
+
+// SaveBackfillJob stores the state of the athlete's latest backfill
+func (s *Store) SaveBackfillJob(job BackfillJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to encode backfill: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:backfill", job.AthleteId)
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save backfill: %w", err)
+	}
+	return nil
+}
+
+// FetchBackfillJob loads the athlete's latest backfill, returning redis.Nil
+// if there was none
+func (s *Store) FetchBackfillJob(athleteId int) (*BackfillJob, error) {
+	key := fmt.Sprintf("athlete:%d:backfill", athleteId)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var job BackfillJob
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("failed to decode backfill: %w", err)
+	}
+	return &job, nil
+}
+
+// ClaimBackfill atomically takes the athlete's backfill slot, returning false
+// while another backfill holds it
+func (s *Store) ClaimBackfill(athleteId int) (bool, error) {
+	key := fmt.Sprintf("athlete:%d:backfill-lock", athleteId)
+	claimed, err := s.client.SetNX(s.ctx, key, time.Now().Unix(), backfillLockTTL).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to claim backfill: %w", err)
+	}
+	return claimed, nil
+}
+
+// RenewBackfill extends the athlete's claim on the backfill slot
+func (s *Store) RenewBackfill(athleteId int) error {
+	key := fmt.Sprintf("athlete:%d:backfill-lock", athleteId)
+	err := s.client.Expire(s.ctx, key, backfillLockTTL).Err()
+	if err != nil {
+		return fmt.Errorf("failed to renew backfill: %w", err)
+	}
+	return nil
+}
+
+// ReleaseBackfill frees the athlete's backfill slot
+func (s *Store) ReleaseBackfill(athleteId int) error {
+	key := fmt.Sprintf("athlete:%d:backfill-lock", athleteId)
+	err := s.client.Del(s.ctx, key).Err()
+	if err != nil {
+		return fmt.Errorf("failed to release backfill: %w", err)
+	}
+	return nil
+}
+
+// SavePrivacySettings replaces the athlete's privacy zones
+func (s *Store) SavePrivacySettings(athleteId int, settings PrivacySettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode privacy zones: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:privacy-zones", athleteId)
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save privacy zones: %w", err)
+	}
+	return nil
+}
+
+// FetchPrivacySettings loads the athlete's privacy zones. Athletes without
+// zones get empty settings.
+func (s *Store) FetchPrivacySettings(athleteId int) (PrivacySettings, error) {
+	key := fmt.Sprintf("athlete:%d:privacy-zones", athleteId)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err == redis.Nil {
+		return PrivacySettings{Mode: PrivacyModeRemove, Zones: []PrivacyZone{}}, nil
+	}
+	if err != nil {
+		return PrivacySettings{}, fmt.Errorf("failed to fetch privacy zones: %w", err)
+	}
+
+	var settings PrivacySettings
+	err = json.Unmarshal(data, &settings)
+	if err != nil {
+		return PrivacySettings{}, fmt.Errorf("failed to decode privacy zones: %w", err)
+	}
+	return settings, nil
+}
+
+// SaveActivitySummary stores the computed summary of an activity
+func (s *Store) SaveActivitySummary(summary ActivitySummary) error {
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("failed to encode activity summary: %w", err)
+	}
+
+	key := fmt.Sprintf("activity:%d:summary", summary.ActivityId)
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save activity summary: %w", err)
+	}
+	return nil
+}
+
+// FetchActivitySummary loads the summary of an activity, returning redis.Nil
+// if it has not been computed
+func (s *Store) FetchActivitySummary(activityId string) (*ActivitySummary, error) {
+	key := fmt.Sprintf("activity:%s:summary", activityId)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var summary ActivitySummary
+	err = json.Unmarshal(data, &summary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode activity summary: %w", err)
+	}
+	return &summary, nil
+}
+
+// DeleteActivitySummary removes the summary of a deleted activity
+func (s *Store) DeleteActivitySummary(activityId int) error {
+	key := fmt.Sprintf("activity:%d:summary", activityId)
+	err := s.client.Del(s.ctx, key).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete activity summary: %w", err)
+	}
+	return nil
+}
+
+// SaveSeasonActivity stores an activity's contribution to one of the
+// athlete's seasons
+func (s *Store) SaveSeasonActivity(athleteId int, hemisphere Hemisphere, season string, activity SeasonActivity) error {
+	data, err := json.Marshal(activity)
+	if err != nil {
+		return fmt.Errorf("failed to encode season activity: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:season:%s:%s:activities", athleteId, hemisphere, season)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(activity.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save season activity: %w", err)
+	}
+	return nil
+}
+
+// DeleteSeasonActivity removes an activity from one of the athlete's seasons
+func (s *Store) DeleteSeasonActivity(athleteId int, hemisphere Hemisphere, season string, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:season:%s:%s:activities", athleteId, hemisphere, season)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete season activity: %w", err)
+	}
+	return nil
+}
+
+// FetchSeasonActivities loads the activities of one of the athlete's seasons
+func (s *Store) FetchSeasonActivities(athleteId int, hemisphere Hemisphere, season string) ([]SeasonActivity, error) {
+	key := fmt.Sprintf("athlete:%d:season:%s:%s:activities", athleteId, hemisphere, season)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch season activities: %w", err)
+	}
+
+	activities := make([]SeasonActivity, 0, len(values))
+	for _, value := range values {
+		var activity SeasonActivity
+		if err := json.Unmarshal([]byte(value), &activity); err != nil {
+			return nil, fmt.Errorf("failed to decode season activity: %w", err)
+		}
+		activities = append(activities, activity)
+	}
+	return activities, nil
+}
+
+// SaveSeasonStats stores the aggregates of one of the athlete's seasons
+func (s *Store) SaveSeasonStats(athleteId int, stats SeasonStats) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to encode season stats: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:season:%s:%s", athleteId, stats.Hemisphere, stats.Season)
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save season stats: %w", err)
+	}
+	return nil
+}
+
+// FetchSeasonStats loads the aggregates of one of the athlete's seasons,
+// returning redis.Nil if the season has no activities
+func (s *Store) FetchSeasonStats(athleteId int, hemisphere Hemisphere, season string) (*SeasonStats, error) {
+	key := fmt.Sprintf("athlete:%d:season:%s:%s", athleteId, hemisphere, season)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var stats SeasonStats
+	err = json.Unmarshal(data, &stats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode season stats: %w", err)
+	}
+	return &stats, nil
+}
+
+// DeleteSeasonStats removes the aggregates of a season left without
+// activities
+func (s *Store) DeleteSeasonStats(athleteId int, hemisphere Hemisphere, season string) error {
+	key := fmt.Sprintf("athlete:%d:season:%s:%s", athleteId, hemisphere, season)
+	err := s.client.Del(s.ctx, key).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete season stats: %w", err)
+	}
+	return nil
+}
+
+// SaveActivityEfforts stores the best efforts of one of the athlete's
+// activities
+func (s *Store) SaveActivityEfforts(athleteId int, efforts ActivityEfforts) error {
+	data, err := json.Marshal(efforts)
+	if err != nil {
+		return fmt.Errorf("failed to encode activity efforts: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:efforts", athleteId)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(efforts.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save activity efforts: %w", err)
+	}
+	return nil
+}
+
+// DeleteActivityEfforts removes the best efforts of one of the athlete's
+// activities
+func (s *Store) DeleteActivityEfforts(athleteId int, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:efforts", athleteId)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete activity efforts: %w", err)
+	}
+	return nil
+}
+
+// FetchActivityEfforts loads the best efforts of all of the athlete's
+// activities
+func (s *Store) FetchActivityEfforts(athleteId int) ([]ActivityEfforts, error) {
+	key := fmt.Sprintf("athlete:%d:efforts", athleteId)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch activity efforts: %w", err)
+	}
+
+	activities := make([]ActivityEfforts, 0, len(values))
+	for _, value := range values {
+		var efforts ActivityEfforts
+		if err := json.Unmarshal([]byte(value), &efforts); err != nil {
+			return nil, fmt.Errorf("failed to decode activity efforts: %w", err)
+		}
+		activities = append(activities, efforts)
+	}
+	return activities, nil
+}
+
+// SavePersonalRecords replaces the athlete's personal records
+func (s *Store) SavePersonalRecords(athleteId int, records []PersonalRecord) error {
+	data, err := json.Marshal(records)
+	if err != nil {
+		return fmt.Errorf("failed to encode personal records: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:records", athleteId)
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save personal records: %w", err)
+	}
+	return nil
+}
+
+// FetchPersonalRecords loads the athlete's personal records. Athletes without
+// records get an empty list.
+func (s *Store) FetchPersonalRecords(athleteId int) ([]PersonalRecord, error) {
+	key := fmt.Sprintf("athlete:%d:records", athleteId)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err == redis.Nil {
+		return []PersonalRecord{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch personal records: %w", err)
+	}
+
+	var records []PersonalRecord
+	err = json.Unmarshal(data, &records)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode personal records: %w", err)
+	}
+	return records, nil
+}
+
+// SaveRecordEvents adds new record events to the athlete's most recent events
+func (s *Store) SaveRecordEvents(athleteId int, events []RecordEvent) error {
+	values := make([]any, 0, len(events))
+	for _, event := range events {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Errorf("failed to encode record event: %w", err)
+		}
+		values = append(values, data)
+	}
+
+	key := fmt.Sprintf("athlete:%d:record-events", athleteId)
+	err := s.client.LPush(s.ctx, key, values...).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save record events: %w", err)
+	}
+	err = s.client.LTrim(s.ctx, key, 0, recordEventLimit-1).Err()
+	if err != nil {
+		return fmt.Errorf("failed to trim record events: %w", err)
+	}
+	return nil
+}
+
+// FetchRecordEvents loads the athlete's most recent record events, newest
+// first
+func (s *Store) FetchRecordEvents(athleteId int) ([]RecordEvent, error) {
+	key := fmt.Sprintf("athlete:%d:record-events", athleteId)
+	values, err := s.client.LRange(s.ctx, key, 0, recordEventLimit-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch record events: %w", err)
+	}
+
+	events := make([]RecordEvent, 0, len(values))
+	for _, value := range values {
+		var event RecordEvent
+		if err := json.Unmarshal([]byte(value), &event); err != nil {
+			return nil, fmt.Errorf("failed to decode record event: %w", err)
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// SaveRecordSettings replaces the athlete's record settings
+func (s *Store) SaveRecordSettings(athleteId int, settings RecordSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode record settings: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:record-settings", athleteId)
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save record settings: %w", err)
+	}
+	return nil
+}
+
+// FetchRecordSettings loads the athlete's record settings. Athletes without
+// settings do not have records written to descriptions.
+func (s *Store) FetchRecordSettings(athleteId int) (RecordSettings, error) {
+	key := fmt.Sprintf("athlete:%d:record-settings", athleteId)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err == redis.Nil {
+		return RecordSettings{}, nil
+	}
+	if err != nil {
+		return RecordSettings{}, fmt.Errorf("failed to fetch record settings: %w", err)
+	}
+
+	var settings RecordSettings
+	err = json.Unmarshal(data, &settings)
+	if err != nil {
+		return RecordSettings{}, fmt.Errorf("failed to decode record settings: %w", err)
+	}
+	return settings, nil
+}
+
+// SaveHeatmapTrack stores the heatmap track of one of the athlete's activities
+func (s *Store) SaveHeatmapTrack(athleteId int, track HeatmapTrack) error {
+	data, err := json.Marshal(track)
+	if err != nil {
+		return fmt.Errorf("failed to encode heatmap track: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:heatmap-tracks", athleteId)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(track.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save heatmap track: %w", err)
+	}
+	return nil
+}
+
+// DeleteHeatmapTrack removes the heatmap track of one of the athlete's
+// activities
+func (s *Store) DeleteHeatmapTrack(athleteId int, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:heatmap-tracks", athleteId)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete heatmap track: %w", err)
+	}
+	return nil
+}
+
+// FetchHeatmapTracks loads the heatmap tracks of all of the athlete's
+// activities
+func (s *Store) FetchHeatmapTracks(athleteId int) ([]HeatmapTrack, error) {
+	key := fmt.Sprintf("athlete:%d:heatmap-tracks", athleteId)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch heatmap tracks: %w", err)
+	}
+
+	tracks := make([]HeatmapTrack, 0, len(values))
+	for _, value := range values {
+		var track HeatmapTrack
+		if err := json.Unmarshal([]byte(value), &track); err != nil {
+			return nil, fmt.Errorf("failed to decode heatmap track: %w", err)
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks, nil
+}
+
+// FetchHeatmapVersion loads the version of the athlete's heatmap tiles, 0
+// until the first activity is processed
+func (s *Store) FetchHeatmapVersion(athleteId int) (int64, error) {
+	key := fmt.Sprintf("athlete:%d:heatmap-version", athleteId)
+	version, err := s.client.Get(s.ctx, key).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch heatmap version: %w", err)
+	}
+	return version, nil
+}
+
+// IncrementHeatmapVersion invalidates the athlete's cached heatmap tiles
+func (s *Store) IncrementHeatmapVersion(athleteId int) error {
+	key := fmt.Sprintf("athlete:%d:heatmap-version", athleteId)
+	err := s.client.Incr(s.ctx, key).Err()
+	if err != nil {
+		return fmt.Errorf("failed to increment heatmap version: %w", err)
+	}
+	return nil
+}
+
+// SaveTileToken replaces the athlete's tile token, given by its hash, so the
+// previous one stops working
+func (s *Store) SaveTileToken(athleteId int, tokenHash string) error {
+	athleteKey := fmt.Sprintf("athlete:%d:tile-token", athleteId)
+	if err := s.DeleteTileToken(athleteId); err != nil {
+		return err
+	}
+
+	tokenKey := fmt.Sprintf("tile-token:%s", tokenHash)
+	err := s.client.Set(s.ctx, tokenKey, athleteId, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save tile token: %w", err)
+	}
+	err = s.client.Set(s.ctx, athleteKey, tokenHash, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save athlete tile token: %w", err)
+	}
+	return nil
+}
+
+// FetchTileTokenAthlete loads the athlete a tile token, given by its hash,
+// was issued to, returning redis.Nil for unknown or revoked tokens
+func (s *Store) FetchTileTokenAthlete(tokenHash string) (int, error) {
+	tokenKey := fmt.Sprintf("tile-token:%s", tokenHash)
+	return s.client.Get(s.ctx, tokenKey).Int()
+}
+
+// DeleteTileToken revokes the athlete's tile token, if any
+func (s *Store) DeleteTileToken(athleteId int) error {
+	athleteKey := fmt.Sprintf("athlete:%d:tile-token", athleteId)
+	previous, err := s.client.Get(s.ctx, athleteKey).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch athlete tile token: %w", err)
+	}
+
+	err = s.client.Del(s.ctx, fmt.Sprintf("tile-token:%s", previous), athleteKey).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete tile token: %w", err)
+	}
+	return nil
+}
+
+// SaveTeam replaces a team
+func (s *Store) SaveTeam(team Team) error {
+	data, err := json.Marshal(team)
+	if err != nil {
+		return fmt.Errorf("failed to encode team: %w", err)
+	}
+
+	key := fmt.Sprintf("team:%s", team.Id)
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save team: %w", err)
+	}
+	return nil
+}
+
+// FetchTeam loads a team, returning redis.Nil for unknown teams
+func (s *Store) FetchTeam(teamId string) (*Team, error) {
+	key := fmt.Sprintf("team:%s", teamId)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var team Team
+	err = json.Unmarshal(data, &team)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode team: %w", err)
+	}
+	return &team, nil
+}
+
+// AddAthleteTeam records that the athlete is a member of the team
+func (s *Store) AddAthleteTeam(athleteId int, teamId string) error {
+	key := fmt.Sprintf("athlete:%d:teams", athleteId)
+	err := s.client.SAdd(s.ctx, key, teamId).Err()
+	if err != nil {
+		return fmt.Errorf("failed to add athlete team: %w", err)
+	}
+	return nil
+}
+
+// FetchAthleteTeams loads the ids of the athlete's teams
+func (s *Store) FetchAthleteTeams(athleteId int) ([]string, error) {
+	key := fmt.Sprintf("athlete:%d:teams", athleteId)
+	teamIds, err := s.client.SMembers(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch athlete teams: %w", err)
+	}
+	sort.Strings(teamIds)
+	return teamIds, nil
+}
+
+// SaveAthleteZones replaces the athlete's named zones
+func (s *Store) SaveAthleteZones(athleteId int, settings ZoneSettings) error {
+	return s.saveZones(fmt.Sprintf("athlete:%d:zones", athleteId), settings)
+}
+
+// FetchAthleteZones loads the athlete's named zones
+func (s *Store) FetchAthleteZones(athleteId int) (ZoneSettings, error) {
+	return s.fetchZones(fmt.Sprintf("athlete:%d:zones", athleteId))
+}
+
+// SaveTeamZones replaces the named zones shared by a team
+func (s *Store) SaveTeamZones(teamId string, settings ZoneSettings) error {
+	return s.saveZones(fmt.Sprintf("team:%s:zones", teamId), settings)
+}
+
+// FetchTeamZones loads the named zones shared by a team
+func (s *Store) FetchTeamZones(teamId string) (ZoneSettings, error) {
+	return s.fetchZones(fmt.Sprintf("team:%s:zones", teamId))
+}
+
+func (s *Store) saveZones(key string, settings ZoneSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("failed to encode zones: %w", err)
+	}
+
+	err = s.client.Set(s.ctx, key, data, 0).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save zones: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) fetchZones(key string) (ZoneSettings, error) {
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err == redis.Nil {
+		return ZoneSettings{Zones: []NamedZone{}}, nil
+	}
+	if err != nil {
+		return ZoneSettings{}, fmt.Errorf("failed to fetch zones: %w", err)
+	}
+
+	var settings ZoneSettings
+	err = json.Unmarshal(data, &settings)
+	if err != nil {
+		return ZoneSettings{}, fmt.Errorf("failed to decode zones: %w", err)
+	}
+	return settings, nil
+}
+
+// SaveZoneVisits stores the zone visits of one of the athlete's activities
+func (s *Store) SaveZoneVisits(athleteId int, visits ActivityZoneVisits) error {
+	data, err := json.Marshal(visits)
+	if err != nil {
+		return fmt.Errorf("failed to encode zone visits: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:zone-visits", athleteId)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(visits.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save zone visits: %w", err)
+	}
+	return nil
+}
+
+// DeleteZoneVisits removes the zone visits of one of the athlete's activities
+func (s *Store) DeleteZoneVisits(athleteId int, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:zone-visits", athleteId)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete zone visits: %w", err)
+	}
+	return nil
+}
+
+// FetchZoneVisits loads the zone visits of all of the athlete's activities
+func (s *Store) FetchZoneVisits(athleteId int) ([]ActivityZoneVisits, error) {
+	key := fmt.Sprintf("athlete:%d:zone-visits", athleteId)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch zone visits: %w", err)
+	}
+
+	activities := make([]ActivityZoneVisits, 0, len(values))
+	for _, value := range values {
+		var visits ActivityZoneVisits
+		if err := json.Unmarshal([]byte(value), &visits); err != nil {
+			return nil, fmt.Errorf("failed to decode zone visits: %w", err)
+		}
+		activities = append(activities, visits)
+	}
+	return activities, nil
+}
+
+// SaveActivitySummits stores the summits of one of the athlete's activities
+func (s *Store) SaveActivitySummits(athleteId int, summits ActivitySummits) error {
+	data, err := json.Marshal(summits)
+	if err != nil {
+		return fmt.Errorf("failed to encode summits: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:summits", athleteId)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(summits.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save summits: %w", err)
+	}
+	return nil
+}
+
+// DeleteActivitySummits removes the summits of one of the athlete's
+// activities
+func (s *Store) DeleteActivitySummits(athleteId int, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:summits", athleteId)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete summits: %w", err)
+	}
+	return nil
+}
+
+// FetchActivitySummits loads the summits of all of the athlete's activities
+func (s *Store) FetchActivitySummits(athleteId int) ([]ActivitySummits, error) {
+	key := fmt.Sprintf("athlete:%d:summits", athleteId)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch summits: %w", err)
+	}
+
+	activities := make([]ActivitySummits, 0, len(values))
+	for _, value := range values {
+		var summits ActivitySummits
+		if err := json.Unmarshal([]byte(value), &summits); err != nil {
+			return nil, fmt.Errorf("failed to decode summits: %w", err)
+		}
+		activities = append(activities, summits)
+	}
+	return activities, nil
+}
+
+// SaveActivityAscents stores the matched ascents of one of the athlete's
+// activities
+func (s *Store) SaveActivityAscents(athleteId int, ascents ActivityAscents) error {
+	data, err := json.Marshal(ascents)
+	if err != nil {
+		return fmt.Errorf("failed to encode ascents: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:ascents", athleteId)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(ascents.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save ascents: %w", err)
+	}
+	return nil
+}
+
+// DeleteActivityAscents removes the matched ascents of one of the athlete's
+// activities
+func (s *Store) DeleteActivityAscents(athleteId int, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:ascents", athleteId)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete ascents: %w", err)
+	}
+	return nil
+}
+
+// FetchActivityAscents loads the matched ascents of all of the athlete's
+// activities
+func (s *Store) FetchActivityAscents(athleteId int) ([]ActivityAscents, error) {
+	key := fmt.Sprintf("athlete:%d:ascents", athleteId)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ascents: %w", err)
+	}
+
+	activities := make([]ActivityAscents, 0, len(values))
+	for _, value := range values {
+		var ascents ActivityAscents
+		if err := json.Unmarshal([]byte(value), &ascents); err != nil {
+			return nil, fmt.Errorf("failed to decode ascents: %w", err)
+		}
+		activities = append(activities, ascents)
+	}
+	return activities, nil
+}
+
+// SaveActivityPace stores the ascent and descent pace of one of the athlete's
+// activities
+func (s *Store) SaveActivityPace(athleteId int, pace ActivityPace) error {
+	data, err := json.Marshal(pace)
+	if err != nil {
+		return fmt.Errorf("failed to encode activity pace: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:paces", athleteId)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(pace.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save activity pace: %w", err)
+	}
+	return nil
+}
+
+// DeleteActivityPace removes the pace of one of the athlete's activities
+func (s *Store) DeleteActivityPace(athleteId int, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:paces", athleteId)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete activity pace: %w", err)
+	}
+	return nil
+}
+
+// FetchActivityPaces loads the pace of all of the athlete's activities
+func (s *Store) FetchActivityPaces(athleteId int) ([]ActivityPace, error) {
+	key := fmt.Sprintf("athlete:%d:paces", athleteId)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch activity paces: %w", err)
+	}
+
+	paces := make([]ActivityPace, 0, len(values))
+	for _, value := range values {
+		var pace ActivityPace
+		if err := json.Unmarshal([]byte(value), &pace); err != nil {
+			return nil, fmt.Errorf("failed to decode activity pace: %w", err)
+		}
+		paces = append(paces, pace)
+	}
+	return paces, nil
+}
+
+// SaveHeartRateZones caches the athlete's heart rate zones for ttl
+func (s *Store) SaveHeartRateZones(athleteId int, zones []StravaZoneRange, ttl time.Duration) error {
+	data, err := json.Marshal(zones)
+	if err != nil {
+		return fmt.Errorf("failed to encode heart rate zones: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:hr-zones", athleteId)
+	err = s.client.Set(s.ctx, key, data, ttl).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save heart rate zones: %w", err)
+	}
+	return nil
+}
+
+// FetchHeartRateZones loads the athlete's cached heart rate zones, returning
+// redis.Nil when they are not cached
+func (s *Store) FetchHeartRateZones(athleteId int) ([]StravaZoneRange, error) {
+	key := fmt.Sprintf("athlete:%d:hr-zones", athleteId)
+	data, err := s.client.Get(s.ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	var zones []StravaZoneRange
+	err = json.Unmarshal(data, &zones)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode heart rate zones: %w", err)
+	}
+	return zones, nil
+}
+
+// SaveActivityLoad stores the training load of one of the athlete's
+// activities
+func (s *Store) SaveActivityLoad(athleteId int, load ActivityLoad) error {
+	data, err := json.Marshal(load)
+	if err != nil {
+		return fmt.Errorf("failed to encode training load: %w", err)
+	}
+
+	key := fmt.Sprintf("athlete:%d:loads", athleteId)
+	err = s.client.HSet(s.ctx, key, strconv.Itoa(load.ActivityId), data).Err()
+	if err != nil {
+		return fmt.Errorf("failed to save training load: %w", err)
+	}
+	return nil
+}
+
+// DeleteActivityLoad removes the training load of one of the athlete's
+// activities
+func (s *Store) DeleteActivityLoad(athleteId int, activityId int) error {
+	key := fmt.Sprintf("athlete:%d:loads", athleteId)
+	err := s.client.HDel(s.ctx, key, strconv.Itoa(activityId)).Err()
+	if err != nil {
+		return fmt.Errorf("failed to delete training load: %w", err)
+	}
+	return nil
+}
+
+// FetchActivityLoads loads the training load of all of the athlete's
+// activities
+func (s *Store) FetchActivityLoads(athleteId int) ([]ActivityLoad, error) {
+	key := fmt.Sprintf("athlete:%d:loads", athleteId)
+	values, err := s.client.HGetAll(s.ctx, key).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch training loads: %w", err)
+	}
+
+	loads := make([]ActivityLoad, 0, len(values))
+	for _, value := range values {
+		var load ActivityLoad
+		if err := json.Unmarshal([]byte(value), &load); err != nil {
+			return nil, fmt.Errorf("failed to decode training load: %w", err)
+		}
+		loads = append(loads, load)
+	}
+	return loads, nil
+}
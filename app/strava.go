@@ -5,7 +5,7 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/json"
-	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -14,22 +14,20 @@ import (
 	"os"
 	"path"
 	"strings"
-	"time"
-
-	"github.com/tkrajina/gpxgo/gpx"
 )
 
 var (
-	ActivityUrl = "https://www.strava.com/api/v3/activities/%s"
-	StreamsUrl  = "https://www.strava.com/api/v3/activities/%s/streams?keys=latlng,altitude,time"
-)
-
-const (
-	xsiSchemaLoc = "http://www.topografix.com/GPX/1/1 http://www.topografix.com/GPX/1/1/gpx.xsd http://www.garmin.com/xmlschemas/GpxExtensions/v3 http://www.garmin.com/xmlschemas/GpxExtensionsv3.xsd http://www.garmin.com/xmlschemas/TrackPointExtension/v1 http://www.garmin.com/xmlschemas/TrackPointExtensionv1.xsd"
+	ActivityUrl          = "https://www.strava.com/api/v3/activities/%s"
+	AthleteActivitiesUrl = "https://www.strava.com/api/v3/athlete/activities?page=%d&per_page=%d"
+	AthleteZonesUrl      = "https://www.strava.com/api/v3/athlete/zones"
+	StreamsUrl           = "https://www.strava.com/api/v3/activities/%s/streams?keys=latlng,altitude,time,distance,heartrate,temp,cadence,watts,moving"
 )
 
 var (
 	DebugSerializeHTTPResponse = false
+
+	// ErrStravaNotFound is wrapped by request errors when strava responds 404
+	ErrStravaNotFound = errors.New("strava resource not found")
 )
 
 type StravaActivity struct {
@@ -37,18 +35,52 @@ type StravaActivity struct {
 	Athlete struct {
 		Id int `json:"id"`
 	} `json:"athlete"`
-	Name           string     `json:"name"`
-	Distance       float64    `json:"distance"`
-	MovingTime     int        `json:"moving_time"`
-	ElapsedTime    int        `json:"elapsed_time"`
-	ElevationGain  float32    `json:"total_elevation_gain"`
-	Type           string     `json:"type"`
-	StartDate      string     `json:"start_date"`
-	StartLatLon    [2]float64 `json:"start_latlng"`
-	EndLatLon      [2]float64 `json:"end_latlng"`
-	Description    string     `json:"description"`
-	Calories       float64    `json:"calories"`
-	RelativeEffort float64    `json:"suffer_score"`
+	Name           string      `json:"name"`
+	Distance       float64     `json:"distance"`
+	MovingTime     int         `json:"moving_time"`
+	ElapsedTime    int         `json:"elapsed_time"`
+	ElevationGain  float32     `json:"total_elevation_gain"`
+	Type           string      `json:"type"`
+	StartDate      string      `json:"start_date"`
+	StartDateLocal string      `json:"start_date_local"`
+	StartLatLon    [2]float64  `json:"start_latlng"`
+	EndLatLon      [2]float64  `json:"end_latlng"`
+	Description    string      `json:"description"`
+	Calories       float64     `json:"calories"`
+	RelativeEffort float64     `json:"suffer_score"`
+	HideFromHome   bool        `json:"hide_from_home"`
+	Laps           []StravaLap `json:"laps"`
+}
+
+type StravaLap struct {
+	Id               int     `json:"id"`
+	Name             string  `json:"name"`
+	LapIndex         int     `json:"lap_index"`
+	StartIndex       int     `json:"start_index"`
+	EndIndex         int     `json:"end_index"`
+	StartDate        string  `json:"start_date"`
+	ElapsedTime      int     `json:"elapsed_time"`
+	MovingTime       int     `json:"moving_time"`
+	Distance         float64 `json:"distance"`
+	ElevationGain    float64 `json:"total_elevation_gain"`
+	MaxSpeed         float64 `json:"max_speed"`
+	AverageHeartRate float64 `json:"average_heartrate"`
+	MaxHeartRate     float64 `json:"max_heartrate"`
+	AverageCadence   float64 `json:"average_cadence"`
+}
+
+// StravaZoneRange is a zone from Min up to Max. The last zone has a Max of
+// -1 and is open ended.
+type StravaZoneRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+type StravaAthleteZones struct {
+	HeartRate struct {
+		CustomZones bool              `json:"custom_zones"`
+		Zones       []StravaZoneRange `json:"zones"`
+	} `json:"heart_rate"`
 }
 
 type StravaStreamPoint struct {
@@ -59,14 +91,9 @@ type StravaStreamPoint struct {
 	Distance    float64
 	HeartRate   float64
 	Temperature float64
-}
-
-type GpxMetadata struct {
-	Name           string
-	Type           string
-	Time           time.Time
-	UseHeartRate   bool
-	UseTemperature bool
+	Cadence     float64
+	Power       float64
+	Moving      bool
 }
 
 type RawStream struct {
@@ -100,18 +127,72 @@ func (c *StravaClient) GetActivity(activityId string) (StravaActivity, error) {
 	}
 
 	var activity StravaActivity
-	json.NewDecoder(body).Decode(&activity)
+	err = json.NewDecoder(body).Decode(&activity)
+	if err != nil {
+		return StravaActivity{}, fmt.Errorf("error decoding activity: %w", err)
+	}
 	return activity, nil
 }
 
-func (c *StravaClient) DownloadActivity(activityId string, path string, metadata GpxMetadata) error {
-	streamPoints, err := c.getActivityStream(activityId)
+// StravaActivityUpdate holds the activity fields to change, nil fields are
+// left unchanged
+type StravaActivityUpdate struct {
+	Description *string `json:"description,omitempty"`
+}
+
+// UpdateActivity changes an activity, which needs the activity:write scope
+func (c *StravaClient) UpdateActivity(activityId string, update StravaActivityUpdate) error {
+	data, err := json.Marshal(update)
 	if err != nil {
-		return err
+		return fmt.Errorf("error encoding activity update: %w", err)
+	}
+
+	url := fmt.Sprintf(ActivityUrl, activityId)
+	headers := map[string]string{
+		"Content-Type": "application/json",
+	}
+	_, err = c.performRequestWithHeaders("PUT", url, bytes.NewReader(data), headers)
+	if err != nil {
+		return fmt.Errorf("error updating activity: %w", err)
+	}
+	return nil
+}
+
+// ListActivities returns one page of the authenticated athlete's activities,
+// most recent first. Pages are numbered from 1.
+func (c *StravaClient) ListActivities(page int, perPage int) ([]StravaActivity, error) {
+	url := fmt.Sprintf(AthleteActivitiesUrl, page, perPage)
+	body, err := c.performRequest("GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing activities: %w", err)
 	}
 
-	gpxDoc, err := buildGpx(streamPoints, metadata)
-	bytes, err := gpxDoc.ToXml(gpx.ToXmlParams{})
+	var activities []StravaActivity
+	err = json.NewDecoder(body).Decode(&activities)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding activities: %w", err)
+	}
+	return activities, nil
+}
+
+// GetAthleteZones returns the authenticated athlete's heart rate zones, which
+// needs the profile:read_all scope
+func (c *StravaClient) GetAthleteZones() (StravaAthleteZones, error) {
+	body, err := c.performRequest("GET", AthleteZonesUrl, nil)
+	if err != nil {
+		return StravaAthleteZones{}, fmt.Errorf("error fetching athlete zones: %w", err)
+	}
+
+	var zones StravaAthleteZones
+	err = json.NewDecoder(body).Decode(&zones)
+	if err != nil {
+		return StravaAthleteZones{}, fmt.Errorf("error decoding athlete zones: %w", err)
+	}
+	return zones, nil
+}
+
+func (c *StravaClient) DownloadActivity(activity StravaActivity, format ExportFormat, path string, metadata GpxMetadata) error {
+	bytes, err := c.ExportActivity(activity, format, metadata)
 	if err != nil {
 		return err
 	}
@@ -133,6 +214,10 @@ func (c *StravaClient) getActivityStream(activityId string) ([]StravaStreamPoint
 		return nil, fmt.Errorf("error decoding streams: %w", err)
 	}
 
+	if len(activityStreams) == 0 {
+		return nil, fmt.Errorf("error decoding streams: no streams returned for activity %s", activityId)
+	}
+
 	originalSize := activityStreams[0].OriginalSize
 	streamPoints := make([]StravaStreamPoint, originalSize)
 	for _, rawStream := range activityStreams {
@@ -156,6 +241,8 @@ func (c *StravaClient) getActivityStream(activityId string) ([]StravaStreamPoint
 				latLngData := item.([]any)
 				streamPoints[i].Latitude = latLngData[0].(float64)
 				streamPoints[i].Longitude = latLngData[1].(float64)
+			} else if rawStream.Type == "moving" {
+				streamPoints[i].Moving = item.(bool)
 			} else {
 				switch rawStream.Type {
 				case "distance":
@@ -168,6 +255,13 @@ func (c *StravaClient) getActivityStream(activityId string) ([]StravaStreamPoint
 					streamPoints[i].HeartRate = item.(float64)
 				case "temp":
 					streamPoints[i].Temperature = item.(float64)
+				case "cadence":
+					streamPoints[i].Cadence = item.(float64)
+				case "watts":
+					// watts contains nulls where the power meter dropped out
+					if item != nil {
+						streamPoints[i].Power = item.(float64)
+					}
 				default:
 					return nil, fmt.Errorf("unrecognized stream type: %s", rawStream.Type)
 				}
@@ -177,35 +271,6 @@ func (c *StravaClient) getActivityStream(activityId string) ([]StravaStreamPoint
 	return streamPoints, nil
 }
 
-func buildGpx(StreamPoints []StravaStreamPoint, metadata GpxMetadata) (gpx.GPX, error) {
-	xmlNsAttrs := []xml.Attr{{Name: xml.Name{Space: "xmlns", Local: "gpxtpx"}, Value: "http://www.garmin.com/xmlschemas/TrackPointExtension/v1"}}
-
-	trackSegment := gpx.GPXTrackSegment{}
-	for _, streamPoint := range StreamPoints {
-		point := gpx.Point{Latitude: streamPoint.Latitude, Longitude: streamPoint.Longitude, Elevation: *gpx.NewNullableFloat64(streamPoint.Altitude)}
-		extension := gpx.Extension{}
-		if metadata.UseHeartRate {
-			name := xml.Name{Space: "gpxtpx", Local: "hr"}
-			node := gpx.ExtensionNode{XMLName: name, Data: fmt.Sprintf("%f", streamPoint.HeartRate)}
-			extension.Nodes = append(extension.Nodes, node)
-		}
-
-		if metadata.UseTemperature {
-			name := xml.Name{Space: "gpxtpx", Local: "atemp"}
-			node := gpx.ExtensionNode{XMLName: name, Data: fmt.Sprintf("%f", streamPoint.HeartRate)}
-			extension.Nodes = append(extension.Nodes, node)
-		}
-
-		timestamp := time.Unix(int64(streamPoint.Time), int64(streamPoint.Time*1_000_000_000))
-		gpxPoint := gpx.GPXPoint{Point: point, Timestamp: timestamp, Extensions: extension}
-		trackSegment.AppendPoint(&gpxPoint)
-	}
-
-	gpxTrack := gpx.GPXTrack{Name: metadata.Name, Type: metadata.Type, Segments: []gpx.GPXTrackSegment{trackSegment}}
-	gpx := gpx.GPX{XmlSchemaLoc: xsiSchemaLoc, Attrs: gpx.NewGPXAttributes(xmlNsAttrs), Version: "1.1", Creator: "skintrackr.fly.dev", Time: &metadata.Time, Tracks: []gpx.GPXTrack{gpxTrack}}
-	return gpx, nil
-}
-
 func (c *StravaClient) performRequest(method string, url string, body io.Reader) (io.Reader, error) {
 	return c.performRequestWithHeaders(method, url, body, nil)
 }
@@ -244,6 +309,7 @@ func (c *StravaClient) performRequestWithHeaders(method string, url string, body
 		slog.Error("unknown http exception", "method", method, "url", url, "err", err)
 		return nil, fmt.Errorf("http request failed: unknown error: %w", err)
 	}
+	stravaRateLimits.Update(response.Header)
 
 	// saves response body to file for debugging when flag is set
 	var bodyReader io.Reader = response.Body
@@ -269,6 +335,11 @@ func (c *StravaClient) performRequestWithHeaders(method string, url string, body
 		bodyReader = bytes.NewReader(body)
 	}
 
+	if response.StatusCode == http.StatusNotFound {
+		slog.Error("http response received with bad status_code", "method", method, "url", url, "status_code", response.StatusCode)
+		return nil, fmt.Errorf("http request failed, invalid status %d: %w", response.StatusCode, ErrStravaNotFound)
+	}
+
 	if response.StatusCode < 200 || response.StatusCode >= 300 {
 		slog.Error("http response received with bad status_code", "method", method, "url", url, "status_code", response.StatusCode)
 		return nil, fmt.Errorf("http request failed, invalid status %d", response.StatusCode)
@@ -1,6 +1,7 @@
 package app
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -292,6 +293,24 @@ func TestStravaClient_GetActivity(t *testing.T) {
 	}
 }
 
+func TestStravaClient_GetActivityNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"message": "Record Not Found"}`))
+	}))
+	defer server.Close()
+
+	originalActivityUrl := ActivityUrl
+	defer func() { ActivityUrl = originalActivityUrl }()
+	ActivityUrl = server.URL + "/activities/%s"
+
+	client := NewStravaClient("test-token")
+	_, err := client.GetActivity("404")
+	if !errors.Is(err, ErrStravaNotFound) {
+		t.Errorf("expected ErrStravaNotFound, got %v", err)
+	}
+}
+
 func TestNewStravaClient(t *testing.T) {
 	token := "test-token-123"
 	client := NewStravaClient(token)
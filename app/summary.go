@@ -0,0 +1,325 @@
+package app
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// maxGradeDistance is the horizontal distance grades are measured over,
+	// long enough that altitude noise does not produce absurd grades
+	maxGradeDistance = 50.0
+
+	// maxSpeedInterval is the shortest time max speed is measured over
+	maxSpeedInterval = 5.0
+)
+
+// LapStats are the statistics of a single ascent, descent or transition
+type LapStats struct {
+	Kind         LapKind   `json:"kind"`
+	StartIndex   int       `json:"start_index"`
+	EndIndex     int       `json:"end_index"`
+	StartTime    time.Time `json:"start_time"`
+	Duration     float64   `json:"duration_s"`
+	Distance     float64   `json:"distance_m"`
+	VerticalGain float64   `json:"vertical_gain_m"`
+	VerticalLoss float64   `json:"vertical_loss_m"`
+	// AscentRate is the vertical gain per hour of ascents
+	AscentRate float64 `json:"ascent_rate_m_h,omitempty"`
+	// AverageSpeed and MaxSpeed are horizontal speeds
+	AverageSpeed float64 `json:"avg_speed_m_s"`
+	MaxSpeed     float64 `json:"max_speed_m_s"`
+	// MaxGrade is the steepest grade in the direction of travel, uphill for
+	// ascents and downhill for descents
+	MaxGrade         float64 `json:"max_grade_pct"`
+	AverageHeartRate float64 `json:"avg_heartrate,omitempty"`
+	MaxHeartRate     float64 `json:"max_heartrate,omitempty"`
+	// HeartRateZones is the time in each of the athlete's heart rate zones
+	HeartRateZones []float64 `json:"hr_zone_times_s,omitempty"`
+	// Zones are the names of the athlete's named zones the lap enters
+	Zones []string `json:"zones,omitempty"`
+	// RouteId is shared by ascents of the same route
+	RouteId string `json:"route_id,omitempty"`
+	// AscentType tells lift rides, skinning and bootpacks apart
+	AscentType AscentType `json:"ascent_type,omitempty"`
+}
+
+// ActivitySummary holds the lap statistics and totals of an activity
+type ActivitySummary struct {
+	ActivityId  int        `json:"activity_id"`
+	AthleteId   int        `json:"athlete_id"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	StartDate   time.Time  `json:"start_date"`
+	ElapsedTime float64    `json:"elapsed_time_s"`
+	Distance    float64    `json:"distance_m"`
+	Laps        []LapStats `json:"laps"`
+	// LapCount is the number of ascents, each a lap of skinning up and
+	// usually skiing back down
+	LapCount         int     `json:"lap_count"`
+	SkinningVertical float64 `json:"skinning_vertical_m"`
+	SkiingVertical   float64 `json:"skiing_vertical_m"`
+	TransitionTime   float64 `json:"transition_time_s"`
+	// LiftVertical is the vertical gained on lift rides, which LapCount and
+	// SkinningVertical leave out when LiftsExcluded is set
+	LiftVertical  float64 `json:"lift_vertical_m,omitempty"`
+	LiftsExcluded bool    `json:"lifts_excluded,omitempty"`
+	// Season is the label of the season the activity counts towards, empty
+	// for activities that are not ski tours. Labels of the two hemispheres
+	// can coincide, so seasons are told apart by SeasonHemisphere as well.
+	Season           string     `json:"season,omitempty"`
+	SeasonHemisphere Hemisphere `json:"season_hemisphere,omitempty"`
+	// Zones are the names of the named zones any lap enters, in the order
+	// they are first entered
+	Zones []string `json:"zones,omitempty"`
+	// Summits are the peaks reached, when a peaks file is configured
+	Summits []Summit `json:"summits,omitempty"`
+	// HeartRateZones is the time in each of the athlete's heart rate zones
+	// and TrainingLoad the Edwards TRIMP computed from it
+	HeartRateZones []float64 `json:"hr_zone_times_s,omitempty"`
+	TrainingLoad   float64   `json:"training_load,omitempty"`
+	// Daylight holds the sun times at the start of the activity
+	Daylight   *Daylight `json:"daylight,omitempty"`
+	ComputedAt time.Time `json:"computed_at"`
+}
+
+// http request handlers
+
+// handleActivitySummary returns the lap statistics of one of the
+// authenticated athlete's activities. Summaries are stored when strava
+// reports the activity and computed from the streams otherwise, or with
+// refresh=true. Computed summaries are not stored and nothing else is
+// updated, see handleActivitySummaryRefresh.
+func (s *ServerState) handleActivitySummary(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if c.QueryParam("refresh") != "true" {
+		summary, err := s.store.FetchActivitySummary(c.Param("id"))
+		if err != nil && err != redis.Nil {
+			slog.Error("failed to fetch activity summary", "athlete_id", tokenInfo.athleteId, "activity_id", c.Param("id"), "err", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch activity summary")
+		}
+		if err == nil {
+			if summary.AthleteId != tokenInfo.athleteId {
+				return echo.NewHTTPError(http.StatusNotFound, "Activity not found")
+			}
+			return c.JSON(http.StatusOK, summary)
+		}
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	summary, _, err := s.summarizeActivity(client, activity)
+	if err != nil {
+		slog.Error("failed to summarize activity", "athlete_id", tokenInfo.athleteId, "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to summarize activity")
+	}
+	return c.JSON(http.StatusOK, summary)
+}
+
+// handleActivitySummaryRefresh runs one of the authenticated athlete's
+// activities through the processing pipeline again, updating its stored
+// summary and everything built from it
+func (s *ServerState) handleActivitySummaryRefresh(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	summary, _, err := s.processActivity(client, activity)
+	if err != nil {
+		slog.Error("failed to process activity", "athlete_id", tokenInfo.athleteId, "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to summarize activity")
+	}
+	return c.JSON(http.StatusOK, summary)
+}
+
+// public functions
+
+// SummarizeActivity segments an activity into laps and computes the
+// statistics of each lap and the activity totals
+func SummarizeActivity(activity StravaActivity, streamPoints []StravaStreamPoint) ActivitySummary {
+	startDate, err := time.Parse(time.RFC3339, activity.StartDate)
+	if err != nil {
+		slog.Warn("failed to parse activity start date", "activity_id", activity.Id, "start_date", activity.StartDate)
+	}
+
+	summary := ActivitySummary{
+		ActivityId: activity.Id,
+		AthleteId:  activity.Athlete.Id,
+		Name:       activity.Name,
+		Type:       activity.Type,
+		StartDate:  startDate,
+		Laps:       []LapStats{},
+		ComputedAt: time.Now().UTC(),
+	}
+	if len(streamPoints) == 0 {
+		return summary
+	}
+
+	summary.ElapsedTime = streamPoints[len(streamPoints)-1].Time - streamPoints[0].Time
+	distances := cumulativeDistances(streamPoints)
+	summary.Distance = distances[len(distances)-1] - distances[0]
+	summary.Daylight = activityDaylight(activity, streamPoints, startDate)
+
+	for _, lap := range SegmentLaps(streamPoints, SegmentOptions{}) {
+		stats := computeLapStats(streamPoints, lap, startDate)
+		summary.Laps = append(summary.Laps, stats)
+
+		switch lap.Kind {
+		case LapAscent:
+			summary.LapCount++
+			summary.SkinningVertical += stats.VerticalGain
+			if stats.AscentType == AscentLift {
+				summary.LiftVertical += stats.VerticalGain
+			}
+		case LapDescent:
+			summary.SkiingVertical += stats.VerticalLoss
+		case LapTransition:
+			summary.TransitionTime += stats.Duration
+		}
+	}
+
+	summary.Distance = roundTo(summary.Distance, 1)
+	summary.SkinningVertical = roundTo(summary.SkinningVertical, 1)
+	summary.SkiingVertical = roundTo(summary.SkiingVertical, 1)
+	summary.LiftVertical = roundTo(summary.LiftVertical, 1)
+	return summary
+}
+
+// helpers
+
+// summarizeActivity fetches the streams of an activity, corrects their
+// elevation when an elevation model is configured and summarizes them
+func (s *ServerState) summarizeActivity(client StravaClient, activity StravaActivity) (ActivitySummary, []StravaStreamPoint, error) {
+	streamPoints, err := client.getActivityStream(strconv.Itoa(activity.Id))
+	if err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	streamPoints = correctElevation(streamPoints, ElevationCorrection{Model: s.elevation, Mode: ElevationModeReplace})
+
+	summary := SummarizeActivity(activity, streamPoints)
+	if s.config.ExcludeLiftVertical {
+		excludeLiftAscents(&summary)
+	}
+	return summary, streamPoints, nil
+}
+
+func computeLapStats(streamPoints []StravaStreamPoint, lap Lap, startDate time.Time) LapStats {
+	stats := LapStats{
+		Kind:       lap.Kind,
+		StartIndex: lap.StartIndex,
+		EndIndex:   lap.EndIndex,
+		StartTime:  startDate.Add(time.Duration(lap.StartTime * float64(time.Second))),
+		Duration:   lap.EndTime - lap.StartTime,
+	}
+
+	points := lapPoints(streamPoints, lap)
+	if lap.EndIndex <= lap.StartIndex || len(points) < 2 {
+		return stats
+	}
+
+	distances := cumulativeDistances(points)
+	stats.Distance = distances[len(distances)-1] - distances[0]
+	stats.VerticalGain, stats.VerticalLoss = elevationChange(points)
+	if stats.Duration > 0 {
+		stats.AverageSpeed = stats.Distance / stats.Duration
+		if lap.Kind == LapAscent {
+			stats.AscentRate = stats.VerticalGain / (stats.Duration / 3600)
+		}
+	}
+	stats.MaxSpeed = maxSpeed(points, distances)
+
+	direction := 0.0
+	switch lap.Kind {
+	case LapAscent:
+		direction = 1
+	case LapDescent:
+		direction = -1
+	}
+	stats.MaxGrade = maxGrade(points, distances, direction)
+	if lap.Kind == LapAscent {
+		stats.AscentType = classifyAscent(points, stats)
+	}
+
+	// the last point belongs to the next lap
+	var heartRateSum float64
+	var heartRateCount int
+	for _, point := range points[:len(points)-1] {
+		if point.HeartRate > 0 {
+			heartRateSum += point.HeartRate
+			heartRateCount++
+			stats.MaxHeartRate = math.Max(stats.MaxHeartRate, point.HeartRate)
+		}
+	}
+	if heartRateCount > 0 {
+		stats.AverageHeartRate = heartRateSum / float64(heartRateCount)
+	}
+
+	stats.Duration = roundTo(stats.Duration, 1)
+	stats.Distance = roundTo(stats.Distance, 1)
+	stats.VerticalGain = roundTo(stats.VerticalGain, 1)
+	stats.VerticalLoss = roundTo(stats.VerticalLoss, 1)
+	stats.AscentRate = roundTo(stats.AscentRate, 1)
+	stats.AverageSpeed = roundTo(stats.AverageSpeed, 2)
+	stats.MaxSpeed = roundTo(stats.MaxSpeed, 2)
+	stats.MaxGrade = roundTo(stats.MaxGrade, 1)
+	stats.AverageHeartRate = roundTo(stats.AverageHeartRate, 1)
+	return stats
+}
+
+// maxSpeed returns the fastest horizontal speed over at least
+// maxSpeedInterval seconds
+func maxSpeed(points []StravaStreamPoint, distances []float64) float64 {
+	fastest := 0.0
+	j := 0
+	for i := range points {
+		for j < len(points) && points[j].Time-points[i].Time < maxSpeedInterval {
+			j++
+		}
+		if j == len(points) {
+			break
+		}
+		fastest = math.Max(fastest, (distances[j]-distances[i])/(points[j].Time-points[i].Time))
+	}
+	return fastest
+}
+
+// maxGrade returns the steepest grade in percent over maxGradeDistance in the
+// given direction, 1 for uphill and -1 for downhill
+func maxGrade(points []StravaStreamPoint, distances []float64, direction float64) float64 {
+	if direction == 0 {
+		return 0
+	}
+
+	steepest := 0.0
+	j := 0
+	for i := range points {
+		for j < len(points) && distances[j]-distances[i] < maxGradeDistance {
+			j++
+		}
+		if j == len(points) {
+			break
+		}
+		grade := direction * (points[j].Altitude - points[i].Altitude) / (distances[j] - distances[i]) * 100
+		steepest = math.Max(steepest, grade)
+	}
+	return steepest
+}
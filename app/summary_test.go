@@ -0,0 +1,65 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSummarizeActivity(t *testing.T) {
+	activity := StravaActivity{Id: 42, Name: "Dawn patrol", Type: "BackcountrySki", StartDate: "2025-02-14T07:30:00Z"}
+	activity.Athlete.Id = 7
+
+	summary := SummarizeActivity(activity, skiTourPoints())
+
+	if summary.ActivityId != 42 || summary.AthleteId != 7 {
+		t.Errorf("expected activity 42 of athlete 7, got %d of %d", summary.ActivityId, summary.AthleteId)
+	}
+	if len(summary.Laps) != 7 {
+		t.Fatalf("expected 7 laps, got %d", len(summary.Laps))
+	}
+	if summary.LapCount != 2 {
+		t.Errorf("expected 2 laps, got %d", summary.LapCount)
+	}
+	// the first sample is already above the start and smoothing may put the
+	// turnaround a sample off the top
+	if math.Abs(summary.SkinningVertical-500) > 4 || math.Abs(summary.SkiingVertical-500) > 4 {
+		t.Errorf("expected about 500 m skinning and skiing, got %v and %v", summary.SkinningVertical, summary.SkiingVertical)
+	}
+	if summary.TransitionTime != 460 {
+		t.Errorf("expected 460 s in transitions, got %v", summary.TransitionTime)
+	}
+	if summary.ElapsedTime != 4370 {
+		t.Errorf("expected 4370 s elapsed, got %v", summary.ElapsedTime)
+	}
+
+	climb, transition, descent := summary.Laps[0], summary.Laps[1], summary.Laps[2]
+	if !climb.StartTime.Equal(time.Date(2025, 2, 14, 7, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected lap start time %v", climb.StartTime)
+	}
+	if math.Abs(climb.VerticalGain-300) > 2 || math.Abs(climb.AscentRate-600) > 4 {
+		t.Errorf("expected 300 m at 600 m/h, got %v at %v", climb.VerticalGain, climb.AscentRate)
+	}
+	if math.Abs(climb.MaxGrade-20.8) > 0.2 {
+		t.Errorf("expected max grade of about 20.8%%, got %v", climb.MaxGrade)
+	}
+	if climb.AverageHeartRate != 140 || climb.MaxHeartRate != 140 {
+		t.Errorf("expected heart rate of 140, got %v avg %v max", climb.AverageHeartRate, climb.MaxHeartRate)
+	}
+	if transition.Kind != LapTransition || transition.Duration != 290 || transition.MaxGrade != 0 {
+		t.Errorf("expected 290 s transition, got %+v", transition)
+	}
+	if descent.VerticalLoss != 300 || descent.AscentRate != 0 {
+		t.Errorf("expected 300 m descent, got %+v", descent)
+	}
+	if math.Abs(descent.MaxSpeed-10) > 0.1 || math.Abs(descent.MaxGrade-10) > 0.2 {
+		t.Errorf("expected 10 m/s at 10%% grade, got %v m/s at %v%%", descent.MaxSpeed, descent.MaxGrade)
+	}
+}
+
+func TestSummarizeActivity_NoStreams(t *testing.T) {
+	summary := SummarizeActivity(StravaActivity{Id: 1, StartDate: "2025-02-14T07:30:00Z"}, nil)
+	if summary.Laps == nil || len(summary.Laps) != 0 || summary.LapCount != 0 {
+		t.Errorf("expected an empty summary, got %+v", summary)
+	}
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"fmt"
+	"math"
+	"time"
+	_ "time/tzdata"
+)
+
+const (
+	// sunriseZenith is the zenith angle of the sun's center at sunrise and
+	// sunset, below the horizon by refraction and the sun's radius
+	sunriseZenith = 90.833
+	// civilTwilightZenith is the zenith angle at the start of civil dawn and
+	// the end of civil dusk
+	civilTwilightZenith = 96.0
+)
+
+// Daylight holds the sun times at a location on a date in the local time of
+// the activity or route, and how the tour fits into them. Times the sun does
+// not reach, such as sunrise during the polar night, are left out.
+type Daylight struct {
+	Date      string     `json:"date"`
+	CivilDawn *time.Time `json:"civil_dawn,omitempty"`
+	Sunrise   *time.Time `json:"sunrise,omitempty"`
+	Sunset    *time.Time `json:"sunset,omitempty"`
+	CivilDusk *time.Time `json:"civil_dusk,omitempty"`
+	// Polar is "day" or "night" when the sun does not rise or set
+	Polar string `json:"polar,omitempty"`
+	// StartAfterSunrise is the time from sunrise to the start, negative for
+	// starts before sunrise
+	StartAfterSunrise *float64 `json:"start_after_sunrise_s,omitempty"`
+	// DaylightRemaining is the time from the finish to sunset, negative for
+	// finishes after sunset
+	DaylightRemaining *float64 `json:"daylight_remaining_s,omitempty"`
+}
+
+// public functions
+
+// ComputeDaylight computes the sun times at a location on the calendar date
+// of start in its location, and the start and finish relative to them
+func ComputeDaylight(latitude float64, longitude float64, start time.Time, finish time.Time) Daylight {
+	year, month, day := start.Date()
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	daylight := Daylight{Date: date.Format("2006-01-02")}
+
+	local := func(t time.Time, ok bool) *time.Time {
+		if !ok {
+			return nil
+		}
+		t = t.In(start.Location())
+		return &t
+	}
+	daylight.CivilDawn = local(sunEvent(date, latitude, longitude, civilTwilightZenith, true))
+	daylight.Sunrise = local(sunEvent(date, latitude, longitude, sunriseZenith, true))
+	daylight.Sunset = local(sunEvent(date, latitude, longitude, sunriseZenith, false))
+	daylight.CivilDusk = local(sunEvent(date, latitude, longitude, civilTwilightZenith, false))
+
+	if daylight.Sunrise == nil {
+		daylight.Polar = "night"
+		if sunCosHourAngle(date, latitude, sunriseZenith) < -1 {
+			daylight.Polar = "day"
+		}
+		return daylight
+	}
+
+	startAfterSunrise := start.Sub(*daylight.Sunrise).Seconds()
+	daylightRemaining := daylight.Sunset.Sub(finish).Seconds()
+	daylight.StartAfterSunrise = &startAfterSunrise
+	daylight.DaylightRemaining = &daylightRemaining
+	return daylight
+}
+
+// helpers
+
+// activityDaylight computes the daylight of an activity at its first point,
+// in the activity's local time
+func activityDaylight(activity StravaActivity, streamPoints []StravaStreamPoint, startDate time.Time) *Daylight {
+	latitude, longitude := activity.StartLatLon[0], activity.StartLatLon[1]
+	for _, point := range streamPoints {
+		if point.Latitude != 0 || point.Longitude != 0 {
+			latitude, longitude = point.Latitude, point.Longitude
+			break
+		}
+	}
+	if startDate.IsZero() || (latitude == 0 && longitude == 0) {
+		return nil
+	}
+
+	// strava formats local times like UTC times, so the difference to the
+	// start date is the utc offset
+	location := solarTimeZone(longitude)
+	if local, err := time.Parse(time.RFC3339, activity.StartDateLocal); err == nil {
+		offset := int(local.Sub(startDate).Seconds())
+		location = time.FixedZone(utcOffsetName(offset), offset)
+	}
+
+	start := startDate.In(location)
+	finish := start.Add(time.Duration(float64(activity.ElapsedTime) * float64(time.Second)))
+	if len(streamPoints) > 1 {
+		finish = start.Add(time.Duration((streamPoints[len(streamPoints)-1].Time - streamPoints[0].Time) * float64(time.Second)))
+	}
+	daylight := ComputeDaylight(latitude, longitude, start, finish)
+	return &daylight
+}
+
+// sunEvent returns when the sun crosses the zenith angle in the morning or
+// evening of a UTC date, following NOAA's general solar position equations.
+// It is false when the sun stays above or below the angle all day.
+func sunEvent(date time.Time, latitude float64, longitude float64, zenith float64, rising bool) (time.Time, bool) {
+	cosHourAngle := sunCosHourAngle(date, latitude, zenith)
+	if cosHourAngle < -1 || cosHourAngle > 1 {
+		return time.Time{}, false
+	}
+
+	hourAngle := math.Acos(cosHourAngle) * 180 / math.Pi
+	if !rising {
+		hourAngle = -hourAngle
+	}
+	equationOfTime, _ := solarPosition(date)
+	minutes := 720 - 4*(longitude+hourAngle) - equationOfTime
+	return date.Add(time.Duration(minutes * float64(time.Minute))).Round(time.Second), true
+}
+
+// sunCosHourAngle returns the cosine of the hour angle at which the sun
+// crosses the zenith angle, outside [-1, 1] when it never does
+func sunCosHourAngle(date time.Time, latitude float64, zenith float64) float64 {
+	_, declination := solarPosition(date)
+	latitude = latitude * math.Pi / 180
+	return math.Cos(zenith*math.Pi/180)/(math.Cos(latitude)*math.Cos(declination)) - math.Tan(latitude)*math.Tan(declination)
+}
+
+// solarPosition returns the equation of time in minutes and the sun's
+// declination in radians at noon of a date
+func solarPosition(date time.Time) (float64, float64) {
+	gamma := 2 * math.Pi / 365 * float64(date.YearDay()-1)
+	equationOfTime := 229.18 * (0.000075 + 0.001868*math.Cos(gamma) - 0.032077*math.Sin(gamma) -
+		0.014615*math.Cos(2*gamma) - 0.040849*math.Sin(2*gamma))
+	declination := 0.006918 - 0.399912*math.Cos(gamma) + 0.070257*math.Sin(gamma) -
+		0.006758*math.Cos(2*gamma) + 0.000907*math.Sin(2*gamma) -
+		0.002697*math.Cos(3*gamma) + 0.00148*math.Sin(3*gamma)
+	return equationOfTime, declination
+}
+
+// solarTimeZone approximates the time zone at a longitude by whole hours
+func solarTimeZone(longitude float64) *time.Location {
+	offset := int(math.Round(longitude/15)) * 3600
+	return time.FixedZone(utcOffsetName(offset), offset)
+}
+
+func utcOffsetName(offset int) string {
+	sign := "+"
+	if offset < 0 {
+		sign, offset = "-", -offset
+	}
+	return fmt.Sprintf("UTC%s%02d:%02d", sign, offset/3600, offset/60%60)
+}
@@ -0,0 +1,92 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestComputeDaylight(t *testing.T) {
+	london := time.FixedZone("BST", 3600)
+	start := time.Date(2025, 6, 21, 6, 0, 0, 0, london)
+	daylight := ComputeDaylight(51.5074, -0.1278, start, start.Add(16*time.Hour))
+
+	// published times for London are 04:43 and 21:21
+	for _, tt := range []struct {
+		name     string
+		value    *time.Time
+		expected time.Time
+	}{
+		{"sunrise", daylight.Sunrise, time.Date(2025, 6, 21, 4, 43, 0, 0, london)},
+		{"sunset", daylight.Sunset, time.Date(2025, 6, 21, 21, 21, 0, 0, london)},
+	} {
+		if tt.value == nil || math.Abs(tt.value.Sub(tt.expected).Minutes()) > 2 {
+			t.Errorf("expected %s near %v, got %v", tt.name, tt.expected, tt.value)
+		}
+	}
+	if daylight.CivilDawn == nil || !daylight.CivilDawn.Before(*daylight.Sunrise) || !daylight.CivilDusk.After(*daylight.Sunset) {
+		t.Errorf("expected civil twilight around sunrise and sunset, got %+v", daylight)
+	}
+	if daylight.Date != "2025-06-21" || daylight.Polar != "" {
+		t.Errorf("unexpected daylight %+v", daylight)
+	}
+	if math.Abs(*daylight.StartAfterSunrise-77*60) > 120 || math.Abs(*daylight.DaylightRemaining+39*60) > 120 {
+		t.Errorf("unexpected margins %v %v", *daylight.StartAfterSunrise, *daylight.DaylightRemaining)
+	}
+
+	tromso := time.Date(2025, 12, 21, 10, 0, 0, 0, time.UTC)
+	if daylight := ComputeDaylight(69.65, 18.96, tromso, tromso); daylight.Polar != "night" || daylight.Sunrise != nil || daylight.CivilDawn == nil {
+		t.Errorf("expected the polar night with civil twilight, got %+v", daylight)
+	}
+	tromso = time.Date(2025, 6, 21, 10, 0, 0, 0, time.UTC)
+	if daylight := ComputeDaylight(69.65, 18.96, tromso, tromso); daylight.Polar != "day" || daylight.StartAfterSunrise != nil {
+		t.Errorf("expected the midnight sun, got %+v", daylight)
+	}
+}
+
+func TestActivityDaylight(t *testing.T) {
+	activity := StravaActivity{StartDate: "2025-02-14T12:30:00Z", StartDateLocal: "2025-02-14T07:30:00Z"}
+	startDate, _ := time.Parse(time.RFC3339, activity.StartDate)
+
+	daylight := activityDaylight(activity, skiTourPoints(), startDate)
+	if daylight == nil || daylight.Sunrise == nil {
+		t.Fatal("expected daylight")
+	}
+	if _, offset := daylight.Sunrise.Zone(); offset != -5*3600 {
+		t.Errorf("expected times in the activity's local time, got offset %d", offset)
+	}
+	// sunrise is at about 06:40 at 44N 71W in mid february
+	if sunrise := daylight.Sunrise.Format("15:04"); sunrise < "06:30" || sunrise > "06:50" {
+		t.Errorf("unexpected sunrise %s", sunrise)
+	}
+	if *daylight.StartAfterSunrise <= 0 || *daylight.DaylightRemaining <= 0 {
+		t.Errorf("expected the tour within daylight, got %+v", daylight)
+	}
+
+	if activityDaylight(StravaActivity{}, nil, startDate) != nil {
+		t.Error("expected no daylight without a location")
+	}
+}
+
+func TestEstimateTour_Daylight(t *testing.T) {
+	rates := MunterRates{Ascent: defaultMunterAscentRate, Descent: defaultMunterDescentRate}
+	plan := TourPlan{Start: 15 * time.Hour, ReturnBy: 18 * time.Hour, Date: time.Date(2025, 12, 21, 0, 0, 0, 0, time.UTC)}
+
+	estimate := EstimateTour(peakRoutePoints(), rates, plan)
+	if estimate.Daylight == nil || estimate.Daylight.Date != "2025-12-21" {
+		t.Fatalf("expected daylight on the planned date, got %+v", estimate.Daylight)
+	}
+	if _, offset := estimate.Daylight.Sunset.Zone(); offset != -5*3600 {
+		t.Errorf("expected the solar time zone of the route, got offset %d", offset)
+	}
+	// finishing at 17:48 in december is well after dark
+	if len(estimate.Warnings) != 1 {
+		t.Errorf("expected a civil twilight warning, got %v", estimate.Warnings)
+	}
+
+	plan.Start = 9 * time.Hour
+	plan.ReturnBy = 14 * time.Hour
+	if estimate := EstimateTour(peakRoutePoints(), rates, plan); len(estimate.Warnings) != 0 {
+		t.Errorf("expected no warnings, got %v", estimate.Warnings)
+	}
+}
@@ -0,0 +1,344 @@
+package app
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultProfileWidth  = 800
+	defaultProfileHeight = 240
+	defaultTrackSize     = 600
+	minSvgSize           = 100
+	maxSvgSize           = 4000
+
+	// svgMinStep drops points closer than this many pixels to the previous
+	// one, which keeps long activities small without visible change
+	svgMinStep = 0.5
+)
+
+// lapColors are the stroke colors of each kind of lap
+var lapColors = map[LapKind]string{
+	LapAscent:     "#d9480f",
+	LapDescent:    "#1c7ed6",
+	LapTransition: "#868e96",
+}
+
+// SvgOptions set the size of rendered SVGs in pixels
+type SvgOptions struct {
+	Width  int
+	Height int
+}
+
+// ParseSvgOptions reads the width and height query parameters, falling back
+// to the given defaults
+func ParseSvgOptions(query url.Values, defaultWidth int, defaultHeight int) (SvgOptions, error) {
+	options := SvgOptions{Width: defaultWidth, Height: defaultHeight}
+	for _, param := range []struct {
+		name   string
+		target *int
+	}{
+		{"width", &options.Width},
+		{"height", &options.Height},
+	} {
+		value := query.Get(param.name)
+		if value == "" {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size < minSvgSize || size > maxSvgSize {
+			return SvgOptions{}, fmt.Errorf("%s must be between %d and %d", param.name, minSvgSize, maxSvgSize)
+		}
+		*param.target = size
+	}
+	return options, nil
+}
+
+// http request handlers
+
+// handleActivityProfileSvg renders the elevation profile of one of the
+// authenticated athlete's activities
+func (s *ServerState) handleActivityProfileSvg(c echo.Context) error {
+	return s.serveActivitySvg(c, defaultProfileWidth, defaultProfileHeight, func(activity StravaActivity, streamPoints []StravaStreamPoint, options SvgOptions) ([]byte, error) {
+		return renderProfileSvg(activity.Name, streamPoints, SegmentLaps(streamPoints, SegmentOptions{}), options), nil
+	})
+}
+
+// handleActivityTrackSvg renders the track outline of one of the
+// authenticated athlete's activities. Points hidden or fuzzed by the
+// athlete's privacy zones are left out, so the outline can be shared.
+func (s *ServerState) handleActivityTrackSvg(c echo.Context) error {
+	hideFromHome := c.QueryParam("hide_from_home") == "true"
+	return s.serveActivitySvg(c, defaultTrackSize, defaultTrackSize, func(activity StravaActivity, streamPoints []StravaStreamPoint, options SvgOptions) ([]byte, error) {
+		privacy, err := s.store.FetchPrivacySettings(activity.Athlete.Id)
+		if err != nil {
+			return nil, err
+		}
+		hidden := svgHiddenPoints(activity, streamPoints, privacy.Options(hideFromHome))
+		return renderTrackSvg(activity.Name, streamPoints, SegmentLaps(streamPoints, SegmentOptions{}), hidden, options), nil
+	})
+}
+
+// helpers
+
+// serveActivitySvg fetches an activity owned by the authenticated athlete
+// with its elevation corrected like activity summaries, and serves the SVG
+// render builds from it
+func (s *ServerState) serveActivitySvg(c echo.Context, defaultWidth int, defaultHeight int, render func(StravaActivity, []StravaStreamPoint, SvgOptions) ([]byte, error)) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	options, err := ParseSvgOptions(c.QueryParams(), defaultWidth, defaultHeight)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	streamPoints, err := client.getActivityStream(strconv.Itoa(activity.Id))
+	if err != nil {
+		slog.Error("failed to fetch activity streams", "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch activity from strava")
+	}
+	streamPoints = correctElevation(streamPoints, ElevationCorrection{Model: s.elevation, Mode: ElevationModeReplace})
+
+	data, err := render(activity, streamPoints, options)
+	if err != nil {
+		slog.Error("failed to render activity", "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to render activity")
+	}
+	return c.Blob(http.StatusOK, "image/svg+xml", data)
+}
+
+// svgHiddenPoints marks the points an export with the privacy options would
+// remove or move, which are both left out of drawings
+func svgHiddenPoints(activity StravaActivity, streamPoints []StravaStreamPoint, options PrivacyOptions) []bool {
+	hidden, fuzzed := privacyMatches(activity, streamPoints, options)
+	for i, zone := range fuzzed {
+		if zone != nil {
+			hidden[i] = true
+		}
+	}
+	return hidden
+}
+
+// renderProfileSvg draws altitude against distance with each lap in its
+// color and dashed lines at transitions
+func renderProfileSvg(title string, streamPoints []StravaStreamPoint, laps []Lap, options SvgOptions) []byte {
+	const left, right, top, bottom = 50.0, 10.0, 10.0, 30.0
+	width, height := float64(options.Width), float64(options.Height)
+
+	var buf bytes.Buffer
+	writeSvgHeader(&buf, title, options)
+	if len(streamPoints) < 2 {
+		buf.WriteString("</svg>\n")
+		return buf.Bytes()
+	}
+
+	distances := cumulativeDistances(streamPoints)
+	total := math.Max(distances[len(distances)-1], 1)
+	low, high := math.Inf(1), math.Inf(-1)
+	for _, point := range streamPoints {
+		low, high = math.Min(low, point.Altitude), math.Max(high, point.Altitude)
+	}
+	altitudeStep := niceStep((high - low) / 4)
+	low = math.Floor(low/altitudeStep) * altitudeStep
+	high = math.Max(math.Ceil(high/altitudeStep)*altitudeStep, low+altitudeStep)
+
+	x := func(distance float64) float64 { return left + distance/total*(width-left-right) }
+	y := func(altitude float64) float64 { return top + (high-altitude)/(high-low)*(height-top-bottom) }
+
+	// grid and axis labels
+	buf.WriteString(`<g stroke="#dee2e6" stroke-width="1" font-family="sans-serif" font-size="11" fill="#495057">` + "\n")
+	for altitude := low; altitude <= high+altitudeStep/2; altitude += altitudeStep {
+		fmt.Fprintf(&buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/><text x="%.1f" y="%.1f" stroke="none" text-anchor="end">%.0f m</text>`+"\n",
+			left, y(altitude), width-right, y(altitude), left-4, y(altitude)+4, altitude)
+	}
+	distanceStep := niceStep(total / 5)
+	for distance := 0.0; distance <= total; distance += distanceStep {
+		fmt.Fprintf(&buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f"/><text x="%.1f" y="%.1f" stroke="none" text-anchor="middle">%s</text>`+"\n",
+			x(distance), top, x(distance), height-bottom, x(distance), height-bottom+16, formatSvgDistance(distance, distanceStep))
+	}
+	buf.WriteString("</g>\n")
+
+	for _, lap := range laps {
+		if lap.StartIndex >= len(streamPoints) {
+			continue
+		}
+		color := lapColors[lap.Kind]
+		// turnarounds without a pause are transitions without points
+		if lap.Kind == LapTransition {
+			position := x(distances[lap.StartIndex])
+			fmt.Fprintf(&buf, `<line class="transition" x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-dasharray="4 3"/>`+"\n",
+				position, top, position, height-bottom, color)
+		}
+		if lap.EndIndex <= lap.StartIndex {
+			continue
+		}
+
+		var coordinates [][2]float64
+		for i := lap.StartIndex; i <= lap.EndIndex && i < len(streamPoints); i++ {
+			coordinates = append(coordinates, [2]float64{x(distances[i]), y(streamPoints[i].Altitude)})
+		}
+		coordinates = thinSvgPoints(coordinates)
+		if lap.Kind != LapTransition {
+			area := append([][2]float64{{coordinates[0][0], height - bottom}}, coordinates...)
+			area = append(area, [2]float64{coordinates[len(coordinates)-1][0], height - bottom})
+			fmt.Fprintf(&buf, `<polygon class="%s" fill="%s" fill-opacity="0.2" stroke="none" points="%s"/>`+"\n", lap.Kind, color, svgPoints(area))
+		}
+		fmt.Fprintf(&buf, `<polyline class="%s" fill="none" stroke="%s" stroke-width="2" stroke-linejoin="round" points="%s"/>`+"\n", lap.Kind, color, svgPoints(coordinates))
+	}
+
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+// renderTrackSvg draws the track outline north up, each lap in its color,
+// with the start, end and transitions marked. Hidden points are left out
+// and break the line.
+func renderTrackSvg(title string, streamPoints []StravaStreamPoint, laps []Lap, hidden []bool, options SvgOptions) []byte {
+	const margin = 20.0
+	width, height := float64(options.Width), float64(options.Height)
+
+	var buf bytes.Buffer
+	writeSvgHeader(&buf, title, options)
+
+	visible := func(i int) bool {
+		point := streamPoints[i]
+		return !hidden[i] && (point.Latitude != 0 || point.Longitude != 0)
+	}
+
+	west, south, east, north := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for i, point := range streamPoints {
+		if visible(i) {
+			west, east = math.Min(west, point.Longitude), math.Max(east, point.Longitude)
+			south, north = math.Min(south, point.Latitude), math.Max(north, point.Latitude)
+		}
+	}
+	if math.IsInf(west, 1) {
+		buf.WriteString("</svg>\n")
+		return buf.Bytes()
+	}
+
+	origin := StravaStreamPoint{Latitude: (south + north) / 2, Longitude: (west + east) / 2}
+	spanX, spanY := projectLocal(origin, StravaStreamPoint{Latitude: north, Longitude: east})
+	scale := math.Min((width/2-margin)/math.Max(spanX, 1), (height/2-margin)/math.Max(spanY, 1))
+	project := func(point StravaStreamPoint) [2]float64 {
+		px, py := projectLocal(origin, point)
+		return [2]float64{width/2 + px*scale, height/2 - py*scale}
+	}
+
+	for _, lap := range laps {
+		var line [][2]float64
+		flush := func() {
+			if len(line) > 1 {
+				fmt.Fprintf(&buf, `<polyline class="%s" fill="none" stroke="%s" stroke-width="2" stroke-linejoin="round" stroke-linecap="round" points="%s"/>`+"\n",
+					lap.Kind, lapColors[lap.Kind], svgPoints(thinSvgPoints(line)))
+			}
+			line = nil
+		}
+		for i := lap.StartIndex; i <= lap.EndIndex && i < len(streamPoints); i++ {
+			if !visible(i) {
+				flush()
+				continue
+			}
+			line = append(line, project(streamPoints[i]))
+		}
+		flush()
+	}
+
+	for _, lap := range laps {
+		if lap.Kind == LapTransition && lap.StartIndex < len(streamPoints) && visible(lap.StartIndex) {
+			writeSvgMarker(&buf, "transition", project(streamPoints[lap.StartIndex]), "#ffffff")
+		}
+	}
+	for i := 0; i < len(streamPoints); i++ {
+		if visible(i) {
+			writeSvgMarker(&buf, "start", project(streamPoints[i]), "#2f9e44")
+			break
+		}
+	}
+	for i := len(streamPoints) - 1; i >= 0; i-- {
+		if visible(i) {
+			writeSvgMarker(&buf, "end", project(streamPoints[i]), "#e03131")
+			break
+		}
+	}
+
+	buf.WriteString("</svg>\n")
+	return buf.Bytes()
+}
+
+func writeSvgHeader(buf *bytes.Buffer, title string, options SvgOptions) {
+	fmt.Fprintf(buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", options.Width, options.Height, options.Width, options.Height)
+	if title != "" {
+		fmt.Fprintf(buf, "<title>%s</title>\n", html.EscapeString(title))
+	}
+}
+
+func writeSvgMarker(buf *bytes.Buffer, class string, position [2]float64, fill string) {
+	fmt.Fprintf(buf, `<circle class="%s" cx="%.1f" cy="%.1f" r="5" fill="%s" stroke="#212529" stroke-width="1.5"/>`+"\n", class, position[0], position[1], fill)
+}
+
+// thinSvgPoints drops points closer than svgMinStep to the last kept point,
+// always keeping the last
+func thinSvgPoints(coordinates [][2]float64) [][2]float64 {
+	if len(coordinates) < 3 {
+		return coordinates
+	}
+	result := [][2]float64{coordinates[0]}
+	for _, coordinate := range coordinates[1 : len(coordinates)-1] {
+		last := result[len(result)-1]
+		if math.Hypot(coordinate[0]-last[0], coordinate[1]-last[1]) >= svgMinStep {
+			result = append(result, coordinate)
+		}
+	}
+	return append(result, coordinates[len(coordinates)-1])
+}
+
+func svgPoints(coordinates [][2]float64) string {
+	var buf bytes.Buffer
+	for i, coordinate := range coordinates {
+		if i > 0 {
+			buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&buf, "%.1f,%.1f", coordinate[0], coordinate[1])
+	}
+	return buf.String()
+}
+
+// niceStep rounds a raw axis step up to 1, 2 or 5 times a power of ten
+func niceStep(raw float64) float64 {
+	if raw <= 0 {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(raw)))
+	for _, factor := range []float64{1, 2, 5} {
+		if raw <= factor*magnitude {
+			return factor * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// formatSvgDistance labels distance ticks in km, with a decimal when the
+// ticks are less than a km apart
+func formatSvgDistance(distance float64, step float64) string {
+	if step < 1000 {
+		return fmt.Sprintf("%.1f km", distance/1000)
+	}
+	return fmt.Sprintf("%.0f km", distance/1000)
+}
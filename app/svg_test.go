@@ -0,0 +1,117 @@
+package app
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// svgElements parses an SVG and counts its elements by name and class
+func svgElements(t *testing.T, data []byte) map[string]int {
+	t.Helper()
+	counts := map[string]int{}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return counts
+		}
+		if err != nil {
+			t.Fatalf("invalid svg: %v", err)
+		}
+		if element, ok := token.(xml.StartElement); ok {
+			key := element.Name.Local
+			for _, attr := range element.Attr {
+				if attr.Name.Local == "class" {
+					key += "." + attr.Value
+				}
+			}
+			counts[key]++
+		}
+	}
+}
+
+func TestRenderProfileSvg(t *testing.T) {
+	points := skiTourPoints()
+	laps := SegmentLaps(points, SegmentOptions{})
+	data := renderProfileSvg("Dawn <patrol>", points, laps, SvgOptions{Width: 800, Height: 240})
+
+	counts := svgElements(t, data)
+	if counts["polyline.ascent"] != 2 || counts["polyline.descent"] != 2 || counts["polygon.ascent"] != 2 {
+		t.Errorf("expected two filled ascents and descents, got %v", counts)
+	}
+	if counts["line.transition"] != 3 {
+		t.Errorf("expected 3 transition markers, got %v", counts)
+	}
+	if !bytes.Contains(data, []byte("<title>Dawn &lt;patrol&gt;</title>")) {
+		t.Error("expected an escaped title")
+	}
+	// the tour climbs from 1000 m to 1300 m
+	if !bytes.Contains(data, []byte(">1000 m<")) || !bytes.Contains(data, []byte(">1300 m<")) {
+		t.Errorf("expected altitude labels from 1000 to 1300 m in %s", data)
+	}
+}
+
+func TestRenderTrackSvg(t *testing.T) {
+	points := skiTourPoints()
+	laps := SegmentLaps(points, SegmentOptions{})
+	hidden := make([]bool, len(points))
+	options := SvgOptions{Width: 600, Height: 600}
+
+	counts := svgElements(t, renderTrackSvg("", points, laps, hidden, options))
+	if counts["polyline.ascent"] != 2 || counts["circle.start"] != 1 || counts["circle.end"] != 1 || counts["circle.transition"] != 3 {
+		t.Errorf("unexpected track elements %v", counts)
+	}
+
+	// hiding the middle of the first climb splits it in two
+	for i := 60; i < 120; i++ {
+		hidden[i] = true
+	}
+	data := renderTrackSvg("", points, laps, hidden, options)
+	if counts := svgElements(t, data); counts["polyline.ascent"] != 3 {
+		t.Errorf("expected the hidden points to split the ascent, got %v", counts)
+	}
+
+	// hiding everything leaves an empty drawing
+	for i := range hidden {
+		hidden[i] = true
+	}
+	data = renderTrackSvg("", points, laps, hidden, options)
+	if strings.Contains(string(data), "polyline") || strings.Contains(string(data), "circle") {
+		t.Errorf("expected nothing drawn, got %s", data)
+	}
+}
+
+func TestNiceStep(t *testing.T) {
+	tests := []struct {
+		raw, expected float64
+	}{
+		{75, 100},
+		{130, 200},
+		{260, 500},
+		{1000, 1000},
+		{0, 1},
+	}
+
+	for _, tt := range tests {
+		if step := niceStep(tt.raw); step != tt.expected {
+			t.Errorf("%v: expected %v, got %v", tt.raw, tt.expected, step)
+		}
+	}
+}
+
+func TestParseSvgOptions(t *testing.T) {
+	options, err := ParseSvgOptions(url.Values{"width": {"1200"}}, 800, 240)
+	if err != nil || options.Width != 1200 || options.Height != 240 {
+		t.Errorf("unexpected options %+v %v", options, err)
+	}
+	if _, err := ParseSvgOptions(url.Values{"height": {"50"}}, 800, 240); err == nil {
+		t.Error("expected a small height to fail")
+	}
+	if _, err := ParseSvgOptions(url.Values{"width": {"wide"}}, 800, 240); err == nil {
+		t.Error("expected an invalid width to fail")
+	}
+}
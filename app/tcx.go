@@ -0,0 +1,239 @@
+package app
+
+import (
+	"encoding/xml"
+	"math"
+	"time"
+)
+
+const (
+	tcxNamespace            = "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2"
+	tcxActivityExtNamespace = "http://www.garmin.com/xmlschemas/ActivityExtension/v2"
+	tcxSchemaLoc            = "http://www.garmin.com/xmlschemas/TrainingCenterDatabase/v2 http://www.garmin.com/xmlschemas/TrainingCenterDatabasev2.xsd"
+
+	// tcxPartNumber fills the part number Application_t requires, which is
+	// meant for Garmin products and matches its XXX-XXXXX-XX pattern
+	tcxPartNumber = "000-00000-00"
+)
+
+// TCX document structure. Element order matters to strict importers and
+// follows TrainingCenterDatabasev2.xsd.
+
+type tcxDatabase struct {
+	XMLName        xml.Name      `xml:"TrainingCenterDatabase"`
+	Xmlns          string        `xml:"xmlns,attr"`
+	XmlnsNs3       string        `xml:"xmlns:ns3,attr"`
+	XmlnsXsi       string        `xml:"xmlns:xsi,attr"`
+	SchemaLocation string        `xml:"xsi:schemaLocation,attr"`
+	Activities     []tcxActivity `xml:"Activities>Activity"`
+}
+
+type tcxActivity struct {
+	Sport   string   `xml:"Sport,attr"`
+	Id      string   `xml:"Id"`
+	Laps    []tcxLap `xml:"Lap"`
+	Creator *tcxCreator
+}
+
+// tcxCreator is an Application_t, whose Build, LangID and PartNumber are
+// required
+type tcxCreator struct {
+	XMLName    xml.Name   `xml:"Creator"`
+	Type       string     `xml:"xsi:type,attr"`
+	Name       string     `xml:"Name"`
+	Version    tcxVersion `xml:"Build>Version"`
+	LangID     string     `xml:"LangID"`
+	PartNumber string     `xml:"PartNumber"`
+}
+
+type tcxVersion struct {
+	VersionMajor int `xml:"VersionMajor"`
+	VersionMinor int `xml:"VersionMinor"`
+}
+
+type tcxLap struct {
+	StartTime           string        `xml:"StartTime,attr"`
+	TotalTimeSeconds    float64       `xml:"TotalTimeSeconds"`
+	DistanceMeters      float64       `xml:"DistanceMeters"`
+	MaximumSpeed        *float64      `xml:"MaximumSpeed,omitempty"`
+	Calories            int           `xml:"Calories"`
+	AverageHeartRateBpm *tcxHeartRate `xml:"AverageHeartRateBpm,omitempty"`
+	MaximumHeartRateBpm *tcxHeartRate `xml:"MaximumHeartRateBpm,omitempty"`
+	Intensity           string        `xml:"Intensity"`
+	Cadence             *int          `xml:"Cadence,omitempty"`
+	TriggerMethod       string        `xml:"TriggerMethod"`
+	Track               []tcxPoint    `xml:"Track>Trackpoint"`
+}
+
+type tcxHeartRate struct {
+	Value int `xml:"Value"`
+}
+
+type tcxPosition struct {
+	LatitudeDegrees  float64 `xml:"LatitudeDegrees"`
+	LongitudeDegrees float64 `xml:"LongitudeDegrees"`
+}
+
+type tcxPoint struct {
+	Time           string        `xml:"Time"`
+	Position       *tcxPosition  `xml:"Position,omitempty"`
+	AltitudeMeters *float64      `xml:"AltitudeMeters,omitempty"`
+	DistanceMeters float64       `xml:"DistanceMeters"`
+	HeartRateBpm   *tcxHeartRate `xml:"HeartRateBpm,omitempty"`
+	Cadence        *int          `xml:"Cadence,omitempty"`
+	Extensions     *tcxPointExt  `xml:"Extensions,omitempty"`
+}
+
+type tcxPointExt struct {
+	Watts int `xml:"ns3:TPX>ns3:Watts"`
+}
+
+func buildTcx(activity StravaActivity, streamPoints []StravaStreamPoint, metadata GpxMetadata) ([]byte, error) {
+	useHeartRate := metadata.UseHeartRate && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.HeartRate })
+	useCadence := metadata.UseCadence && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Cadence })
+	usePower := metadata.UsePower && hasStreamData(streamPoints, func(p StravaStreamPoint) float64 { return p.Power })
+	distances := cumulativeDistances(streamPoints)
+
+	tcxLaps := []tcxLap{}
+	for _, lapRange := range activityLapRanges(activity, len(streamPoints)) {
+		lapPoints := streamPoints[lapRange[0]:lapRange[1]]
+		if len(lapPoints) == 0 {
+			continue
+		}
+
+		first, last := lapPoints[0], lapPoints[len(lapPoints)-1]
+		lap := tcxLap{
+			StartTime:        formatTcxTime(streamPointTime(metadata.Time, first)),
+			TotalTimeSeconds: last.Time - first.Time,
+			DistanceMeters:   distances[lapRange[1]-1] - distances[lapRange[0]],
+			Intensity:        "Active",
+			TriggerMethod:    "Manual",
+		}
+
+		if activity.ElapsedTime > 0 {
+			lap.Calories = int(math.Round(activity.Calories * lap.TotalTimeSeconds / float64(activity.ElapsedTime)))
+		}
+
+		var heartRateSum, maxHeartRate, cadenceSum, maxSpeed float64
+		heartRateSamples := 0
+		for i, point := range lapPoints {
+			index := lapRange[0] + i
+			tcxPoint := tcxPoint{
+				Time:           formatTcxTime(streamPointTime(metadata.Time, point)),
+				AltitudeMeters: &point.Altitude,
+				DistanceMeters: distances[index],
+			}
+			// points without gps have no position
+			if point.Latitude != 0 || point.Longitude != 0 {
+				tcxPoint.Position = &tcxPosition{LatitudeDegrees: point.Latitude, LongitudeDegrees: point.Longitude}
+			}
+
+			if useHeartRate && point.HeartRate > 0 {
+				tcxPoint.HeartRateBpm = &tcxHeartRate{Value: int(math.Round(point.HeartRate))}
+				heartRateSum += point.HeartRate
+				maxHeartRate = math.Max(maxHeartRate, point.HeartRate)
+				heartRateSamples++
+			}
+			if useCadence {
+				cadence := int(math.Round(point.Cadence))
+				tcxPoint.Cadence = &cadence
+				cadenceSum += point.Cadence
+			}
+			if usePower {
+				tcxPoint.Extensions = &tcxPointExt{Watts: int(math.Round(point.Power))}
+			}
+
+			if i > 0 {
+				elapsed := point.Time - lapPoints[i-1].Time
+				if elapsed > 0 {
+					maxSpeed = math.Max(maxSpeed, (distances[index]-distances[index-1])/elapsed)
+				}
+			}
+
+			lap.Track = append(lap.Track, tcxPoint)
+		}
+
+		if heartRateSamples > 0 {
+			lap.AverageHeartRateBpm = &tcxHeartRate{Value: int(math.Round(heartRateSum / float64(heartRateSamples)))}
+			lap.MaximumHeartRateBpm = &tcxHeartRate{Value: int(math.Round(maxHeartRate))}
+		}
+		if useCadence {
+			cadence := int(math.Round(cadenceSum / float64(len(lapPoints))))
+			lap.Cadence = &cadence
+		}
+		if maxSpeed > 0 {
+			lap.MaximumSpeed = &maxSpeed
+		}
+
+		tcxLaps = append(tcxLaps, lap)
+	}
+
+	document := tcxDatabase{
+		Xmlns:          tcxNamespace,
+		XmlnsNs3:       tcxActivityExtNamespace,
+		XmlnsXsi:       "http://www.w3.org/2001/XMLSchema-instance",
+		SchemaLocation: tcxSchemaLoc,
+		Activities: []tcxActivity{{
+			Sport:   tcxSport(metadata.Type),
+			Id:      formatTcxTime(metadata.Time),
+			Laps:    tcxLaps,
+			Creator: newTcxCreator(),
+		}},
+	}
+
+	output, err := xml.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), output...), nil
+}
+
+// newTcxCreator describes skintrackr as the application that wrote the file
+func newTcxCreator() *tcxCreator {
+	return &tcxCreator{
+		Type:       "Application_t",
+		Name:       "skintrackr.fly.dev",
+		Version:    tcxVersion{VersionMajor: 1},
+		LangID:     "en",
+		PartNumber: tcxPartNumber,
+	}
+}
+
+// activityLapRanges returns [start, end) stream index ranges for each lap of
+// the activity, or a single range over the whole stream when the activity
+// has no laps
+func activityLapRanges(activity StravaActivity, streamLength int) [][2]int {
+	if len(activity.Laps) == 0 {
+		return [][2]int{{0, streamLength}}
+	}
+
+	var ranges [][2]int
+	previousEnd := 0
+	for _, lap := range activity.Laps {
+		// strava's end_index is inclusive and is usually repeated as the
+		// start_index of the following lap
+		start := min(max(lap.StartIndex, previousEnd), streamLength)
+		end := min(max(lap.EndIndex+1, start), streamLength)
+		if end > start {
+			ranges = append(ranges, [2]int{start, end})
+			previousEnd = end
+		}
+	}
+	return ranges
+}
+
+// tcxSport maps a strava activity type onto the three sports TCX supports
+func tcxSport(activityType string) string {
+	switch activityType {
+	case "Run", "TrailRun", "VirtualRun":
+		return "Running"
+	case "Ride", "MountainBikeRide", "GravelRide", "EBikeRide", "VirtualRide":
+		return "Biking"
+	default:
+		return "Other"
+	}
+}
+
+func formatTcxTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
@@ -0,0 +1,140 @@
+package app
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestActivityLapRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		laps     []StravaLap
+		length   int
+		expected [][2]int
+	}{
+		{
+			name:     "no laps covers whole stream",
+			laps:     nil,
+			length:   10,
+			expected: [][2]int{{0, 10}},
+		},
+		{
+			name:     "laps sharing boundary index",
+			laps:     []StravaLap{{StartIndex: 0, EndIndex: 4}, {StartIndex: 4, EndIndex: 9}},
+			length:   10,
+			expected: [][2]int{{0, 5}, {5, 10}},
+		},
+		{
+			name:     "lap past end of stream is clamped",
+			laps:     []StravaLap{{StartIndex: 0, EndIndex: 20}},
+			length:   10,
+			expected: [][2]int{{0, 10}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges := activityLapRanges(StravaActivity{Laps: tt.laps}, tt.length)
+			if len(ranges) != len(tt.expected) {
+				t.Fatalf("expected %d ranges, got %d: %v", len(tt.expected), len(ranges), ranges)
+			}
+			for i := range ranges {
+				if ranges[i] != tt.expected[i] {
+					t.Errorf("range %d: expected %v, got %v", i, tt.expected[i], ranges[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildTcx(t *testing.T) {
+	activity := StravaActivity{
+		Id:          42,
+		ElapsedTime: 405,
+		Calories:    405,
+		Laps: []StravaLap{
+			{StartIndex: 0, EndIndex: 2},
+			{StartIndex: 2, EndIndex: 4},
+		},
+	}
+	metadata := GpxMetadata{
+		Type:         "BackcountrySki",
+		Time:         time.Date(2025, 2, 14, 7, 30, 0, 0, time.UTC),
+		UseHeartRate: true,
+		UseCadence:   true,
+	}
+
+	output, err := buildTcx(activity, testStreamPoints(), metadata)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var parsed tcxDatabase
+	if err := xml.Unmarshal(output, &parsed); err != nil {
+		t.Fatalf("failed to parse generated tcx: %v", err)
+	}
+
+	laps := parsed.Activities[0].Laps
+	if len(laps) != 2 {
+		t.Fatalf("expected 2 laps, got %d", len(laps))
+	}
+	if parsed.Activities[0].Sport != "Other" {
+		t.Errorf("expected sport Other, got %q", parsed.Activities[0].Sport)
+	}
+	if len(laps[0].Track) != 3 || len(laps[1].Track) != 2 {
+		t.Errorf("expected 3 and 2 trackpoints, got %d and %d", len(laps[0].Track), len(laps[1].Track))
+	}
+	if laps[1].StartTime != "2025-02-14T07:36:40.000Z" {
+		t.Errorf("unexpected second lap start time %q", laps[1].StartTime)
+	}
+	if laps[0].TotalTimeSeconds != 10 {
+		t.Errorf("expected first lap duration 10, got %f", laps[0].TotalTimeSeconds)
+	}
+	if laps[0].Calories != 10 {
+		t.Errorf("expected calories split by lap duration, got %d", laps[0].Calories)
+	}
+	if laps[0].AverageHeartRateBpm == nil || laps[0].AverageHeartRateBpm.Value != 120 {
+		t.Errorf("expected average heart rate 120 excluding dropouts, got %+v", laps[0].AverageHeartRateBpm)
+	}
+	if laps[0].Track[2].HeartRateBpm != nil {
+		t.Error("expected heart rate dropout to be omitted")
+	}
+	if laps[1].Track[1].DistanceMeters <= laps[1].Track[0].DistanceMeters {
+		t.Error("expected cumulative distance to increase")
+	}
+	if strings.Contains(string(output), "Watts") {
+		t.Error("expected power to be omitted when not requested")
+	}
+	creator := parsed.Activities[0].Creator
+	if creator == nil || creator.Version.VersionMajor != 1 || creator.LangID != "en" || creator.PartNumber != tcxPartNumber {
+		t.Errorf("expected a complete Application_t creator, got %+v", creator)
+	}
+	if !strings.Contains(string(output), `<Creator xsi:type="Application_t">`) {
+		t.Error("expected the creator to be typed as Application_t")
+	}
+}
+
+func TestBuildTcx_NoGps(t *testing.T) {
+	streamPoints := testStreamPoints()
+	streamPoints[1].Latitude, streamPoints[1].Longitude = 0, 0
+
+	output, err := buildTcx(StravaActivity{Id: 42}, streamPoints, GpxMetadata{Time: time.Now()})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var parsed tcxDatabase
+	if err := xml.Unmarshal(output, &parsed); err != nil {
+		t.Fatalf("failed to parse generated tcx: %v", err)
+	}
+
+	track := parsed.Activities[0].Laps[0].Track
+	if track[1].Position != nil {
+		t.Errorf("expected a point without gps to have no position, got %+v", track[1].Position)
+	}
+	if track[0].Position == nil {
+		t.Error("expected a point with gps to have a position")
+	}
+}
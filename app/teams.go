@@ -0,0 +1,178 @@
+package app
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const maxTeamNameLength = 100
+
+// teamLock serializes changes to team membership, so that athletes joining
+// at the same time cannot drop each other
+var teamLock sync.Mutex
+
+// Team is a group of athletes sharing named zones. Athletes join with the
+// team's invite code.
+type Team struct {
+	Id         string `json:"id"`
+	Name       string `json:"name"`
+	InviteCode string `json:"invite_code"`
+	Members    []int  `json:"members"`
+}
+
+type teamRequest struct {
+	Name       string `json:"name"`
+	InviteCode string `json:"invite_code"`
+}
+
+func (t Team) isMember(athleteId int) bool {
+	return slices.Contains(t.Members, athleteId)
+}
+
+// http request handlers
+
+// handleTeamsGet lists the authenticated athlete's teams
+func (s *ServerState) handleTeamsGet(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	teams, err := s.athleteTeams(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch teams", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch teams")
+	}
+	return c.JSON(http.StatusOK, teams)
+}
+
+// handleTeamCreate creates a team with the authenticated athlete as its
+// first member
+func (s *ServerState) handleTeamCreate(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	var request teamRequest
+	if err := c.Bind(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid team")
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || len(name) > maxTeamNameLength {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Team name must be between 1 and %d characters", maxTeamNameLength))
+	}
+
+	team := Team{
+		Id:         randomString(8),
+		Name:       name,
+		InviteCode: randomString(16),
+		Members:    []int{tokenInfo.athleteId},
+	}
+	if err := s.store.SaveTeam(team); err != nil {
+		slog.Error("failed to save team", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team")
+	}
+	if err := s.store.AddAthleteTeam(tokenInfo.athleteId, team.Id); err != nil {
+		slog.Error("failed to add athlete to team", "athlete_id", tokenInfo.athleteId, "team_id", team.Id, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team")
+	}
+
+	slog.Info("created team", "athlete_id", tokenInfo.athleteId, "team_id", team.Id)
+	return c.JSON(http.StatusCreated, team)
+}
+
+// handleTeamJoin adds the authenticated athlete to a team given its invite
+// code
+func (s *ServerState) handleTeamJoin(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	var request teamRequest
+	if err := c.Bind(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid invite code")
+	}
+
+	teamLock.Lock()
+	defer teamLock.Unlock()
+
+	team, err := s.store.FetchTeam(c.Param("id"))
+	if err == redis.Nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Team not found")
+	}
+	if err != nil {
+		slog.Error("failed to fetch team", "athlete_id", tokenInfo.athleteId, "team_id", c.Param("id"), "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to join team")
+	}
+	// a wrong code looks like a missing team, so team ids cannot be probed
+	if subtle.ConstantTimeCompare([]byte(request.InviteCode), []byte(team.InviteCode)) != 1 {
+		return echo.NewHTTPError(http.StatusNotFound, "Team not found")
+	}
+
+	if !team.isMember(tokenInfo.athleteId) {
+		team.Members = append(team.Members, tokenInfo.athleteId)
+		if err := s.store.SaveTeam(*team); err != nil {
+			slog.Error("failed to save team", "athlete_id", tokenInfo.athleteId, "team_id", team.Id, "err", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to join team")
+		}
+	}
+	if err := s.store.AddAthleteTeam(tokenInfo.athleteId, team.Id); err != nil {
+		slog.Error("failed to add athlete to team", "athlete_id", tokenInfo.athleteId, "team_id", team.Id, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to join team")
+	}
+
+	slog.Info("joined team", "athlete_id", tokenInfo.athleteId, "team_id", team.Id)
+	return c.JSON(http.StatusOK, team)
+}
+
+// helpers
+
+// athleteTeams loads the teams the athlete is a member of
+func (s *ServerState) athleteTeams(athleteId int) ([]Team, error) {
+	teamIds, err := s.store.FetchAthleteTeams(athleteId)
+	if err != nil {
+		return nil, err
+	}
+
+	teams := []Team{}
+	for _, teamId := range teamIds {
+		team, err := s.store.FetchTeam(teamId)
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if team.isMember(athleteId) {
+			teams = append(teams, *team)
+		}
+	}
+	return teams, nil
+}
+
+// memberTeam loads a team the athlete is a member of, returning an
+// echo.HTTPError otherwise
+func (s *ServerState) memberTeam(athleteId int, teamId string) (*Team, error) {
+	team, err := s.store.FetchTeam(teamId)
+	if err == redis.Nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Team not found")
+	}
+	if err != nil {
+		slog.Error("failed to fetch team", "athlete_id", athleteId, "team_id", teamId, "err", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch team")
+	}
+	if !team.isMember(athleteId) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Team not found")
+	}
+	return team, nil
+}
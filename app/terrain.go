@@ -0,0 +1,202 @@
+package app
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+// flatSlope is the slope below which terrain has no meaningful aspect
+const flatSlope = 5.0
+
+// slopeBand is a range of slope angles in degrees, [Min, Max)
+type slopeBand struct {
+	Label string
+	Min   float64
+	Max   float64
+}
+
+var slopeBands = []slopeBand{
+	{"<25", 0, 25},
+	{"25-30", 25, 30},
+	{"30-35", 30, 35},
+	{"35-45", 35, 45},
+	{">45", 45, 90},
+}
+
+// aspectLabels are the eight points of the aspect rose, clockwise from north
+var aspectLabels = []string{"N", "NE", "E", "SE", "S", "SW", "W", "NW"}
+
+// TerrainPoint is the slope angle and aspect, in degrees, under a track point
+type TerrainPoint struct {
+	Slope  float64 `json:"slope"`
+	Aspect float64 `json:"aspect"`
+	// Ok is false where the elevation model has no data
+	Ok bool `json:"ok"`
+}
+
+// TerrainExposure is the time and distance spent in a slope band or aspect
+type TerrainExposure struct {
+	Band     string  `json:"band"`
+	Time     float64 `json:"time_s"`
+	Distance float64 `json:"distance_m"`
+}
+
+// TerrainBreakdown is the exposure of one kind of lap by slope and aspect.
+// Flat terrain is counted under the "flat" aspect.
+type TerrainBreakdown struct {
+	Slopes  []TerrainExposure `json:"slopes"`
+	Aspects []TerrainExposure `json:"aspects"`
+}
+
+// TerrainReport is the slope and aspect exposure of an activity's ascents and
+// descents
+type TerrainReport struct {
+	ActivityId int              `json:"activity_id"`
+	Ascent     TerrainBreakdown `json:"ascent"`
+	Descent    TerrainBreakdown `json:"descent"`
+	// Coverage is the share of points the elevation model covers
+	Coverage float64        `json:"coverage"`
+	Points   []TerrainPoint `json:"points,omitempty"`
+}
+
+// http request handlers
+
+// handleActivityTerrain reports the time and distance an activity spent in
+// each slope band and aspect. points=true includes the slope and aspect of
+// every point of the stream.
+func (s *ServerState) handleActivityTerrain(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	if s.elevation == nil {
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Elevation model is not configured")
+	}
+
+	client, activity, err := s.fetchOwnedActivity(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	streamPoints, err := client.getActivityStream(strconv.Itoa(activity.Id))
+	if err != nil {
+		slog.Error("failed to fetch activity streams", "activity_id", activity.Id, "err", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to fetch activity from strava")
+	}
+	streamPoints = correctElevation(streamPoints, ElevationCorrection{Model: s.elevation, Mode: ElevationModeReplace})
+
+	terrain := annotateTerrain(streamPoints, s.elevation)
+	report := buildTerrainReport(streamPoints, terrain, SegmentLaps(streamPoints, SegmentOptions{}))
+	report.ActivityId = activity.Id
+	if c.QueryParam("points") == "true" {
+		report.Points = terrain
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
+// helpers
+
+// annotateTerrain looks up the slope and aspect under every point
+func annotateTerrain(streamPoints []StravaStreamPoint, model *ElevationModel) []TerrainPoint {
+	terrain := make([]TerrainPoint, len(streamPoints))
+	for i, point := range streamPoints {
+		slope, aspect, ok := model.SlopeAspect(point.Latitude, point.Longitude)
+		terrain[i] = TerrainPoint{Slope: roundTo(slope, 1), Aspect: roundTo(aspect, 0), Ok: ok}
+	}
+	return terrain
+}
+
+// buildTerrainReport adds the time and distance from each point to the next
+// to the slope band and aspect of the point, for ascents and descents
+func buildTerrainReport(streamPoints []StravaStreamPoint, terrain []TerrainPoint, laps []Lap) TerrainReport {
+	report := TerrainReport{Ascent: newTerrainBreakdown(), Descent: newTerrainBreakdown()}
+	if len(streamPoints) == 0 {
+		return report
+	}
+
+	distances := cumulativeDistances(streamPoints)
+	covered := 0
+	for _, point := range terrain {
+		if point.Ok {
+			covered++
+		}
+	}
+	report.Coverage = roundTo(float64(covered)/float64(len(terrain)), 3)
+
+	for _, lap := range laps {
+		var breakdown *TerrainBreakdown
+		switch lap.Kind {
+		case LapAscent:
+			breakdown = &report.Ascent
+		case LapDescent:
+			breakdown = &report.Descent
+		default:
+			continue
+		}
+
+		for i := lap.StartIndex; i < lap.EndIndex && i+1 < len(streamPoints); i++ {
+			if !terrain[i].Ok {
+				continue
+			}
+			elapsed := streamPoints[i+1].Time - streamPoints[i].Time
+			distance := distances[i+1] - distances[i]
+			breakdown.add(terrain[i], elapsed, distance)
+		}
+	}
+
+	for _, breakdown := range []*TerrainBreakdown{&report.Ascent, &report.Descent} {
+		for i := range breakdown.Slopes {
+			breakdown.Slopes[i].Distance = roundTo(breakdown.Slopes[i].Distance, 1)
+		}
+		for i := range breakdown.Aspects {
+			breakdown.Aspects[i].Distance = roundTo(breakdown.Aspects[i].Distance, 1)
+		}
+	}
+	return report
+}
+
+func newTerrainBreakdown() TerrainBreakdown {
+	breakdown := TerrainBreakdown{}
+	for _, band := range slopeBands {
+		breakdown.Slopes = append(breakdown.Slopes, TerrainExposure{Band: band.Label})
+	}
+	for _, label := range aspectLabels {
+		breakdown.Aspects = append(breakdown.Aspects, TerrainExposure{Band: label})
+	}
+	breakdown.Aspects = append(breakdown.Aspects, TerrainExposure{Band: "flat"})
+	return breakdown
+}
+
+func (b *TerrainBreakdown) add(point TerrainPoint, elapsed float64, distance float64) {
+	band := slopeBandIndex(point.Slope)
+	b.Slopes[band].Time += elapsed
+	b.Slopes[band].Distance += distance
+
+	aspect := len(aspectLabels)
+	if point.Slope >= flatSlope {
+		aspect = aspectIndex(point.Aspect)
+	}
+	b.Aspects[aspect].Time += elapsed
+	b.Aspects[aspect].Distance += distance
+}
+
+func slopeBandIndex(slope float64) int {
+	for i, band := range slopeBands {
+		if slope < band.Max {
+			return i
+		}
+	}
+	return len(slopeBands) - 1
+}
+
+// aspectIndex returns the rose sector of an aspect, each 45° wide and
+// centered on its compass point
+func aspectIndex(aspect float64) int {
+	sector := 360.0 / float64(len(aspectLabels))
+	return int(math.Mod(aspect+sector/2, 360) / sector)
+}
@@ -0,0 +1,116 @@
+package app
+
+import (
+	"math"
+	"testing"
+)
+
+func TestElevationModel_SlopeAspect(t *testing.T) {
+	dir := t.TempDir()
+	// a plane falling 22096 m every half degree to the east
+	writeHgtTile(t, dir, "N46E007.hgt", [9]int16{
+		30000, 7904, -14192,
+		30000, 7904, -14192,
+		30000, 7904, -14192,
+	})
+	model := NewElevationModel(dir)
+
+	slope, aspect, ok := model.SlopeAspect(46.5, 7.5)
+	if !ok {
+		t.Fatal("expected the point to be covered")
+	}
+
+	halfDegreeEast := 0.5 * math.Pi / 180 * earthRadiusMeters * math.Cos(46.5*math.Pi/180)
+	expectedSlope := math.Atan(22096/halfDegreeEast) * 180 / math.Pi
+	if math.Abs(slope-expectedSlope) > 0.1 {
+		t.Errorf("expected slope %v, got %v", expectedSlope, slope)
+	}
+	if math.Abs(aspect-90) > 0.1 {
+		t.Errorf("expected an east facing slope, got aspect %v", aspect)
+	}
+
+	if _, _, ok := model.SlopeAspect(45.5, 7.5); ok {
+		t.Error("expected point outside the tiles to be uncovered")
+	}
+}
+
+func TestAspectIndex(t *testing.T) {
+	tests := []struct {
+		aspect   float64
+		expected string
+	}{
+		{0, "N"},
+		{350, "N"},
+		{22.4, "N"},
+		{22.5, "NE"},
+		{90, "E"},
+		{200, "S"},
+		{315, "NW"},
+		{337.5, "N"},
+	}
+
+	for _, tt := range tests {
+		if label := aspectLabels[aspectIndex(tt.aspect)]; label != tt.expected {
+			t.Errorf("aspect %v: expected %s, got %s", tt.aspect, tt.expected, label)
+		}
+	}
+}
+
+func TestBuildTerrainReport(t *testing.T) {
+	points := skiTourPoints()
+	laps := SegmentLaps(points, SegmentOptions{})
+
+	// climb north facing slopes of 32° and ski east facing slopes of 40°,
+	// with no data for the first point
+	terrain := make([]TerrainPoint, len(points))
+	for _, lap := range laps {
+		for i := lap.StartIndex; i < lap.EndIndex; i++ {
+			switch lap.Kind {
+			case LapAscent:
+				terrain[i] = TerrainPoint{Slope: 32, Aspect: 10, Ok: true}
+			case LapDescent:
+				terrain[i] = TerrainPoint{Slope: 40, Aspect: 100, Ok: true}
+			default:
+				terrain[i] = TerrainPoint{Slope: 2, Aspect: 200, Ok: true}
+			}
+		}
+	}
+	terrain[0].Ok = false
+
+	report := buildTerrainReport(points, terrain, laps)
+
+	if report.Coverage != roundTo(float64(len(points)-1)/float64(len(points)), 3) {
+		t.Errorf("unexpected coverage %v", report.Coverage)
+	}
+
+	ascentTime := 0.0
+	for _, lap := range laps {
+		if lap.Kind == LapAscent {
+			ascentTime += lap.EndTime - lap.StartTime
+		}
+	}
+	// the first point has no data
+	ascentTime -= 10
+
+	ascentSlopes := report.Ascent.Slopes[2]
+	if ascentSlopes.Band != "30-35" || ascentSlopes.Time != ascentTime {
+		t.Errorf("expected %v s in 30-35°, got %+v", ascentTime, ascentSlopes)
+	}
+	if report.Ascent.Aspects[0].Band != "N" || report.Ascent.Aspects[0].Time != ascentTime {
+		t.Errorf("expected %v s on north aspects, got %+v", ascentTime, report.Ascent.Aspects[0])
+	}
+	if report.Ascent.Slopes[3].Time != 0 {
+		t.Errorf("expected no ascent time in 35-45°, got %+v", report.Ascent.Slopes[3])
+	}
+
+	descentSlopes := report.Descent.Slopes[3]
+	if descentSlopes.Band != "35-45" || descentSlopes.Time == 0 || descentSlopes.Distance < 4000 {
+		t.Errorf("expected the descents in 35-45°, got %+v", descentSlopes)
+	}
+	if report.Descent.Aspects[2].Band != "E" || report.Descent.Aspects[2].Time != descentSlopes.Time {
+		t.Errorf("expected the descents on east aspects, got %+v", report.Descent.Aspects[2])
+	}
+	if flat := report.Descent.Aspects[len(aspectLabels)]; flat.Band != "flat" || flat.Time != 0 {
+		t.Errorf("expected transitions to be left out, got %+v", flat)
+	}
+}
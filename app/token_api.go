@@ -49,7 +49,7 @@ func (s *ServerState) handleTokenStart(c echo.Context) error {
 	params.Add("client_id", s.config.StravaClientId)
 	params.Add("redirect_uri", redirectUrl)
 	params.Add("response_type", "code")
-	params.Add("scope", "read,activity:read_all")
+	params.Add("scope", stravaReadScope)
 	params.Add("state", state)
 	authorizationUrl.RawQuery = params.Encode()
 
@@ -95,6 +95,13 @@ func (s *ServerState) handleTokenCallback(c echo.Context) error {
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save token to redis")
 	}
+	err = s.store.SaveTokenScope(token.Athlete.ID, c.QueryParam("scope"))
+	if err != nil {
+		slog.Error("failed to save token scope", "athlete_id", token.Athlete.ID, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save token to redis")
+	}
+
+	s.startInitialBackfill(token.Athlete.ID)
 
 	// Generate JWT with 30-day expiration
 	expirationDuration := 30 * 24 * time.Hour
@@ -0,0 +1,270 @@
+package app
+
+import (
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// heartRateZonesTtl is how long an athlete's heart rate zones are cached
+	heartRateZonesTtl = 24 * time.Hour
+
+	// maxHeartRateGap is the longest interval between two samples counted
+	// towards time in zone, so pauses are left out
+	maxHeartRateGap = 60.0
+
+	// acuteLoadDays and chronicLoadDays are the spans of the exponentially
+	// weighted averages of daily training load
+	acuteLoadDays   = 7
+	chronicLoadDays = 42
+)
+
+// ActivityLoad is the training load of one activity
+type ActivityLoad struct {
+	ActivityId int `json:"activity_id"`
+	// Date is the local start date of the activity
+	Date string  `json:"date"`
+	Type string  `json:"type"`
+	Load float64 `json:"load"`
+}
+
+// TrainingLoadDay is the training load of a day with the acute and chronic
+// load at its end
+type TrainingLoadDay struct {
+	Date    string  `json:"date"`
+	Load    float64 `json:"load"`
+	Acute   float64 `json:"acute"`
+	Chronic float64 `json:"chronic"`
+}
+
+// TrainingLoad is an athlete's training load over a season
+type TrainingLoad struct {
+	Season     string     `json:"season"`
+	Hemisphere Hemisphere `json:"hemisphere"`
+	StartDate  string     `json:"start_date"`
+	EndDate    string     `json:"end_date"`
+	Activities int        `json:"activities"`
+	TotalLoad  float64    `json:"total_load"`
+	// Acute, Chronic, Ratio and RampRate are as of the last day of Days.
+	// RampRate is the change of the chronic load over the last week.
+	Acute    float64 `json:"acute"`
+	Chronic  float64 `json:"chronic"`
+	Ratio    float64 `json:"acute_chronic_ratio"`
+	RampRate float64 `json:"ramp_rate"`
+	// Days runs from the start of the season to its end or today
+	Days []TrainingLoadDay `json:"days"`
+}
+
+// http request handlers
+
+// handleTrainingLoad returns the authenticated athlete's training load over a
+// season, given by its label or as current
+func (s *ServerState) handleTrainingLoad(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	season, hemisphere, err := s.seasonParam(c)
+	if err != nil {
+		return err
+	}
+
+	loads, err := s.store.FetchActivityLoads(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch training loads", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch training load")
+	}
+	return c.JSON(http.StatusOK, BuildTrainingLoad(s.config.Seasons, hemisphere, season, loads, time.Now()))
+}
+
+// public functions
+
+// ApplyHeartRateZones sets the time in each heart rate zone of every lap and
+// of the activity, and the activity's training load. The load is Edwards'
+// TRIMP, the minutes in each zone weighted by the zone's number, which counts
+// long skins at low intensity that relative effort barely registers.
+func ApplyHeartRateZones(summary *ActivitySummary, streamPoints []StravaStreamPoint, zones []StravaZoneRange) {
+	if len(zones) == 0 {
+		return
+	}
+
+	activityTimes := make([]float64, len(zones))
+	for i := range summary.Laps {
+		lap := &summary.Laps[i]
+		lapTimes := make([]float64, len(zones))
+		for j := lap.StartIndex; j < lap.EndIndex && j+1 < len(streamPoints); j++ {
+			interval := streamPoints[j+1].Time - streamPoints[j].Time
+			zone := heartRateZone(streamPoints[j].HeartRate, zones)
+			if zone < 0 || interval <= 0 || interval > maxHeartRateGap {
+				continue
+			}
+			lapTimes[zone] += interval
+			activityTimes[zone] += interval
+		}
+		if hasTime(lapTimes) {
+			lap.HeartRateZones = lapTimes
+		}
+	}
+	if !hasTime(activityTimes) {
+		return
+	}
+
+	summary.HeartRateZones = activityTimes
+	load := 0.0
+	for zone, seconds := range activityTimes {
+		load += seconds / 60 * float64(zone+1)
+	}
+	summary.TrainingLoad = roundTo(load, 1)
+}
+
+// BuildTrainingLoad computes the daily training load of a season with its
+// acute (7 day) and chronic (42 day) exponentially weighted averages. Loads
+// from before the season warm up the averages.
+func BuildTrainingLoad(calendar SeasonCalendar, hemisphere Hemisphere, season string, loads []ActivityLoad, today time.Time) TrainingLoad {
+	year, _ := strconv.Atoi(season[:4])
+	start := calendar.Start(hemisphere, year)
+	end := calendar.Start(hemisphere, year+1).AddDate(0, 0, -1)
+	stats := TrainingLoad{
+		Season:     season,
+		Hemisphere: hemisphere,
+		StartDate:  start.Format(seasonDateLayout),
+		EndDate:    end.Format(seasonDateLayout),
+		Days:       []TrainingLoadDay{},
+	}
+
+	daily := map[string]float64{}
+	for _, load := range loads {
+		daily[load.Date] += load.Load
+		if load.Date >= stats.StartDate && load.Date <= stats.EndDate {
+			stats.Activities++
+			stats.TotalLoad += load.Load
+		}
+	}
+	stats.TotalLoad = roundTo(stats.TotalLoad, 1)
+
+	last := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	if last.After(end) {
+		last = end
+	}
+
+	acuteWeight := 2.0 / (acuteLoadDays + 1)
+	chronicWeight := 2.0 / (chronicLoadDays + 1)
+	acute, chronic := 0.0, 0.0
+	var chronicHistory []float64
+	for day := start.AddDate(0, 0, -2*chronicLoadDays); !day.After(last); day = day.AddDate(0, 0, 1) {
+		date := day.Format(seasonDateLayout)
+		load := daily[date]
+		acute += acuteWeight * (load - acute)
+		chronic += chronicWeight * (load - chronic)
+		chronicHistory = append(chronicHistory, chronic)
+
+		if !day.Before(start) {
+			stats.Days = append(stats.Days, TrainingLoadDay{
+				Date:    date,
+				Load:    roundTo(load, 1),
+				Acute:   roundTo(acute, 1),
+				Chronic: roundTo(chronic, 1),
+			})
+		}
+	}
+	if len(stats.Days) == 0 {
+		return stats
+	}
+
+	stats.Acute = roundTo(acute, 1)
+	stats.Chronic = roundTo(chronic, 1)
+	if chronic > 0 {
+		stats.Ratio = roundTo(acute/chronic, 2)
+	}
+	stats.RampRate = roundTo(chronic-chronicHistory[len(chronicHistory)-8], 1)
+	return stats
+}
+
+// helpers
+
+// updateTrainingLoad computes the time in zone and training load of an
+// activity with heart rate and stores its load
+func (s *ServerState) updateTrainingLoad(client StravaClient, summary *ActivitySummary, activity StravaActivity, streamPoints []StravaStreamPoint) error {
+	athleteId := activity.Athlete.Id
+	hasHeartRate := false
+	for _, point := range streamPoints {
+		hasHeartRate = hasHeartRate || point.HeartRate > 0
+	}
+	if !hasHeartRate {
+		return s.store.DeleteActivityLoad(athleteId, activity.Id)
+	}
+
+	zones, err := s.heartRateZones(client, athleteId)
+	if err != nil {
+		return err
+	}
+	ApplyHeartRateZones(summary, streamPoints, zones)
+	if summary.TrainingLoad == 0 {
+		return s.store.DeleteActivityLoad(athleteId, activity.Id)
+	}
+
+	return s.store.SaveActivityLoad(athleteId, ActivityLoad{
+		ActivityId: activity.Id,
+		Date:       activityLocalDate(activity, *summary).Format(seasonDateLayout),
+		Type:       activity.Type,
+		Load:       summary.TrainingLoad,
+	})
+}
+
+// heartRateZones returns the athlete's heart rate zones, cached for
+// heartRateZonesTtl. Athletes whose zones strava does not return, such as
+// those who connected before the profile:read_all scope was requested, have
+// no zones, which are cached too so strava is not asked on every activity.
+func (s *ServerState) heartRateZones(client StravaClient, athleteId int) ([]StravaZoneRange, error) {
+	zones, err := s.store.FetchHeartRateZones(athleteId)
+	if err == nil {
+		return zones, nil
+	}
+	if err != redis.Nil {
+		return nil, err
+	}
+
+	zones = []StravaZoneRange{}
+	athleteZones, err := client.GetAthleteZones()
+	if err != nil {
+		slog.Warn("failed to fetch heart rate zones", "athlete_id", athleteId, "err", err)
+	} else {
+		zones = athleteZones.HeartRate.Zones
+		sort.Slice(zones, func(i, j int) bool { return zones[i].Min < zones[j].Min })
+	}
+	if err := s.store.SaveHeartRateZones(athleteId, zones, heartRateZonesTtl); err != nil {
+		return nil, err
+	}
+	return zones, nil
+}
+
+// heartRateZone returns the index of the zone a heart rate falls in, or -1
+// for samples without heart rate. Zones are sorted by their minimum.
+func heartRateZone(heartRate float64, zones []StravaZoneRange) int {
+	if heartRate <= 0 {
+		return -1
+	}
+	zone := 0
+	for i, candidate := range zones {
+		if heartRate >= float64(candidate.Min) {
+			zone = i
+		}
+	}
+	return zone
+}
+
+func hasTime(times []float64) bool {
+	for _, seconds := range times {
+		if seconds > 0 {
+			return true
+		}
+	}
+	return false
+}
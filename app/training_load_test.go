@@ -0,0 +1,99 @@
+package app
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// stravaHeartRateZones are strava's default zones for a maximum heart rate
+// of 190
+var stravaHeartRateZones = []StravaZoneRange{{0, 123}, {123, 153}, {153, 169}, {169, 184}, {184, -1}}
+
+func TestHeartRateZone(t *testing.T) {
+	tests := []struct {
+		heartRate float64
+		expected  int
+	}{
+		{0, -1},
+		{95, 0},
+		{123, 1},
+		{152.5, 1},
+		{170, 3},
+		{201, 4},
+	}
+
+	for _, tt := range tests {
+		if zone := heartRateZone(tt.heartRate, stravaHeartRateZones); zone != tt.expected {
+			t.Errorf("%v bpm: expected zone %d, got %d", tt.heartRate, tt.expected, zone)
+		}
+	}
+}
+
+func TestApplyHeartRateZones(t *testing.T) {
+	points := skiTourPoints()
+	// a pause before the last point is left out
+	points[len(points)-1].Time += 600
+	summary := SummarizeActivity(StravaActivity{Id: 42}, points)
+
+	ApplyHeartRateZones(&summary, points, stravaHeartRateZones)
+
+	// every sample is at 140 bpm, in zone 2
+	expected := float64(len(points)-2) * 10
+	if len(summary.HeartRateZones) != 5 || summary.HeartRateZones[1] != expected || summary.HeartRateZones[0] != 0 {
+		t.Fatalf("unexpected time in zone %v", summary.HeartRateZones)
+	}
+	if math.Abs(summary.TrainingLoad-expected/60*2) > 0.1 {
+		t.Errorf("expected a load of %v, got %v", expected/60*2, summary.TrainingLoad)
+	}
+
+	first := summary.Laps[0]
+	if first.HeartRateZones[1] != first.Duration {
+		t.Errorf("expected the first ascent in zone 2 throughout, got %v", first.HeartRateZones)
+	}
+	for _, lap := range summary.Laps {
+		if lap.EndIndex <= lap.StartIndex && lap.HeartRateZones != nil {
+			t.Errorf("expected no zones for an empty lap, got %v", lap.HeartRateZones)
+		}
+	}
+
+	unzoned := SummarizeActivity(StravaActivity{Id: 42}, points)
+	ApplyHeartRateZones(&unzoned, points, nil)
+	if unzoned.TrainingLoad != 0 || unzoned.HeartRateZones != nil {
+		t.Errorf("expected no load without zones, got %v", unzoned.TrainingLoad)
+	}
+}
+
+func TestBuildTrainingLoad(t *testing.T) {
+	calendar := SeasonCalendar{North: SeasonStart{Month: time.November, Day: 1}}
+	loads := []ActivityLoad{
+		// before the season, only warming up the averages
+		{ActivityId: 1, Date: "2024-10-20", Load: 100},
+		{ActivityId: 2, Date: "2024-11-10", Load: 100},
+		{ActivityId: 3, Date: "2024-11-10", Load: 50},
+		{ActivityId: 4, Date: "2024-11-12", Load: 200},
+	}
+
+	stats := BuildTrainingLoad(calendar, HemisphereNorth, "2024-25", loads, time.Date(2024, 11, 14, 9, 0, 0, 0, time.UTC))
+	if stats.StartDate != "2024-11-01" || stats.EndDate != "2025-10-31" || len(stats.Days) != 14 {
+		t.Fatalf("unexpected season %s to %s with %d days", stats.StartDate, stats.EndDate, len(stats.Days))
+	}
+	if stats.Activities != 3 || stats.TotalLoad != 350 {
+		t.Errorf("expected 3 activities with a load of 350, got %d %v", stats.Activities, stats.TotalLoad)
+	}
+	if stats.Days[0].Chronic <= 0 || stats.Days[9].Load != 150 || stats.Days[9].Date != "2024-11-10" {
+		t.Errorf("unexpected days %+v", stats.Days[:10])
+	}
+	if stats.Acute <= stats.Chronic || stats.Ratio != roundTo(stats.Acute/stats.Chronic, 2) || stats.RampRate <= 0 {
+		t.Errorf("expected a rising load, got %+v", stats)
+	}
+	last := stats.Days[len(stats.Days)-1]
+	if last.Date != "2024-11-14" || last.Acute != stats.Acute {
+		t.Errorf("expected the averages as of today, got %+v", last)
+	}
+
+	empty := BuildTrainingLoad(calendar, HemisphereNorth, "2026-27", loads, time.Date(2024, 11, 14, 0, 0, 0, 0, time.UTC))
+	if len(empty.Days) != 0 || empty.Acute != 0 {
+		t.Errorf("expected no days for a future season, got %+v", empty)
+	}
+}
@@ -2,32 +2,49 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"net/url"
+	"strconv"
 
 	"github.com/labstack/echo/v4"
+	"github.com/redis/go-redis/v9"
 )
 
 const (
 	subscriptionsUrl = "https://www.strava.com/api/v3/push_subscriptions"
+
+	// webhookWorkers is the number of activity events processed at once
+	webhookWorkers = 4
+	// webhookQueueSize is the number of events waiting for each worker
+	// before further events are refused, for strava to retry them later
+	webhookQueueSize = 64
 )
 
 type PushEvent struct {
-	ObjectType     string          `json:"object_type"`
-	ObjectId       int             `json:"object_id"`
-	AspectType     string          `json:"aspect_type"`
-	Updates        map[string]bool `json:"updates"`
-	OwnerId        int             `json:"owner_id"`
-	SubscriptionId int             `json:"subscription_id"`
-	EventTime      int             `json:"event_time"`
+	ObjectType string `json:"object_type"`
+	ObjectId   int    `json:"object_id"`
+	AspectType string `json:"aspect_type"`
+	// strava sends update values as strings, e.g. {"title": "Dawn patrol"}
+	Updates        map[string]string `json:"updates"`
+	OwnerId        int               `json:"owner_id"`
+	SubscriptionId int               `json:"subscription_id"`
+	EventTime      int               `json:"event_time"`
 }
 
 type SubscriptionsResponse struct {
 	Id int `json:"id"`
 }
 
+// eventQueue processes activity events on a fixed number of workers. Events
+// of the same activity always go to the same worker, so they are processed
+// in the order they arrive.
+type eventQueue struct {
+	workers []chan PushEvent
+}
+
 func (s *ServerState) handleSubscriptionCallback(c echo.Context) error {
 	if c.QueryParam("hub.verify_token") != s.config.VerifyToken {
 		slog.Warn("received subscription callback with incorrect verify_token")
@@ -41,12 +58,32 @@ func (s *ServerState) handleSubscriptionCallback(c echo.Context) error {
 	return nil
 }
 
-func handlePushEvent(c echo.Context) error {
+// handlePushEvent acknowledges webhook events immediately, since strava
+// expects a response within two seconds, and processes activities in the
+// background
+func (s *ServerState) handlePushEvent(c echo.Context) error {
 	var event PushEvent
 	c.Bind(&event)
+
+	// the callback is public, so events are only trusted when they carry the
+	// id of the subscription this server registered
+	subscriptionId := s.subscriptionId.Load()
+	if subscriptionId == 0 {
+		slog.Warn("webhook received before the subscription was established", "subscription_id", event.SubscriptionId)
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Subscription is not established")
+	}
+	if int64(event.SubscriptionId) != subscriptionId {
+		slog.Warn("webhook received: unknown subscription", "subscription_id", event.SubscriptionId)
+		return echo.NewHTTPError(http.StatusForbidden, "Unknown subscription")
+	}
+
 	switch event.ObjectType {
 	case "activity":
-		slog.Info("webhook received: activity update", "athlete_id", event.OwnerId, "activity_id", event.ObjectId)
+		slog.Info("webhook received: activity update", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "aspect_type", event.AspectType)
+		if !s.events.enqueue(event) {
+			slog.Warn("webhook queue full, refusing event", "athlete_id", event.OwnerId, "activity_id", event.ObjectId)
+			return echo.NewHTTPError(http.StatusServiceUnavailable, "Too many events")
+		}
 	case "athlete":
 		slog.Info("webhook received: athlete revoked access", "athlete_id", event.OwnerId)
 	default:
@@ -56,12 +93,200 @@ func handlePushEvent(c echo.Context) error {
 	return nil
 }
 
-func EstablishSubscriptions(config *Config, client *StravaClient) {
+// handleActivityEvent summarizes created and updated activities and removes
+// the summaries of deleted ones
+func (s *ServerState) handleActivityEvent(event PushEvent) {
+	if event.AspectType == "delete" {
+		s.handleActivityDeleted(event)
+		return
+	}
+
+	client, err := s.athleteClient(event.OwnerId)
+	if err != nil {
+		slog.Error("failed to create strava client", "athlete_id", event.OwnerId, "err", err)
+		return
+	}
+
+	activity, err := client.GetActivity(strconv.Itoa(event.ObjectId))
+	if err != nil {
+		slog.Error("failed to fetch activity", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+		return
+	}
+	if activity.Athlete.Id != event.OwnerId {
+		slog.Warn("ignoring event for activity of another athlete", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "owner_id", activity.Athlete.Id)
+		return
+	}
+
+	summary, events, err := s.processActivity(client, activity)
+	if err != nil {
+		slog.Error("failed to process activity", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+		return
+	}
+	// only announce on create, writing the description triggers an update
+	// event and updates would otherwise be processed twice
+	if event.AspectType == "create" && len(events) > 0 {
+		s.announceRecords(client, activity, summary, events)
+	}
+	slog.Info("processed activity", "athlete_id", event.OwnerId, "activity_id", event.ObjectId)
+}
+
+// handleActivityDeleted removes everything derived from a deleted activity.
+// Since anyone can post to the callback, the activity must belong to the
+// event's owner and strava must no longer return it.
+func (s *ServerState) handleActivityDeleted(event PushEvent) {
+	summary, err := s.store.FetchActivitySummary(strconv.Itoa(event.ObjectId))
+	if err == redis.Nil {
+		slog.Info("no summary stored for deleted activity", "athlete_id", event.OwnerId, "activity_id", event.ObjectId)
+		return
+	}
+	if err != nil {
+		slog.Error("failed to fetch activity summary", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+		return
+	}
+
+	client, err := s.athleteClient(event.OwnerId)
+	if err != nil {
+		slog.Error("failed to create strava client", "athlete_id", event.OwnerId, "err", err)
+		return
+	}
+	if err := verifyActivityDeleted(client, *summary, event); err != nil {
+		slog.Warn("ignoring delete event", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+		return
+	}
+
+	if err := s.removeSeasonActivity(*summary); err != nil {
+		slog.Error("failed to remove activity from season", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+	if err := s.removeRecordEfforts(event.OwnerId, event.ObjectId); err != nil {
+		slog.Error("failed to remove activity from personal records", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+	if err := s.store.DeleteZoneVisits(event.OwnerId, event.ObjectId); err != nil {
+		slog.Error("failed to delete zone visits", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+	if err := s.store.DeleteActivitySummits(event.OwnerId, event.ObjectId); err != nil {
+		slog.Error("failed to delete summits", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+	if err := s.store.DeleteActivityAscents(event.OwnerId, event.ObjectId); err != nil {
+		slog.Error("failed to delete ascents", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+	if err := s.store.DeleteActivityPace(event.OwnerId, event.ObjectId); err != nil {
+		slog.Error("failed to delete activity pace", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+	if err := s.store.DeleteActivityLoad(event.OwnerId, event.ObjectId); err != nil {
+		slog.Error("failed to delete training load", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+	if err := s.store.DeleteHeatmapTrack(event.OwnerId, event.ObjectId); err != nil {
+		slog.Error("failed to delete heatmap track", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	} else if err := s.invalidateHeatmap(event.OwnerId); err != nil {
+		slog.Error("failed to invalidate heatmap", "athlete_id", event.OwnerId, "err", err)
+	}
+	if err := s.store.DeleteActivitySummary(event.ObjectId); err != nil {
+		slog.Error("failed to delete activity summary", "athlete_id", event.OwnerId, "activity_id", event.ObjectId, "err", err)
+	}
+}
+
+// processActivity is the activity processing pipeline, run for every new or
+// updated activity. It fetches the streams, corrects their elevation when an
+// elevation model is configured, stores the activity summary and updates the
+// athlete's season statistics, zone visits, summits, route matches, training
+// load, pace, heatmap and personal records. It returns the records the
+// activity newly set, which it leaves to the caller to announce.
+func (s *ServerState) processActivity(client StravaClient, activity StravaActivity) (ActivitySummary, []RecordEvent, error) {
+	summary, streamPoints, err := s.summarizeActivity(client, activity)
+	if err != nil {
+		return ActivitySummary{}, nil, err
+	}
+
+	previous, err := s.store.FetchActivitySummary(strconv.Itoa(activity.Id))
+	if err != nil && err != redis.Nil {
+		return ActivitySummary{}, nil, err
+	}
+
+	if err := s.updateSeasons(previous, &summary, activity); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	if err := s.updateZones(&summary, activity, streamPoints); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	if err := s.updateSummits(&summary, activity, streamPoints); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	if err := s.updateRouteMatches(&summary, activity, streamPoints); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	if err := s.updateTrainingLoad(client, &summary, activity, streamPoints); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	if err := s.store.SaveActivitySummary(summary); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	if err := s.updatePace(summary, activity); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+
+	if err := s.updateHeatmap(activity, streamPoints); err != nil {
+		return ActivitySummary{}, nil, err
+	}
+
+	events, err := s.updateRecords(activity, summary, streamPoints)
+	if err != nil {
+		return ActivitySummary{}, nil, err
+	}
+	return summary, events, nil
+}
+
+// newEventQueue starts workers that pass queued events to handle
+func newEventQueue(workers int, size int, handle func(PushEvent)) *eventQueue {
+	queue := &eventQueue{workers: make([]chan PushEvent, workers)}
+	for i := range queue.workers {
+		events := make(chan PushEvent, size)
+		queue.workers[i] = events
+		go func() {
+			for event := range events {
+				handle(event)
+			}
+		}()
+	}
+	return queue
+}
+
+// enqueue hands an event to its activity's worker, returning false when the
+// worker's queue is full
+func (q *eventQueue) enqueue(event PushEvent) bool {
+	worker := q.workers[uint(event.ObjectId)%uint(len(q.workers))]
+	select {
+	case worker <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// verifyActivityDeleted checks that the activity of a delete event belongs to
+// the event's owner and that strava responds 404 for it with the owner's
+// token
+func verifyActivityDeleted(client StravaClient, summary ActivitySummary, event PushEvent) error {
+	if summary.AthleteId != event.OwnerId {
+		return fmt.Errorf("activity belongs to athlete %d", summary.AthleteId)
+	}
+	_, err := client.GetActivity(strconv.Itoa(event.ObjectId))
+	if err == nil {
+		return fmt.Errorf("activity still exists")
+	}
+	if !errors.Is(err, ErrStravaNotFound) {
+		return fmt.Errorf("failed to check whether the activity exists: %w", err)
+	}
+	return nil
+}
+
+// EstablishSubscriptions fetches the app's push subscription, creating it if
+// there is none, and returns its id, or 0 when it could not be established
+func EstablishSubscriptions(config *Config, client *StravaClient) int {
 	slog.Info("fetching current subscription info")
 	subscriptionsUrlBuilder, err := url.Parse(subscriptionsUrl)
 	if err != nil {
 		slog.Error("error fetching subscription: failed to parse url", "subscriptions_url", subscriptionsUrl)
-		return
+		return 0
 	}
 	queryParams := subscriptionsUrlBuilder.Query()
 	queryParams.Add("client_id", config.StravaClientId)
@@ -71,19 +296,19 @@ func EstablishSubscriptions(config *Config, client *StravaClient) {
 	body, err := client.performRequest("GET", subscriptionsUrlBuilder.String(), nil)
 	if err != nil {
 		slog.Error("error fetching subscription: http request failed", "err", err)
-		return
+		return 0
 	}
 
 	var currentSubscriptions []SubscriptionsResponse
 	err = json.NewDecoder(body).Decode(&currentSubscriptions)
 	if err != nil {
 		slog.Error("error fetching subscription: decoding response failed", "err", err)
-		return
+		return 0
 	}
 
 	if len(currentSubscriptions) > 0 {
 		slog.Info("fetched current subscription", "subscription_id", currentSubscriptions[0].Id)
-		return
+		return currentSubscriptions[0].Id
 	}
 
 	slog.Info("no existing subscription found, will attempt to create one")
@@ -97,15 +322,16 @@ func EstablishSubscriptions(config *Config, client *StravaClient) {
 	body, err = client.performRequestForm("POST", subscriptionsUrl, formData)
 	if err != nil {
 		slog.Error("error creating subscription: http request failed", "err", err)
-		return
+		return 0
 	}
 
 	var newSubscription SubscriptionsResponse
 	err = json.NewDecoder(body).Decode(&newSubscription)
 	if err != nil {
 		slog.Error("error creating subscription: decoding response failed", "err", err)
-		return
+		return 0
 	}
 
 	slog.Info("created new subscription", "subscription_id", newSubscription.Id)
+	return newSubscription.Id
 }
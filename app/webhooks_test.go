@@ -0,0 +1,121 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestHandlePushEvent_Subscription(t *testing.T) {
+	tests := []struct {
+		name           string
+		established    int64
+		body           string
+		expectedStatus int
+	}{
+		{"not established", 0, `{"object_type":"activity","aspect_type":"delete","object_id":1,"owner_id":2,"subscription_id":7}`, http.StatusServiceUnavailable},
+		{"forged subscription", 7, `{"object_type":"activity","aspect_type":"delete","object_id":1,"owner_id":2,"subscription_id":8}`, http.StatusForbidden},
+		{"missing subscription", 7, `{"object_type":"activity","aspect_type":"delete","object_id":1,"owner_id":2}`, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/subscriptions/callback", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			c := e.NewContext(req, httptest.NewRecorder())
+
+			s := &ServerState{subscriptionId: new(atomic.Int64)}
+			s.subscriptionId.Store(tt.established)
+
+			err := s.handlePushEvent(c)
+			httpErr, ok := err.(*echo.HTTPError)
+			if !ok {
+				t.Fatalf("expected *echo.HTTPError, got %T", err)
+			}
+			if httpErr.Code != tt.expectedStatus {
+				t.Errorf("expected status %d, got %d", tt.expectedStatus, httpErr.Code)
+			}
+		})
+	}
+}
+
+func TestVerifyActivityDeleted(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(`{"id": 42}`))
+	}))
+	defer server.Close()
+
+	originalActivityUrl := ActivityUrl
+	defer func() { ActivityUrl = originalActivityUrl }()
+	ActivityUrl = server.URL + "/activities/%s"
+
+	client := NewStravaClient("test-token")
+	summary := ActivitySummary{ActivityId: 42, AthleteId: 2}
+	event := PushEvent{ObjectType: "activity", AspectType: "delete", ObjectId: 42, OwnerId: 2}
+
+	if err := verifyActivityDeleted(client, summary, event); err != nil {
+		t.Errorf("expected a deleted activity to be verified, got %v", err)
+	}
+
+	forged := event
+	forged.OwnerId = 3
+	if err := verifyActivityDeleted(client, summary, forged); err == nil {
+		t.Error("expected an activity of another athlete to be rejected")
+	}
+
+	status = http.StatusOK
+	if err := verifyActivityDeleted(client, summary, event); err == nil {
+		t.Error("expected an activity strava still returns to be rejected")
+	}
+
+	status = http.StatusInternalServerError
+	if err := verifyActivityDeleted(client, summary, event); err == nil {
+		t.Error("expected an unconfirmed deletion to be rejected")
+	}
+}
+
+func TestEventQueue(t *testing.T) {
+	taken := make(chan struct{}, 8)
+	release := make(chan struct{})
+	processed := make(chan PushEvent, 8)
+	queue := newEventQueue(2, 2, func(event PushEvent) {
+		taken <- struct{}{}
+		<-release
+		processed <- event
+	})
+
+	// the worker of activity 4 holds one event and queues two more
+	for i, aspect := range []string{"create", "update", "update"} {
+		if !queue.enqueue(PushEvent{ObjectId: 4, AspectType: aspect, EventTime: i}) {
+			t.Fatalf("expected event %d to be queued", i)
+		}
+		if i == 0 {
+			<-taken
+		}
+	}
+	if queue.enqueue(PushEvent{ObjectId: 6, EventTime: 3}) {
+		t.Error("expected the full worker to refuse events")
+	}
+	if !queue.enqueue(PushEvent{ObjectId: 5, EventTime: 4}) {
+		t.Error("expected the other worker to accept events")
+	}
+
+	close(release)
+	var times []int
+	for i := 0; i < 4; i++ {
+		event := <-processed
+		if event.ObjectId == 4 {
+			times = append(times, event.EventTime)
+		}
+	}
+	if len(times) != 3 || times[0] != 0 || times[1] != 1 || times[2] != 2 {
+		t.Errorf("expected the events of an activity in order, got %v", times)
+	}
+}
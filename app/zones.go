@@ -0,0 +1,392 @@
+package app
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	maxZones             = 200
+	maxZoneVertices      = 500
+	maxZoneNameLength    = 100
+	minZonePolygonPoints = 3
+)
+
+// NamedZone is a polygon such as a ravine or a named run. Polygon is the
+// outer ring as [longitude, latitude] pairs, closed or not.
+type NamedZone struct {
+	Id      string       `json:"id"`
+	Name    string       `json:"name"`
+	Polygon [][2]float64 `json:"polygon"`
+	// TeamId is set on zones shared by a team
+	TeamId string `json:"team_id,omitempty"`
+}
+
+// ZoneSettings are the named zones of an athlete or a team
+type ZoneSettings struct {
+	Zones []NamedZone `json:"zones"`
+}
+
+// ZoneVisit is one lap entering a zone, with the vertical climbed or skied
+// inside the zone
+type ZoneVisit struct {
+	ZoneId       string  `json:"zone_id"`
+	Name         string  `json:"name"`
+	TeamId       string  `json:"team_id,omitempty"`
+	Kind         LapKind `json:"kind"`
+	VerticalGain float64 `json:"vertical_gain_m"`
+	VerticalLoss float64 `json:"vertical_loss_m"`
+}
+
+// ActivityZoneVisits are the zone visits of one activity
+type ActivityZoneVisits struct {
+	ActivityId int         `json:"activity_id"`
+	Date       string      `json:"date"`
+	Visits     []ZoneVisit `json:"visits"`
+}
+
+// ZoneStats are an athlete's totals in one zone. Runs are descents entering
+// the zone and climbs are ascents entering it.
+type ZoneStats struct {
+	ZoneId          string  `json:"zone_id"`
+	Name            string  `json:"name"`
+	TeamId          string  `json:"team_id,omitempty"`
+	Runs            int     `json:"runs"`
+	Climbs          int     `json:"climbs"`
+	SkiedVertical   float64 `json:"skied_vertical_m"`
+	ClimbedVertical float64 `json:"climbed_vertical_m"`
+	LastVisit       string  `json:"last_visit"`
+}
+
+// bounds returns the zone's bounding box as west, south, east, north
+func (z NamedZone) bounds() [4]float64 {
+	bounds := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, vertex := range z.Polygon {
+		bounds[0] = math.Min(bounds[0], vertex[0])
+		bounds[1] = math.Min(bounds[1], vertex[1])
+		bounds[2] = math.Max(bounds[2], vertex[0])
+		bounds[3] = math.Max(bounds[3], vertex[1])
+	}
+	return bounds
+}
+
+// http request handlers
+
+// handleZonesGet returns the authenticated athlete's named zones
+func (s *ServerState) handleZonesGet(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	settings, err := s.store.FetchAthleteZones(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch zones", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch zones")
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// handleZonesPut replaces the authenticated athlete's named zones. Zones
+// apply to activities processed afterwards.
+func (s *ServerState) handleZonesPut(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	var settings ZoneSettings
+	if err := c.Bind(&settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid zones")
+	}
+	if err := settings.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	settings.assignIds("")
+
+	if err := s.store.SaveAthleteZones(tokenInfo.athleteId, settings); err != nil {
+		slog.Error("failed to save zones", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save zones")
+	}
+
+	slog.Info("saved zones", "athlete_id", tokenInfo.athleteId, "zones", len(settings.Zones))
+	return c.JSON(http.StatusOK, settings)
+}
+
+// handleTeamZonesGet returns the named zones shared by one of the
+// authenticated athlete's teams
+func (s *ServerState) handleTeamZonesGet(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	team, err := s.memberTeam(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	settings, err := s.store.FetchTeamZones(team.Id)
+	if err != nil {
+		slog.Error("failed to fetch team zones", "athlete_id", tokenInfo.athleteId, "team_id", team.Id, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch zones")
+	}
+	return c.JSON(http.StatusOK, settings)
+}
+
+// handleTeamZonesPut replaces the named zones shared by one of the
+// authenticated athlete's teams
+func (s *ServerState) handleTeamZonesPut(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	team, err := s.memberTeam(tokenInfo.athleteId, c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	var settings ZoneSettings
+	if err := c.Bind(&settings); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid zones")
+	}
+	if err := settings.validate(); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	settings.assignIds(team.Id)
+
+	if err := s.store.SaveTeamZones(team.Id, settings); err != nil {
+		slog.Error("failed to save team zones", "athlete_id", tokenInfo.athleteId, "team_id", team.Id, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save zones")
+	}
+
+	slog.Info("saved team zones", "athlete_id", tokenInfo.athleteId, "team_id", team.Id, "zones", len(settings.Zones))
+	return c.JSON(http.StatusOK, settings)
+}
+
+// handleZoneStats returns the authenticated athlete's run counts and vertical
+// totals in each zone they have visited
+func (s *ServerState) handleZoneStats(c echo.Context) error {
+	tokenInfo, err := s.AuthenticateRequest(c.Request())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err)
+	}
+
+	activities, err := s.store.FetchZoneVisits(tokenInfo.athleteId)
+	if err != nil {
+		slog.Error("failed to fetch zone visits", "athlete_id", tokenInfo.athleteId, "err", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch zone statistics")
+	}
+	return c.JSON(http.StatusOK, BuildZoneStats(activities))
+}
+
+// public functions
+
+// MatchZones sets the names of the zones each ascent and descent enters on
+// the summary's laps and returns the visits. Points are tested against every
+// zone, so a lap through overlapping zones visits all of them.
+func MatchZones(summary *ActivitySummary, streamPoints []StravaStreamPoint, zones []NamedZone) []ZoneVisit {
+	visits := []ZoneVisit{}
+	summary.Zones = nil
+	if len(zones) == 0 || len(streamPoints) == 0 {
+		return visits
+	}
+
+	bounds := make([][4]float64, len(zones))
+	for i, zone := range zones {
+		bounds[i] = zone.bounds()
+	}
+	altitudes := smoothAltitude(streamPoints, defaultSmoothingWindow)
+
+	seen := map[string]bool{}
+	for l := range summary.Laps {
+		lap := &summary.Laps[l]
+		lap.Zones = nil
+		if lap.Kind == LapTransition {
+			continue
+		}
+
+		for z, zone := range zones {
+			visit := ZoneVisit{ZoneId: zone.Id, Name: zone.Name, TeamId: zone.TeamId, Kind: lap.Kind}
+			entered := false
+			previousInside := false
+			for i := lap.StartIndex; i <= lap.EndIndex && i < len(streamPoints); i++ {
+				point := streamPoints[i]
+				inside := point.Latitude != 0 || point.Longitude != 0
+				inside = inside && point.Longitude >= bounds[z][0] && point.Latitude >= bounds[z][1] &&
+					point.Longitude <= bounds[z][2] && point.Latitude <= bounds[z][3] &&
+					pointInPolygon(point.Longitude, point.Latitude, zone.Polygon)
+				if inside {
+					entered = true
+					if previousInside {
+						delta := altitudes[i] - altitudes[i-1]
+						if delta > 0 {
+							visit.VerticalGain += delta
+						} else {
+							visit.VerticalLoss -= delta
+						}
+					}
+				}
+				previousInside = inside
+			}
+			if !entered {
+				continue
+			}
+
+			lap.Zones = append(lap.Zones, zone.Name)
+			if lap.Kind == LapDescent || summary.countsAscent(*lap) {
+				visits = append(visits, visit)
+			}
+			if !seen[zone.Name] {
+				seen[zone.Name] = true
+				summary.Zones = append(summary.Zones, zone.Name)
+			}
+		}
+	}
+	return visits
+}
+
+// BuildZoneStats totals zone visits per zone, most visited first
+func BuildZoneStats(activities []ActivityZoneVisits) []ZoneStats {
+	byZone := map[string]*ZoneStats{}
+	for _, activity := range activities {
+		for _, visit := range activity.Visits {
+			key := visit.TeamId + "/" + visit.ZoneId
+			stats, ok := byZone[key]
+			if !ok {
+				stats = &ZoneStats{ZoneId: visit.ZoneId, Name: visit.Name, TeamId: visit.TeamId}
+				byZone[key] = stats
+			}
+			switch visit.Kind {
+			case LapDescent:
+				stats.Runs++
+				stats.SkiedVertical += visit.VerticalLoss
+			case LapAscent:
+				stats.Climbs++
+				stats.ClimbedVertical += visit.VerticalGain
+			}
+			if activity.Date > stats.LastVisit {
+				stats.LastVisit = activity.Date
+				// renamed zones show their latest name
+				stats.Name = visit.Name
+			}
+		}
+	}
+
+	result := make([]ZoneStats, 0, len(byZone))
+	for _, stats := range byZone {
+		result = append(result, *stats)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Runs != result[j].Runs {
+			return result[i].Runs > result[j].Runs
+		}
+		if result[i].Climbs != result[j].Climbs {
+			return result[i].Climbs > result[j].Climbs
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// helpers
+
+// athleteZones loads the athlete's own zones and those of their teams
+func (s *ServerState) athleteZones(athleteId int) ([]NamedZone, error) {
+	settings, err := s.store.FetchAthleteZones(athleteId)
+	if err != nil {
+		return nil, err
+	}
+	zones := settings.Zones
+
+	teams, err := s.athleteTeams(athleteId)
+	if err != nil {
+		return nil, err
+	}
+	for _, team := range teams {
+		teamSettings, err := s.store.FetchTeamZones(team.Id)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, teamSettings.Zones...)
+	}
+	return zones, nil
+}
+
+// updateZones matches the activity's laps to the athlete's zones and stores
+// the visits
+func (s *ServerState) updateZones(summary *ActivitySummary, activity StravaActivity, streamPoints []StravaStreamPoint) error {
+	athleteId := activity.Athlete.Id
+	zones, err := s.athleteZones(athleteId)
+	if err != nil {
+		return err
+	}
+
+	visits := MatchZones(summary, streamPoints, zones)
+	if len(visits) == 0 {
+		return s.store.DeleteZoneVisits(athleteId, activity.Id)
+	}
+	return s.store.SaveZoneVisits(athleteId, ActivityZoneVisits{
+		ActivityId: activity.Id,
+		Date:       activityLocalDate(activity, *summary).Format("2006-01-02"),
+		Visits:     visits,
+	})
+}
+
+func (z *ZoneSettings) validate() error {
+	if len(z.Zones) > maxZones {
+		return fmt.Errorf("at most %d zones are allowed", maxZones)
+	}
+
+	for i := range z.Zones {
+		zone := &z.Zones[i]
+		zone.Name = strings.TrimSpace(zone.Name)
+		if zone.Name == "" || len(zone.Name) > maxZoneNameLength {
+			return fmt.Errorf("zone %d: name must be between 1 and %d characters", i, maxZoneNameLength)
+		}
+		// a closing vertex repeating the first one is dropped
+		if n := len(zone.Polygon); n > 1 && zone.Polygon[0] == zone.Polygon[n-1] {
+			zone.Polygon = zone.Polygon[:n-1]
+		}
+		if len(zone.Polygon) < minZonePolygonPoints || len(zone.Polygon) > maxZoneVertices {
+			return fmt.Errorf("zone %d: polygon must have between %d and %d vertices", i, minZonePolygonPoints, maxZoneVertices)
+		}
+		for _, vertex := range zone.Polygon {
+			if vertex[0] < -180 || vertex[0] > 180 || vertex[1] < -90 || vertex[1] > 90 {
+				return fmt.Errorf("zone %d: invalid coordinates", i)
+			}
+		}
+	}
+	return nil
+}
+
+// assignIds gives new zones an id and marks them as belonging to the team,
+// empty for personal zones
+func (z *ZoneSettings) assignIds(teamId string) {
+	for i := range z.Zones {
+		if z.Zones[i].Id == "" {
+			z.Zones[i].Id = randomString(8)
+		}
+		z.Zones[i].TeamId = teamId
+	}
+}
+
+// pointInPolygon tests a point against a ring of [longitude, latitude]
+// vertices by ray casting
+func pointInPolygon(lon, lat float64, polygon [][2]float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a[1] > lat) != (b[1] > lat) && lon < (b[0]-a[0])*(lat-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
@@ -0,0 +1,155 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+// boxZone is a rectangle across the longitude of skiTourPoints
+func boxZone(id string, name string, south float64, north float64) NamedZone {
+	return NamedZone{
+		Id:      id,
+		Name:    name,
+		Polygon: [][2]float64{{-71.01, south}, {-70.99, south}, {-70.99, north}, {-71.01, north}},
+	}
+}
+
+func TestPointInPolygon(t *testing.T) {
+	// an L shape, whose notch is inside the bounding box but not the polygon
+	polygon := [][2]float64{{0, 0}, {2, 0}, {2, 1}, {1, 1}, {1, 2}, {0, 2}}
+
+	tests := []struct {
+		lon, lat float64
+		expected bool
+	}{
+		{0.5, 0.5, true},
+		{1.5, 0.5, true},
+		{0.5, 1.5, true},
+		{1.5, 1.5, false},
+		{-0.5, 0.5, false},
+		{2.5, 0.5, false},
+	}
+
+	for _, tt := range tests {
+		if inside := pointInPolygon(tt.lon, tt.lat, polygon); inside != tt.expected {
+			t.Errorf("%v,%v: expected %v, got %v", tt.lon, tt.lat, tt.expected, inside)
+		}
+	}
+}
+
+func TestMatchZones(t *testing.T) {
+	points := skiTourPoints()
+	summary := SummarizeActivity(StravaActivity{Id: 42}, points)
+
+	// the lower half of the first climb, and the top of the second climb with
+	// the run below it
+	zones := []NamedZone{
+		boxZone("a", "Lower Skin Track", 43.99, 44.006),
+		boxZone("b", "Hillman's Highway", 44.045, 44.09),
+	}
+	zones[1].TeamId = "team"
+
+	visits := MatchZones(&summary, points, zones)
+	if len(visits) != 3 {
+		t.Fatalf("expected 3 visits, got %+v", visits)
+	}
+
+	expected := []struct {
+		zoneId string
+		kind   LapKind
+	}{
+		{"a", LapAscent},
+		{"b", LapAscent},
+		{"b", LapDescent},
+	}
+	for i, tt := range expected {
+		if visits[i].ZoneId != tt.zoneId || visits[i].Kind != tt.kind {
+			t.Errorf("visit %d: expected %s %s, got %+v", i, tt.zoneId, tt.kind, visits[i])
+		}
+	}
+	if visits[0].VerticalGain < 100 || visits[0].VerticalGain > 150 {
+		t.Errorf("expected about 125 m climbed in the lower zone, got %v", visits[0].VerticalGain)
+	}
+	if visits[2].VerticalLoss < 180 || visits[2].TeamId != "team" {
+		t.Errorf("expected the whole run in the team zone, got %+v", visits[2])
+	}
+
+	var zoned []string
+	for _, lap := range summary.Laps {
+		if lap.Kind == LapTransition && len(lap.Zones) > 0 {
+			t.Errorf("expected transitions to have no zones, got %v", lap.Zones)
+		}
+		zoned = append(zoned, lap.Zones...)
+	}
+	if strings.Join(zoned, ",") != "Lower Skin Track,Hillman's Highway,Hillman's Highway" {
+		t.Errorf("unexpected lap zones %v", zoned)
+	}
+	if strings.Join(summary.Zones, ",") != "Lower Skin Track,Hillman's Highway" {
+		t.Errorf("unexpected activity zones %v", summary.Zones)
+	}
+
+	if visits := MatchZones(&summary, points, nil); len(visits) != 0 || summary.Zones != nil {
+		t.Errorf("expected no visits without zones, got %+v", visits)
+	}
+}
+
+func TestBuildZoneStats(t *testing.T) {
+	activities := []ActivityZoneVisits{
+		{ActivityId: 1, Date: "2025-02-01", Visits: []ZoneVisit{
+			{ZoneId: "a", Name: "Tuckerman", Kind: LapAscent, VerticalGain: 300},
+			{ZoneId: "a", Name: "Tuckerman", Kind: LapDescent, VerticalLoss: 280},
+			{ZoneId: "b", Name: "Hillman's", Kind: LapDescent, VerticalLoss: 400},
+		}},
+		{ActivityId: 2, Date: "2025-03-01", Visits: []ZoneVisit{
+			{ZoneId: "a", Name: "Tuckerman Ravine", Kind: LapDescent, VerticalLoss: 250},
+			// a team zone with the same id is a different zone
+			{ZoneId: "a", TeamId: "team", Name: "Bowl", Kind: LapDescent, VerticalLoss: 100},
+		}},
+	}
+
+	stats := BuildZoneStats(activities)
+	if len(stats) != 3 {
+		t.Fatalf("expected 3 zones, got %+v", stats)
+	}
+	first := stats[0]
+	if first.ZoneId != "a" || first.TeamId != "" || first.Runs != 2 || first.Climbs != 1 {
+		t.Errorf("unexpected first zone %+v", first)
+	}
+	if first.SkiedVertical != 530 || first.ClimbedVertical != 300 {
+		t.Errorf("expected 530 m skied and 300 m climbed, got %+v", first)
+	}
+	if first.Name != "Tuckerman Ravine" || first.LastVisit != "2025-03-01" {
+		t.Errorf("expected the latest name and visit, got %+v", first)
+	}
+}
+
+func TestZoneSettings_Validate(t *testing.T) {
+	valid := boxZone("", " Gully ", 44, 44.01)
+	closed := boxZone("", "Closed", 44, 44.01)
+	closed.Polygon = append(closed.Polygon, closed.Polygon[0])
+
+	settings := ZoneSettings{Zones: []NamedZone{valid, closed}}
+	if err := settings.validate(); err != nil {
+		t.Fatalf("expected valid zones, got %v", err)
+	}
+	if settings.Zones[0].Name != "Gully" || len(settings.Zones[1].Polygon) != 4 {
+		t.Errorf("expected a trimmed name and an open ring, got %+v", settings.Zones)
+	}
+
+	invalid := []NamedZone{
+		{Name: "", Polygon: valid.Polygon},
+		{Name: "Line", Polygon: valid.Polygon[:2]},
+		{Name: "Far", Polygon: [][2]float64{{0, 0}, {1, 0}, {1, 91}}},
+		{Name: strings.Repeat("x", maxZoneNameLength+1), Polygon: valid.Polygon},
+	}
+	for _, zone := range invalid {
+		if err := (&ZoneSettings{Zones: []NamedZone{zone}}).validate(); err == nil {
+			t.Errorf("expected %q to be invalid", zone.Name)
+		}
+	}
+
+	settings.assignIds("team")
+	if settings.Zones[0].Id == "" || settings.Zones[0].TeamId != "team" {
+		t.Errorf("expected an id and the team, got %+v", settings.Zones[0])
+	}
+}
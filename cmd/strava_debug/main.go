@@ -4,8 +4,8 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
-	"time"
 
 	"github.com/cderwin/skintrackr/app"
 	"github.com/urfave/cli/v3"
@@ -15,6 +15,13 @@ func main() {
 	var token string
 	var activityId string
 	var outputPath string
+	var format string
+	var simplify string
+	var resample string
+	var despike string
+	var reportFormat string
+	var demDir string
+	var peaksFile string
 
 	cli := &cli.Command{
 		Name:  "strava-debug",
@@ -39,9 +46,95 @@ func main() {
 				Required:    true,
 				Destination: &outputPath,
 			},
+			&cli.StringFlag{
+				Name:        "format",
+				Local:       true,
+				Aliases:     []string{"f"},
+				Usage:       "export format (gpx, tcx, fit, geojson, kml)",
+				Value:       "gpx",
+				Destination: &format,
+			},
+			&cli.StringFlag{
+				Name:        "simplify",
+				Local:       true,
+				Usage:       "simplification tolerance in meters",
+				Destination: &simplify,
+			},
+			&cli.StringFlag{
+				Name:        "resample",
+				Local:       true,
+				Usage:       "resample interval, a duration (30s) or distance (25m)",
+				Destination: &resample,
+			},
+			&cli.StringFlag{
+				Name:        "despike",
+				Local:       true,
+				Usage:       "remove gps spikes, true or a max speed in m/s",
+				Destination: &despike,
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "report",
+				Usage: "write a trip report of the activity",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:        "format",
+						Aliases:     []string{"f"},
+						Usage:       "report format (html, markdown)",
+						Value:       "html",
+						Destination: &reportFormat,
+					},
+					&cli.StringFlag{
+						Name:        "dem-dir",
+						Usage:       "directory of elevation tiles for elevation correction and terrain exposure",
+						Destination: &demDir,
+						Sources:     cli.EnvVars("DEM_DIR"),
+					},
+					&cli.StringFlag{
+						Name:        "peaks-file",
+						Usage:       "GeoJSON or CSV file of named peaks for summits",
+						Destination: &peaksFile,
+						Sources:     cli.EnvVars("PEAKS_FILE"),
+					},
+				},
+				Action: func(context.Context, *cli.Command) error {
+					format, err := app.ParseReportFormat(reportFormat)
+					if err != nil {
+						return err
+					}
+
+					options := app.ReportOptions{Format: format}
+					if demDir != "" {
+						options.Elevation = app.NewElevationModel(demDir)
+					}
+					if peaksFile != "" {
+						options.Peaks, err = app.LoadPeaks(peaksFile)
+						if err != nil {
+							return err
+						}
+						options.Summits = app.DefaultSummitOptions()
+					}
+					return WriteReport(activityId, token, options, outputPath)
+				},
+			},
 		},
 		Action: func(context.Context, *cli.Command) error {
-			err := DownloadActivityGpx(activityId, token, outputPath)
+			exportFormat, err := app.ParseExportFormat(format)
+			if err != nil {
+				return err
+			}
+
+			simplifyOptions, err := app.ParseSimplifyOptions(url.Values{
+				"simplify": {simplify},
+				"resample": {resample},
+				"despike":  {despike},
+			})
+			if err != nil {
+				return err
+			}
+
+			err = DownloadActivity(activityId, token, exportFormat, simplifyOptions, outputPath)
 			if err != nil {
 				panic(err)
 			}
@@ -54,29 +147,36 @@ func main() {
 	}
 }
 
-func DownloadActivityGpx(activityId string, token string, path string) error {
+func DownloadActivity(activityId string, token string, format app.ExportFormat, simplify app.SimplifyOptions, path string) error {
 	client := app.NewStravaClient(token)
 	activity, err := client.GetActivity(activityId)
 	if err != nil {
 		panic(fmt.Errorf("failed to fetch activity: %w", err))
 	}
 
-	startTime, err := time.Parse(time.RFC3339, activity.StartDate)
+	metadata, err := app.NewGpxMetadata(activity)
 	if err != nil {
-		panic(fmt.Errorf("failed to parse activity start time: %w", err))
+		panic(err)
 	}
+	metadata.Simplify = simplify
 
-	metadata := app.GpxMetadata{
-		Name:           activity.Name,
-		Type:           activity.Type,
-		Time:           startTime,
-		UseHeartRate:   true,
-		UseTemperature: true,
+	err = client.DownloadActivity(activity, format, path, metadata)
+	if err != nil {
+		panic(fmt.Errorf("failed to download activity %s: %w", format, err))
 	}
+	return nil
+}
 
-	err = client.DownloadActivity(activityId, path, metadata)
+func WriteReport(activityId string, token string, options app.ReportOptions, path string) error {
+	client := app.NewStravaClient(token)
+	activity, err := client.GetActivity(activityId)
 	if err != nil {
-		panic(fmt.Errorf("failed to download activity gpx: %w", err))
+		return fmt.Errorf("failed to fetch activity: %w", err)
 	}
-	return nil
+
+	data, err := client.ActivityReport(activity, options)
+	if err != nil {
+		return fmt.Errorf("failed to build report: %w", err)
+	}
+	return os.WriteFile(path, data, 0644)
 }